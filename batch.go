@@ -0,0 +1,1019 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ostafen/xdcc-cli/internal/group"
+	"github.com/ostafen/xdcc-cli/pkg/xdcc"
+)
+
+type itemState string
+
+const (
+	itemStateQueued      itemState = "queued"
+	itemStateConnecting  itemState = "connecting"
+	itemStateDownloading itemState = "downloading"
+	itemStateCompleted   itemState = "completed"
+	itemStateFailed      itemState = "failed"
+	itemStateCancelled   itemState = "cancelled"
+	itemStateSkipped     itemState = "skipped"
+	itemStatePaused      itemState = "paused" // waiting for the schedule to reopen
+)
+
+// downloadRequest is a file to download, along with the alternative sources offering it.
+type downloadRequest struct {
+	url          xdcc.IRCFileURL
+	alternatives []xdcc.IRCFileURL
+	fileName     string       // expected file name, if known
+	fileSize     int64        // advertised size of the file, if known
+	hash         string       // hash reported by the search engine, if any
+	fserve       *xdcc.FServe // session getting the file from the fserve of the bot, if any
+	forward      []string     // targets the file is uploaded to, instead of the global ones
+}
+
+func newDownloadRequests(urlList []xdcc.IRCFileURL) []downloadRequest {
+	requests := make([]downloadRequest, 0, len(urlList))
+	for _, url := range urlList {
+		requests = append(requests, downloadRequest{url: url})
+	}
+	return requests
+}
+
+// batchItem tracks the progress of a single file of a batch.
+type batchItem struct {
+	url            xdcc.IRCFileURL
+	source         string // url requested, identifying the item in the checkpoints even if the pack was renumbered
+	alternatives   []xdcc.IRCFileURL
+	expectedName   string
+	expectedHash   string
+	fserve         *xdcc.FServe
+	forwardTargets []string
+	destTemplate   string
+	nameSuffix     string
+	state          itemState
+	fileName       string
+	filePath       string
+	fileSize       uint64
+	bytes          uint64
+	speed          float64
+	err            error
+	started        time.Time
+	requested      time.Time     // when the file was last requested to a bot
+	offset         uint64        // bytes already downloaded when the transfer started
+	pausing        bool          // set when the schedule closes during the transfer
+	interrupted    int           // number of times the file was requested again after losing the connection
+	received       uint64        // bytes received by this run, over every attempt
+	elapsed        time.Duration // time spent downloading by the previous attempts
+	peakSpeed      float64
+	checkpoints    []transferCheckpoint
+}
+
+type batchError struct {
+	time time.Time
+	url  xdcc.IRCFileURL
+	err  error
+}
+
+const maxRecentErrors = 10
+
+// Batch is the set of files downloaded by a single run.
+type Batch struct {
+	mu           sync.Mutex
+	items        []*batchItem
+	recentErrors []batchError
+	started      time.Time
+	stopping     bool
+	stopped      chan struct{} // closed by SoftStop
+	hooks        *hookRunner
+	notifier     *desktopNotifier          // nil unless the transfers are notified on the desktop
+	onCheckpoint func(*transferCheckpoint) // called with the batch lock held
+
+	departures        group.Group // channels being left
+	delayedDepartures bool
+	finishing         group.Group // items whose outcome is being recorded, see finishItem
+}
+
+func NewBatch(requests []downloadRequest) *Batch {
+	batch := &Batch{
+		items:        make([]*batchItem, 0, len(requests)),
+		recentErrors: make([]batchError, 0, maxRecentErrors),
+		started:      time.Now(),
+		stopped:      make(chan struct{}),
+	}
+
+	for _, req := range requests {
+		batch.items = append(batch.items, &batchItem{
+			url:            req.url,
+			source:         req.url.String(),
+			alternatives:   req.alternatives,
+			expectedName:   req.fileName,
+			expectedHash:   req.hash,
+			fserve:         req.fserve,
+			forwardTargets: req.forward,
+			state:          itemStateQueued,
+		})
+	}
+	return batch
+}
+
+// SoftStop cancels the queued and paused items, letting the active transfers finish.
+func (batch *Batch) SoftStop() {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	if !batch.stopping {
+		close(batch.stopped)
+	}
+
+	batch.stopping = true
+	for _, item := range batch.items {
+		if item.state == itemStateQueued || item.state == itemStatePaused {
+			batch.transitionLocked(item, itemStateCancelled)
+		}
+	}
+}
+
+// startItem marks the item as connecting, unless it has been cancelled.
+func (batch *Batch) startItem(item *batchItem) bool {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	if batch.stopping || item.state != itemStateQueued {
+		return false
+	}
+	batch.transitionLocked(item, itemStateConnecting)
+	return true
+}
+
+func (batch *Batch) setState(item *batchItem, state itemState) {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	batch.transitionLocked(item, state)
+}
+
+func (batch *Batch) itemState(item *batchItem) itemState {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	return item.state
+}
+
+func (batch *Batch) setStarted(item *batchItem, evt *xdcc.TransferStartedEvent) {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	item.fileName = evt.FileName
+	item.filePath = evt.FilePath
+	item.fileSize = evt.FileSize
+	item.bytes = evt.Offset
+	item.offset = evt.Offset
+	item.started = time.Now()
+	batch.transitionLocked(item, itemStateDownloading)
+}
+
+func (batch *Batch) addProgress(item *batchItem, n uint64, speed float64) {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	item.bytes += n
+	item.received += n
+	item.speed = speed
+	if speed > item.peakSpeed {
+		item.peakSpeed = speed
+	}
+	metrics.addBytes(n)
+}
+
+func (batch *Batch) receivedBytes(item *batchItem) uint64 {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	return item.bytes
+}
+
+func (batch *Batch) hasAlternatives(item *batchItem) bool {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	return len(item.alternatives) > 0
+}
+
+// switchToAlternative makes the item use the next alternative source, if any.
+func (batch *Batch) switchToAlternative(item *batchItem) bool {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	if len(item.alternatives) == 0 {
+		return false
+	}
+
+	item.url = item.alternatives[0]
+	item.alternatives = item.alternatives[1:]
+	item.err = nil
+	item.speed = 0
+	batch.transitionLocked(item, itemStateConnecting)
+	return true
+}
+
+// expectedPackName returns the file the pack of the item is expected to offer, if it is checked.
+func expectedPackName(item *batchItem, opts *transferOptions) string {
+	if opts.trustPackNumbers || item.fserve != nil {
+		return ""
+	}
+	return item.expectedName
+}
+
+// setRelocated changes the pack of the item, renumbered by the bot.
+func (batch *Batch) setRelocated(item *batchItem, slot int) {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	item.url.Slot = slot
+}
+
+func (batch *Batch) setCompleted(item *batchItem, fileSize uint64) {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	item.bytes = fileSize
+	item.speed = 0
+	batch.transitionLocked(item, itemStateCompleted)
+}
+
+func (batch *Batch) setFailed(item *batchItem, err error) {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	item.err = err
+	item.speed = 0
+	batch.transitionLocked(item, itemStateFailed)
+
+	if len(batch.recentErrors) == maxRecentErrors {
+		batch.recentErrors = batch.recentErrors[1:]
+	}
+	batch.recentErrors = append(batch.recentErrors, batchError{time: time.Now(), url: item.url, err: err})
+}
+
+// PrintStatus writes a snapshot of the active transfers, the queue and the recent errors.
+func (batch *Batch) PrintStatus(w io.Writer) {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	queued, active, completed, failed, cancelled := 0, 0, 0, 0, 0
+	for _, item := range batch.items {
+		switch item.state {
+		case itemStateCancelled, itemStateSkipped:
+			cancelled++
+		case itemStateQueued, itemStatePaused:
+			queued++
+		case itemStateConnecting, itemStateDownloading:
+			active++
+		case itemStateCompleted:
+			completed++
+		case itemStateFailed:
+			failed++
+		}
+	}
+
+	fmt.Fprintf(w, "\n=== status after %s: %d active, %d queued, %d completed, %d failed, %d cancelled\n",
+		time.Since(batch.started).Round(time.Second), active, queued, completed, failed, cancelled)
+
+	for _, item := range batch.items {
+		switch item.state {
+		case itemStateConnecting:
+			fmt.Fprintf(w, "  %s: connecting\n", item.url.String())
+		case itemStateDownloading:
+			percent := 0.0
+			if item.fileSize > 0 {
+				percent = 100 * float64(item.bytes) / float64(item.fileSize)
+			}
+			fmt.Fprintf(w, "  %s: %s %s / %s (%.1f%%) at %s/s, running for %s\n",
+				item.url.String(), item.fileName, formatSize(int64(item.bytes)), formatSize(int64(item.fileSize)),
+				percent, formatSize(int64(item.speed)), time.Since(item.started).Round(time.Second))
+		default:
+			continue
+		}
+
+		if tags, _ := userNotes.lookupURL(&item.url); len(tags) > 0 {
+			fmt.Fprintf(w, "    tags: %s\n", strings.Join(tags, ", "))
+		}
+	}
+
+	if len(batch.recentErrors) > 0 {
+		fmt.Fprintf(w, "recent errors:\n")
+		for _, e := range batch.recentErrors {
+			fmt.Fprintf(w, "  %s %s: %s\n", e.time.Format("15:04:05"), e.url.String(), e.err)
+		}
+	}
+}
+
+// transferFailure is a failed transfer of the summary.
+type transferFailure struct {
+	Source string `json:"source"`
+	Error  string `json:"error"`
+	Reason string `json:"reason"` // see failureReason
+}
+
+// transferSummary is the machine-readable report printed at the end of a quiet run.
+type transferSummary struct {
+	Completed int               `json:"completed"`
+	Failed    int               `json:"failed"`
+	Cancelled int               `json:"cancelled"`
+	Skipped   int               `json:"skipped"`
+	Bytes     uint64            `json:"bytes"`
+	Failures  []transferFailure `json:"failures,omitempty"`
+}
+
+func (batch *Batch) Summary() transferSummary {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	summary := transferSummary{}
+	for _, item := range batch.items {
+		switch item.state {
+		case itemStateCompleted:
+			summary.Completed++
+		case itemStateCancelled:
+			summary.Cancelled++
+		case itemStateSkipped:
+			summary.Skipped++
+		default:
+			summary.Failed++
+			if item.err != nil {
+				summary.Failures = append(summary.Failures, transferFailure{Source: item.url.String(), Error: item.err.Error(), Reason: failureReason(item.err)})
+			}
+		}
+		summary.Bytes += item.bytes
+	}
+	return summary
+}
+
+func printSummary(summary *transferSummary) {
+	data, _ := json.Marshal(summary)
+	fmt.Println(string(data))
+}
+
+func shellQuote(s string) string {
+	safe := true
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_-./:#@%+=,", r)) {
+			safe = false
+			break
+		}
+	}
+
+	if safe && s != "" {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", "'\\''", -1) + "'"
+}
+
+// getCommandLine returns the command downloading the url with the given options.
+func getCommandLine(url xdcc.IRCFileURL, opts *transferOptions, destTemplate string, collisionPolicy string) string {
+	args := []string{filepath.Base(os.Args[0]), "get", url.String(), "-o", opts.path}
+	if destTemplate != "" {
+		args = append(args, "--dest-template", destTemplate)
+	}
+	args = append(args, "--on-collision", collisionPolicy)
+
+	if opts.skipCertificateCheck {
+		args = append(args, "--allow-unknown-authority")
+	}
+	if opts.noSSL {
+		args = append(args, "--no-ssl")
+	}
+	if opts.requireTLSDCC {
+		args = append(args, "--require-tls-dcc")
+	}
+
+	for i := range args {
+		args[i] = shellQuote(args[i])
+	}
+	return strings.Join(args, " ")
+}
+
+// printRetryHints prints the commands which resume or retry a failed transfer.
+func printRetryHints(batch *Batch, item *batchItem, opts *transferOptions) {
+	if item.fserve != nil {
+		return // not a pack
+	}
+
+	batch.mu.Lock()
+	bytes := item.bytes
+	batch.mu.Unlock()
+
+	if bytes > 0 {
+		logError("to resume from byte %d: %s", bytes, getCommandLine(item.url, opts, item.destTemplate, xdcc.CollisionResume))
+	} else {
+		logError("to retry: %s", getCommandLine(item.url, opts, item.destTemplate, opts.collisionPolicy))
+	}
+
+	if len(item.alternatives) > 0 {
+		logError("fallback source: %s", getCommandLine(item.alternatives[0], opts, item.destTemplate, xdcc.CollisionResume))
+	}
+}
+
+var errTooSlow = errors.New("transfer too slow")
+
+// speedMonitor detects transfers whose speed stays below a minimum for a given time.
+type speedMonitor struct {
+	minSpeed   float64
+	window     time.Duration
+	lastBytes  uint64
+	lastCheck  time.Time
+	slowSince  time.Time
+	monitoring bool
+}
+
+func newSpeedMonitor(minSpeed int64, window time.Duration) *speedMonitor {
+	return &speedMonitor{minSpeed: float64(minSpeed), window: window}
+}
+
+// check updates the monitor with the number of received bytes and
+// returns true if the transfer has been too slow for the whole window.
+func (monitor *speedMonitor) check(bytes uint64, now time.Time) bool {
+	if monitor.minSpeed <= 0 {
+		return false
+	}
+
+	if !monitor.monitoring {
+		monitor.monitoring = true
+		monitor.lastBytes, monitor.lastCheck = bytes, now
+		return false
+	}
+
+	elapsed := now.Sub(monitor.lastCheck).Seconds()
+	if elapsed <= 0 {
+		return false
+	}
+
+	speed := float64(bytes-monitor.lastBytes) / elapsed
+	monitor.lastBytes, monitor.lastCheck = bytes, now
+
+	if speed >= monitor.minSpeed {
+		monitor.slowSince = time.Time{}
+		return false
+	}
+
+	if monitor.slowSince.IsZero() {
+		monitor.slowSince = now
+	}
+	return now.Sub(monitor.slowSince) >= monitor.window
+}
+
+const speedCheckInterval = time.Second
+
+// transferLoop follows the transfer until it ends, aborting it through abort if it is too slow.
+// It returns true if the transfer has been aborted for being too slow.
+func transferLoop(ctx context.Context, abort context.CancelFunc, transfer *xdcc.Transfer, batch *Batch, item *batchItem, opts *transferOptions) bool {
+	pb := NewProgressBar()
+
+	ticker := time.NewTicker(speedCheckInterval)
+	defer ticker.Stop()
+
+	monitor := newSpeedMonitor(opts.minSpeed, opts.minSpeedWindow)
+	downloading := false
+	tooSlow := false
+
+	evts := transfer.PollEvents()
+	quit := false
+	for !quit {
+		var e xdcc.TransferEvent
+		select {
+		case e = <-evts:
+		case now := <-ticker.C:
+			if downloading && !tooSlow && monitor.check(batch.receivedBytes(item), now) {
+				tooSlow = true
+				abort()
+			}
+			continue
+		}
+
+		switch evtType := e.(type) {
+		case *xdcc.TransferStartedEvent:
+			downloading = true
+			batch.setStarted(item, evtType)
+			pb.SetTotal(int(evtType.FileSize))
+			pb.SetFileName(evtType.FileName)
+			pb.SetState(ProgressStateDownloading)
+			pb.Increment(int(evtType.Offset))
+		case *xdcc.TransferProgressEvent:
+			batch.addProgress(item, evtType.Bytes, float64(evtType.Rate))
+			pb.Increment(int(evtType.Bytes))
+		case *xdcc.TransferCompletedEvent:
+			if opts.verifyCRC {
+				if err := verifyCRC(item); err != nil {
+					batch.setFailed(item, err)
+					pb.SetState(ProgressStateAborted)
+					logError("%s: %s", transfer.URL().String(), err)
+					quit = true
+					break
+				}
+			}
+			batch.setCompleted(item, evtType.FileSize)
+			pb.SetState(ProgressStateCompleted)
+			quit = true
+		case *xdcc.TransferQueuedEvent:
+			logInfo("%s: %s by the bot", transfer.URL().String(), queueStatus(evtType))
+			batch.notifier.notifyQueued(item, transfer.URL().String(), evtType.Position)
+		case *xdcc.TransferRelocatedEvent:
+			batch.setRelocated(item, evtType.NewSlot)
+			logWarn("%s: pack #%d now offers %s, requesting #%d instead", transfer.URL().String(), evtType.OldSlot, evtType.FileName, evtType.NewSlot)
+		case *xdcc.TransferSkippedEvent:
+			batch.setState(item, itemStateSkipped)
+			pb.SetState(ProgressStateAborted)
+			logInfo("%s: skipping %s, %s", transfer.URL().String(), evtType.FileName, evtType.Reason)
+			quit = true
+		case *xdcc.TransferAbortedEvent:
+			pb.SetState(ProgressStateAborted)
+			if !tooSlow && batch.isPausing(item) {
+				batch.setPaused(item)
+				logInfo("%s: paused until the schedule reopens", transfer.URL().String())
+				quit = true
+				break
+			}
+
+			if !tooSlow && ctx.Err() == nil && evtType.Interrupted && batch.setInterrupted(item, opts.retries) {
+				logWarn("%s: %s, requesting the file again", transfer.URL().String(), evtType.Error)
+				quit = true
+				break
+			}
+
+			switch {
+			case tooSlow:
+				batch.setFailed(item, errTooSlow)
+				logError("%s: slower than %s/s for %s", transfer.URL().String(), formatSize(opts.minSpeed), opts.minSpeedWindow)
+			case ctx.Err() != nil:
+				batch.setState(item, itemStateCancelled)
+				logInfo("%s: cancelled", transfer.URL().String())
+			case evtType.Refused:
+				batch.setFailed(item, &transferError{reason: failureBotRefused, msg: evtType.Error})
+				logError("%s: %s", transfer.URL().String(), evtType.Error)
+			default:
+				batch.setFailed(item, errors.New(evtType.Error))
+				logError("%s: %s", transfer.URL().String(), evtType.Error)
+			}
+
+			if !tooSlow || !batch.hasAlternatives(item) {
+				printRetryHints(batch, item, opts)
+			}
+			quit = true
+		}
+	}
+	// TODO: do clean-up operations here
+	return tooSlow
+}
+
+func suggestUnknownAuthoritySwitch(err error) {
+	if err.Error() == (x509.UnknownAuthorityError{}.Error()) {
+		logInfo("use the --allow-unknown-authority flag to skip certificate verification")
+	}
+}
+
+func newItemTransfer(item *batchItem, opts *transferOptions, collisionPolicy string) *xdcc.Transfer {
+	return xdcc.NewTransfer(item.url, xdcc.TransferConfig{
+		FilePath:             opts.path,
+		DestTemplate:         item.destTemplate,
+		NameSuffix:           item.nameSuffix,
+		CollisionPolicy:      collisionPolicy,
+		EnableSSL:            !opts.noSSL,
+		SkipCertificateCheck: opts.skipCertificateCheck,
+		RequireTLSDCC:        opts.requireTLSDCC,
+		NoAutoJoin:           opts.noAutoJoin,
+		FServe:               item.fserve,
+		TrustFileNames:       opts.trustFileNames,
+		ExpectedName:         expectedPackName(item, opts),
+		ResumeStore:          resumeStore,
+		ReserveSpace:         reserveSpace(opts),
+		DirectIO:             opts.directIO,
+		VerifyBot:            verifyBot(opts),
+	})
+}
+
+// reserveSpace returns the function checking the disk space available for the transfers, unless forced.
+func reserveSpace(opts *transferOptions) func(string, uint64) (func(), error) {
+	if opts.force {
+		return nil
+	}
+	return diskSpace.Reserve
+}
+
+// interruptedRetryDelay is the time waited before requesting again a file whose transfer was interrupted.
+const interruptedRetryDelay = 5 * time.Second
+
+// setInterrupted marks the item as connecting again after its transfer lost the connection,
+// returning false if the file has already been requested again maxRetries times.
+func (batch *Batch) setInterrupted(item *batchItem, maxRetries int) bool {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	if batch.stopping || item.interrupted >= maxRetries {
+		return false
+	}
+	item.interrupted++
+	item.speed = 0
+	batch.transitionLocked(item, itemStateConnecting)
+	return true
+}
+
+// doTransfer downloads the file of the item. Transfers which are too slow
+// are retried from the alternative sources, if any, and the interrupted ones
+// are requested again, resuming the file.
+func doTransfer(ctx context.Context, batch *Batch, item *batchItem, opts *transferOptions) {
+	defer batch.finishItem(item)
+	defer batch.notifyDone(item)
+	start := time.Now()
+	defer func() { metrics.transferFinished(batch.itemState(item), time.Since(start)) }()
+
+	if opts.mirror {
+		batch.selectMirror(ctx, item, opts)
+	}
+
+	collisionPolicy := opts.collisionPolicy
+	for {
+		transfer := newItemTransfer(item, opts, collisionPolicy)
+
+		batch.setRequested(item)
+		transferCtx, abort := context.WithCancel(ctx)
+		stopPausing := batch.pauseAtScheduleEnd(item, abort, opts)
+		err := config.Networks.Check(item.url.Network)
+		if err == nil && !opts.force {
+			err = config.Bots.Check(item.url.Network, item.url.UserName)
+		}
+		if err == nil {
+			err = transfer.Start(transferCtx)
+		}
+
+		if err != nil {
+			stopPausing()
+			abort()
+			batch.setFailed(item, err)
+			logError("%s: %s", transfer.URL().String(), err)
+			suggestUnknownAuthoritySwitch(err)
+			printRetryHints(batch, item, opts)
+			return
+		}
+
+		tooSlow := transferLoop(transferCtx, abort, transfer, batch, item, opts)
+		stopPausing()
+		abort()
+		transfer.Wait()
+		batch.recordBotActivity(item)
+		batch.recordBotIdentity(item, transfer)
+
+		if batch.itemState(item) == itemStatePaused {
+			if !batch.waitSchedule(ctx, opts) {
+				batch.setState(item, itemStateCancelled)
+				return
+			}
+			if !batch.resumePaused(item) {
+				return
+			}
+
+			logInfo("resuming %s", item.url.String())
+			collisionPolicy = xdcc.CollisionResume
+			continue
+		}
+
+		if batch.itemState(item) == itemStateConnecting {
+			// interrupted: the old connection is dropped, and the file requested again
+			transfer.Leave(ctx, xdcc.Departure{Mode: xdcc.DepartImmediately})
+			select {
+			case <-time.After(interruptedRetryDelay):
+			case <-ctx.Done():
+				batch.setState(item, itemStateCancelled)
+				return
+			}
+
+			logInfo("requesting %s again", item.url.String())
+			collisionPolicy = xdcc.CollisionResume
+			continue
+		}
+
+		if !tooSlow {
+			// aborted transfers have already left the network
+			batch.leave(ctx, transfer, departureFor(&item.url, opts))
+			return
+		}
+
+		if !batch.switchToAlternative(item) {
+			return
+		}
+
+		logInfo("retrying from %s", item.url.String())
+		// the alternative source offers the same file: keep what was already downloaded
+		collisionPolicy = xdcc.CollisionResume
+	}
+}
+
+// finishItem records the final state of the item in the history, then runs its hooks. Since the
+// completed file is hashed first, once for both, this is done in the background so that the
+// transfer slot is released.
+func (batch *Batch) finishItem(item *batchItem) {
+	entry := batch.downloadEntry(item)
+	payload := batch.finalHookPayload(item)
+
+	batch.finishing.Go(func() error {
+		if entry.Outcome == string(itemStateCompleted) && entry.File != "" {
+			hashes, err := fileContentHashes(entry.File)
+			if err != nil {
+				logWarn("unable to hash %s: %s", entry.File, err)
+			}
+			entry.Hashes = hashes
+		}
+		recordHistory(entry)
+
+		if payload != nil {
+			if entry.Hashes != nil && payload.Event == hookOnComplete {
+				payload.SHA256 = entry.Hashes.SHA256
+			}
+			batch.hooks.Run(payload)
+		}
+		return nil
+	})
+}
+
+// departureFor returns when to leave the channel of url, according to the options and the configuration.
+func departureFor(url *xdcc.IRCFileURL, opts *transferOptions) xdcc.Departure {
+	departure := config.Departure(url)
+	if opts.departureMode != "" {
+		departure.Mode = opts.departureMode
+	}
+	if opts.departureDelay > 0 {
+		departure.Delay = opts.departureDelay
+	}
+	if departure.Mode == xdcc.DepartNever && opts.daemon {
+		// the daemon runs until stopped: the channels are left after the delay instead
+		departure.Mode = xdcc.DepartAfterDelay
+	}
+	return departure
+}
+
+// leave leaves the channel of the transfer in background, as specified by departure.
+func (batch *Batch) leave(ctx context.Context, transfer *xdcc.Transfer, departure xdcc.Departure) {
+	if departure.Mode == xdcc.DepartAfterDelay && departure.Delay > 0 {
+		batch.mu.Lock()
+		batch.delayedDepartures = true
+		batch.mu.Unlock()
+	}
+
+	batch.departures.Go(func() error {
+		transfer.Leave(ctx, departure)
+		return nil
+	})
+}
+
+// waitDepartures waits for the channels of the batch to be left.
+func (batch *Batch) waitDepartures() {
+	batch.mu.Lock()
+	delayed := batch.delayedDepartures
+	batch.mu.Unlock()
+
+	if delayed {
+		logInfo("waiting before leaving the IRC channels (see --part)")
+	}
+	batch.departures.Wait()
+}
+
+// validateTransferOptions checks the policies given on the command line.
+func validateTransferOptions(opts *transferOptions) error {
+	if !xdcc.IsValidCollisionPolicy(opts.collisionPolicy) {
+		return fmt.Errorf("invalid collision policy: %s", opts.collisionPolicy)
+	}
+
+	if !isValidChecksumFormat(opts.checksumFormat) || !isValidChecksumScope(opts.checksumScope) {
+		return fmt.Errorf("invalid checksum format or scope: %s, %s", opts.checksumFormat, opts.checksumScope)
+	}
+
+	if opts.batchConflictPolicy != BatchConflictSuffix && opts.batchConflictPolicy != BatchConflictBotDir {
+		return fmt.Errorf("invalid batch conflict policy: %s", opts.batchConflictPolicy)
+	}
+
+	if opts.mirrorRace && !opts.mirror {
+		return errors.New("--mirror-race requires --mirror")
+	}
+
+	if opts.ignoreFailures && opts.failFast {
+		return errors.New("--ignore-failures and --fail-fast cannot be used together")
+	}
+
+	if !isValidStatsFormat(opts.stats) {
+		return fmt.Errorf("invalid stats format: %s", opts.stats)
+	}
+
+	if opts.retries < 0 {
+		return fmt.Errorf("invalid number of retries: %d", opts.retries)
+	}
+
+	if err := checkSchedule(opts); err != nil {
+		return err
+	}
+
+	x, err := newExtractor(opts)
+	if err != nil {
+		return err
+	}
+	opts.extractor = x
+
+	fwd, err := newForwarder(opts)
+	if err != nil {
+		return err
+	}
+	opts.forwarder = fwd
+
+	if fwd.deleteLocal && x != nil {
+		return errors.New("the forwarded files cannot be both extracted and deleted")
+	}
+
+	if opts.departureMode != "" && !xdcc.IsValidDepartureMode(opts.departureMode) {
+		return fmt.Errorf("invalid part mode: %s", opts.departureMode)
+	}
+
+	for _, rule := range config.Departures {
+		if rule.Mode != "" && !xdcc.IsValidDepartureMode(rule.Mode) {
+			return fmt.Errorf("invalid part mode in the configuration: %s", rule.Mode)
+		}
+	}
+	return nil
+}
+
+// newTransferBatch creates the batch of the requests, giving distinct destinations to conflicting files.
+func newTransferBatch(requests []downloadRequest, opts *transferOptions) *Batch {
+	batch := NewBatch(requests)
+	batch.resolveConflicts(opts.destTemplate, opts.batchConflictPolicy)
+	if opts.notify || config.Notifications.Desktop {
+		batch.notifier = newDesktopNotifier(config.Notifications.Events)
+	}
+	return batch
+}
+
+// runBatch downloads the files of the batch, then writes the checksum files and the manifest.
+func runBatch(ctx context.Context, batch *Batch, opts *transferOptions) {
+	batch.hooks = newHookRunner(&config.Hooks)
+	defer batch.hooks.Wait()
+
+	if batch.hooks.hasHooks(hookOnQueue) {
+		for _, item := range batch.items {
+			batch.hooks.Run(batch.hookPayload(item, hookOnQueue))
+		}
+	}
+
+	if opts.checkpointInterval > 0 {
+		checkpointCtx, stopCheckpoints := context.WithCancel(ctx)
+		sampled := make(chan struct{})
+		go func() {
+			batch.sampleCheckpoints(checkpointCtx, opts.checkpointInterval)
+			close(sampled)
+		}()
+
+		defer func() {
+			stopCheckpoints()
+			<-sampled
+		}()
+	}
+
+	ctx, abort := context.WithCancel(ctx)
+	defer abort()
+
+	if opts.schedule != nil && !opts.schedule.isOpen(time.Now()) {
+		logInfo("waiting for the schedule to open at %s", opts.schedule.next(time.Now(), true).Format("Mon 15:04"))
+	}
+
+	g := &group.Group{}
+	g.SetLimit(opts.maxParallel)
+	scheduled := &group.Group{} // items waiting for the window of their bot, without holding a slot
+	for _, item := range batch.items {
+		item := item
+		run := func() error {
+			// the item may have been cancelled while waiting for a free slot or for the schedule
+			if ctx.Err() == nil && batch.waitSchedule(ctx, opts) && batch.startItem(item) {
+				doTransfer(ctx, batch, item, opts)
+			}
+
+			// unless failing fast, a failed transfer does not stop the others
+			if opts.failFast && batch.itemState(item) == itemStateFailed {
+				batch.SoftStop()
+				abort()
+			}
+			return nil
+		}
+
+		if next := batch.botWindow(item, opts); !next.IsZero() {
+			scheduled.Go(func() error {
+				batch.waitUntil(ctx, next)
+				g.Go(run) // skipped if the batch was stopped meanwhile
+				return nil
+			})
+			continue
+		}
+		g.Go(run)
+	}
+	scheduled.Wait()
+	g.Wait()
+	batch.finishing.Wait() // before the files are moved
+
+	if opts.checksumFormat != "" {
+		writeChecksumFiles(batch, opts.checksumFormat, opts.checksumScope)
+	}
+
+	if opts.manifestPath != "" {
+		if err := writeManifest(batch, opts.manifestPath); err != nil {
+			logError("unable to write manifest: %s", err)
+		}
+	}
+
+	if opts.forwarder != nil {
+		forwardFiles(ctx, batch, opts.forwarder, opts.path)
+	}
+
+	if opts.extractor != nil {
+		extractArchives(ctx, batch, opts.extractor)
+	}
+
+	batch.waitDepartures()
+}
+
+// downloadFiles downloads the requested files. It returns a commandFailure if some of them failed,
+// unless failures are ignored, or if the batch was cancelled.
+func downloadFiles(ctx context.Context, requests []downloadRequest, opts *transferOptions) error {
+	if err := validateTransferOptions(opts); err != nil {
+		logError(err.Error())
+		os.Exit(1)
+	}
+
+	if opts.dryRun {
+		return dryRunFiles(ctx, requests, opts)
+	}
+
+	if !opts.force {
+		if err := checkBatchSpace(requests, opts); err != nil {
+			logError(err.Error())
+			return newCommandFailure(failureError, "%s", err)
+		}
+	}
+
+	batch := newTransferBatch(requests, opts)
+
+	if opts.checkpointsPath != "" {
+		w, err := newCheckpointWriter(opts.checkpointsPath)
+		if err != nil {
+			logError("unable to write checkpoints: %s", err)
+			os.Exit(1)
+		}
+		defer w.Close()
+		batch.onCheckpoint = w.Write
+	}
+
+	if opts.onDone != nil {
+		write := batch.onCheckpoint
+		batch.onCheckpoint = func(cp *transferCheckpoint) {
+			if write != nil {
+				write(cp)
+			}
+			if cp.State == itemStateCompleted || cp.State == itemStateSkipped {
+				opts.onDone(cp.Source)
+			}
+		}
+	}
+
+	stopStatusRequests := handleStatusRequests(batch, !opts.stdinCommands)
+	defer stopStatusRequests()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stopInterruptHandling := handleInterrupts(batch, cancel)
+	defer stopInterruptHandling()
+
+	runBatch(ctx, batch, opts)
+
+	if opts.metricsPath != "" {
+		if err := writeMetricsFile(opts.metricsPath, batch); err != nil {
+			logError("unable to write metrics: %s", err)
+		}
+	}
+
+	summary := batch.Summary()
+	printStats(batch, opts.stats)
+	batch.notifyBatchDone(&summary)
+	batch.notifier.Wait()
+
+	if summary.Failed > 0 && !opts.ignoreFailures {
+		return transfersFailure(summary.Failed, summary.Failures)
+	}
+	if summary.Cancelled > 0 {
+		return newCommandFailure(failureCancelled, "%d of the transfers were cancelled", summary.Cancelled)
+	}
+	return nil
+}
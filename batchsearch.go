@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ostafen/xdcc-cli/pkg/search"
+)
+
+// batchQuery is a query of a batch search, along with its results.
+type batchQuery struct {
+	Query   string
+	Results []search.FileInfo
+}
+
+// readBatchQueries reads the queries of a batch search, one per line, from the file at path
+// or from stdin if path is "-". Blank lines and lines starting with # are skipped.
+func readBatchQueries(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	queries := make([]string, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		queries = append(queries, line)
+	}
+	return queries, scanner.Err()
+}
+
+// runBatchSearch runs the queries one after the other, applying the filter to the results of each.
+// The queries left when ctx is cancelled are not run.
+func runBatchSearch(ctx context.Context, queries []string, filter *ResultFilter) []batchQuery {
+	batch := make([]batchQuery, 0, len(queries))
+	for _, query := range queries {
+		if ctx.Err() != nil {
+			break
+		}
+
+		queryFilter := *filter
+		queryFilter.Query = ParseSearchQuery(query)
+		if len(queryFilter.Query.KeywordSets()) < 1 {
+			logWarn("%q: no keyword, skipped", query)
+			continue
+		}
+
+		resultsChan, numResults := searchQueryAsync(ctx, queryFilter.Query)
+		res, _, _ := collectResults(FilterResultsAsync(resultsChan, &queryFilter), numResults, 0)
+
+		outcome := "completed"
+		if ctx.Err() != nil {
+			outcome = "interrupted"
+		}
+		recordSearch(query, len(res), outcome)
+		batch = append(batch, batchQuery{Query: query, Results: res})
+	}
+	return batch
+}
+
+// topResult returns the preferred result of a query: the one with the highest value of the
+// sort key (the most gets by default) among the results of the highest priority, if any.
+func topResult(res []search.FileInfo, sortBy string) *search.FileInfo {
+	if len(res) == 0 {
+		return nil
+	}
+
+	sorted := append([]search.FileInfo(nil), res...)
+	sortResults(sorted, sortBy)
+
+	// the results of the highest priority come first, in ascending order of the sort key
+	top := 0
+	for i := 1; i < len(sorted); i++ {
+		if config.ResultPriority(&sorted[i]) != config.ResultPriority(&sorted[0]) {
+			break
+		}
+		top = i
+	}
+	return &sorted[top]
+}
+
+// printBatchResults prints the results of each query of a batch search, labelled with the query.
+func printBatchResults(batch []batchQuery, opts *printOptions) {
+	switch opts.format {
+	case outputFormatJSON:
+		printBatchResultsJSON(batch, opts)
+		return
+	case outputFormatCSV:
+		printBatchResultsCSV(batch, opts)
+		return
+	}
+
+	if r, ok := markupRenderers[opts.format]; ok {
+		printBatchResultsMarkup(r, batch, opts)
+		return
+	}
+
+	for i := range batch {
+		q := &batch[i]
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("=== %s: %d results\n", q.Query, len(q.Results))
+		printResults(q.Results, opts)
+	}
+}
+
+func printBatchResultsJSON(batch []batchQuery, opts *printOptions) {
+	type queryRecord struct {
+		Query   string         `json:"query"`
+		Results []resultRecord `json:"results"`
+	}
+
+	records := make([]queryRecord, 0, len(batch))
+	for i := range batch {
+		res := batch[i].Results
+		sortResults(res, opts.sortBy)
+		start, end := pageBounds(len(res), opts.limit, opts.page)
+		records = append(records, queryRecord{Query: batch[i].Query, Results: newResultRecords(res[start:end], opts)})
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(records); err != nil {
+		logError("unable to print results: %s", err)
+	}
+}
+
+// printBatchResultsCSV prints the displayed columns of the results, with the query and the number
+// of each result among the results of its query.
+func printBatchResultsCSV(batch []batchQuery, opts *printOptions) {
+	all := make([]search.FileInfo, 0)
+	for i := range batch {
+		all = append(all, batch[i].Results...)
+	}
+	columns := displayedColumns(all, opts)
+
+	w := csv.NewWriter(os.Stdout)
+	header := []string{"query", "#"}
+	for _, col := range columns {
+		header = append(header, col.name)
+	}
+	w.Write(header)
+
+	for i := range batch {
+		res := batch[i].Results
+		sortResults(res, opts.sortBy)
+		start, end := pageBounds(len(res), opts.limit, opts.page)
+		for j := start; j < end; j++ {
+			record := []string{batch[i].Query, strconv.Itoa(j + 1)}
+			for _, col := range columns {
+				record = append(record, col.value(&res[j], opts))
+			}
+			w.Write(record)
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		logError("unable to print results: %s", err)
+	}
+}
+
+// batchSearchCommand runs the queries read from path, printing their results and downloading
+// the top result of each one if top is set.
+func batchSearchCommand(path string, filter *ResultFilter, printOpts *printOptions, top bool, opts *transferOptions) {
+	queries, err := readBatchQueries(path)
+	if err != nil {
+		logError("search: unable to read the queries: %s", err)
+		os.Exit(1)
+	}
+
+	if len(queries) == 0 {
+		fmt.Println("search: no query provided.")
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stopInterrupts := cancelOnInterrupt(cancel)
+	batch := runBatchSearch(ctx, queries, filter)
+	stopInterrupts()
+
+	if ctx.Err() != nil {
+		logInfo("search interrupted after %d of %d queries", len(batch), len(queries))
+	}
+	printBatchResults(batch, printOpts)
+
+	if ctx.Err() != nil {
+		exitWithFailure(newCommandFailure(failureCancelled, "search interrupted after %d of %d queries", len(batch), len(queries)))
+	}
+
+	found := false
+	for i := range batch {
+		found = found || len(batch[i].Results) > 0
+	}
+	if !found {
+		exitWithFailure(newCommandFailure(failureNoResults, "no results for any query"))
+	}
+
+	if !top {
+		return
+	}
+
+	picked := make([]search.FileInfo, 0, len(batch))
+	all := make([]search.FileInfo, 0)
+	for i := range batch {
+		all = append(all, batch[i].Results...)
+		if info := topResult(batch[i].Results, printOpts.sortBy); info != nil {
+			picked = append(picked, *info)
+		} else {
+			logWarn("%q: no result", batch[i].Query)
+		}
+	}
+
+	if err := downloadResults(context.Background(), picked, all, opts); err != nil {
+		exitWithFailure(err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const bookmarksFileName = "bookmarks.json"
+
+// bookmark is either a recurring search query or a favorite bot.
+type bookmark struct {
+	Query []string `json:"query,omitempty"` // arguments of the search subcommand
+	Bot   string   `json:"bot,omitempty"`   // irc://network/channel/bot
+}
+
+// parseBotURL parses an url of the form irc://network/channel/bot.
+func parseBotURL(url string) (string, bool) {
+	if !strings.HasPrefix(url, "irc://") {
+		return "", false
+	}
+
+	fields := strings.Split(strings.TrimPrefix(url, "irc://"), "/")
+	if len(fields) != 3 || fields[0] == "" || strings.TrimPrefix(fields[1], "#") == "" || fields[2] == "" {
+		return "", false
+	}
+	return "irc://" + strings.Join(fields, "/"), true
+}
+
+func loadBookmarks() (map[string]*bookmark, string, error) {
+	path, err := dataFilePath(bookmarksFileName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	bookmarks := make(map[string]*bookmark)
+	if err := readJSONFile(path, &bookmarks); err != nil {
+		return nil, "", err
+	}
+	return bookmarks, path, nil
+}
+
+func mustLoadBookmarks() (map[string]*bookmark, string) {
+	bookmarks, path, err := loadBookmarks()
+	if err != nil {
+		logError("unable to load bookmarks: %s", err)
+		os.Exit(1)
+	}
+	return bookmarks, path
+}
+
+func mustSaveBookmarks(path string, bookmarks map[string]*bookmark) {
+	if err := writeJSONFile(path, bookmarks); err != nil {
+		logError("unable to save bookmarks: %s", err)
+		os.Exit(1)
+	}
+}
+
+func printBookmarks(bookmarks map[string]*bookmark) {
+	names := make([]string, 0, len(bookmarks))
+	for name := range bookmarks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	printer := NewTablePrinter([]string{"Name", "Type", "Bookmark"})
+	for _, name := range names {
+		b := bookmarks[name]
+		if b.Bot != "" {
+			printer.AddRow(Row{name, "bot", b.Bot})
+		} else {
+			printer.AddRow(Row{name, "query", strings.Join(b.Query, " ")})
+		}
+	}
+	printer.Print()
+}
+
+// runBookmark searches a query bookmark, or downloads the given slots of a bot bookmark.
+func runBookmark(b *bookmark, args []string) {
+	if b.Query != nil {
+		searchCommand(append(append([]string(nil), b.Query...), args...))
+		return
+	}
+
+	getArgs := make([]string, 0, len(args))
+	for i, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			getArgs = append(getArgs, args[i:]...)
+			break
+		}
+		getArgs = append(getArgs, b.Bot+"/#"+strings.TrimPrefix(arg, "#"))
+	}
+
+	if len(getArgs) == 0 || strings.HasPrefix(getArgs[0], "-") {
+		fmt.Println("bookmark run: the slots to download from the bot are expected")
+		os.Exit(1)
+	}
+	getCommand(getArgs)
+}
+
+func printBookmarkUsageAndExit() {
+	fmt.Println("usage:")
+	fmt.Println("  xdcc bookmark add <name> <query>...")
+	fmt.Println("  xdcc bookmark add <name> irc://network/channel/bot")
+	fmt.Println("  xdcc bookmark list")
+	fmt.Println("  xdcc bookmark remove <name>")
+	fmt.Println("  xdcc bookmark run <name> [search flags]")
+	fmt.Println("  xdcc bookmark run <name> <slot>... [get flags]")
+	os.Exit(1)
+}
+
+func bookmarkCommand(args []string) {
+	if len(args) < 1 {
+		printBookmarkUsageAndExit()
+	}
+
+	bookmarks, path := mustLoadBookmarks()
+
+	switch args[0] {
+	case "add":
+		if len(args) < 3 {
+			printBookmarkUsageAndExit()
+		}
+
+		if bot, ok := parseBotURL(args[2]); ok && len(args) == 3 {
+			bookmarks[args[1]] = &bookmark{Bot: bot}
+		} else {
+			bookmarks[args[1]] = &bookmark{Query: args[2:]}
+		}
+		mustSaveBookmarks(path, bookmarks)
+	case "list":
+		printBookmarks(bookmarks)
+	case "remove":
+		if len(args) != 2 {
+			printBookmarkUsageAndExit()
+		}
+
+		if _, ok := bookmarks[args[1]]; !ok {
+			logError("no such bookmark: %s", args[1])
+			os.Exit(1)
+		}
+		delete(bookmarks, args[1])
+		mustSaveBookmarks(path, bookmarks)
+	case "run":
+		if len(args) < 2 {
+			printBookmarkUsageAndExit()
+		}
+
+		b, ok := bookmarks[args[1]]
+		if !ok {
+			logError("no such bookmark: %s", args[1])
+			os.Exit(1)
+		}
+		runBookmark(b, args[2:])
+	default:
+		fmt.Println("no such command: ", args[0])
+		os.Exit(1)
+	}
+}
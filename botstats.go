@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ostafen/xdcc-cli/pkg/xdcc"
+)
+
+const botStatsFileName = "bots.json"
+
+const (
+	maxHourSamples     = 20 // samples averaged per hour, so that the averages follow the changes of the bots
+	minWindowHours     = 3  // hours with samples needed to tell the slow hours of a bot
+	windowSpeedRatio   = 0.5
+	windowResponseBase = 30 * time.Second // response times are compared past this margin
+)
+
+// hourStats are the averages of the transfers started by a bot during an hour of the day.
+type hourStats struct {
+	Samples      int     `json:"samples"`
+	Speed        float64 `json:"speed"`        // bytes per second
+	ResponseTime float64 `json:"responseTime"` // seconds from the request to the first byte
+}
+
+func (h *hourStats) add(speed float64, responseTime time.Duration) {
+	if h.Samples < maxHourSamples {
+		h.Samples++
+	}
+	n := float64(h.Samples)
+	h.Speed += (speed - h.Speed) / n
+	h.ResponseTime += (responseTime.Seconds() - h.ResponseTime) / n
+}
+
+// botActivity is the activity of a bot by hour of the day, in UTC since the time zone of the bot is unknown.
+type botActivity struct {
+	Hours [24]hourStats `json:"hours"`
+}
+
+// slowHours returns the hours when the bot was much slower, or much longer to respond, than
+// during its best hours. Nothing is returned until the bot has been seen at enough hours.
+func (activity *botActivity) slowHours() [24]bool {
+	var slow [24]bool
+
+	known := 0
+	bestSpeed, bestResponse := 0.0, -1.0
+	for _, h := range activity.Hours {
+		if h.Samples == 0 {
+			continue
+		}
+
+		known++
+		if h.Speed > bestSpeed {
+			bestSpeed = h.Speed
+		}
+		if bestResponse < 0 || h.ResponseTime < bestResponse {
+			bestResponse = h.ResponseTime
+		}
+	}
+
+	if known < minWindowHours {
+		return slow
+	}
+
+	maxResponse := 2*bestResponse + windowResponseBase.Seconds()
+	for i, h := range activity.Hours {
+		// the hours without samples are not assumed to be slow
+		slow[i] = h.Samples > 0 && (h.Speed < windowSpeedRatio*bestSpeed || h.ResponseTime > maxResponse)
+	}
+	return slow
+}
+
+// nextWindow returns when the bot is next expected to be fast, which is t itself if it is already.
+func (activity *botActivity) nextWindow(t time.Time) time.Time {
+	slow := activity.slowHours()
+
+	t = t.UTC()
+	if !slow[t.Hour()] {
+		return t
+	}
+
+	next := t.Truncate(time.Hour)
+	for i := 0; i < 24; i++ {
+		next = next.Add(time.Hour)
+		if !slow[next.Hour()] {
+			break
+		}
+	}
+	return next
+}
+
+// BotStats records the speed and the response time of the bots across runs, by hour of the day.
+type BotStats struct {
+	mu   sync.Mutex
+	path string
+	bots map[string]*botActivity
+}
+
+func NewBotStats(path string) (*BotStats, error) {
+	stats := &BotStats{path: path, bots: make(map[string]*botActivity)}
+	if err := readJSONFile(path, &stats.bots); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func botStatsKey(url *xdcc.IRCFileURL) string {
+	return strings.ToLower(url.Network + "/" + url.UserName)
+}
+
+// Record adds a completed transfer, requested at the given time, to the activity of the bot.
+func (stats *BotStats) Record(url *xdcc.IRCFileURL, requested time.Time, speed float64, responseTime time.Duration) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	key := botStatsKey(url)
+	activity, ok := stats.bots[key]
+	if !ok {
+		activity = &botActivity{}
+		stats.bots[key] = activity
+	}
+	activity.Hours[requested.UTC().Hour()].add(speed, responseTime)
+
+	if err := writeJSONFile(stats.path, stats.bots); err != nil {
+		logWarn("unable to save bot statistics: %s", err)
+	}
+}
+
+// NextWindow returns when the bot is next expected to be fast, which is now if it is already or if it is unknown.
+func (stats *BotStats) NextWindow(url *xdcc.IRCFileURL, now time.Time) time.Time {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	activity, ok := stats.bots[botStatsKey(url)]
+	if !ok {
+		return now
+	}
+	if next := activity.nextWindow(now); next.After(now) {
+		return next
+	}
+	return now
+}
+
+var botStats *BotStats
+
+func setupBotStats() {
+	path, err := dataFilePath(botStatsFileName)
+	if err != nil {
+		logWarn("unable to locate bot statistics file: %s", err)
+		return
+	}
+
+	stats, err := NewBotStats(path)
+	if err != nil {
+		logWarn("unable to load bot statistics file: %s", err)
+		return
+	}
+	botStats = stats
+}
+
+func (batch *Batch) setRequested(item *batchItem) {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	item.requested = time.Now()
+}
+
+// recordBotActivity adds the transfer of the item to the statistics of its bot, if completed.
+func (batch *Batch) recordBotActivity(item *batchItem) {
+	batch.mu.Lock()
+	url, requested, started := item.url, item.requested, item.started
+	received := item.bytes - item.offset
+	completed := item.state == itemStateCompleted
+	batch.mu.Unlock()
+
+	if botStats == nil || !completed || started.Before(requested) {
+		return
+	}
+
+	elapsed := time.Since(started).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	botStats.Record(&url, requested, float64(received)/elapsed, started.Sub(requested))
+}
+
+// botWindow returns when the item should be started with --bot-windows, or the zero time if it can start now.
+func (batch *Batch) botWindow(item *batchItem, opts *transferOptions) time.Time {
+	if !opts.botWindows || botStats == nil {
+		return time.Time{}
+	}
+
+	now := time.Now()
+	next := botStats.NextWindow(&item.url, now)
+	if !next.After(now) {
+		return time.Time{}
+	}
+
+	logInfo("%s: %s is usually faster from %s, waiting until then", item.url.String(), item.url.UserName, next.Local().Format("15:04"))
+	return next
+}
+
+// waitUntil waits until t, unless the batch is stopped or the context cancelled before.
+func (batch *Batch) waitUntil(ctx context.Context, t time.Time) {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-batch.stopped:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// transferCheckpoint is a snapshot of a transfer, taken on each state transition
+// and periodically while downloading, so that its timeline can be reconstructed.
+type transferCheckpoint struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	File   string    `json:"file,omitempty"`
+	State  itemState `json:"state"`
+	Offset uint64    `json:"offset"`
+	Size   uint64    `json:"size,omitempty"`
+	Speed  float64   `json:"speed"` // bytes per second
+	Error  string    `json:"error,omitempty"`
+	Reason string    `json:"reason,omitempty"` // of the failure, see failureReason
+}
+
+const (
+	defaultCheckpointInterval = 10 * time.Second
+	maxItemCheckpoints        = 1000
+)
+
+// checkpointLocked records the current state of the item. The batch lock must be held.
+func (batch *Batch) checkpointLocked(item *batchItem) {
+	cp := transferCheckpoint{
+		Time:   time.Now(),
+		Source: item.source,
+		File:   item.fileName,
+		State:  item.state,
+		Offset: item.bytes,
+		Size:   item.fileSize,
+		Speed:  item.speed,
+	}
+
+	if item.err != nil {
+		cp.Error = item.err.Error()
+		cp.Reason = failureReason(item.err)
+	}
+
+	if len(item.checkpoints) == maxItemCheckpoints {
+		item.checkpoints = item.checkpoints[1:]
+	}
+	item.checkpoints = append(item.checkpoints, cp)
+
+	if batch.onCheckpoint != nil {
+		batch.onCheckpoint(&cp)
+	}
+}
+
+// transitionLocked changes the state of the item, recording a checkpoint. The batch lock must be held.
+func (batch *Batch) transitionLocked(item *batchItem, state itemState) {
+	if item.state == itemStateDownloading && state != itemStateDownloading {
+		item.elapsed += time.Since(item.started)
+	}
+	item.state = state
+	batch.checkpointLocked(item)
+}
+
+// sampleCheckpoints records a checkpoint of the items being downloaded at each interval, until ctx is done.
+func (batch *Batch) sampleCheckpoints(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		batch.mu.Lock()
+		for _, item := range batch.items {
+			if item.state == itemStateDownloading {
+				batch.checkpointLocked(item)
+			}
+		}
+		batch.mu.Unlock()
+	}
+}
+
+// checkpointWriter writes the checkpoints as NDJSON lines.
+type checkpointWriter struct {
+	mu   sync.Mutex
+	w    io.Writer
+	file *os.File
+}
+
+// newCheckpointWriter appends the checkpoints to the file at path, or writes them to stdout if path is "-".
+func newCheckpointWriter(path string) (*checkpointWriter, error) {
+	if path == "-" {
+		return &checkpointWriter{w: os.Stdout}, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &checkpointWriter{w: file, file: file}, nil
+}
+
+func (writer *checkpointWriter) Write(cp *transferCheckpoint) {
+	writer.mu.Lock()
+	defer writer.mu.Unlock()
+
+	if err := json.NewEncoder(writer.w).Encode(cp); err != nil {
+		logError("unable to write checkpoint: %s", err)
+	}
+}
+
+func (writer *checkpointWriter) Close() error {
+	if writer.file == nil {
+		return nil
+	}
+	return writer.file.Close()
+}
+
+// transferStatus is the current state of a transfer, along with its timeline.
+type transferStatus struct {
+	Source      string               `json:"source"`
+	File        string               `json:"file,omitempty"`
+	Path        string               `json:"path,omitempty"`
+	State       itemState            `json:"state"`
+	Offset      uint64               `json:"offset"`
+	Size        uint64               `json:"size,omitempty"`
+	Speed       float64              `json:"speed"`
+	Checkpoints []transferCheckpoint `json:"checkpoints"`
+}
+
+func (batch *Batch) transferStatuses() []transferStatus {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	statuses := make([]transferStatus, 0, len(batch.items))
+	for _, item := range batch.items {
+		statuses = append(statuses, transferStatus{
+			Source:      item.source,
+			File:        item.fileName,
+			Path:        item.filePath,
+			State:       item.state,
+			Offset:      item.bytes,
+			Size:        item.fileSize,
+			Speed:       item.speed,
+			Checkpoints: append([]transferCheckpoint(nil), item.checkpoints...),
+		})
+	}
+	return statuses
+}
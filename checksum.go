@@ -0,0 +1,195 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// fileChecksum returns the hex encoded digest of the file content.
+func fileChecksum(path string, newHash func() hash.Hash) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := newHash()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+var crc32Regexp = regexp.MustCompile(`^[0-9A-Fa-f]{8}$`)
+
+// verifyCRC checks the downloaded file of the item against its CRC32, which is the hash reported by
+// the search engine if it is one, or else the CRC32 tag of the file name. Files without any are not checked.
+func verifyCRC(item *batchItem) error {
+	expected := item.expectedHash
+	if !crc32Regexp.MatchString(expected) {
+		expected = crc32Tag(item.fileName)
+	}
+	if expected == "" {
+		return nil
+	}
+
+	sum, err := fileChecksum(item.filePath, func() hash.Hash { return crc32.NewIEEE() })
+	if err != nil {
+		return fmt.Errorf("unable to verify the CRC32: %s", err)
+	}
+
+	if !strings.EqualFold(sum, expected) {
+		return &transferError{reason: failureChecksumMismatch, msg: fmt.Sprintf("CRC32 mismatch: expected %s, got %s", strings.ToUpper(expected), strings.ToUpper(sum))}
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, error) {
+	return fileChecksum(path, sha256.New)
+}
+
+// contentHashes identifies the content of a file, regardless of its name and of the bot which sent it.
+type contentHashes struct {
+	Size   int64  `json:"size"`
+	CRC32  string `json:"crc32"` // as in the CRC32 tags of the release names
+	SHA256 string `json:"sha256"`
+}
+
+// fileContentHashes computes the hashes of the file in a single read.
+func fileContentHashes(path string) (*contentHashes, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	crc, sha := crc32.NewIEEE(), sha256.New()
+	size, err := io.Copy(io.MultiWriter(crc, sha), file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &contentHashes{
+		Size:   size,
+		CRC32:  strings.ToUpper(hex.EncodeToString(crc.Sum(nil))),
+		SHA256: hex.EncodeToString(sha.Sum(nil)),
+	}, nil
+}
+
+const (
+	ChecksumFormatSFV = "sfv"
+	ChecksumFormatMD5 = "md5"
+
+	ChecksumScopeFile = "file"
+	ChecksumScopeDir  = "dir"
+)
+
+func isValidChecksumFormat(format string) bool {
+	return format == "" || format == ChecksumFormatSFV || format == ChecksumFormatMD5
+}
+
+func isValidChecksumScope(scope string) bool {
+	return scope == ChecksumScopeFile || scope == ChecksumScopeDir
+}
+
+// checksumLine returns the line describing the file in a .sfv or .md5 file.
+func checksumLine(path string, format string) (string, error) {
+	name := filepath.Base(path)
+	if format == ChecksumFormatSFV {
+		sum, err := fileChecksum(path, func() hash.Hash { return crc32.NewIEEE() })
+		if err != nil {
+			return "", err
+		}
+		return name + " " + strings.ToUpper(sum), nil
+	}
+
+	sum, err := fileChecksum(path, md5.New)
+	if err != nil {
+		return "", err
+	}
+	return sum + "  " + name, nil
+}
+
+// checksumLineName returns the name of the file described by a checksum line.
+func checksumLineName(line string, format string) string {
+	if format == ChecksumFormatSFV {
+		if idx := strings.LastIndex(line, " "); idx >= 0 {
+			return line[:idx]
+		}
+		return line
+	}
+
+	if idx := strings.Index(line, "  "); idx >= 0 {
+		return line[idx+2:]
+	}
+	return line
+}
+
+// updateChecksumFile adds the lines to the checksum file, replacing the ones
+// describing the same files.
+func updateChecksumFile(path string, format string, lines []string) error {
+	replaced := make(map[string]bool)
+	for _, line := range lines {
+		replaced[checksumLineName(line, format)] = true
+	}
+
+	content := make([]string, 0)
+	if data, err := ioutil.ReadFile(path); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line == "" || strings.HasPrefix(line, ";") {
+				continue
+			}
+
+			if !replaced[checksumLineName(line, format)] {
+				content = append(content, line)
+			}
+		}
+	}
+	content = append(content, lines...)
+
+	header := ""
+	if format == ChecksumFormatSFV {
+		header = "; Generated by xdcc-cli\n"
+	}
+	return ioutil.WriteFile(path, []byte(header+strings.Join(content, "\n")+"\n"), 0644)
+}
+
+// writeChecksumFiles writes a .sfv or .md5 file for each completed file of the batch,
+// or for each directory containing completed files, depending on the scope.
+func writeChecksumFiles(batch *Batch, format string, scope string) {
+	linesByFile := make(map[string][]string)
+	for _, entry := range batch.manifestEntries() {
+		if entry.Status != string(itemStateCompleted) {
+			continue
+		}
+
+		line, err := checksumLine(entry.Path, format)
+		if err != nil {
+			logError("%s: unable to compute checksum: %s", entry.Path, err)
+			continue
+		}
+
+		checksumPath := entry.Path + "." + format
+		if scope == ChecksumScopeDir {
+			dir := filepath.Dir(entry.Path)
+			checksumPath = filepath.Join(dir, filepath.Base(dir)+"."+format)
+		}
+		linesByFile[checksumPath] = append(linesByFile[checksumPath], line)
+	}
+
+	for path, lines := range linesByFile {
+		if err := updateChecksumFile(path, format, lines); err != nil {
+			logError("unable to write %s: %s", path, err)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+)
+
+// ANSI escape sequences of the colors used in the tables.
+const (
+	colorReset  = "\x1b[0m"
+	colorBold   = "\x1b[1m"
+	colorDim    = "\x1b[2m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorCyan   = "\x1b[36m"
+)
+
+// colorEnabled is false when the output is not a terminal, or when colors
+// are disabled with --no-color or the NO_COLOR environment variable (see https://no-color.org).
+var colorEnabled = os.Getenv("NO_COLOR") == "" && isTerminal(os.Stdout)
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func disableColors() {
+	colorEnabled = false
+}
+
+// colorize wraps s in the given color, if colors are enabled.
+func colorize(s string, color string) string {
+	if !colorEnabled || color == "" {
+		return s
+	}
+	return color + s + colorReset
+}
@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ostafen/xdcc-cli/pkg/search"
+)
+
+// resultColumn is a column of the table of search results.
+type resultColumn struct {
+	name     string
+	header   string
+	align    Alignment
+	maxWidth int // including the padding, 0 means no limit
+	value    func(info *search.FileInfo, opts *printOptions) string
+	color    func(info *search.FileInfo) string
+	custom   bool // defined in the configuration
+}
+
+const maxNameColumnWidth = 100
+
+var resultColumns = []*resultColumn{
+	{name: "pack", header: "Pack", align: AlignLeft, value: func(info *search.FileInfo, _ *printOptions) string { return resultPackRef(info) }},
+	{name: "network", header: "Network", align: AlignLeft, value: func(info *search.FileInfo, _ *printOptions) string { return info.Network }},
+	{name: "channel", header: "Channel", align: AlignLeft, value: func(info *search.FileInfo, _ *printOptions) string { return info.Channel }},
+	{name: "bot", header: "Bot", align: AlignLeft, value: func(info *search.FileInfo, _ *printOptions) string { return info.BotName }},
+	{name: "slot", header: "Slot", align: AlignRight, value: func(info *search.FileInfo, _ *printOptions) string { return info.Slot }},
+	{name: "gets", header: "Gets", align: AlignRight, value: func(info *search.FileInfo, _ *printOptions) string { return strconv.Itoa(info.Gets) }, color: getsColor},
+	{name: "size", header: "Size", align: AlignRight, value: sizeColumnValue, color: sizeColor},
+	{name: "name", header: "File Name", align: AlignLeft, maxWidth: maxNameColumnWidth, value: func(info *search.FileInfo, _ *printOptions) string { return info.Name }},
+	{name: "hash", header: "Hash", align: AlignLeft, value: func(info *search.FileInfo, _ *printOptions) string { return resultHash(info) }},
+	{name: "url", header: "Link", align: AlignLeft, value: func(info *search.FileInfo, _ *printOptions) string { return info.Url }},
+	{name: "cmd", header: "Command", align: AlignLeft, value: func(info *search.FileInfo, _ *printOptions) string { return info.Command }},
+	{name: "added", header: "Added", align: AlignLeft, value: func(info *search.FileInfo, _ *printOptions) string { return formatTimestamp(info.Added) }},
+	{name: "announced", header: "Announced", align: AlignLeft, value: func(info *search.FileInfo, _ *printOptions) string { return formatTimestamp(info.LastAnnounced) }},
+	{name: "tags", header: "Tags", align: AlignLeft, value: func(info *search.FileInfo, _ *printOptions) string {
+		tags, _ := userNotes.lookupResult(info)
+		return strings.Join(tags, ", ")
+	}},
+	{name: "first-seen", header: "First Seen", align: AlignLeft, value: func(info *search.FileInfo, _ *printOptions) string {
+		if pack := packIndex.lookup(info); pack != nil {
+			return formatSeen(pack.FirstSeen)
+		}
+		return ""
+	}},
+	{name: "last-seen", header: "Last Seen", align: AlignLeft, value: func(info *search.FileInfo, _ *printOptions) string {
+		if pack := packIndex.lookup(info); pack != nil {
+			return formatSeen(pack.LastSeen)
+		}
+		return ""
+	}},
+	{name: "seen", header: "Seen", align: AlignRight, value: func(info *search.FileInfo, _ *printOptions) string {
+		if pack := packIndex.lookup(info); pack != nil {
+			return strconv.Itoa(pack.Seen)
+		}
+		return ""
+	}},
+	{name: "note", header: "Note", align: AlignLeft, value: func(info *search.FileInfo, _ *printOptions) string {
+		_, texts := userNotes.lookupResult(info)
+		return strings.Join(texts, "; ")
+	}},
+	{name: "blocked", header: "Blocked", align: AlignLeft, value: func(info *search.FileInfo, _ *printOptions) string {
+		if !config.Bots.AllowsResult(info) {
+			return "blocked"
+		}
+		return ""
+	}, color: func(*search.FileInfo) string { return colorRed }},
+}
+
+// defaultColumns are the columns displayed when --columns is not set.
+// The tags and the notes are added when some result has any, the availability of the packs with search --local,
+// and the blocked bots when their results are marked rather than hidden.
+var defaultColumns = []string{"network", "channel", "bot", "slot", "gets", "size", "name"}
+
+func findResultColumn(name string) *resultColumn {
+	for _, col := range resultColumns {
+		if col.name == name {
+			return col
+		}
+	}
+	return nil
+}
+
+func resultColumnNames() []string {
+	names := make([]string, 0, len(resultColumns))
+	for _, col := range resultColumns {
+		names = append(names, col.name)
+	}
+	return names
+}
+
+// columnList is a flag accepting a comma separated list of column names.
+type columnList []string
+
+func (columns *columnList) String() string {
+	return strings.Join(*columns, ",")
+}
+
+func (columns *columnList) Set(value string) error {
+	names, err := parseColumns(value)
+	if err != nil {
+		return err
+	}
+	*columns = names
+	return nil
+}
+
+// parseColumns parses a comma separated list of column names, such as "network,bot,slot,size,name".
+func parseColumns(spec string) ([]string, error) {
+	names := make([]string, 0)
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		if findResultColumn(name) == nil {
+			return nil, fmt.Errorf("unknown column %q, available columns: %s", name, strings.Join(resultColumnNames(), ", "))
+		}
+		names = append(names, name)
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no column selected")
+	}
+	return names, nil
+}
+
+// displayedColumns returns the columns to display for the results.
+func displayedColumns(res []search.FileInfo, opts *printOptions) []*resultColumn {
+	names := opts.columns
+	if len(names) == 0 {
+		names = append([]string(nil), defaultColumns...)
+
+		hasTags, hasNotes := false, false
+		for i := range res {
+			tags, texts := userNotes.lookupResult(&res[i])
+			hasTags = hasTags || len(tags) > 0
+			hasNotes = hasNotes || len(texts) > 0
+		}
+
+		if hasTags {
+			names = append(names, "tags")
+		}
+		if hasNotes {
+			names = append(names, "note")
+		}
+		if opts.local {
+			names = append(names, "first-seen", "last-seen", "seen")
+		}
+
+		if config.Bots.marksResults() {
+			for i := range res {
+				if !config.Bots.AllowsResult(&res[i]) {
+					names = append(names, "blocked")
+					break
+				}
+			}
+		}
+
+		for _, col := range resultColumns {
+			if col.custom {
+				names = append(names, col.name)
+			}
+		}
+	}
+
+	columns := make([]*resultColumn, 0, len(names))
+	for _, name := range names {
+		columns = append(columns, findResultColumn(name))
+	}
+	return columns
+}
+
+// toFloat converts the numbers used in the templates of the custom columns.
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	case string:
+		return strconv.ParseFloat(n, 64)
+	}
+	return 0, fmt.Errorf("not a number: %v", v)
+}
+
+func floatOp(op func(x, y float64) float64) func(a, b interface{}) (float64, error) {
+	return func(a, b interface{}) (float64, error) {
+		x, err := toFloat(a)
+		if err != nil {
+			return 0, err
+		}
+
+		y, err := toFloat(b)
+		if err != nil {
+			return 0, err
+		}
+		return op(x, y), nil
+	}
+}
+
+// columnTemplateFuncs are the functions available in the templates of the custom columns,
+// in addition to the predefined ones such as printf.
+var columnTemplateFuncs = template.FuncMap{
+	"add": floatOp(func(x, y float64) float64 { return x + y }),
+	"sub": floatOp(func(x, y float64) float64 { return x - y }),
+	"mul": floatOp(func(x, y float64) float64 { return x * y }),
+	"div": floatOp(func(x, y float64) float64 {
+		if y == 0 {
+			return 0
+		}
+		return x / y
+	}),
+	"mib":  func(size int64) float64 { return float64(size) / float64(search.MegaByte) },
+	"gib":  func(size int64) float64 { return float64(size) / float64(search.GigaByte) },
+	"size": formatSize,
+	"hash": resultHash,
+	"tags": func(info *search.FileInfo) string {
+		tags, _ := userNotes.lookupResult(info)
+		return strings.Join(tags, ", ")
+	},
+}
+
+func parseAlignment(align string) (Alignment, error) {
+	switch strings.ToLower(align) {
+	case "", "left":
+		return AlignLeft, nil
+	case "right":
+		return AlignRight, nil
+	case "center":
+		return AlignCenter, nil
+	}
+	return AlignLeft, fmt.Errorf("invalid alignment %q", align)
+}
+
+func newCustomColumn(def *CustomColumn) (*resultColumn, error) {
+	name := strings.ToLower(strings.TrimSpace(def.Name))
+	if name == "" || strings.ContainsAny(name, ", ") {
+		return nil, fmt.Errorf("invalid column name %q", def.Name)
+	}
+
+	if findResultColumn(name) != nil {
+		return nil, fmt.Errorf("column %q already exists", name)
+	}
+
+	align, err := parseAlignment(def.Align)
+	if err != nil {
+		return nil, fmt.Errorf("column %q: %s", name, err)
+	}
+
+	tmpl, err := template.New(name).Funcs(columnTemplateFuncs).Parse(def.Template)
+	if err != nil {
+		return nil, fmt.Errorf("column %q: %s", name, err)
+	}
+
+	header := def.Header
+	if header == "" {
+		header = def.Name
+	}
+
+	return &resultColumn{
+		name:   name,
+		header: header,
+		align:  align,
+		custom: true,
+		value: func(info *search.FileInfo, _ *printOptions) string {
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, info); err != nil {
+				logAt(LogProviders, "column %s: %s", name, err)
+				return ""
+			}
+			return buf.String()
+		},
+	}, nil
+}
+
+// setupCustomColumns adds the columns defined in the configuration to the available ones.
+func setupCustomColumns() {
+	for i := range config.Columns {
+		col, err := newCustomColumn(&config.Columns[i])
+		if err != nil {
+			logError("invalid custom column: %s", err)
+			os.Exit(1)
+		}
+		resultColumns = append(resultColumns, col)
+	}
+}
+
+// resultPackRef returns the reference of the pack, which can be passed to get.
+func resultPackRef(info *search.FileInfo) string {
+	url, err := info.IRCFileURL()
+	if err != nil {
+		return ""
+	}
+	return url.PackRef()
+}
+
+func sizeColumnValue(info *search.FileInfo, opts *printOptions) string {
+	if opts.exactBytes {
+		return strconv.FormatInt(info.Size, 10)
+	}
+	return formatSize(info.Size)
+}
+
+// formatTimestamp returns the time reported by a provider, or an empty string if unknown.
+func formatTimestamp(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return formatSeen(*t)
+}
+
+// sizeColor tells the larger files apart at a glance.
+func sizeColor(info *search.FileInfo) string {
+	switch {
+	case info.Size <= 0:
+		return colorDim
+	case info.Size < search.GigaByte:
+		return colorGreen
+	case info.Size < 10*search.GigaByte:
+		return colorCyan
+	case info.Size < search.TeraByte:
+		return colorYellow
+	}
+	return colorRed
+}
+
+// getsColor highlights the popular packs, which are likely to be complete and well seeded.
+func getsColor(info *search.FileInfo) string {
+	switch {
+	case info.Gets == 0:
+		return colorDim
+	case info.Gets >= 1000:
+		return colorGreen
+	case info.Gets >= 100:
+		return colorYellow
+	}
+	return ""
+}
+
+// printResultsTable prints the results as a table, numbered from first.
+func printResultsTable(res []search.FileInfo, first int, opts *printOptions) {
+	columns := displayedColumns(res, opts)
+
+	headers := []string{"#"}
+	aligns := []Alignment{AlignRight}
+	widths := []int{0}
+	for _, col := range columns {
+		headers = append(headers, col.header)
+		aligns = append(aligns, col.align)
+		widths = append(widths, col.maxWidth)
+	}
+
+	printer := NewTablePrinter(headers)
+	printer.SetAligns(aligns)
+	printer.SetMaxWidths(widths)
+	printer.CellColor = func(row int, col int) string {
+		if col == 0 || columns[col-1].color == nil {
+			return ""
+		}
+		return columns[col-1].color(&res[row])
+	}
+
+	for i := range res {
+		row := Row{strconv.Itoa(first + i)}
+		for _, col := range columns {
+			row = append(row, col.value(&res[i], opts))
+		}
+		printer.AddRow(row)
+	}
+	printer.Print()
+}
+
+const (
+	outputFormatTable = "table"
+	outputFormatJSON  = "json"
+	outputFormatCSV   = "csv"
+)
+
+func isValidOutputFormat(format string) bool {
+	_, markup := markupRenderers[format]
+	return format == outputFormatTable || format == outputFormatJSON || format == outputFormatCSV || markup
+}
+
+// resultRecord is a search result along with the values of the custom columns, as exported in JSON.
+type resultRecord struct {
+	search.FileInfo
+	Pack     string            `json:"pack,omitempty"`     // see xdcc.ParsePackRef
+	Blocked  bool              `json:"blocked,omitempty"`  // the bot is blocked by the configuration
+	Provider string            `json:"provider,omitempty"` // search engine which found the result, when streamed
+	Columns  map[string]string `json:"columns,omitempty"`
+}
+
+func newResultRecords(res []search.FileInfo, opts *printOptions) []resultRecord {
+	records := make([]resultRecord, 0, len(res))
+	for i := range res {
+		record := resultRecord{FileInfo: res[i], Pack: resultPackRef(&res[i]), Blocked: !config.Bots.AllowsResult(&res[i])}
+		for _, col := range resultColumns {
+			if !col.custom {
+				continue
+			}
+
+			if record.Columns == nil {
+				record.Columns = make(map[string]string)
+			}
+			record.Columns[col.name] = col.value(&res[i], opts)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func printResultsJSON(res []search.FileInfo, opts *printOptions) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(newResultRecords(res, opts)); err != nil {
+		logError("unable to print results: %s", err)
+	}
+}
+
+// printResultsCSV prints the displayed columns of the results, numbered from first.
+func printResultsCSV(res []search.FileInfo, first int, opts *printOptions) {
+	columns := displayedColumns(res, opts)
+
+	w := csv.NewWriter(os.Stdout)
+	header := []string{"#"}
+	for _, col := range columns {
+		header = append(header, col.name)
+	}
+	w.Write(header)
+
+	for i := range res {
+		record := []string{strconv.Itoa(first + i)}
+		for _, col := range columns {
+			record = append(record, col.value(&res[i], opts))
+		}
+		w.Write(record)
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		logError("unable to print results: %s", err)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ostafen/xdcc-cli/pkg/xdcc"
+)
+
+var subcommands = []string{"search", "get", "fserve", "preview", "watch", "watches", "queue", "daemon", "providers", "notes", "bots", "history", "bookmark", "completion"}
+
+// nestedSubcommands are the subcommands of the subcommands.
+var nestedSubcommands = map[string][]string{
+	"providers":  {"status"},
+	"notes":      {"list", "tag", "untag", "note"},
+	"bots":       {"list", "forget"},
+	"queue":      {"add", "list", "remove", "run", "export", "import"},
+	"watches":    {"export", "import"},
+	"history":    {"backfill"},
+	"bookmark":   {"add", "list", "remove", "run"},
+	"completion": {"bash", "zsh", "fish"},
+}
+
+// flagValues are the values suggested for the flags accepting a fixed set of them.
+var flagValues = map[string][]string{
+	"on-collision":   {xdcc.CollisionSkip, xdcc.CollisionOverwrite, xdcc.CollisionRename, xdcc.CollisionResume},
+	"batch-conflict": {BatchConflictSuffix, BatchConflictBotDir},
+	"checksum-file":  {ChecksumFormatSFV, ChecksumFormatMD5},
+	"checksum-scope": {ChecksumScopeFile, ChecksumScopeDir},
+	"part":           {xdcc.DepartImmediately, xdcc.DepartAfterDelay, xdcc.DepartNever},
+	"sort":           {sortByGets, sortBySize, sortByName, sortByDate},
+	"format":         {outputFormatTable, outputFormatJSON, outputFormatCSV, outputFormatMarkdown, outputFormatHTML},
+	"stats":          {statsText, statsJSON, outputFormatMarkdown, outputFormatHTML, statsNone},
+	"kind":           {historySearch, historyDownload},
+}
+
+// commandFlagSet returns the flags of a subcommand, given its arguments starting with the nested subcommand, if any.
+func commandFlagSet(command string, args []string) *flag.FlagSet {
+	nested := ""
+	if len(args) > 0 {
+		nested = args[0]
+	}
+
+	var flagSet *flag.FlagSet
+	switch command {
+	case "search":
+		flagSet, _ = newSearchFlagSet()
+	case "get":
+		flagSet, _ = newGetFlagSet()
+	case "fserve":
+		flagSet, _ = newFServeFlagSet()
+	case "preview":
+		flagSet, _, _ = newPreviewFlagSet()
+	case "watch":
+		flagSet, _, _ = newWatchFlagSet()
+	case "daemon":
+		flagSet, _ = newDaemonFlagSet()
+	case "history":
+		if nested != "backfill" {
+			flagSet, _ = newHistoryFlagSet()
+		}
+	case "providers":
+		if nested == "status" {
+			flagSet, _, _ = newProvidersStatusFlagSet()
+		}
+	}
+	return flagSet
+}
+
+func flagName(f *flag.Flag) string {
+	if len(f.Name) == 1 {
+		return "-" + f.Name
+	}
+	return "--" + f.Name
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && boolFlag.IsBoolFlag()
+}
+
+// botPackPrefix returns the beginning of the pack references of the bot of url, up to the slot.
+func botPackPrefix(url *xdcc.IRCFileURL) string {
+	ref := url.PackRef()
+	return ref[:strings.LastIndex(ref, "#")+1]
+}
+
+// knownPackPrefixes returns the networks and the bots seen in the history and in the bookmarks, as pack reference prefixes.
+func knownPackPrefixes() []string {
+	prefixes := make([]string, 0)
+	for alias := range xdcc.NetworkAliases {
+		prefixes = append(prefixes, alias+"/")
+	}
+
+	if entries, _, err := loadHistory(); err == nil {
+		for _, entry := range entries {
+			if url, err := xdcc.ParsePackRef(entry.Source); entry.Kind == historyDownload && err == nil {
+				prefixes = append(prefixes, botPackPrefix(url))
+			}
+		}
+	}
+
+	if bookmarks, _, err := loadBookmarks(); err == nil {
+		for _, b := range bookmarks {
+			if url, err := xdcc.ParsePackRef(b.Bot + "/#1"); b.Bot != "" && err == nil {
+				prefixes = append(prefixes, botPackPrefix(url))
+			}
+		}
+	}
+	return prefixes
+}
+
+// knownNoteTargets returns the networks, channels and bots seen in the history, as targets of the notes.
+func knownNoteTargets() []string {
+	targets := make([]string, 0)
+	entries, _, err := loadHistory()
+	if err != nil {
+		return targets
+	}
+
+	for _, entry := range entries {
+		url, err := xdcc.ParseURL(entry.Source)
+		if entry.Kind != historyDownload || err != nil {
+			continue
+		}
+		targets = append(targets, url.Network, url.Network+"/"+url.Channel, url.Network+"/"+url.UserName)
+	}
+	return targets
+}
+
+func bookmarkNames() []string {
+	bookmarks, _, err := loadBookmarks()
+	if err != nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(bookmarks))
+	for name := range bookmarks {
+		names = append(names, name)
+	}
+	return names
+}
+
+// positionalCandidates returns the suggestions for the i-th argument of the command which is not a flag,
+// counting the nested subcommand.
+func positionalCandidates(command string, args []string, i int) []string {
+	nested := ""
+	if len(args) > 0 {
+		nested = args[0]
+	}
+
+	switch {
+	case command == "get" || command == "preview":
+		return knownPackPrefixes()
+	case command == "bookmark" && (nested == "remove" || nested == "run") && i == 1:
+		return bookmarkNames()
+	case command == "bookmark" && nested == "run" && i > 1:
+		return nil // slots
+	case command == "notes" && nested != "list" && i == 1:
+		return knownNoteTargets()
+	}
+	return nil
+}
+
+// completeArgs returns the suggestions for the last argument, given the arguments of the command line.
+func completeArgs(args []string) []string {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	current := args[len(args)-1]
+
+	var candidates []string
+	if len(args) == 1 {
+		candidates = subcommands
+	} else {
+		candidates = completeCommandArgs(args[0], args[1:len(args)-1], current)
+	}
+
+	matches := make([]string, 0, len(candidates))
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		if strings.HasPrefix(c, current) && !seen[c] {
+			seen[c] = true
+			matches = append(matches, c)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+func completeCommandArgs(command string, args []string, current string) []string {
+	if nested, ok := nestedSubcommands[command]; ok && len(args) == 0 && !strings.HasPrefix(current, "-") {
+		return nested
+	}
+
+	flagSet := commandFlagSet(command, args)
+	if flagSet != nil && len(args) > 0 {
+		prev := strings.TrimLeft(args[len(args)-1], "-")
+		if f := flagSet.Lookup(prev); f != nil && strings.HasPrefix(args[len(args)-1], "-") && !isBoolFlag(f) {
+			return flagValues[prev] // files and free values are left to the shell
+		}
+	}
+
+	if strings.HasPrefix(current, "-") {
+		names := make([]string, 0)
+		if flagSet != nil {
+			flagSet.VisitAll(func(f *flag.Flag) {
+				names = append(names, flagName(f))
+			})
+		}
+		return names
+	}
+
+	positional := 0
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "-") {
+			positional++
+		} else if f := lookupFlag(flagSet, args[i]); f != nil && !isBoolFlag(f) && !strings.Contains(args[i], "=") {
+			i++ // the value of the flag
+		}
+	}
+	return positionalCandidates(command, args, positional)
+}
+
+func lookupFlag(flagSet *flag.FlagSet, arg string) *flag.Flag {
+	if flagSet == nil {
+		return nil
+	}
+	name := strings.TrimLeft(arg, "-")
+	if idx := strings.Index(name, "="); idx >= 0 {
+		name = name[:idx]
+	}
+	return flagSet.Lookup(name)
+}
+
+// completeCommand prints the suggestions for the last argument, one per line. It is called by the completion scripts.
+func completeCommand(args []string) {
+	for _, c := range completeArgs(args) {
+		fmt.Println(c)
+	}
+}
+
+const bashCompletionScript = `# bash completion for %[1]s
+_%[2]s_complete() {
+    local IFS=$'\n'
+    COMPREPLY=($(%[1]s __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+    if [[ ${#COMPREPLY[@]} -eq 1 && ${COMPREPLY[0]} == */ ]]; then
+        compopt -o nospace
+    fi
+}
+complete -o default -F _%[2]s_complete %[1]s
+`
+
+const zshCompletionScript = `#compdef %[1]s
+_%[2]s_complete() {
+    local -a candidates prefixes others
+    candidates=("${(@f)$(%[1]s __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+    candidates=(${candidates:#})
+    if (( ${#candidates} == 0 )); then
+        _files
+        return
+    fi
+    prefixes=(${(M)candidates:#*/})
+    others=(${candidates:#*/})
+    compadd -S '' -a prefixes
+    compadd -a others
+}
+compdef _%[2]s_complete %[1]s
+`
+
+const fishCompletionScript = `# fish completion for %[1]s
+function __%[2]s_complete
+    set -l tokens (commandline -opc) (commandline -ct)
+    %[1]s __complete $tokens[2..-1] 2>/dev/null
+end
+complete -c %[1]s -a '(__%[2]s_complete)'
+`
+
+func printCompletionUsageAndExit() {
+	fmt.Println("usage: xdcc completion bash|zsh|fish")
+	fmt.Println("e.g. source <(xdcc completion bash), or xdcc completion fish > ~/.config/fish/completions/xdcc.fish")
+	os.Exit(1)
+}
+
+func completionCommand(args []string) {
+	if len(args) != 1 {
+		printCompletionUsageAndExit()
+	}
+
+	program := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	ident := strings.Map(func(r rune) rune {
+		if r == '-' || r == '.' {
+			return '_'
+		}
+		return r
+	}, program)
+
+	switch args[0] {
+	case "bash":
+		fmt.Printf(bashCompletionScript, program, ident)
+	case "zsh":
+		fmt.Printf(zshCompletionScript, program, ident)
+	case "fish":
+		fmt.Printf(fishCompletionScript, program, ident)
+	default:
+		printCompletionUsageAndExit()
+	}
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ostafen/xdcc-cli/pkg/search"
+	"github.com/ostafen/xdcc-cli/pkg/xdcc"
+)
+
+const configDirName = "xdcc-cli"
+const configFileName = "config.json"
+
+// ResultPriorities lists the networks and bots whose search results
+// should be moved up or down in the result list.
+type ResultPriorities struct {
+	Networks []string `json:"networks"`
+	Bots     []string `json:"bots"`
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ResultPriorities) Match(info *search.FileInfo) bool {
+	return containsFold(p.Networks, info.Network) || containsFold(p.Bots, info.BotName)
+}
+
+// NetworkPolicy restricts the IRC networks which can be connected to.
+// A network matches an entry if it is equal to it or is one of its subdomains
+// (e.g. "irc.rizon.net" matches "rizon.net").
+type NetworkPolicy struct {
+	Allowed []string `json:"allowed"` // if not empty, only these networks can be used
+	Denied  []string `json:"denied"`
+}
+
+func matchNetwork(list []string, network string) bool {
+	if host, _, err := net.SplitHostPort(network); err == nil {
+		network = host
+	}
+	network = strings.ToLower(network)
+
+	for _, entry := range list {
+		entry = strings.ToLower(entry)
+		if network == entry || strings.HasSuffix(network, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+func (policy *NetworkPolicy) Allows(network string) bool {
+	if len(policy.Allowed) > 0 && !matchNetwork(policy.Allowed, network) {
+		return false
+	}
+	return !matchNetwork(policy.Denied, network)
+}
+
+// Check returns an error if the network is forbidden.
+func (policy *NetworkPolicy) Check(network string) error {
+	if !policy.Allows(network) {
+		return fmt.Errorf("network %s is forbidden by the configuration", network)
+	}
+	return nil
+}
+
+// NotificationConfig selects the desktop notifications of the transfers.
+type NotificationConfig struct {
+	Desktop bool     `json:"desktop"` // notify every batch, as with --notify
+	Events  []string `json:"events"`  // among completed, failed, queued and batch, all of them by default
+}
+
+// ProviderRequestConfig are the HTTP settings of the requests of a provider.
+type ProviderRequestConfig struct {
+	UserAgent      string            `json:"userAgent"`
+	AcceptLanguage string            `json:"acceptLanguage"`
+	Headers        map[string]string `json:"headers"`
+	Cookies        bool              `json:"cookies"`    // keep the cookies of the provider across runs
+	SessionURL     string            `json:"sessionUrl"` // page visited before searching, to start a session
+}
+
+// Handling of the search results of the blocked bots.
+const (
+	BlockedResultsHide = "hide"
+	BlockedResultsMark = "mark"
+)
+
+// BotPolicy lists the bots not to download from, such as known fakes, and optionally the only ones allowed.
+// Entries are bot names, which can be patterns such as "Fake*", optionally preceded by their network
+// (subdomains included), e.g. "rizon.net/SomeBot" or "irc.example.net/*" for every bot of a network.
+type BotPolicy struct {
+	Allowed []string `json:"allowed"` // if not empty, only these bots can be used
+	Blocked []string `json:"blocked"`
+	Results string   `json:"results"` // BlockedResultsHide (default) or BlockedResultsMark
+
+	Fingerprints string `json:"fingerprints"` // FingerprintsWarn (default), FingerprintsBlock or FingerprintsOff
+}
+
+func matchBot(list []string, network string, bot string) bool {
+	for _, entry := range list {
+		pattern := entry
+		if idx := strings.Index(entry, "/"); idx >= 0 {
+			if !matchNetwork([]string{entry[:idx]}, network) {
+				continue
+			}
+			pattern = entry[idx+1:]
+		}
+
+		pattern, name := strings.ToLower(pattern), strings.ToLower(bot)
+		if ok, err := path.Match(pattern, name); ok || err != nil && pattern == name {
+			return true
+		}
+	}
+	return false
+}
+
+// marksResults reports whether the search results of the blocked bots are marked rather than hidden.
+func (policy *BotPolicy) marksResults() bool {
+	return policy.Results == BlockedResultsMark
+}
+
+func (policy *BotPolicy) Allows(network string, bot string) bool {
+	if len(policy.Allowed) > 0 && !matchBot(policy.Allowed, network, bot) {
+		return false
+	}
+	return !matchBot(policy.Blocked, network, bot)
+}
+
+// AllowsResult reports whether the bot of the search result is allowed.
+func (policy *BotPolicy) AllowsResult(info *search.FileInfo) bool {
+	return policy.Allows(info.Network, info.BotName)
+}
+
+// Check returns an error if the bot is blocked.
+func (policy *BotPolicy) Check(network string, bot string) error {
+	if !policy.Allows(network, bot) {
+		return fmt.Errorf("bot %s of %s is blocked by the configuration (use --force to download anyway)", bot, network)
+	}
+	return nil
+}
+
+// Duration is a time.Duration encoded as a string (e.g. "10m") in the config file.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	value, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(value)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// AnnounceChannelConfig is an IRC channel where bots announce the packs they offer.
+type AnnounceChannelConfig struct {
+	Network              string   `json:"network"`
+	Channel              string   `json:"channel"`
+	Window               Duration `json:"window"` // time spent collecting announcements
+	NoSSL                bool     `json:"noSSL"`
+	SkipCertificateCheck bool     `json:"allowUnknownAuthority"`
+}
+
+// PluginConfig is a search provider implemented by an external program, see search.PluginProvider.
+type PluginConfig struct {
+	Name    string   `json:"name"`
+	Command []string `json:"command"` // program and arguments
+}
+
+// ClientConfig is how the client presents itself to the IRC servers and the bots, see xdcc.ClientProfile.
+// The fields left empty are taken from the mimicked client.
+type ClientConfig struct {
+	Mimic       string `json:"mimic"` // name of one of xdcc.ClientProfiles
+	Version     string `json:"version"`
+	Ident       string `json:"ident"`
+	RealName    string `json:"realName"`
+	QuitMessage string `json:"quitMessage"`
+}
+
+// IdentityConfig is the identity used on a network, instead of a random nick.
+type IdentityConfig struct {
+	Nick             string `json:"nick"`
+	Username         string `json:"username"`
+	RealName         string `json:"realName"`
+	ServerPassword   string `json:"serverPassword"`
+	NickServPassword string `json:"nickservPassword"`
+	SASLUser         string `json:"saslUser"` // the nick if empty
+	SASLPassword     string `json:"saslPassword"`
+	Modes            string `json:"modes"`        // user modes set once connected, e.g. "+x"
+	WaitForVHost     bool   `json:"waitForVHost"` // join the channels once the virtual host is set
+}
+
+// DepartureRule tells when to leave the matching channels once a download is over.
+type DepartureRule struct {
+	Network string   `json:"network"` // any network if empty
+	Channel string   `json:"channel"` // any channel if empty
+	Mode    string   `json:"mode"`    // immediately, delay or never
+	Delay   Duration `json:"delay"`
+}
+
+func (rule *DepartureRule) Match(url *xdcc.IRCFileURL) bool {
+	if rule.Network != "" && !matchNetwork([]string{rule.Network}, url.Network) {
+		return false
+	}
+	return rule.Channel == "" || strings.EqualFold(strings.TrimPrefix(rule.Channel, "#"), strings.TrimPrefix(url.Channel, "#"))
+}
+
+// CustomColumn is an additional column of the search results, computed from a template
+// over the result (e.g. "{{ printf \"%.0f\" (div .Gets (gib .Size)) }}" for the gets per GiB).
+type CustomColumn struct {
+	Name     string `json:"name"`   // used by --columns, and in the JSON and CSV outputs
+	Header   string `json:"header"` // Name if empty
+	Template string `json:"template"`
+	Align    string `json:"align"` // left, right or center, left by default
+}
+
+type Config struct {
+	Pinned        ResultPriorities `json:"pinned"`
+	Deprioritized ResultPriorities `json:"deprioritized"`
+
+	// number of consecutive failures after which a provider is skipped
+	ProviderFailureThreshold int `json:"providerFailureThreshold"`
+	// time after which a failing provider is tried again
+	ProviderCooldown Duration `json:"providerCooldown"`
+
+	// time given to each provider to answer, zero meaning no limit
+	ProviderTimeout Duration `json:"providerTimeout"`
+	// overrides ProviderTimeout for the given providers
+	ProviderTimeouts map[string]Duration `json:"providerTimeouts"`
+	// total time of a search, zero meaning no limit
+	SearchTimeout Duration `json:"searchTimeout"`
+	// minimum time between two requests of the given providers (1s by default)
+	ProviderRateLimits map[string]Duration `json:"providerRateLimits"`
+	// decimal separator of the numbers reported by the given providers: "point", "comma" or a language code such as "de"
+	ProviderLocales map[string]string `json:"providerLocales"`
+	// HTTP settings of the requests of the given providers
+	ProviderRequests map[string]ProviderRequestConfig `json:"providerRequests"`
+
+	// "binary" (KiB, MiB, ...) or "si" (kB, MB, ...)
+	SizeUnits string `json:"sizeUnits"`
+
+	// do not record the packs returned by the searches in the pack index (see search --local)
+	DisablePackIndex bool `json:"disablePackIndex"`
+
+	AnnounceChannels []AnnounceChannelConfig `json:"announceChannels"`
+	Plugins          []PluginConfig          `json:"plugins"`
+
+	Networks NetworkPolicy `json:"networks"`
+	Bots     BotPolicy     `json:"bots"`
+
+	Hooks Hooks `json:"hooks"`
+
+	// desktop notifications of the transfers
+	Notifications NotificationConfig `json:"notifications"`
+
+	// windows during which the transfers can run, such as "01:00-07:00", "sat,sun 00:00-24:00" or cron expressions
+	Schedule []string `json:"schedule"`
+
+	// extraction of the downloaded archives
+	Extract ExtractConfig `json:"extract"`
+
+	// upload of the downloaded files to remote storage
+	Forward ForwardConfig `json:"forward"`
+
+	// token required by the HTTP API of the daemon, and sent to it with --remote
+	DaemonToken string `json:"daemonToken"`
+
+	// when to leave the channels after downloading, the first matching rule applies
+	Departures []DepartureRule `json:"departures"`
+
+	// command used by the preview subcommand to play the samples
+	PreviewPlayer string `json:"previewPlayer"`
+
+	// additional columns of the search results
+	Columns []CustomColumn `json:"columns"`
+
+	// SHA-256 fingerprints of the certificates expected from the IRC servers, by network (subdomains included)
+	CertificatePins map[string][]string `json:"certificatePins"`
+
+	// reply to CTCP VERSION requests and other details shown to the IRC servers and the bots
+	Client ClientConfig `json:"client"`
+
+	// identities used on the networks (subdomains included, the most specific network applying)
+	Identities map[string]IdentityConfig `json:"identities"`
+}
+
+const (
+	defaultProviderFailureThreshold = 2
+	defaultProviderCooldown         = 10 * time.Minute
+	defaultPreviewPlayer            = "mpv"
+	defaultDepartureDelay           = 30 * time.Second
+)
+
+func NewDefaultConfig() *Config {
+	return &Config{
+		ProviderFailureThreshold: defaultProviderFailureThreshold,
+		ProviderCooldown:         Duration(defaultProviderCooldown),
+		ProviderTimeout:          Duration(search.DefaultProviderTimeout),
+		SizeUnits:                sizeUnitsBinary,
+		PreviewPlayer:            defaultPreviewPlayer,
+	}
+}
+
+var config *Config = NewDefaultConfig()
+
+func configDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, configDirName), nil
+}
+
+func configPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, configFileName), nil
+}
+
+// dataFilePath returns the path of a file stored along with the configuration,
+// creating the configuration directory if needed.
+func dataFilePath(name string) (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// readJSONFile decodes the content of the file into v.
+// A missing file is not considered an error and leaves v untouched.
+func readJSONFile(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// PinnedCertificates returns the fingerprints pinned for the server. When several networks of
+// CertificatePins match the server, the most specific one applies.
+func (cfg *Config) PinnedCertificates(server string) []string {
+	networks := make([]string, 0, len(cfg.CertificatePins))
+	for network := range cfg.CertificatePins {
+		networks = append(networks, network)
+	}
+
+	if match := mostSpecificNetwork(networks, server); match != "" {
+		return cfg.CertificatePins[match]
+	}
+	return nil
+}
+
+// mostSpecificNetwork returns the longest of the networks matching the server, subdomains included.
+// Networks can be given by their aliases (e.g. rizon). It returns an empty string if none matches.
+func mostSpecificNetwork(networks []string, server string) string {
+	match, matchLen := "", 0
+	for _, network := range networks {
+		resolved := network
+		if alias, ok := xdcc.NetworkAliases[strings.ToLower(network)]; ok {
+			resolved = alias
+		}
+
+		if matchNetwork([]string{resolved}, server) && len(resolved) > matchLen {
+			match, matchLen = network, len(resolved)
+		}
+	}
+	return match
+}
+
+// NetworkIdentity returns the identity configured for the server, if any. When several networks
+// of Identities match the server, the most specific one applies.
+func (cfg *Config) NetworkIdentity(server string) *xdcc.Identity {
+	networks := make([]string, 0, len(cfg.Identities))
+	for network := range cfg.Identities {
+		networks = append(networks, network)
+	}
+
+	match := mostSpecificNetwork(networks, server)
+	if match == "" {
+		return nil
+	}
+
+	id := cfg.Identities[match]
+	return &xdcc.Identity{
+		Nick:             id.Nick,
+		Ident:            id.Username,
+		RealName:         id.RealName,
+		ServerPassword:   id.ServerPassword,
+		NickServPassword: id.NickServPassword,
+		SASLUser:         id.SASLUser,
+		SASLPassword:     id.SASLPassword,
+		UserModes:        id.Modes,
+		WaitForVHost:     id.WaitForVHost,
+	}
+}
+
+// setupCertificatePins makes the connections to the IRC servers verify the pinned certificates.
+func setupCertificatePins() {
+	for network, fingerprints := range config.CertificatePins {
+		for _, fingerprint := range fingerprints {
+			if _, err := xdcc.NormalizeFingerprint(fingerprint); err != nil {
+				logError("certificate pins of %s: %s", network, err)
+				os.Exit(1)
+			}
+		}
+	}
+	xdcc.PinnedCertificates = config.PinnedCertificates
+}
+
+// setupNetworkIdentities makes the connections to the IRC servers use the configured identities.
+func setupNetworkIdentities() {
+	xdcc.NetworkIdentity = config.NetworkIdentity
+}
+
+// setupBotPolicy hides the search results of the blocked bots, unless they are only marked.
+func setupBotPolicy() {
+	switch config.Bots.Results {
+	case "", BlockedResultsHide:
+		registry.SetResultFilter(config.Bots.AllowsResult)
+	case BlockedResultsMark:
+	default:
+		logError("invalid bots.results %q, expected hide or mark", config.Bots.Results)
+		os.Exit(1)
+	}
+
+	switch config.Bots.Fingerprints {
+	case "", FingerprintsWarn, FingerprintsBlock, FingerprintsOff:
+	default:
+		logError("invalid bots.fingerprints %q, expected warn, block or off", config.Bots.Fingerprints)
+		os.Exit(1)
+	}
+}
+
+// setupClientProfile makes the connections to the IRC servers present the configured client.
+func setupClientProfile() {
+	profile := xdcc.Client
+	if name := strings.ToLower(config.Client.Mimic); name != "" {
+		mimicked, ok := xdcc.ClientProfiles[name]
+		if !ok {
+			logError("unknown client %q, expected one of: %s", config.Client.Mimic, strings.Join(xdcc.ClientProfileNames(), ", "))
+			os.Exit(1)
+		}
+		profile = mimicked
+	}
+
+	if config.Client.Version != "" {
+		profile.Version = config.Client.Version
+	}
+	if config.Client.Ident != "" {
+		profile.Ident = config.Client.Ident
+	}
+	if config.Client.RealName != "" {
+		profile.RealName = config.Client.RealName
+	}
+	if config.Client.QuitMessage != "" {
+		profile.QuitMessage = config.Client.QuitMessage
+	}
+	xdcc.Client = profile
+}
+
+// Departure returns when to leave the channel of url once its download is over.
+// By default, the channel is left after a short delay, since many channels
+// ban the users leaving right after getting their file. With DepartNever, the
+// connection stays open until the program exits, which the daemon never does:
+// it leaves after the delay instead (see departureFor).
+func (cfg *Config) Departure(url *xdcc.IRCFileURL) xdcc.Departure {
+	departure := xdcc.Departure{Mode: xdcc.DepartAfterDelay, Delay: defaultDepartureDelay}
+	for _, rule := range cfg.Departures {
+		if rule.Match(url) {
+			if rule.Mode != "" {
+				departure.Mode = rule.Mode
+			}
+			if rule.Delay > 0 {
+				departure.Delay = time.Duration(rule.Delay)
+			}
+			break
+		}
+	}
+	return departure
+}
+
+// loadConfig reads the configuration file, if any. Default values are used
+// for the settings missing from the file.
+func loadConfig(path string) (*Config, error) {
+	cfg := NewDefaultConfig()
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+const (
+	priorityPinned = iota
+	priorityNormal
+	priorityLow
+)
+
+// ResultPriority returns the rank of the result group the file belongs to.
+// Lower values are displayed first.
+func (cfg *Config) ResultPriority(info *search.FileInfo) int {
+	if cfg.Pinned.Match(info) {
+		return priorityPinned
+	}
+	if cfg.Deprioritized.Match(info) {
+		return priorityLow
+	}
+	return priorityNormal
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const cookiesFileName = "cookies.json"
+
+// storedCookie is a cookie kept across runs.
+type storedCookie struct {
+	Name     string    `json:"name"`
+	Value    string    `json:"value"`
+	Path     string    `json:"path,omitempty"`
+	Domain   string    `json:"domain,omitempty"`
+	Expires  time.Time `json:"expires,omitempty"`
+	Secure   bool      `json:"secure,omitempty"`
+	HttpOnly bool      `json:"httpOnly,omitempty"`
+}
+
+func (c *storedCookie) expired(now time.Time) bool {
+	return !c.Expires.IsZero() && c.Expires.Before(now)
+}
+
+// CookieStore keeps the cookies of the providers across runs, for the indexes requiring a session.
+// The cookies are stored by provider and by the URL which set them.
+type CookieStore struct {
+	mu      sync.Mutex
+	path    string
+	cookies map[string]map[string][]storedCookie
+}
+
+func NewCookieStore(path string) (*CookieStore, error) {
+	store := &CookieStore{path: path, cookies: make(map[string]map[string][]storedCookie)}
+	if err := readJSONFile(path, &store.cookies); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Jar returns the cookie jar of the provider, holding the cookies kept from the previous runs.
+func (store *CookieStore) Jar(provider string) (http.CookieJar, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	now := time.Now()
+	for rawURL, stored := range store.cookies[provider] {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			continue
+		}
+
+		cookies := make([]*http.Cookie, 0, len(stored))
+		for _, c := range stored {
+			if !c.expired(now) {
+				cookies = append(cookies, &http.Cookie{Name: c.Name, Value: c.Value, Path: c.Path, Domain: c.Domain,
+					Expires: c.Expires, Secure: c.Secure, HttpOnly: c.HttpOnly})
+			}
+		}
+		jar.SetCookies(u, cookies)
+	}
+	return &persistentJar{Jar: jar, store: store, provider: provider}, nil
+}
+
+// set records the cookies set by u, replacing those of the same name and dropping the deleted ones.
+func (store *CookieStore) set(provider string, u *url.URL, cookies []*http.Cookie) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	byURL, ok := store.cookies[provider]
+	if !ok {
+		byURL = make(map[string][]storedCookie)
+		store.cookies[provider] = byURL
+	}
+
+	key := u.Scheme + "://" + u.Host
+	now := time.Now()
+	for _, c := range cookies {
+		stored := storedCookie{Name: c.Name, Value: c.Value, Path: c.Path, Domain: c.Domain,
+			Expires: c.Expires, Secure: c.Secure, HttpOnly: c.HttpOnly}
+		if c.MaxAge > 0 {
+			stored.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		}
+
+		kept := byURL[key][:0]
+		for _, old := range byURL[key] {
+			if old.Name != c.Name || old.Path != c.Path {
+				kept = append(kept, old)
+			}
+		}
+		if c.MaxAge >= 0 && !stored.expired(now) {
+			kept = append(kept, stored)
+		}
+		byURL[key] = kept
+	}
+
+	if err := writeJSONFile(store.path, store.cookies); err != nil {
+		logWarn("unable to save the cookies of %s: %s", provider, err)
+	}
+}
+
+// persistentJar is a cookie jar saving the cookies it receives to its store.
+type persistentJar struct {
+	*cookiejar.Jar
+	store    *CookieStore
+	provider string
+}
+
+func (jar *persistentJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	jar.Jar.SetCookies(u, cookies)
+	if len(cookies) > 0 {
+		jar.store.set(jar.provider, u, cookies)
+	}
+}
@@ -0,0 +1,471 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ostafen/xdcc-cli/pkg/xdcc"
+)
+
+const (
+	defaultDaemonAddr     = "127.0.0.1:9100"
+	daemonShutdownTimeout = 5 * time.Second
+	maxFinishedBatches    = 50
+)
+
+// daemon serves searches and downloads over HTTP, along with the Prometheus metrics.
+type daemon struct {
+	ctx         context.Context
+	opts        *transferOptions
+	token       string            // required by the requests, unless empty
+	checkpoints *checkpointWriter // nil unless --checkpoints is given
+
+	mu       sync.Mutex
+	batches  []*Batch // running batches
+	finished []*Batch // most recently finished batches
+	wg       sync.WaitGroup
+
+	subMu       sync.Mutex
+	subscribers map[chan transferCheckpoint]chan struct{} // streams of /events, with the channel closed once they end
+}
+
+// subscriberBufferSize is the number of checkpoints buffered for a stream of /events.
+// The checkpoints are dropped while the buffer of a slow client is full, except for the final
+// ones, which the clients following the transfers wait for.
+const subscriberBufferSize = 256
+
+func writeJSONResponse(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+type daemonError struct {
+	Error string `json:"error"`
+}
+
+// authorize serves the requests bearing the token of the daemon, if any, as a bearer token.
+// The POST requests must send JSON, which web pages cannot do without being allowed by CORS.
+func (d *daemon) authorize(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if d.token != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(d.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeJSONResponse(w, http.StatusUnauthorized, &daemonError{Error: "missing or invalid token"})
+				return
+			}
+		}
+
+		if r.Method == http.MethodPost {
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err != nil || mediaType != "application/json" {
+				writeJSONResponse(w, http.StatusUnsupportedMediaType, &daemonError{Error: "expected a JSON body"})
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+// checkDaemonAddr refuses to serve other hosts than the local one without a token.
+func checkDaemonAddr(addr string, token string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if token != "" || host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return errors.New("a token is required to listen on " + addr + " (see --token)")
+}
+
+func (d *daemon) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := ParseSearchQuery(r.URL.Query().Get("q"))
+	if len(query.KeywordSets()) == 0 {
+		writeJSONResponse(w, http.StatusBadRequest, &daemonError{Error: "no keyword provided"})
+		return
+	}
+
+	res, err := searchQuery(r.Context(), query)
+	if err != nil {
+		writeJSONResponse(w, http.StatusBadGateway, &daemonError{Error: err.Error()})
+		return
+	}
+
+	res = (&ResultFilter{Query: query}).Apply(res)
+	sortResults(res, sortByGets)
+
+	if renderer, ok := markupRenderers[r.URL.Query().Get("format")]; ok {
+		writeMarkupResponse(w, r.URL.Query().Get("format"), func(w io.Writer) {
+			writeResultsMarkup(w, renderer, "Search results for "+r.URL.Query().Get("q"), res, 1, defaultPrintOptions())
+		})
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, newResultRecords(res, defaultPrintOptions()))
+}
+
+// writeMarkupResponse writes the document of the markup format with write.
+func writeMarkupResponse(w http.ResponseWriter, format string, write func(w io.Writer)) {
+	if format == outputFormatHTML {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	}
+	write(w)
+}
+
+// handleReport serves the reports of the running and recently finished batches as a page,
+// in HTML or with ?format=markdown.
+func (d *daemon) handleReport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = outputFormatHTML
+	}
+
+	renderer, ok := markupRenderers[format]
+	if !ok {
+		writeJSONResponse(w, http.StatusBadRequest, &daemonError{Error: "invalid format " + format + ", expected html or markdown"})
+		return
+	}
+
+	d.mu.Lock()
+	batches := append(append([]*Batch(nil), d.batches...), d.finished...)
+	d.mu.Unlock()
+
+	writeMarkupResponse(w, format, func(w io.Writer) {
+		renderer.Begin(w, "xdcc-cli report")
+		if len(batches) == 0 {
+			renderer.Paragraph(w, "No transfers yet.")
+		}
+		for _, batch := range batches {
+			stats := batch.Stats()
+			heading := "Batch of " + stats.Started.Local().Format(time.RFC1123)
+			if d.running(batch) {
+				heading += " (running)"
+			}
+			writeStatsSection(w, renderer, heading, &stats)
+		}
+		renderer.End(w)
+	})
+}
+
+// running tells whether the batch is in the list of the running batches of the daemon.
+func (d *daemon) running(batch *Batch) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, b := range d.batches {
+		if b == batch {
+			return true
+		}
+	}
+	return false
+}
+
+type daemonDownloadRequest struct {
+	URLs    []string `json:"urls"`
+	Forward []string `json:"forward"` // targets of the files, instead of the global ones
+}
+
+type daemonDownloadResponse struct {
+	Queued int `json:"queued"`
+}
+
+func (d *daemon) handleDownloads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONResponse(w, http.StatusMethodNotAllowed, &daemonError{Error: "use POST"})
+		return
+	}
+
+	var req daemonDownloadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONResponse(w, http.StatusBadRequest, &daemonError{Error: err.Error()})
+		return
+	}
+
+	urlList := make([]xdcc.IRCFileURL, 0, len(req.URLs))
+	for _, urlStr := range req.URLs {
+		url, err := xdcc.ParsePackRef(urlStr)
+		if err != nil {
+			writeJSONResponse(w, http.StatusBadRequest, &daemonError{Error: urlStr + ": " + err.Error()})
+			return
+		}
+		urlList = append(urlList, *url)
+	}
+
+	for _, target := range req.Forward {
+		if !d.allowsForwardTarget(target) {
+			writeJSONResponse(w, http.StatusForbidden, &daemonError{Error: "forward target " + target + " is not allowed"})
+			return
+		}
+	}
+
+	requests := newDownloadRequests(urlList)
+	for i := range requests {
+		requests[i].forward = req.Forward
+	}
+	d.startBatch(newTransferBatch(requests, d.opts))
+	writeJSONResponse(w, http.StatusAccepted, &daemonDownloadResponse{Queued: len(urlList)})
+}
+
+// allowsForwardTarget tells whether the files of a request can be uploaded to the target, which must
+// be one of the targets of the configuration or of --forward: the clients cannot choose where the
+// files offered by the bots are written.
+func (d *daemon) allowsForwardTarget(target string) bool {
+	for _, allowed := range append(append([]string(nil), config.Forward.Targets...), d.opts.forwardTargets...) {
+		if target == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *daemon) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	batches := append([]*Batch(nil), d.batches...)
+	d.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.WriteTo(w, batches)
+}
+
+// handleTransfers reports the state and the checkpoints of the transfers of both running and recently finished batches.
+func (d *daemon) handleTransfers(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	batches := append(append([]*Batch(nil), d.finished...), d.batches...)
+	d.mu.Unlock()
+
+	statuses := make([]transferStatus, 0)
+	for _, batch := range batches {
+		statuses = append(statuses, batch.transferStatuses()...)
+	}
+	writeJSONResponse(w, http.StatusOK, statuses)
+}
+
+// publish writes the checkpoint to the checkpoints file, if any, and delivers it to the streams of /events.
+func (d *daemon) publish(cp *transferCheckpoint) {
+	if d.checkpoints != nil {
+		d.checkpoints.Write(cp)
+	}
+
+	d.subMu.Lock()
+	defer d.subMu.Unlock()
+
+	for ch, done := range d.subscribers {
+		select {
+		case ch <- *cp:
+		default:
+			if isFinalState(cp.State) {
+				d.deliverLater(ch, done, *cp)
+			}
+		}
+	}
+}
+
+// deliverLater delivers the checkpoint once the stream has room for it, unless it ends first.
+func (d *daemon) deliverLater(ch chan transferCheckpoint, done chan struct{}, cp transferCheckpoint) {
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		select {
+		case ch <- cp:
+		case <-done:
+		}
+	}()
+}
+
+func (d *daemon) subscribe() chan transferCheckpoint {
+	ch := make(chan transferCheckpoint, subscriberBufferSize)
+
+	d.subMu.Lock()
+	defer d.subMu.Unlock()
+
+	if d.subscribers == nil {
+		d.subscribers = make(map[chan transferCheckpoint]chan struct{})
+	}
+	d.subscribers[ch] = make(chan struct{})
+	return ch
+}
+
+func (d *daemon) unsubscribe(ch chan transferCheckpoint) {
+	d.subMu.Lock()
+	defer d.subMu.Unlock()
+
+	close(d.subscribers[ch])
+	delete(d.subscribers, ch)
+}
+
+// handleEvents streams the checkpoints of the transfers as NDJSON lines, as they are recorded.
+func (d *daemon) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSONResponse(w, http.StatusInternalServerError, &daemonError{Error: "streaming is not supported"})
+		return
+	}
+
+	ch := d.subscribe()
+	defer d.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case cp := <-ch:
+			if err := enc.Encode(&cp); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *daemon) startBatch(batch *Batch) {
+	batch.onCheckpoint = d.publish
+
+	d.mu.Lock()
+	d.batches = append(d.batches, batch)
+	d.mu.Unlock()
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+
+		runBatch(d.ctx, batch, d.opts)
+		d.removeBatch(batch)
+	}()
+}
+
+func (d *daemon) removeBatch(batch *Batch) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, b := range d.batches {
+		if b == batch {
+			d.batches = append(d.batches[:i], d.batches[i+1:]...)
+			break
+		}
+	}
+
+	if len(d.finished) == maxFinishedBatches {
+		d.finished = d.finished[1:]
+	}
+	d.finished = append(d.finished, batch)
+}
+
+// daemonFlags holds the flags of the daemon subcommand.
+type daemonFlags struct {
+	addr     string
+	token    string
+	transfer *transferOptions
+	log      *logFlags
+}
+
+// newDaemonFlagSet returns the flags of the daemon subcommand, also used by the completion.
+func newDaemonFlagSet() (*flag.FlagSet, *daemonFlags) {
+	daemonCmd := flag.NewFlagSet("daemon", flag.ExitOnError)
+	flags := &daemonFlags{}
+	daemonCmd.StringVar(&flags.addr, "listen", defaultDaemonAddr, "address of the HTTP server")
+	daemonCmd.StringVar(&flags.token, "token", config.DaemonToken, "token required by the HTTP API, as a bearer token")
+	flags.transfer = addTransferFlags(daemonCmd)
+	flags.log = addLogFlags(daemonCmd)
+	return daemonCmd, flags
+}
+
+func daemonCommand(args []string) {
+	daemonCmd, flags := newDaemonFlagSet()
+	opts := flags.transfer
+
+	parseFlags(daemonCmd, args)
+	flags.log.apply()
+
+	if err := validateTransferOptions(opts); err != nil {
+		logError(err.Error())
+		os.Exit(1)
+	}
+
+	if err := checkDaemonAddr(flags.addr, flags.token); err != nil {
+		logError("daemon: %s", err)
+		os.Exit(1)
+	}
+
+	if opts.dryRun {
+		logError("daemon: --dry-run is not supported")
+		os.Exit(1)
+	}
+
+	if opts.metricsPath != "" {
+		logError("daemon: --metrics-file is not supported, the metrics are served on /metrics")
+		os.Exit(1)
+	}
+
+	// the connections left open with --part never would pile up until the daemon stops
+	opts.daemon = true
+
+	// the state of the transfers is exposed through the metrics
+	setProgressOutput(ioutil.Discard)
+	registry.SetQueryObserver(metrics.providerQueried)
+
+	ctx, stop := interruptContext(context.Background())
+	defer stop()
+
+	d := &daemon{ctx: ctx, opts: opts, token: flags.token}
+	if opts.checkpointsPath != "" {
+		w, err := newCheckpointWriter(opts.checkpointsPath)
+		if err != nil {
+			logError("unable to write checkpoints: %s", err)
+			os.Exit(1)
+		}
+		defer w.Close()
+		d.checkpoints = w
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/search", d.authorize(d.handleSearch))
+	mux.HandleFunc("/downloads", d.authorize(d.handleDownloads))
+	mux.HandleFunc("/metrics", d.authorize(d.handleMetrics))
+	mux.HandleFunc("/transfers", d.authorize(d.handleTransfers))
+	mux.HandleFunc("/events", d.authorize(d.handleEvents))
+	mux.HandleFunc("/report", d.authorize(d.handleReport))
+
+	server := &http.Server{Addr: flags.addr, Handler: mux}
+	go func() {
+		logInfo("listening on %s", flags.addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logError(err.Error())
+			os.Exit(1)
+		}
+	}()
+
+	<-ctx.Done()
+	logInfo("shutting down, cancelling the active transfers")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), daemonShutdownTimeout)
+	defer cancel()
+	server.Shutdown(shutdownCtx)
+
+	d.wg.Wait()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/ostafen/xdcc-cli/pkg/xdcc"
+)
+
+const (
+	BatchConflictSuffix = "suffix"
+	BatchConflictBotDir = "bot-dir"
+)
+
+// resolveConflicts detects the items of the batch which would be saved to the same path,
+// and gives them distinct destinations before any transfer starts. Items whose file name
+// is not known in advance are ignored.
+func (batch *Batch) resolveConflicts(destTemplate string, policy string) {
+	now := time.Now()
+	destOf := func(item *batchItem) string {
+		return xdcc.ExpandDestTemplate(item.destTemplate, item.url, xdcc.AddNameSuffix(item.expectedName, item.nameSuffix), now)
+	}
+
+	groupByDest := func() map[string][]*batchItem {
+		groups := make(map[string][]*batchItem)
+		for _, item := range batch.items {
+			if item.expectedName != "" {
+				dest := destOf(item)
+				groups[dest] = append(groups[dest], item)
+			}
+		}
+		return groups
+	}
+
+	for _, item := range batch.items {
+		item.destTemplate = destTemplate
+	}
+
+	if policy == BatchConflictBotDir {
+		for dest, items := range groupByDest() {
+			if len(items) < 2 {
+				continue
+			}
+
+			logInfo("%d packs would be saved as %s, using a directory per bot", len(items), dest)
+			for _, item := range items {
+				item.destTemplate = filepath.Join("{bot}", destTemplate)
+			}
+		}
+	}
+
+	// packs offered by the same bot (or any pack, with the suffix policy) can still collide
+	for dest, items := range groupByDest() {
+		if len(items) < 2 {
+			continue
+		}
+
+		logInfo("%d packs would be saved as %s, adding a numeric suffix", len(items), dest)
+		for i, item := range items[1:] {
+			item.nameSuffix = "_" + strconv.Itoa(i+2)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var errDiskUsageUnsupported = errors.New("unable to get the available disk space on this platform")
+
+// existingDir returns the closest existing directory containing path,
+// since the destination directories are only created when a file is received.
+func existingDir(path string) string {
+	dir := filepath.Clean(path)
+	for {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// spaceReservation is the disk space needed by a file being received.
+type spaceReservation struct {
+	volume   string
+	filePath string
+	start    int64  // space allocated to the file when the space was reserved
+	size     uint64 // number of bytes to receive
+}
+
+// pending returns the number of bytes for which the disk blocks are not allocated yet. The blocks
+// preallocated by the transfer are already taken from the available space, and so are not counted.
+func (r *spaceReservation) pending() uint64 {
+	written := allocatedSize(r.filePath) - r.start
+	if written <= 0 {
+		return r.size
+	}
+	if uint64(written) >= r.size {
+		return 0
+	}
+	return r.size - uint64(written)
+}
+
+// spaceReserver keeps the concurrent transfers from filling the same disk, accounting
+// for the bytes which the other transfers have yet to write when checking the available space.
+type spaceReserver struct {
+	mu           sync.Mutex
+	reservations map[*spaceReservation]bool
+}
+
+var diskSpace = &spaceReserver{reservations: make(map[*spaceReservation]bool)}
+
+func (reserver *spaceReserver) pendingLocked(volume string) uint64 {
+	total := uint64(0)
+	for r := range reserver.reservations {
+		if r.volume == volume {
+			total += r.pending()
+		}
+	}
+	return total
+}
+
+// Reserve reserves the space needed to receive size more bytes of the file.
+// It fails if the space available, minus the one reserved by the other transfers, is not enough.
+func (reserver *spaceReserver) Reserve(filePath string, size uint64) (func(), error) {
+	free, volume, err := diskUsage(existingDir(filepath.Dir(filePath)))
+	if err != nil {
+		logAt(LogTransfers, "%s: not checking the available disk space: %s", filePath, err)
+		return func() {}, nil
+	}
+
+	reserver.mu.Lock()
+	defer reserver.mu.Unlock()
+
+	pending := reserver.pendingLocked(volume)
+	if pending+size > free {
+		return nil, newDiskSpaceError(filepath.Base(filePath), size, free, pending)
+	}
+
+	r := &spaceReservation{volume: volume, filePath: filePath, start: allocatedSize(filePath), size: size}
+	reserver.reservations[r] = true
+
+	return func() {
+		reserver.mu.Lock()
+		delete(reserver.reservations, r)
+		reserver.mu.Unlock()
+	}, nil
+}
+
+func newDiskSpaceError(what string, size uint64, free uint64, pending uint64) error {
+	available := "0 B"
+	if free > pending {
+		available = formatSize(int64(free - pending))
+	}
+
+	msg := fmt.Sprintf("not enough disk space for %s: %s needed, %s available", what, formatSize(int64(size)), available)
+	if pending > 0 {
+		msg += fmt.Sprintf(" (%s reserved by other transfers)", formatSize(int64(pending)))
+	}
+	return errors.New(msg + ", use --force to download anyway")
+}
+
+// checkBatchSpace checks that the advertised size of the requested files fits in the output folder.
+// Files whose size is unknown are not accounted for.
+func checkBatchSpace(requests []downloadRequest, opts *transferOptions) error {
+	total := uint64(0)
+	for _, req := range requests {
+		if req.fileSize > 0 {
+			total += uint64(req.fileSize)
+		}
+	}
+
+	if total == 0 {
+		return nil
+	}
+
+	free, volume, err := diskUsage(existingDir(opts.path))
+	if err != nil {
+		logAt(LogTransfers, "not checking the available disk space: %s", err)
+		return nil
+	}
+
+	diskSpace.mu.Lock()
+	pending := diskSpace.pendingLocked(volume)
+	diskSpace.mu.Unlock()
+
+	if pending+total > free {
+		what := fmt.Sprintf("%d files", len(requests))
+		if len(requests) == 1 {
+			what = requests[0].fileName
+		}
+		return newDiskSpaceError(what, total, free, pending)
+	}
+	return nil
+}
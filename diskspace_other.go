@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !windows
+// +build !linux,!darwin,!freebsd,!windows
+
+package main
+
+// diskUsage is not supported on this platform, so the available space is never checked.
+func diskUsage(dir string) (free uint64, volume string, err error) {
+	return 0, "", errDiskUsageUnsupported
+}
+
+// allocatedSize returns the disk space allocated to a file, the blocks being allocated as it is written.
+func allocatedSize(path string) int64 {
+	return fileSize(path)
+}
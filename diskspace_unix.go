@@ -0,0 +1,33 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package main
+
+import (
+	"strconv"
+	"syscall"
+)
+
+// diskUsage returns the space available to the user in the filesystem of dir, along with an identifier of the filesystem.
+func diskUsage(dir string) (free uint64, volume string, err error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return 0, "", err
+	}
+
+	var info syscall.Stat_t
+	if err := syscall.Stat(dir, &info); err != nil {
+		return 0, "", err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), strconv.FormatUint(uint64(info.Dev), 10), nil
+}
+
+// allocatedSize returns the disk space allocated to a file, which includes the blocks preallocated
+// past its end, or 0 if it does not exist.
+func allocatedSize(path string) int64 {
+	var info syscall.Stat_t
+	if err := syscall.Stat(path, &info); err != nil {
+		return 0
+	}
+	return int64(info.Blocks) * 512
+}
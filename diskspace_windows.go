@@ -0,0 +1,35 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceEx = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+// diskUsage returns the space available to the user in the volume of dir, along with the name of the volume.
+func diskUsage(dir string) (free uint64, volume string, err error) {
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return 0, "", err
+	}
+
+	path, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, "", err
+	}
+
+	if ret, _, err := procGetDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(path)), uintptr(unsafe.Pointer(&free)), 0, 0); ret == 0 {
+		return 0, "", err
+	}
+	return free, filepath.VolumeName(dir), nil
+}
+
+// allocatedSize returns the disk space allocated to a file, the blocks being allocated as it is written.
+func allocatedSize(path string) int64 {
+	return fileSize(path)
+}
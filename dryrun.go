@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ostafen/xdcc-cli/internal/group"
+	"github.com/ostafen/xdcc-cli/pkg/xdcc"
+)
+
+// dryRunTimeout is the time given to a bot to offer the requested file.
+const dryRunTimeout = time.Minute
+
+// dryRunResult is the outcome of requesting a pack without downloading it.
+type dryRunResult struct {
+	url    xdcc.IRCFileURL
+	offer  *xdcc.TransferOfferedEvent
+	queued *xdcc.TransferQueuedEvent // set if the bot queued the request instead of offering the file
+	err    error
+}
+
+// probe requests the pack to the bot and reports its offer, without accepting it.
+// Requests which are queued by the bot are removed from the queue.
+// Once the file is offered, the returned transfer must be left.
+func probe(ctx context.Context, url xdcc.IRCFileURL, opts *transferOptions, timeout time.Duration) (dryRunResult, *xdcc.Transfer) {
+	if err := config.Networks.Check(url.Network); err != nil {
+		return dryRunResult{url: url, err: err}, nil
+	}
+	if !opts.force {
+		if err := config.Bots.Check(url.Network, url.UserName); err != nil {
+			return dryRunResult{url: url, err: err}, nil
+		}
+	}
+
+	transfer := xdcc.NewTransfer(url, xdcc.TransferConfig{
+		EnableSSL:            !opts.noSSL,
+		SkipCertificateCheck: opts.skipCertificateCheck,
+		RequireTLSDCC:        opts.requireTLSDCC,
+		NoAutoJoin:           opts.noAutoJoin,
+		DryRun:               true,
+	})
+
+	// cancelling the transfer before the offer removes the request from the queue of the bot
+	transferCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := transfer.Start(transferCtx); err != nil {
+		return dryRunResult{url: url, err: err}, nil
+	}
+
+	evts := transfer.PollEvents()
+	for {
+		switch evt := (<-evts).(type) {
+		case *xdcc.TransferOfferedEvent:
+			return dryRunResult{url: url, offer: evt}, transfer
+		case *xdcc.TransferQueuedEvent:
+			return dryRunResult{url: url, queued: evt}, nil
+		case *xdcc.TransferAbortedEvent:
+			if transferCtx.Err() == context.DeadlineExceeded {
+				return dryRunResult{url: url, err: fmt.Errorf("no offer within %s", timeout)}, nil
+			}
+			if evt.Refused {
+				return dryRunResult{url: url, err: &transferError{reason: failureBotRefused, msg: evt.Error}}, nil
+			}
+			return dryRunResult{url: url, err: errors.New(evt.Error)}, nil
+		}
+	}
+}
+
+// dryRun probes the pack, leaving the channel in background through departures.
+func dryRun(ctx context.Context, url xdcc.IRCFileURL, opts *transferOptions, departures *group.Group) dryRunResult {
+	res, transfer := probe(ctx, url, opts, dryRunTimeout)
+	if transfer != nil {
+		departures.Go(func() error {
+			transfer.Leave(ctx, departureFor(&url, opts))
+			return nil
+		})
+	}
+	return res
+}
+
+func queueStatus(queued *xdcc.TransferQueuedEvent) string {
+	if queued.Position > 0 {
+		return fmt.Sprintf("queued at position %d", queued.Position)
+	}
+	return "queued"
+}
+
+func printDryRunResults(results []dryRunResult) {
+	printer := NewTablePrinter([]string{"URL", "File Name", "File Size", "Response Time", "Status"})
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			printer.AddRow(Row{r.url.String(), "", "", "", r.err.Error()})
+			continue
+		case r.queued != nil:
+			printer.AddRow(Row{r.url.String(), "", "", "", queueStatus(r.queued)})
+			continue
+		}
+
+		status := "offered"
+		if r.offer.Secure {
+			status = "offered over TLS"
+		}
+		printer.AddRow(Row{r.url.String(), r.offer.FileName, formatSize(int64(r.offer.FileSize)),
+			r.offer.ResponseTime.Round(time.Millisecond).String(), status})
+	}
+	printer.Print()
+}
+
+// dryRunFiles requests the files to the bots without downloading them, reporting the offers.
+// It returns a commandFailure if some bot did not offer its file, unless failures are ignored.
+func dryRunFiles(ctx context.Context, requests []downloadRequest, opts *transferOptions) error {
+	ctx, stop := interruptContext(ctx)
+	defer stop()
+
+	results := make([]dryRunResult, len(requests))
+	departures := &group.Group{}
+
+	g := &group.Group{}
+	g.SetLimit(opts.maxParallel)
+	for i, req := range requests {
+		i, req := i, req
+		g.Go(func() error {
+			if ctx.Err() != nil {
+				results[i] = dryRunResult{url: req.url, err: errors.New("cancelled")}
+				return nil
+			}
+
+			results[i] = dryRun(ctx, req.url, opts, departures)
+			if results[i].err != nil && opts.failFast {
+				stop()
+			}
+			return nil
+		})
+	}
+	g.Wait()
+
+	printDryRunResults(results)
+	departures.Wait()
+
+	if opts.ignoreFailures {
+		return nil
+	}
+
+	failures := make([]transferFailure, 0)
+	for _, r := range results {
+		if r.err != nil {
+			failures = append(failures, transferFailure{Source: r.url.String(), Error: r.err.Error(), Reason: failureReason(r.err)})
+		}
+	}
+	if len(failures) > 0 {
+		return transfersFailure(len(failures), failures)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// The exit codes of the commands, which tell the scripts what went wrong.
+// Invalid usage, configuration and any other error exit with exitCodeError.
+const (
+	exitCodeError            = 1
+	exitCodeInvalidFlags     = 2 // exited by the flag package when the switches cannot be parsed
+	exitCodeNoResults        = 3
+	exitCodeProviderFailure  = 4 // every search engine failed
+	exitCodeTransferFailed   = 5
+	exitCodeChecksumMismatch = 6
+	exitCodeBotRefused       = 7
+	exitCodeCancelled        = exitCodeInterrupted
+)
+
+// The reasons of the failures, as reported with --errors json.
+const (
+	failureError            = "error"
+	failureNoResults        = "no_results"
+	failureProviderFailure  = "provider_failure"
+	failureTransferFailed   = "transfer_failed"
+	failureChecksumMismatch = "checksum_mismatch"
+	failureBotRefused       = "bot_refused"
+	failureCancelled        = "cancelled"
+)
+
+var failureExitCodes = map[string]int{
+	failureError:            exitCodeError,
+	failureNoResults:        exitCodeNoResults,
+	failureProviderFailure:  exitCodeProviderFailure,
+	failureTransferFailed:   exitCodeTransferFailed,
+	failureChecksumMismatch: exitCodeChecksumMismatch,
+	failureBotRefused:       exitCodeBotRefused,
+	failureCancelled:        exitCodeCancelled,
+}
+
+const (
+	errorsFormatText = "text"
+	errorsFormatJSON = "json"
+)
+
+// errorsFormat is the format of the failure reports, set with --errors.
+var errorsFormat = errorsFormatText
+
+// transferError is the error of a failed transfer, along with its reason.
+type transferError struct {
+	reason string
+	msg    string
+}
+
+func (err *transferError) Error() string {
+	return err.msg
+}
+
+// failureReason returns the reason of the failure of a transfer.
+func failureReason(err error) string {
+	if err, ok := err.(*transferError); ok {
+		return err.reason
+	}
+	return failureTransferFailed
+}
+
+// commandFailure is the reason why a command failed, which determines its exit code.
+type commandFailure struct {
+	Code      int               `json:"code"`
+	Reason    string            `json:"reason"`
+	Message   string            `json:"message"`
+	Transfers []transferFailure `json:"transfers,omitempty"`
+}
+
+func (failure *commandFailure) Error() string {
+	return failure.Message
+}
+
+func newCommandFailure(reason string, format string, args ...interface{}) *commandFailure {
+	return &commandFailure{Code: failureExitCodes[reason], Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+// transfersFailure returns the failure of a batch where count transfers failed. Its reason is the
+// one of every reported failure if they have the same, or failureTransferFailed otherwise.
+func transfersFailure(count int, failures []transferFailure) *commandFailure {
+	reason := ""
+	for i, f := range failures {
+		if i == 0 {
+			reason = f.Reason
+		} else if f.Reason != reason {
+			reason = failureTransferFailed
+		}
+	}
+	if failureExitCodes[reason] == 0 {
+		reason = failureTransferFailed
+	}
+
+	failure := newCommandFailure(reason, "%d of the transfers failed", count)
+	failure.Transfers = failures
+	return failure
+}
+
+// exitWithFailure exits with the exit code of err, reporting it as JSON on stderr with --errors json.
+// Errors which are not a commandFailure exit with exitCodeError.
+func exitWithFailure(err error) {
+	failure, ok := err.(*commandFailure)
+	if !ok {
+		failure = newCommandFailure(failureError, "%s", err)
+	}
+
+	if errorsFormat == errorsFormatJSON {
+		data, _ := json.Marshal(failure)
+		fmt.Fprintln(os.Stderr, string(data))
+	}
+	os.Exit(failure.Code)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultExtractCommand = "7z"
+
+// ExtractConfig holds the defaults of the extraction of the downloaded archives.
+type ExtractConfig struct {
+	Enabled        bool     `json:"enabled"`
+	Command        string   `json:"command"`      // 7-Zip program, "7z" by default
+	Dir            string   `json:"dir"`          // destination, relative to the folder of the archive unless absolute
+	Passwords      []string `json:"passwords"`    // tried in order on encrypted archives
+	PasswordFile   string   `json:"passwordFile"` // file with a password per line, tried after Passwords
+	DeleteArchives bool     `json:"deleteArchives"`
+}
+
+// archiveSet is an archive, made of one or several volumes.
+type archiveSet struct {
+	first   string // path of the volume given to the extraction program
+	volumes []string
+}
+
+// volumePatterns match the volumes of the multi-part archives. The first submatch is the
+// name shared by the volumes, the second the number of the volume, if any.
+var volumePatterns = []struct {
+	pattern     *regexp.Regexp
+	volume      string // pattern of the volumes of the set, given the quoted shared name
+	firstVolume func(stem string) string
+}{
+	{regexp.MustCompile(`(?i)^(.+)\.part(\d+)\.rar$`), `(?i)^%s\.part(\d+)\.rar$`, nil},
+	{regexp.MustCompile(`(?i)^(.+\.(?:7z|zip|rar))\.(\d+)$`), `(?i)^%s\.(\d+)$`, nil},
+	{regexp.MustCompile(`(?i)^(.+)\.(?:rar|r\d\d)$`), `(?i)^%s\.(?:rar|r\d\d)$`, func(stem string) string { return stem + ".rar" }},
+	{regexp.MustCompile(`(?i)^(.+)\.(?:zip|z\d\d)$`), `(?i)^%s\.(?:zip|z\d\d)$`, func(stem string) string { return stem + ".zip" }},
+	{regexp.MustCompile(`(?i)^(.+)\.7z$`), `(?i)^%s\.7z$`, func(stem string) string { return stem + ".7z" }},
+}
+
+// findArchiveSet returns the archive the file is a volume of, looking for the other volumes
+// in its folder. It returns nil if the file is not an archive.
+func findArchiveSet(path string) (*archiveSet, error) {
+	dir, name := filepath.Split(path)
+	for _, p := range volumePatterns {
+		m := p.pattern.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+
+		files, err := ioutil.ReadDir(filepath.Clean(dir))
+		if err != nil {
+			return nil, err
+		}
+
+		volume := regexp.MustCompile(fmt.Sprintf(p.volume, regexp.QuoteMeta(m[1])))
+		set := &archiveSet{}
+		firstNumber := -1
+		for _, f := range files {
+			vm := volume.FindStringSubmatch(f.Name())
+			if vm == nil || f.IsDir() {
+				continue
+			}
+			set.volumes = append(set.volumes, filepath.Join(dir, f.Name()))
+
+			if p.firstVolume != nil {
+				if strings.EqualFold(f.Name(), filepath.Base(p.firstVolume(m[1]))) {
+					set.first = filepath.Join(dir, f.Name())
+				}
+			} else if n, _ := strconv.Atoi(vm[1]); firstNumber < 0 || n < firstNumber {
+				firstNumber = n
+				set.first = filepath.Join(dir, f.Name())
+			}
+		}
+
+		if set.first == "" || p.firstVolume == nil && firstNumber != 1 {
+			return nil, fmt.Errorf("first volume of %s not found", name)
+		}
+		sort.Strings(set.volumes)
+		return set, nil
+	}
+	return nil, nil
+}
+
+// extractor extracts the archives with 7-Zip, trying each password on the encrypted ones.
+type extractor struct {
+	command        string
+	dir            string
+	passwords      []string
+	deleteArchives bool
+}
+
+func readPasswordFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	passwords := make([]string, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), "\r"); line != "" {
+			passwords = append(passwords, line)
+		}
+	}
+	return passwords, scanner.Err()
+}
+
+// newExtractor returns the extractor of the options, or nil if extraction is disabled.
+func newExtractor(opts *transferOptions) (*extractor, error) {
+	if !opts.extract && !config.Extract.Enabled {
+		return nil, nil
+	}
+
+	x := &extractor{
+		command:        config.Extract.Command,
+		dir:            config.Extract.Dir,
+		passwords:      []string{""}, // archives without password first
+		deleteArchives: config.Extract.DeleteArchives || opts.deleteArchives,
+	}
+
+	if x.command == "" {
+		x.command = defaultExtractCommand
+	}
+	if opts.extractDir != "" {
+		x.dir = opts.extractDir
+	}
+	x.passwords = append(x.passwords, config.Extract.Passwords...)
+
+	for _, path := range []string{config.Extract.PasswordFile, opts.passwordFile} {
+		if path == "" {
+			continue
+		}
+
+		passwords, err := readPasswordFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read passwords: %s", err)
+		}
+		x.passwords = append(x.passwords, passwords...)
+	}
+
+	if _, err := exec.LookPath(x.command); err != nil {
+		return nil, fmt.Errorf("extraction requires 7-Zip (%s): %s", x.command, err)
+	}
+	return x, nil
+}
+
+// run7z runs 7-Zip, returning its last line of output as error if it fails.
+func (x *extractor) run7z(ctx context.Context, args ...string) error {
+	out, err := exec.CommandContext(ctx, x.command, args...).CombinedOutput()
+	if err == nil {
+		return nil
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if msg := strings.TrimSpace(lines[len(lines)-1]); msg != "" {
+		return errors.New(msg)
+	}
+	return err
+}
+
+// destination returns the folder where the archive is extracted.
+func (x *extractor) destination(set *archiveSet) string {
+	dir := filepath.Dir(set.first)
+	if x.dir == "" {
+		return dir
+	}
+	if filepath.IsAbs(x.dir) {
+		return x.dir
+	}
+	return filepath.Join(dir, x.dir)
+}
+
+// extract tests the archive with each password until one of them works, then extracts it
+// without overwriting the existing files, which are renamed instead.
+func (x *extractor) extract(ctx context.Context, set *archiveSet) (string, error) {
+	var testErr error
+	for _, password := range x.passwords {
+		if testErr = x.run7z(ctx, "t", "-y", "-p"+password, set.first); testErr != nil {
+			continue
+		}
+
+		dest := x.destination(set)
+		if err := x.run7z(ctx, "x", "-y", "-aou", "-p"+password, "-o"+dest, set.first); err != nil {
+			return "", err
+		}
+		return dest, nil
+	}
+
+	if len(x.passwords) > 1 {
+		return "", fmt.Errorf("verification failed with %d passwords: %s", len(x.passwords), testErr)
+	}
+	return "", fmt.Errorf("verification failed: %s", testErr)
+}
+
+// completedArchives returns the archives of the files downloaded by the batch.
+func (batch *Batch) completedArchives() []*archiveSet {
+	sets := make([]*archiveSet, 0)
+	seen := make(map[string]bool)
+	for _, entry := range batch.manifestEntries() {
+		if entry.Status != string(itemStateCompleted) || entry.Path == "" {
+			continue
+		}
+
+		set, err := findArchiveSet(entry.Path)
+		if err != nil {
+			logWarn("%s: not extracted, %s", entry.Path, err)
+			continue
+		}
+
+		if set != nil && !seen[set.first] {
+			seen[set.first] = true
+			sets = append(sets, set)
+		}
+	}
+	return sets
+}
+
+// extractArchives extracts the archives downloaded by the batch, deleting them if asked to.
+func extractArchives(ctx context.Context, batch *Batch, x *extractor) {
+	for _, set := range batch.completedArchives() {
+		if ctx.Err() != nil {
+			return
+		}
+
+		logInfo("extracting %s", set.first)
+		dest, err := x.extract(ctx, set)
+		if err != nil {
+			logError("%s: unable to extract: %s", set.first, err)
+			continue
+		}
+		logInfo("extracted %s to %s", filepath.Base(set.first), dest)
+
+		if !x.deleteArchives {
+			continue
+		}
+
+		for _, volume := range set.volumes {
+			if err := os.Remove(volume); err != nil {
+				logWarn("unable to delete %s: %s", volume, err)
+			}
+		}
+	}
+}
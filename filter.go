@@ -0,0 +1,115 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/ostafen/xdcc-cli/pkg/search"
+)
+
+// ResultFilter selects the search results matching a known file,
+// e.g. to find an alternative source for a partially downloaded one.
+type ResultFilter struct {
+	Size          int64
+	SizeTolerance int64
+	Hash          string
+	Query         *SearchQuery
+	Sample        int      // maximum number of results kept for each provider, in the provider order
+	Tags          []string // tags of the bot, channel or network set through the notes subcommand
+}
+
+func (filter *ResultFilter) IsEmpty() bool {
+	return filter.Size <= 0 && filter.Hash == "" && filter.Sample <= 0 && len(filter.Tags) == 0 &&
+		(filter.Query == nil || !filter.Query.hasOperators())
+}
+
+// tagList is a flag which can be repeated, each value being a comma separated list of tags.
+type tagList []string
+
+func (tags *tagList) String() string {
+	return strings.Join(*tags, ",")
+}
+
+func (tags *tagList) Set(value string) error {
+	for _, tag := range strings.Split(value, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			*tags = append(*tags, tag)
+		}
+	}
+	return nil
+}
+
+var crc32TagRegexp = regexp.MustCompile(`[\[(]([0-9A-Fa-f]{8})[\])]`)
+
+// resultHash returns the hash of the file, either as reported by the provider or,
+// as most releases do, from the CRC32 tag of its name (e.g. "[1A2B3C4D]").
+func resultHash(info *search.FileInfo) string {
+	if info.Hash != "" {
+		return info.Hash
+	}
+	return crc32Tag(info.Name)
+}
+
+// crc32Tag returns the CRC32 tag of the file name, or an empty string if it has none.
+func crc32Tag(name string) string {
+	matches := crc32TagRegexp.FindAllStringSubmatch(name, -1)
+	if len(matches) == 0 {
+		return ""
+	}
+	return matches[len(matches)-1][1]
+}
+
+func (filter *ResultFilter) Match(info *search.FileInfo) bool {
+	if filter.Size > 0 {
+		diff := info.Size - filter.Size
+		if diff < 0 {
+			diff = -diff
+		}
+
+		if info.Size <= 0 || diff > filter.SizeTolerance {
+			return false
+		}
+	}
+
+	if filter.Hash != "" && !strings.EqualFold(resultHash(info), filter.Hash) {
+		return false
+	}
+
+	if len(filter.Tags) > 0 {
+		if tags, _ := userNotes.lookupResult(info); !matchTags(tags, filter.Tags) {
+			return false
+		}
+	}
+	return filter.Query == nil || filter.Query.Match(info)
+}
+
+func (filter *ResultFilter) Apply(results []search.FileInfo) []search.FileInfo {
+	filtered := make([]search.FileInfo, 0, len(results))
+	for i := range results {
+		if filter.Match(&results[i]) {
+			filtered = append(filtered, results[i])
+		}
+	}
+	return filtered
+}
+
+// FilterResultsAsync drops the results not matching the filter from the provider results,
+// keeping at most filter.Sample results for each provider.
+func FilterResultsAsync(resultsChan <-chan search.ProviderResult, filter *ResultFilter) <-chan search.ProviderResult {
+	if filter.IsEmpty() {
+		return resultsChan
+	}
+
+	filteredChan := make(chan search.ProviderResult, cap(resultsChan))
+	go func() {
+		for r := range resultsChan {
+			r.Results = filter.Apply(r.Results)
+			if filter.Sample > 0 && len(r.Results) > filter.Sample {
+				r.Results = r.Results[:filter.Sample]
+			}
+			filteredChan <- r
+		}
+		close(filteredChan)
+	}()
+	return filteredChan
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const defaultForwardCommand = "rclone"
+
+// ForwardConfig holds the defaults of the forwarding of the downloaded files to remote storage.
+type ForwardConfig struct {
+	Targets     []string `json:"targets"`     // every completed file is uploaded to each of them
+	Command     string   `json:"command"`     // rclone program, "rclone" by default
+	DeleteLocal bool     `json:"deleteLocal"` // delete the files once uploaded to every target
+}
+
+// forwarder uploads the downloaded files to remote storage with rclone. The targets are rclone
+// remotes ("nas:backup"), local folders, or sftp://, webdav://, webdavs:// and s3:// urls, which
+// are turned into rclone on the fly remotes. Their query parameters are options of the remote,
+// e.g. "s3://bucket/dir?provider=Minio&endpoint=http://nas:9000".
+type forwarder struct {
+	command     string
+	targets     []string // of every item without targets of its own
+	deleteLocal bool
+}
+
+// newForwarder returns the forwarder of the options. Items can have targets of their own,
+// so it is returned even without global targets.
+func newForwarder(opts *transferOptions) (*forwarder, error) {
+	f := &forwarder{
+		command:     config.Forward.Command,
+		targets:     append([]string(nil), config.Forward.Targets...),
+		deleteLocal: config.Forward.DeleteLocal || opts.deleteForwarded,
+	}
+
+	if f.command == "" {
+		f.command = defaultForwardCommand
+	}
+	if len(opts.forwardTargets) > 0 {
+		f.targets = opts.forwardTargets
+	}
+
+	for _, target := range f.targets {
+		if _, err := rcloneRemote(target); err != nil {
+			return nil, fmt.Errorf("invalid forward target %s: %s", target, err)
+		}
+	}
+
+	if len(f.targets) > 0 {
+		if _, err := exec.LookPath(f.command); err != nil {
+			return nil, fmt.Errorf("forwarding requires rclone (%s): %s", f.command, err)
+		}
+	}
+	return f, nil
+}
+
+// rcloneBackends are the url schemes of the targets, with their rclone backend.
+var rcloneBackends = map[string]string{
+	"sftp":    "sftp",
+	"webdav":  "webdav",
+	"webdavs": "webdav",
+	"s3":      "s3",
+}
+
+// rcloneOption formats an option of an on the fly remote, quoting its value if needed.
+func rcloneOption(name string, value string) string {
+	if strings.ContainsAny(value, ",:='\"") {
+		value = "'" + strings.Replace(value, "'", "''", -1) + "'"
+	}
+	return name + "=" + value
+}
+
+// rcloneRemote returns the rclone path of the target.
+func rcloneRemote(target string) (string, error) {
+	if strings.HasPrefix(target, "-") {
+		return "", errors.New("targets cannot start with -") // taken for an option by rclone
+	}
+
+	u, err := url.Parse(target)
+	if err != nil || rcloneBackends[u.Scheme] == "" {
+		if strings.TrimSpace(target) == "" {
+			return "", errors.New("empty target")
+		}
+		return target, nil // a remote of the rclone configuration, or a local folder
+	}
+
+	backend := rcloneBackends[u.Scheme]
+	options := make([]string, 0)
+	path := strings.TrimPrefix(u.Path, "/")
+
+	switch backend {
+	case "sftp":
+		path = u.Path // absolute, unlike the paths of the other remotes
+		options = append(options, rcloneOption("host", u.Hostname()))
+		if port := u.Port(); port != "" {
+			options = append(options, rcloneOption("port", port))
+		}
+		if u.User != nil {
+			options = append(options, rcloneOption("user", u.User.Username()))
+		}
+	case "webdav":
+		scheme := "http"
+		if u.Scheme == "webdavs" {
+			scheme = "https"
+		}
+		options = append(options, rcloneOption("url", scheme+"://"+u.Host+u.Path))
+		if u.User != nil {
+			options = append(options, rcloneOption("user", u.User.Username()))
+		}
+		path = ""
+	case "s3":
+		// the credentials come from the environment (AWS_ACCESS_KEY_ID, ...) unless given as options
+		options = append(options, "env_auth=true")
+		path = u.Host + "/" + path
+	}
+
+	if u.Host == "" {
+		return "", errors.New("missing host")
+	}
+
+	query := u.Query()
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		options = append(options, rcloneOption(name, query.Get(name)))
+	}
+	return ":" + backend + "," + strings.Join(options, ",") + ":" + strings.TrimSuffix(path, "/"), nil
+}
+
+// remotePath returns the rclone path of a file in the target.
+func remotePath(remote string, rel string) string {
+	rel = filepath.ToSlash(rel)
+	if strings.HasSuffix(remote, ":") || strings.HasSuffix(remote, "/") {
+		return remote + rel
+	}
+	return remote + "/" + rel
+}
+
+// runRclone runs rclone, returning its last line of output as error if it fails.
+func (f *forwarder) runRclone(ctx context.Context, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, f.command, args...)
+	out, err := cmd.Output()
+	if err == nil {
+		return out, nil
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		lines := strings.Split(strings.TrimSpace(string(exitErr.Stderr)), "\n")
+		if msg := strings.TrimSpace(lines[len(lines)-1]); msg != "" {
+			return nil, errors.New(msg)
+		}
+	}
+	return nil, err
+}
+
+// upload copies the file to the target, checking that the uploaded file has the size of the local one.
+func (f *forwarder) upload(ctx context.Context, path string, rel string, target string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	remote, err := rcloneRemote(target)
+	if err != nil {
+		return err
+	}
+	dest := remotePath(remote, rel)
+
+	if _, err := f.runRclone(ctx, "copyto", path, dest); err != nil {
+		return err
+	}
+
+	out, err := f.runRclone(ctx, "size", "--json", dest)
+	if err != nil {
+		return fmt.Errorf("unable to verify the upload: %s", err)
+	}
+
+	var size struct {
+		Count int   `json:"count"`
+		Bytes int64 `json:"bytes"`
+	}
+	if err := json.Unmarshal(out, &size); err != nil {
+		return fmt.Errorf("unable to verify the upload: %s", err)
+	}
+	if size.Count != 1 || size.Bytes != info.Size() {
+		return fmt.Errorf("uploaded %d bytes instead of %d", size.Bytes, info.Size())
+	}
+	return nil
+}
+
+// forwardedFile is a completed file of the batch, with the targets it is uploaded to.
+type forwardedFile struct {
+	path    string
+	rel     string // path within the download folder, kept in the targets
+	targets []string
+}
+
+// forwardedFiles returns the completed files of the batch which have forward targets.
+func (batch *Batch) forwardedFiles(f *forwarder, downloadDir string) []forwardedFile {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	files := make([]forwardedFile, 0)
+	for _, item := range batch.items {
+		if item.state != itemStateCompleted || item.filePath == "" {
+			continue
+		}
+
+		targets := item.forwardTargets
+		if len(targets) == 0 {
+			targets = f.targets
+		}
+		if len(targets) == 0 {
+			continue
+		}
+
+		rel, err := filepath.Rel(downloadDir, item.filePath)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			rel = filepath.Base(item.filePath)
+		}
+		files = append(files, forwardedFile{path: item.filePath, rel: rel, targets: targets})
+	}
+	return files
+}
+
+// forwardFiles uploads the files downloaded by the batch to their targets, deleting
+// the local files uploaded to every target if asked to.
+func forwardFiles(ctx context.Context, batch *Batch, f *forwarder, downloadDir string) {
+	for _, file := range batch.forwardedFiles(f, downloadDir) {
+		uploaded := true
+		for _, target := range file.targets {
+			if ctx.Err() != nil {
+				return
+			}
+
+			logInfo("uploading %s to %s", file.rel, target)
+			if err := f.upload(ctx, file.path, file.rel, target); err != nil {
+				logError("%s: unable to upload to %s: %s", file.path, target, err)
+				uploaded = false
+				continue
+			}
+			logInfo("uploaded %s to %s", file.rel, target)
+		}
+
+		if uploaded && f.deleteLocal {
+			if err := os.Remove(file.path); err != nil {
+				logWarn("unable to delete %s: %s", file.path, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ostafen/xdcc-cli/pkg/xdcc"
+)
+
+// parseBotRef parses the reference of a bot, network/#channel/bot or irc://network/channel/bot.
+func parseBotRef(ref string) (*xdcc.IRCFileURL, error) {
+	// a bot is referred to as its packs, without the slot
+	url, err := xdcc.ParsePackRef(strings.TrimSuffix(ref, "/") + "/#0")
+	if err != nil {
+		return nil, errors.New("invalid bot reference: network/#channel/bot expected")
+	}
+	return url, nil
+}
+
+// parseFServeScript splits a script such as "cd movies; get file.mkv" into its commands,
+// checking that it ends by requesting a file.
+func parseFServeScript(script string) ([]string, error) {
+	commands := make([]string, 0)
+	for _, command := range strings.Split(script, ";") {
+		if command = strings.TrimSpace(command); command != "" {
+			commands = append(commands, command)
+		}
+	}
+
+	for i, command := range commands {
+		if strings.EqualFold(strings.Fields(command)[0], "get") {
+			if i < len(commands)-1 {
+				return nil, errors.New("the script must end with its get command")
+			}
+			return commands, nil
+		}
+	}
+	return nil, errors.New("the script does not get any file")
+}
+
+// stdinCommands returns the function reading the fserve commands typed by the user.
+func stdinCommands() func() (string, bool) {
+	scanner := bufio.NewScanner(os.Stdin)
+	return func() (string, bool) {
+		fmt.Fprint(os.Stderr, "fserve> ")
+		if !scanner.Scan() {
+			return "", false
+		}
+		return scanner.Text(), true
+	}
+}
+
+// fserveFlags holds the flags of the fserve subcommand.
+type fserveFlags struct {
+	trigger  string
+	script   string
+	transfer *transferOptions
+	log      *logFlags
+}
+
+// newFServeFlagSet returns the flags of the fserve subcommand, also used by the completion.
+func newFServeFlagSet() (*flag.FlagSet, *fserveFlags) {
+	fserveCmd := flag.NewFlagSet("fserve", flag.ExitOnError)
+	flags := &fserveFlags{}
+	fserveCmd.StringVar(&flags.trigger, "trigger", "", "CTCP trigger of the fserve (e.g. !files)")
+	fserveCmd.StringVar(&flags.script, "script", "", "commands sent to the fserve, separated by ';' and ending with get (e.g. \"cd movies; get file.mkv\").\nWithout script, the commands are read from the standard input")
+	flags.transfer = addTransferFlags(fserveCmd)
+	flags.log = addLogFlags(fserveCmd)
+	return fserveCmd, flags
+}
+
+func fserveCommand(args []string) {
+	fserveCmd, flags := newFServeFlagSet()
+	opts := flags.transfer
+
+	refs := parseFlags(fserveCmd, args)
+	flags.log.apply()
+
+	if len(refs) != 1 || flags.trigger == "" {
+		fmt.Printf("usage: fserve network/#channel/bot --trigger trigger [--script \"cd dir; get file\"] [-o path]\n\nFlag set:\n")
+		fserveCmd.PrintDefaults()
+		os.Exit(1)
+	}
+
+	if opts.dryRun || opts.mirror {
+		logError("fserve: --dry-run and --mirror are not supported")
+		os.Exit(1)
+	}
+
+	url, err := parseBotRef(refs[0])
+	if err != nil {
+		logError("%s: %s", refs[0], err)
+		os.Exit(1)
+	}
+
+	fserve := &xdcc.FServe{Trigger: flags.trigger}
+	if flags.script != "" {
+		if fserve.Commands, err = parseFServeScript(flags.script); err != nil {
+			logError("fserve: %s", err)
+			os.Exit(1)
+		}
+	} else {
+		fserve.NextCommand = stdinCommands()
+		fserve.OnLine = func(line string) { fmt.Println(line) }
+		opts.stdinCommands = true
+	}
+
+	requests := []downloadRequest{{url: *url, fserve: fserve}}
+	if err := downloadFiles(context.Background(), requests, opts); err != nil {
+		exitWithFailure(err)
+	}
+}
@@ -1,4 +1,4 @@
-module xdcc-cli
+module github.com/ostafen/xdcc-cli
 
 go 1.13
 
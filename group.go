@@ -0,0 +1,438 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ostafen/xdcc-cli/pkg/search"
+)
+
+// release is what the name of a file tells about the episode of a show it holds.
+type release struct {
+	key        string // identifies the show, regardless of the naming of the releases
+	title      string
+	season     int // 0 if unknown, e.g. for the absolute numbering of anime
+	episode    int // 0 for the packs of a whole season
+	resolution string
+}
+
+var (
+	releaseGroupRegexp    = regexp.MustCompile(`^\s*(\[[^\]]*\]\s*)+`)
+	releaseSpacesRegexp   = regexp.MustCompile(`[\s._]+`)
+	seasonEpisodeRegexp   = regexp.MustCompile(`(?i)\bS(\d{1,2}) ?E(\d{1,4})\b`)
+	crossEpisodeRegexp    = regexp.MustCompile(`(?i)\b(\d{1,2})x(\d{2,3})\b`)
+	wordsEpisodeRegexp    = regexp.MustCompile(`(?i)\bSeason (\d{1,2}),? Episode (\d{1,4})\b`)
+	seasonPackRegexp      = regexp.MustCompile(`(?i)\b(?:S|Season )(\d{1,2})\b`)
+	absoluteEpisodeRegexp = regexp.MustCompile(`(?i)(?: - |\bE|\bEp |\bEpisode )(\d{1,4})(?:v\d)?\b`)
+	resolutionRegexp      = regexp.MustCompile(`(?i)\b(?:(2160|1440|1080|720|576|480)[pi]|\d{3,4}x(2160|1440|1080|720|576|480)|(4K|UHD))\b`)
+)
+
+// parseRelease parses the common naming of the releases of shows, such as "Show.Name.S01E02.1080p.mkv",
+// "Show Name 1x02", "Show.Name.S01.Complete" (a season pack) and "[Group] Show Name - 02 (1080p).mkv".
+func parseRelease(name string) (release, bool) {
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+	name = releaseGroupRegexp.ReplaceAllString(name, "")
+	name = releaseSpacesRegexp.ReplaceAllString(name, " ")
+
+	r := release{resolution: parseResolution(name)}
+
+	var loc []int
+	for _, re := range []*regexp.Regexp{seasonEpisodeRegexp, crossEpisodeRegexp, wordsEpisodeRegexp} {
+		if loc = re.FindStringSubmatchIndex(name); loc != nil {
+			r.season, _ = strconv.Atoi(name[loc[2]:loc[3]])
+			r.episode, _ = strconv.Atoi(name[loc[4]:loc[5]])
+			break
+		}
+	}
+
+	switch {
+	case loc != nil:
+	case seasonPackRegexp.MatchString(name):
+		loc = seasonPackRegexp.FindStringSubmatchIndex(name)
+		r.season, _ = strconv.Atoi(name[loc[2]:loc[3]])
+	case absoluteEpisodeRegexp.MatchString(name):
+		loc = absoluteEpisodeRegexp.FindStringSubmatchIndex(name)
+		r.episode, _ = strconv.Atoi(name[loc[2]:loc[3]])
+		if r.episode >= 1900 && r.episode < 2100 {
+			return r, false // the year of a movie, rather than an episode
+		}
+	default:
+		return r, false
+	}
+
+	r.title = strings.Trim(name[:loc[0]], " -([")
+	if r.title == "" || (r.episode == 0 && r.season == 0) {
+		return r, false
+	}
+	r.key = strings.ToLower(r.title)
+	return r, true
+}
+
+func parseResolution(name string) string {
+	m := resolutionRegexp.FindStringSubmatch(name)
+	switch {
+	case m == nil:
+		return ""
+	case m[1] != "":
+		return m[1] + "p"
+	case m[2] != "":
+		return m[2] + "p"
+	}
+	return "2160p"
+}
+
+// episodeGroup holds the results offering the same episode of a show.
+type episodeGroup struct {
+	episode    int // 0 for the packs of the whole season
+	best       search.FileInfo
+	candidates int
+}
+
+// resultGroup holds the results of a season of a show.
+type resultGroup struct {
+	title       string
+	season      int
+	episodes    []*episodeGroup
+	resolutions []string
+	results     int
+}
+
+// missingEpisodes returns the episodes missing between the first and the last found.
+func (group *resultGroup) missingEpisodes() []int {
+	missing := make([]int, 0)
+	next := 0
+	for _, ep := range group.episodes {
+		if ep.episode == 0 {
+			continue
+		}
+		if next > 0 {
+			for n := next; n < ep.episode; n++ {
+				missing = append(missing, n)
+			}
+		}
+		next = ep.episode + 1
+	}
+	return missing
+}
+
+func (group *resultGroup) String() string {
+	s := group.title
+	if group.season > 0 {
+		s += fmt.Sprintf(" - Season %d", group.season)
+	}
+
+	numEpisodes := 0
+	for _, ep := range group.episodes {
+		if ep.episode > 0 {
+			numEpisodes++
+		}
+	}
+
+	details := make([]string, 0)
+	if numEpisodes > 0 {
+		details = append(details, fmt.Sprintf("%d episodes", numEpisodes))
+	}
+	if len(group.episodes) > numEpisodes {
+		details = append(details, "season pack")
+	}
+	if missing := group.missingEpisodes(); len(missing) > 0 {
+		details = append(details, "missing "+joinInts(missing))
+	}
+	if len(group.resolutions) > 0 {
+		details = append(details, strings.Join(group.resolutions, "/"))
+	}
+	details = append(details, fmt.Sprintf("%d results", group.results))
+	return s + " (" + strings.Join(details, ", ") + ")"
+}
+
+func joinInts(values []int) string {
+	s := make([]string, 0, len(values))
+	for _, v := range values {
+		s = append(s, strconv.Itoa(v))
+	}
+	return strings.Join(s, ", ")
+}
+
+// groupRow is a displayed row of the grouped results: the best candidate of an episode,
+// or a result whose name tells no episode.
+type groupRow struct {
+	group   *resultGroup // nil for the other results
+	episode *episodeGroup
+	info    *search.FileInfo
+}
+
+// groupResults groups the results by show and season, keeping the best candidate of each episode,
+// which is the one downloaded by --top among the results offering it. The results whose names tell no
+// episode come last, in the usual order.
+func groupResults(res []search.FileInfo, sortBy string) []groupRow {
+	type episodeKey struct {
+		key     string
+		season  int
+		episode int
+	}
+
+	byEpisode := make(map[episodeKey][]search.FileInfo)
+	groups := make(map[episodeKey]*resultGroup) // by show and season, the episode being 0
+	resolutions := make(map[episodeKey]map[string]bool)
+	other := make([]search.FileInfo, 0)
+
+	for i := range res {
+		r, ok := parseRelease(res[i].Name)
+		if !ok {
+			other = append(other, res[i])
+			continue
+		}
+
+		seasonKey := episodeKey{key: r.key, season: r.season}
+		group := groups[seasonKey]
+		if group == nil {
+			group = &resultGroup{title: r.title, season: r.season}
+			groups[seasonKey] = group
+			resolutions[seasonKey] = make(map[string]bool)
+		}
+		group.results++
+		if r.resolution != "" {
+			resolutions[seasonKey][r.resolution] = true
+		}
+
+		key := episodeKey{key: r.key, season: r.season, episode: r.episode}
+		byEpisode[key] = append(byEpisode[key], res[i])
+	}
+
+	for key, candidates := range byEpisode {
+		group := groups[episodeKey{key: key.key, season: key.season}]
+		group.episodes = append(group.episodes, &episodeGroup{
+			episode:    key.episode,
+			best:       *topResult(candidates, sortBy),
+			candidates: len(candidates),
+		})
+	}
+
+	sorted := make([]*resultGroup, 0, len(groups))
+	for key, group := range groups {
+		sort.Slice(group.episodes, func(i, j int) bool { return group.episodes[i].episode < group.episodes[j].episode })
+		for resolution := range resolutions[key] {
+			group.resolutions = append(group.resolutions, resolution)
+		}
+		sort.Slice(group.resolutions, func(i, j int) bool {
+			ri, _ := strconv.Atoi(strings.TrimSuffix(group.resolutions[i], "p"))
+			rj, _ := strconv.Atoi(strings.TrimSuffix(group.resolutions[j], "p"))
+			return ri < rj
+		})
+		sorted = append(sorted, group)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if ki, kj := strings.ToLower(sorted[i].title), strings.ToLower(sorted[j].title); ki != kj {
+			return ki < kj
+		}
+		return sorted[i].season < sorted[j].season
+	})
+
+	rows := make([]groupRow, 0, len(res))
+	for _, group := range sorted {
+		for _, ep := range group.episodes {
+			rows = append(rows, groupRow{group: group, episode: ep, info: &ep.best})
+		}
+	}
+
+	sortResults(other, sortBy)
+	for i := range other {
+		rows = append(rows, groupRow{info: &other[i]})
+	}
+	return rows
+}
+
+// groupedCandidates returns the displayed results of the rows, in order.
+func groupedCandidates(rows []groupRow) []search.FileInfo {
+	res := make([]search.FileInfo, 0, len(rows))
+	for _, row := range rows {
+		res = append(res, *row.info)
+	}
+	return res
+}
+
+func (row *groupRow) episodeLabel() string {
+	switch {
+	case row.episode == nil:
+		return ""
+	case row.episode.episode == 0:
+		return "pack"
+	case row.group.season > 0:
+		return fmt.Sprintf("S%02dE%02d", row.group.season, row.episode.episode)
+	}
+	return strconv.Itoa(row.episode.episode)
+}
+
+func (row *groupRow) alternatives() int {
+	if row.episode == nil {
+		return 0
+	}
+	return row.episode.candidates - 1
+}
+
+// printGroupedResults prints the results grouped by show and season (see groupResults).
+func printGroupedResults(rows []groupRow, opts *printOptions) {
+	start, end := pageBounds(len(rows), opts.limit, opts.page)
+	switch opts.format {
+	case outputFormatJSON:
+		printGroupedResultsJSON(rows[start:end], opts)
+		return
+	case outputFormatCSV:
+		printGroupedResultsCSV(rows[start:end], start+1, opts)
+		return
+	}
+
+	if r, ok := markupRenderers[opts.format]; ok {
+		printGroupedResultsMarkup(r, rows[start:end], start+1, opts)
+		return
+	}
+
+	for i := start; i < end; {
+		group := rows[i].group
+		j := i + 1
+		for j < end && rows[j].group == group {
+			j++
+		}
+
+		if i > start {
+			fmt.Println()
+		}
+		if group != nil {
+			fmt.Println(group.String())
+		} else {
+			fmt.Printf("Other results (%d)\n", j-i)
+		}
+		printGroupTable(rows[i:j], i+1, opts)
+		i = j
+	}
+
+	if opts.limit > 0 {
+		fmt.Printf("\npage %d/%d (%d results)\n", opts.page, numPages(len(rows), opts.limit), len(rows))
+	}
+}
+
+// printGroupTable prints the rows numbered from first, along with their episode, resolution and
+// number of alternative results.
+func printGroupTable(rows []groupRow, first int, opts *printOptions) {
+	res := groupedCandidates(rows)
+	columns := displayedColumns(res, opts)
+
+	headers := []string{"#", "Ep", "Res", "Alt"}
+	aligns := []Alignment{AlignRight, AlignLeft, AlignLeft, AlignRight}
+	widths := []int{0, 0, 0, 0}
+	for _, col := range columns {
+		headers = append(headers, col.header)
+		aligns = append(aligns, col.align)
+		widths = append(widths, col.maxWidth)
+	}
+
+	printer := NewTablePrinter(headers)
+	printer.SetAligns(aligns)
+	printer.SetMaxWidths(widths)
+	printer.CellColor = func(row int, col int) string {
+		if col < 4 || columns[col-4].color == nil {
+			return ""
+		}
+		return columns[col-4].color(&res[row])
+	}
+
+	for i := range rows {
+		row := Row{strconv.Itoa(first + i), rows[i].episodeLabel(), parseResolution(res[i].Name), ""}
+		if alternatives := rows[i].alternatives(); alternatives > 0 {
+			row[3] = "+" + strconv.Itoa(alternatives)
+		}
+		for _, col := range columns {
+			row = append(row, col.value(&res[i], opts))
+		}
+		printer.AddRow(row)
+	}
+	printer.Print()
+}
+
+// groupRecord is a show season of the grouped results, as exported in JSON.
+type groupRecord struct {
+	Title    string          `json:"title"`
+	Season   int             `json:"season,omitempty"`
+	Missing  []int           `json:"missing,omitempty"`
+	Episodes []episodeRecord `json:"episodes"`
+}
+
+type episodeRecord struct {
+	Episode      int          `json:"episode"` // 0 for the packs of the whole season
+	Resolution   string       `json:"resolution,omitempty"`
+	Alternatives int          `json:"alternatives"`
+	Best         resultRecord `json:"best"`
+}
+
+func printGroupedResultsJSON(rows []groupRow, opts *printOptions) {
+	output := struct {
+		Groups []groupRecord  `json:"groups"`
+		Other  []resultRecord `json:"other"`
+	}{Groups: make([]groupRecord, 0), Other: make([]resultRecord, 0)}
+
+	records := newResultRecords(groupedCandidates(rows), opts)
+	var last *resultGroup
+	for i, row := range rows {
+		if row.group == nil {
+			output.Other = append(output.Other, records[i])
+			continue
+		}
+
+		if row.group != last {
+			last = row.group
+			output.Groups = append(output.Groups, groupRecord{Title: row.group.title, Season: row.group.season, Missing: row.group.missingEpisodes()})
+		}
+		record := &output.Groups[len(output.Groups)-1]
+		record.Episodes = append(record.Episodes, episodeRecord{
+			Episode:      row.episode.episode,
+			Resolution:   parseResolution(row.info.Name),
+			Alternatives: row.alternatives(),
+			Best:         records[i],
+		})
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(&output); err != nil {
+		logError("unable to print results: %s", err)
+	}
+}
+
+// printGroupedResultsCSV prints the displayed columns of the rows numbered from first, preceded by
+// their show, season, episode, resolution and number of alternative results.
+func printGroupedResultsCSV(rows []groupRow, first int, opts *printOptions) {
+	res := groupedCandidates(rows)
+	columns := displayedColumns(res, opts)
+
+	w := csv.NewWriter(os.Stdout)
+	header := []string{"#", "title", "season", "episode", "resolution", "alternatives"}
+	for _, col := range columns {
+		header = append(header, col.name)
+	}
+	w.Write(header)
+
+	for i, row := range rows {
+		record := []string{strconv.Itoa(first + i), "", "", "", parseResolution(res[i].Name), strconv.Itoa(row.alternatives())}
+		if row.group != nil {
+			record[1] = row.group.title
+			record[2] = strconv.Itoa(row.group.season)
+			record[3] = strconv.Itoa(row.episode.episode)
+		}
+		for _, col := range columns {
+			record = append(record, col.value(&res[i], opts))
+		}
+		w.Write(record)
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		logError("unable to print results: %s", err)
+	}
+}
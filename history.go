@@ -0,0 +1,234 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	historyFileName   = "history.json"
+	maxHistoryEntries = 1000
+)
+
+const (
+	historySearch   = "search"
+	historyDownload = "download"
+)
+
+// historyEntry is a past search or download.
+type historyEntry struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"` // historySearch or historyDownload
+	Query   string    `json:"query,omitempty"`
+	Results int       `json:"results,omitempty"`
+	Source  string    `json:"source,omitempty"`
+	File    string    `json:"file,omitempty"`
+	Outcome string    `json:"outcome"`
+	Error   string    `json:"error,omitempty"`
+
+	Hashes *contentHashes `json:"hashes,omitempty"` // of the completed downloads
+}
+
+// historyMu serializes the updates of the history file by concurrent transfers.
+var historyMu sync.Mutex
+
+func loadHistory() ([]historyEntry, string, error) {
+	path, err := dataFilePath(historyFileName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	entries := make([]historyEntry, 0)
+	if err := readJSONFile(path, &entries); err != nil {
+		return nil, "", err
+	}
+	return entries, path, nil
+}
+
+// recordHistory appends the entry to the history file, dropping the oldest entries.
+func recordHistory(entry historyEntry) {
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	entries, path, err := loadHistory()
+	if err == nil {
+		entries = append(entries, entry)
+		if len(entries) > maxHistoryEntries {
+			entries = entries[len(entries)-maxHistoryEntries:]
+		}
+		err = writeJSONFile(path, entries)
+	}
+
+	if err != nil {
+		logWarn("unable to update history: %s", err)
+	}
+}
+
+func recordSearch(query string, numResults int, outcome string) {
+	recordHistory(historyEntry{
+		Time:    time.Now(),
+		Kind:    historySearch,
+		Query:   query,
+		Results: numResults,
+		Outcome: outcome,
+	})
+}
+
+// downloadEntry returns the history entry of the final state of the item, without the hashes of its file.
+func (batch *Batch) downloadEntry(item *batchItem) historyEntry {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	entry := historyEntry{
+		Time:    time.Now(),
+		Kind:    historyDownload,
+		Source:  item.url.String(),
+		File:    item.filePath,
+		Outcome: string(item.state),
+	}
+	if item.err != nil {
+		entry.Error = item.err.Error()
+	}
+	return entry
+}
+
+// backfillHistory hashes the completed downloads recorded before the hashes were,
+// as long as their files are still there. It returns the number of hashed and missing files.
+func backfillHistory() (int, int, error) {
+	historyMu.Lock()
+	entries, _, err := loadHistory()
+	historyMu.Unlock()
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// the files are hashed without holding the lock, since the transfers record their downloads meanwhile
+	hashes := make(map[string]*contentHashes)
+	missing := 0
+	for _, entry := range entries {
+		if entry.Kind != historyDownload || entry.Outcome != string(itemStateCompleted) ||
+			entry.File == "" || entry.Hashes != nil || hashes[entry.File] != nil {
+			continue
+		}
+
+		h, err := fileContentHashes(entry.File)
+		if os.IsNotExist(err) {
+			missing++
+			continue
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+
+		logInfo("%s: %s", entry.File, h.SHA256)
+		hashes[entry.File] = h
+	}
+
+	if len(hashes) == 0 {
+		return 0, missing, nil
+	}
+
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	entries, path, err := loadHistory()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for i := range entries {
+		if entries[i].Hashes == nil && entries[i].Outcome == string(itemStateCompleted) {
+			entries[i].Hashes = hashes[entries[i].File]
+		}
+	}
+	return len(hashes), missing, writeJSONFile(path, entries)
+}
+
+func historyBackfillCommand(args []string) {
+	parseFlags(flag.NewFlagSet("backfill", flag.ExitOnError), args)
+
+	hashed, missing, err := backfillHistory()
+	if err != nil {
+		logError("unable to backfill history: %s", err)
+		os.Exit(1)
+	}
+	logInfo("%d files hashed, %d files no longer available", hashed, missing)
+}
+
+// historyFlags holds the flags of the history subcommand.
+type historyFlags struct {
+	limit int
+	kind  string
+	clear bool
+}
+
+// newHistoryFlagSet returns the flags of the history subcommand, also used by the completion.
+func newHistoryFlagSet() (*flag.FlagSet, *historyFlags) {
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+	flags := &historyFlags{}
+	historyCmd.IntVar(&flags.limit, "n", 20, "number of entries to display (0 means all)")
+	historyCmd.StringVar(&flags.kind, "kind", "", "only display the searches or the downloads")
+	historyCmd.BoolVar(&flags.clear, "clear", false, "delete the history")
+	return historyCmd, flags
+}
+
+func historyCommand(args []string) {
+	if len(args) > 0 && args[0] == "backfill" {
+		historyBackfillCommand(args[1:])
+		return
+	}
+
+	historyCmd, flags := newHistoryFlagSet()
+
+	parseFlags(historyCmd, args)
+
+	historyMu.Lock()
+	defer historyMu.Unlock()
+
+	entries, path, err := loadHistory()
+	if err != nil {
+		logError("unable to load history: %s", err)
+		os.Exit(1)
+	}
+
+	if flags.clear {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			logError("unable to delete history: %s", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	filtered := make([]historyEntry, 0, len(entries))
+	for _, entry := range entries {
+		if flags.kind == "" || entry.Kind == flags.kind {
+			filtered = append(filtered, entry)
+		}
+	}
+
+	if flags.limit > 0 && len(filtered) > flags.limit {
+		filtered = filtered[len(filtered)-flags.limit:]
+	}
+
+	printer := NewTablePrinter([]string{"Time", "Kind", "Query / Source", "Outcome"})
+	for _, entry := range filtered {
+		what, outcome := entry.Query, entry.Outcome
+		if entry.Kind == historySearch {
+			outcome = fmt.Sprintf("%s, %d results", outcome, entry.Results)
+		} else {
+			what = entry.Source
+			if entry.File != "" {
+				what += " (" + entry.File + ")"
+			}
+			if entry.Error != "" {
+				outcome += ": " + entry.Error
+			}
+		}
+		printer.AddRow(Row{entry.Time.Format("2006-01-02 15:04"), entry.Kind, what, outcome})
+	}
+	printer.Print()
+}
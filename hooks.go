@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	hookOnQueue    = "on_queue"
+	hookOnComplete = "on_complete"
+	hookOnError    = "on_error"
+)
+
+// Hook is a user command or a webhook run on a transfer lifecycle event.
+// Commands receive the event payload as JSON on their standard input
+// and through XDCC_* environment variables.
+type Hook struct {
+	Command string `json:"command"`
+	Webhook string `json:"webhook"`
+}
+
+type Hooks struct {
+	OnQueue    []Hook `json:"on_queue"`
+	OnComplete []Hook `json:"on_complete"`
+	OnError    []Hook `json:"on_error"`
+}
+
+func (hooks *Hooks) forEvent(event string) []Hook {
+	switch event {
+	case hookOnQueue:
+		return hooks.OnQueue
+	case hookOnComplete:
+		return hooks.OnComplete
+	case hookOnError:
+		return hooks.OnError
+	}
+	return nil
+}
+
+type hookPayload struct {
+	Event    string  `json:"event"`
+	File     string  `json:"file,omitempty"`
+	Path     string  `json:"path,omitempty"`
+	Size     uint64  `json:"size"`
+	Network  string  `json:"network"`
+	Channel  string  `json:"channel"`
+	Bot      string  `json:"bot"`
+	Slot     int     `json:"slot"`
+	Source   string  `json:"source"`
+	Duration float64 `json:"duration"` // in seconds
+	SHA256   string  `json:"sha256,omitempty"`
+	Error    string  `json:"error,omitempty"`
+}
+
+func (payload *hookPayload) environ() []string {
+	return append(os.Environ(),
+		"XDCC_EVENT="+payload.Event,
+		"XDCC_FILE="+payload.File,
+		"XDCC_PATH="+payload.Path,
+		"XDCC_SIZE="+strconv.FormatUint(payload.Size, 10),
+		"XDCC_NETWORK="+payload.Network,
+		"XDCC_CHANNEL="+payload.Channel,
+		"XDCC_BOT="+payload.Bot,
+		"XDCC_SLOT="+strconv.Itoa(payload.Slot),
+		"XDCC_SOURCE="+payload.Source,
+		"XDCC_DURATION="+strconv.FormatFloat(payload.Duration, 'f', 0, 64),
+		"XDCC_SHA256="+payload.SHA256,
+		"XDCC_ERROR="+payload.Error,
+	)
+}
+
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+func runHookCommand(command string, payload *hookPayload) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	cmd := shellCommand(command)
+	cmd.Env = payload.environ()
+	cmd.Stdin = bytes.NewReader(data)
+	// keep the standard output clean for the --quiet summary
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// hookRunner runs the configured hooks in background.
+type hookRunner struct {
+	hooks *Hooks
+	wg    sync.WaitGroup
+}
+
+func newHookRunner(hooks *Hooks) *hookRunner {
+	return &hookRunner{hooks: hooks}
+}
+
+func (runner *hookRunner) hasHooks(event string) bool {
+	return runner != nil && len(runner.hooks.forEvent(event)) > 0
+}
+
+func (runner *hookRunner) Run(payload *hookPayload) {
+	for _, hook := range runner.hooks.forEvent(payload.Event) {
+		runner.wg.Add(1)
+		go func(hook Hook) {
+			defer runner.wg.Done()
+
+			if hook.Command != "" {
+				logAt(LogTransfers, "%s: running %q", payload.Event, hook.Command)
+				if err := runHookCommand(hook.Command, payload); err != nil {
+					logError("%s hook %q failed: %s", payload.Event, hook.Command, err)
+				}
+			}
+
+			if hook.Webhook != "" {
+				logAt(LogTransfers, "%s: posting to %s", payload.Event, hook.Webhook)
+				if err := postWebhook(hook.Webhook, payload); err != nil {
+					logError("%s webhook %s failed: %s", payload.Event, hook.Webhook, err)
+				}
+			}
+		}(hook)
+	}
+}
+
+// Wait waits for the running hooks to terminate.
+func (runner *hookRunner) Wait() {
+	runner.wg.Wait()
+}
+
+func (batch *Batch) hookPayload(item *batchItem, event string) *hookPayload {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	payload := &hookPayload{
+		Event:   event,
+		File:    item.fileName,
+		Path:    item.filePath,
+		Size:    item.bytes,
+		Network: item.url.Network,
+		Channel: item.url.Channel,
+		Bot:     item.url.UserName,
+		Slot:    item.url.Slot,
+		Source:  item.url.String(),
+	}
+
+	if !item.started.IsZero() {
+		payload.Duration = time.Since(item.started).Seconds()
+	}
+
+	if item.err != nil {
+		payload.Error = item.err.Error()
+	}
+	return payload
+}
+
+// finalHookPayload returns the payload of the hooks matching the final state of the item, or nil if there are none.
+func (batch *Batch) finalHookPayload(item *batchItem) *hookPayload {
+	event := ""
+	switch batch.itemState(item) {
+	case itemStateCompleted:
+		event = hookOnComplete
+	case itemStateFailed:
+		event = hookOnError
+	}
+
+	if !batch.hooks.hasHooks(event) {
+		return nil
+	}
+	return batch.hookPayload(item, event)
+}
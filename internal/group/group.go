@@ -0,0 +1,73 @@
+// Package group runs goroutines sharing a context, which is cancelled as soon as one of them fails.
+// It follows the API of golang.org/x/sync/errgroup.
+package group
+
+import (
+	"context"
+	"sync"
+)
+
+// Group is a set of goroutines working on subtasks of the same task.
+// The zero value is a valid Group with no limit on the number of active goroutines.
+type Group struct {
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	sem    chan struct{}
+
+	errOnce sync.Once
+	err     error
+}
+
+// WithContext returns a new Group and a context derived from ctx, which is cancelled
+// the first time a function passed to Go returns an error or when Wait returns.
+func WithContext(ctx context.Context) (*Group, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Group{cancel: cancel}, ctx
+}
+
+// SetLimit limits the number of active goroutines to n. A negative or zero value removes the limit.
+// It must not be called while goroutines are active.
+func (g *Group) SetLimit(n int) {
+	if n <= 0 {
+		g.sem = nil
+		return
+	}
+	g.sem = make(chan struct{}, n)
+}
+
+// Go runs f in a new goroutine, blocking until the number of active goroutines is below the limit.
+func (g *Group) Go(f func() error) {
+	if g.sem != nil {
+		g.sem <- struct{}{}
+	}
+
+	g.wg.Add(1)
+	go func() {
+		defer g.done()
+
+		if err := f(); err != nil {
+			g.errOnce.Do(func() {
+				g.err = err
+				if g.cancel != nil {
+					g.cancel()
+				}
+			})
+		}
+	}()
+}
+
+func (g *Group) done() {
+	if g.sem != nil {
+		<-g.sem
+	}
+	g.wg.Done()
+}
+
+// Wait waits for all the goroutines to return, and returns the first error, if any.
+func (g *Group) Wait() error {
+	g.wg.Wait()
+	if g.cancel != nil {
+		g.cancel()
+	}
+	return g.err
+}
@@ -0,0 +1,296 @@
+package testbot
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	loopbackAddress = 2130706433 // 127.0.0.1, as sent in the DCC offers
+	offerTimeout    = time.Minute
+	ackTimeout      = 30 * time.Second
+	dccChunkSize    = 16 << 10
+)
+
+// offer is a file offered to a client, until it is received or cancelled.
+type offer struct {
+	pack     *Pack
+	secure   bool
+	port     int // 0 for passive offers
+	token    int // passive offers only
+	listener net.Listener
+	position uint64 // set by a resume request
+	conn     net.Conn
+}
+
+func (c *client) nextToken() int {
+	c.server.mu.Lock()
+	defer c.server.mu.Unlock()
+	c.server.tokens++
+	return c.server.tokens
+}
+
+// offer offers the pack to the client, which either connects to the bot or, with passive offers,
+// tells the bot where to connect.
+func (c *client) offer(pack *Pack, secure bool) error {
+	command := "SEND"
+	if secure {
+		command = "SSEND"
+	}
+
+	o := &offer{pack: pack, secure: secure}
+	if c.server.config.Passive {
+		o.token = c.nextToken()
+		c.mu.Lock()
+		c.offers[o.token] = o
+		c.mu.Unlock()
+
+		c.fromBot("PRIVMSG", fmt.Sprintf("\x01DCC %s %s %d 0 %d %d\x01", command, pack.Name, loopbackAddress, len(pack.Data), o.token))
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	o.listener = listener
+	o.port = listener.Addr().(*net.TCPAddr).Port
+
+	c.mu.Lock()
+	c.offers[o.port] = o
+	c.mu.Unlock()
+
+	go c.serveOffer(o)
+	c.fromBot("PRIVMSG", fmt.Sprintf("\x01DCC %s %s %d %d %d\x01", command, pack.Name, loopbackAddress, o.port, len(pack.Data)))
+	return nil
+}
+
+// serveOffer waits for the client to connect and sends the file.
+func (c *client) serveOffer(o *offer) {
+	listener := o.listener.(*net.TCPListener)
+	listener.SetDeadline(time.Now().Add(offerTimeout))
+	conn, err := listener.Accept()
+	listener.Close()
+	if err != nil {
+		c.removeOffer(o)
+		return
+	}
+
+	if o.secure {
+		conn = tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{c.server.cert}})
+	}
+	c.sendFile(o, conn)
+}
+
+func (c *client) removeOffer(o *offer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, other := range c.offers {
+		if other == o {
+			delete(c.offers, key)
+		}
+	}
+}
+
+// cancelOffers stops offering the files and closes the transfers in progress.
+func (c *client) cancelOffers() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, o := range c.offers {
+		if o.listener != nil {
+			o.listener.Close()
+		}
+		if o.conn != nil {
+			o.conn.Close()
+		}
+		delete(c.offers, key)
+	}
+}
+
+// handleDCC handles the DCC requests of the client: the resume requests, and the answers to passive offers.
+func (c *client) handleDCC(command string, args []string) {
+	switch command {
+	case "RESUME": // name port position [token]
+		if c.server.config.NoResume || len(args) < 3 {
+			return
+		}
+
+		key, _ := strconv.Atoi(args[1])
+		if key == 0 && len(args) > 3 {
+			key, _ = strconv.Atoi(args[3])
+		}
+		position, err := strconv.ParseUint(args[2], 10, 64)
+		if err != nil {
+			return
+		}
+		if rewind := uint64(c.server.config.ResumeRewind); rewind < position {
+			position -= rewind
+		} else {
+			position = 0
+		}
+
+		c.mu.Lock()
+		o, ok := c.offers[key]
+		if ok && o.conn == nil && position <= uint64(len(o.pack.Data)) {
+			o.position = position
+		}
+		c.mu.Unlock()
+
+		if ok {
+			c.fromBot("PRIVMSG", fmt.Sprintf("\x01DCC ACCEPT %s %s %d%s\x01", args[0], args[1], position, tokenSuffix(args, 3)))
+		}
+	case "SEND", "SSEND": // name address port size token, answering a passive offer
+		if len(args) < 5 {
+			return
+		}
+
+		token, _ := strconv.Atoi(args[4])
+		c.mu.Lock()
+		o, ok := c.offers[token]
+		c.mu.Unlock()
+		if !ok || o.port != 0 {
+			return
+		}
+
+		address, err := parseAddress(args[1])
+		if err != nil {
+			return
+		}
+		go c.connect(o, net.JoinHostPort(address.String(), args[2]))
+	}
+}
+
+func tokenSuffix(args []string, i int) string {
+	if i < len(args) {
+		return " " + args[i]
+	}
+	return ""
+}
+
+// parseAddress parses an address of a DCC message, either a 32-bit integer or an IP address.
+func parseAddress(s string) (net.IP, error) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, uint32(n))
+		return ip, nil
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		return ip, nil
+	}
+	return nil, errors.New("invalid address: " + s)
+}
+
+// connect connects to the client answering a passive offer and sends the file, the bot being the TLS client.
+func (c *client) connect(o *offer, address string) {
+	conn, err := net.DialTimeout("tcp", address, offerTimeout)
+	if err != nil {
+		c.removeOffer(o)
+		return
+	}
+
+	if o.secure {
+		conn = tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	}
+	c.sendFile(o, conn)
+}
+
+// sendFile sends the file from the resume position, then waits for the client to acknowledge all of it.
+func (c *client) sendFile(o *offer, conn net.Conn) {
+	defer c.removeOffer(o)
+	defer conn.Close()
+
+	c.mu.Lock()
+	o.conn = conn
+	position := o.position
+	c.mu.Unlock()
+
+	data := o.pack.Data
+	acked := make(chan struct{})
+	go readAcks(conn, uint64(len(data)), acked)
+
+	for position < uint64(len(data)) {
+		end := position + dccChunkSize
+		if end > uint64(len(data)) {
+			end = uint64(len(data))
+		}
+
+		if _, err := conn.Write(data[position:end]); err != nil {
+			return
+		}
+		if rate := c.server.config.Rate; rate > 0 {
+			time.Sleep(time.Duration(end-position) * time.Second / time.Duration(rate))
+		}
+		position = end
+	}
+
+	select {
+	case <-acked:
+	case <-time.After(ackTimeout):
+	}
+}
+
+// readAcks reads the acknowledgements of the client, which are 64-bit for the files larger than 4 GiB,
+// closing acked once the whole file is acknowledged.
+func readAcks(conn net.Conn, size uint64, acked chan struct{}) {
+	buf := make([]byte, 4)
+	expected := size & math.MaxUint32
+	if size > math.MaxUint32 {
+		buf, expected = make([]byte, 8), size
+	}
+
+	for {
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return
+		}
+
+		received := uint64(binary.BigEndian.Uint32(buf[len(buf)-4:]))
+		if len(buf) == 8 {
+			received = binary.BigEndian.Uint64(buf)
+		}
+		if received == expected {
+			close(acked)
+			return
+		}
+	}
+}
+
+// selfSignedCertificate returns a certificate for the TLS connections to the server and the TLS DCC transfers,
+// which the clients accept without verifying it, as they do with the bots.
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: serverName},
+		DNSNames:     []string{serverName},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
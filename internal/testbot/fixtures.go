@@ -0,0 +1,67 @@
+package testbot
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// fixtureExtensions are the extensions of the fixtures, tried in order, with their content type.
+var fixtureExtensions = []struct {
+	ext         string
+	contentType string
+}{
+	{".json", "application/json"},
+	{".html", "text/html; charset=utf-8"},
+	{"", "application/octet-stream"},
+}
+
+// Fixtures is an http.RoundTripper answering the requests of the search engines with the files of a directory,
+// found by the host and the path of the request, with an optional .json or .html extension: the requests of
+// https://api.nibl.co.uk/nibl/search are answered with api.nibl.co.uk/nibl/search.json. The query is ignored,
+// and the requests without a fixture are answered with 404. It is set as the Transport of search.RequestOptions.
+type Fixtures struct {
+	Dir string
+}
+
+// ProviderFixtures returns the Fixtures of the sample responses of the providers of package search,
+// kept in testdata/providers.
+func ProviderFixtures() *Fixtures {
+	_, file, _, _ := runtime.Caller(0)
+	return &Fixtures{Dir: filepath.Join(filepath.Dir(file), "testdata", "providers")}
+}
+
+func (f *Fixtures) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := filepath.Join(f.Dir, req.URL.Hostname(), filepath.FromSlash(strings.TrimSuffix(req.URL.Path, "/")))
+	for _, e := range fixtureExtensions {
+		data, err := ioutil.ReadFile(base + e.ext)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return fixtureResponse(req, http.StatusOK, e.contentType, data), nil
+	}
+
+	return fixtureResponse(req, http.StatusNotFound, "text/plain", []byte(fmt.Sprintf("no fixture for %s", req.URL))), nil
+}
+
+func fixtureResponse(req *http.Request, status int, contentType string, data []byte) *http.Response {
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{contentType}},
+		Body:          ioutil.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}
+}
@@ -0,0 +1,418 @@
+// Package testbot runs an IRC server on the loopback interface hosting an XDCC bot, which speaks enough
+// IRC and DCC (active and passive offers, resume, TLS) to exercise the download engine end to end without
+// live networks. It also serves sample responses of the search engines, see Fixtures.
+package testbot
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ostafen/xdcc-cli/pkg/xdcc"
+)
+
+const (
+	serverName = "testbot.local"
+	botHost    = "testbot.local"
+	botIdent   = "bot"
+)
+
+// Pack is a file offered by the bot.
+type Pack struct {
+	Slot int
+	Name string // sent as is, so without spaces
+	Data []byte
+}
+
+// Config describes the server and the behavior of its bot.
+type Config struct {
+	Nick    string // of the bot, TestBot by default
+	Channel string // where the bot is, #test by default
+	Packs   []Pack
+	Version string // answered to CTCP VERSION requests
+
+	TLS           bool          // the server only accepts TLS connections, with a self-signed certificate
+	Passive       bool          // offer the files by passive (reverse) DCC, the client connecting to nothing
+	NoResume      bool          // ignore the resume requests
+	ResumeRewind  int           // bytes by which the resume position is moved back, as some bots do
+	Announce      bool          // announce the packs in the channel to the users joining it
+	QueuePosition int           // if positive, notify the requests as queued at this position before offering them
+	OfferDelay    time.Duration // wait before offering the requested files
+	Rate          int           // bytes sent per second by DCC, 0 for no limit
+}
+
+// Server is an IRC server hosting a single XDCC bot.
+type Server struct {
+	config   Config
+	listener net.Listener
+	cert     tls.Certificate // self-signed, for TLS connections and DCC
+
+	mu       sync.Mutex
+	clients  map[*client]bool
+	requests []string
+	tokens   int
+	wg       sync.WaitGroup
+}
+
+// Start starts the server on a random port of the loopback interface.
+func Start(config Config) (*Server, error) {
+	if config.Nick == "" {
+		config.Nick = "TestBot"
+	}
+	if config.Channel == "" {
+		config.Channel = "#test"
+	}
+	if !strings.HasPrefix(config.Channel, "#") {
+		config.Channel = "#" + config.Channel
+	}
+	if config.Version == "" {
+		config.Version = "iroffer-dinoex testbot"
+	}
+
+	cert, err := selfSignedCertificate()
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	if config.TLS {
+		listener = tls.NewListener(listener, &tls.Config{Certificates: []tls.Certificate{cert}})
+	}
+
+	s := &Server{config: config, listener: listener, cert: cert, clients: make(map[*client]bool)}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the address of the server, to be used as the network of the packs.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// PackURL returns the url of a pack of the bot.
+func (s *Server) PackURL(slot int) xdcc.IRCFileURL {
+	return xdcc.IRCFileURL{Network: s.Addr(), Channel: s.config.Channel, UserName: s.config.Nick, Slot: slot}
+}
+
+// Requests returns the messages sent to the bot so far, such as "xdcc send #1" or "DCC RESUME ...",
+// with the CTCP delimiters removed.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+// DropClients closes the connections of the clients, as a server going down would.
+// The transfers in progress go on.
+func (s *Server) DropClients() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.clients {
+		c.conn.Close()
+	}
+}
+
+// Close stops the server, closing the connections of the clients and the transfers in progress.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	for c := range s.clients {
+		c.conn.Close()
+		c.cancelOffers()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		c := &client{server: s, conn: conn, offers: make(map[int]*offer)}
+		s.mu.Lock()
+		s.clients[c] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+
+			s.mu.Lock()
+			delete(s.clients, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+func (s *Server) record(request string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, request)
+}
+
+func (s *Server) findPack(slot int) *Pack {
+	for i := range s.config.Packs {
+		if s.config.Packs[i].Slot == slot {
+			return &s.config.Packs[i]
+		}
+	}
+	return nil
+}
+
+// client is a connection to the server.
+type client struct {
+	server *Server
+	conn   net.Conn
+
+	mu         sync.Mutex // guards the fields below and the writes to conn
+	nick       string
+	user       string
+	registered bool
+	offers     map[int]*offer // by port, or by token for passive offers
+}
+
+func (c *client) serve() {
+	defer c.conn.Close()
+	defer c.cancelOffers()
+
+	scanner := bufio.NewScanner(c.conn)
+	for scanner.Scan() {
+		if !c.handleLine(strings.TrimRight(scanner.Text(), "\r")) {
+			return
+		}
+	}
+}
+
+// send writes a line to the client.
+func (c *client) send(format string, args ...interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(c.conn, format+"\r\n", args...)
+}
+
+func (c *client) prefix() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nick + "!" + c.user + "@127.0.0.1"
+}
+
+func (c *client) currentNick() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nick
+}
+
+// fromBot sends a message of the bot to the client.
+func (c *client) fromBot(command string, text string) {
+	c.send(":%s!%s@%s %s %s :%s", c.server.config.Nick, botIdent, botHost, command, c.currentNick(), text)
+}
+
+// parseLine splits a line into its command and arguments, the trailing one included.
+func parseLine(line string) (string, []string) {
+	if strings.HasPrefix(line, ":") { // prefix, not sent by clients anyway
+		if i := strings.Index(line, " "); i >= 0 {
+			line = line[i+1:]
+		}
+	}
+
+	trailing, hasTrailing := "", false
+	if i := strings.Index(line, " :"); i >= 0 {
+		line, trailing, hasTrailing = line[:i], line[i+2:], true
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	args := fields[1:]
+	if hasTrailing {
+		args = append(args, trailing)
+	}
+	return strings.ToUpper(fields[0]), args
+}
+
+// handleLine handles a line sent by the client, returning false once the client quits.
+func (c *client) handleLine(line string) bool {
+	command, args := parseLine(line)
+	switch command {
+	case "NICK":
+		if len(args) > 0 {
+			c.setNick(args[0])
+		}
+	case "USER":
+		if len(args) > 0 {
+			c.mu.Lock()
+			c.user = args[0]
+			c.mu.Unlock()
+			c.welcome()
+		}
+	case "PING":
+		token := serverName
+		if len(args) > 0 {
+			token = args[len(args)-1]
+		}
+		c.send(":%s PONG %s :%s", serverName, serverName, token)
+	case "JOIN":
+		if len(args) > 0 {
+			for _, channel := range strings.Split(args[0], ",") {
+				c.join(channel)
+			}
+		}
+	case "PRIVMSG":
+		if len(args) == 2 && strings.EqualFold(args[0], c.server.config.Nick) {
+			c.handleBotMessage(args[1])
+		}
+	case "QUIT":
+		return false
+	}
+	return true
+}
+
+func (c *client) setNick(nick string) {
+	c.mu.Lock()
+	old, registered := c.nick, c.registered
+	c.nick = nick
+	c.mu.Unlock()
+
+	if registered {
+		c.send(":%s!%s@127.0.0.1 NICK :%s", old, c.user, nick)
+		return
+	}
+	c.welcome()
+}
+
+// welcome completes the registration once the nick and the user are known.
+func (c *client) welcome() {
+	c.mu.Lock()
+	if c.registered || c.nick == "" || c.user == "" {
+		c.mu.Unlock()
+		return
+	}
+	c.registered = true
+	nick := c.nick
+	c.mu.Unlock()
+
+	c.send(":%s 001 %s :Welcome to the test network %s", serverName, nick, nick)
+	c.send(":%s 376 %s :End of /MOTD command.", serverName, nick)
+}
+
+func (c *client) join(channel string) {
+	nick := c.currentNick()
+	c.send(":%s JOIN :%s", c.prefix(), channel)
+
+	names := nick
+	if strings.EqualFold(channel, c.server.config.Channel) {
+		names += " +" + c.server.config.Nick
+	}
+	c.send(":%s 353 %s = %s :%s", serverName, nick, channel, names)
+	c.send(":%s 366 %s %s :End of /NAMES list.", serverName, nick, channel)
+
+	if c.server.config.Announce && strings.EqualFold(channel, c.server.config.Channel) {
+		for _, pack := range c.server.config.Packs {
+			c.send(":%s!%s@%s PRIVMSG %s :\x02[ADDED]\x02 #%d [%s] %s - /msg %s xdcc send #%d", c.server.config.Nick, botIdent, botHost,
+				channel, pack.Slot, formatPackSize(len(pack.Data)), pack.Name, c.server.config.Nick, pack.Slot)
+		}
+	}
+}
+
+// formatPackSize formats a size as in the packlists of the bots, e.g. "1.5M".
+func formatPackSize(size int) string {
+	units := []string{"K", "M", "G"}
+	value := float64(size) / 1024
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + units[unit]
+}
+
+func (c *client) handleBotMessage(text string) {
+	if strings.HasPrefix(text, "\x01") {
+		ctcp := strings.Trim(text, "\x01")
+		c.server.record(ctcp)
+		c.handleCTCP(ctcp)
+		return
+	}
+	c.server.record(text)
+
+	fields := strings.Fields(strings.ToLower(text))
+	if len(fields) < 2 || fields[0] != "xdcc" {
+		return
+	}
+
+	switch fields[1] {
+	case "send", "ssend":
+		if len(fields) < 3 {
+			return
+		}
+		slot, err := strconv.Atoi(strings.TrimPrefix(fields[2], "#"))
+		if err != nil {
+			c.fromBot("NOTICE", "Invalid Pack Number, Try Again")
+			return
+		}
+		go c.requestPack(slot, fields[1] == "ssend")
+	case "cancel":
+		c.cancelOffers()
+	case "remove":
+		c.fromBot("NOTICE", "You Don't Appear To Be In A Queue")
+	case "list":
+		c.fromBot("PRIVMSG", fmt.Sprintf("** %d packs **", len(c.server.config.Packs)))
+		for _, pack := range c.server.config.Packs {
+			c.fromBot("PRIVMSG", fmt.Sprintf("#%-3d 0x [%s] %s", pack.Slot, formatPackSize(len(pack.Data)), pack.Name))
+		}
+	}
+}
+
+func (c *client) handleCTCP(ctcp string) {
+	fields := strings.Fields(ctcp)
+	if len(fields) == 0 {
+		return
+	}
+
+	switch strings.ToUpper(fields[0]) {
+	case "VERSION":
+		c.fromBot("NOTICE", "\x01VERSION "+c.server.config.Version+"\x01")
+	case "DCC":
+		if len(fields) >= 2 {
+			c.handleDCC(strings.ToUpper(fields[1]), fields[2:])
+		}
+	}
+}
+
+func (c *client) requestPack(slot int, secure bool) {
+	pack := c.server.findPack(slot)
+	if pack == nil {
+		c.fromBot("NOTICE", "Invalid Pack Number, Try Again")
+		return
+	}
+
+	if position := c.server.config.QueuePosition; position > 0 {
+		c.fromBot("NOTICE", fmt.Sprintf("Added you to the main queue for pack %d (\"%s\") in position %d", slot, pack.Name, position))
+	}
+	time.Sleep(c.server.config.OfferDelay)
+
+	c.fromBot("NOTICE", fmt.Sprintf("Sending you pack #%d (\"%s\")", slot, pack.Name))
+	if err := c.offer(pack, secure); err != nil {
+		c.fromBot("NOTICE", "Unable to send the pack: "+err.Error())
+	}
+}
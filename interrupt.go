@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+)
+
+const exitCodeInterrupted = 130
+
+// cancelOnInterrupt calls cancel on interrupt (Ctrl-C).
+// The returned function stops handling the interrupts.
+func cancelOnInterrupt(cancel context.CancelFunc) func() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-sigChan:
+			cancel()
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigChan)
+		close(done)
+	}
+}
+
+// interruptContext returns a context which is cancelled on interrupt (Ctrl-C).
+// The returned function stops handling the interrupts and cancels the context.
+func interruptContext(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	stop := cancelOnInterrupt(cancel)
+
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// handleInterrupts makes the first interrupt stop the batch after the active transfers,
+// the second one abort the active transfers, and the third one exit immediately.
+// The returned function stops handling the interrupts.
+func handleInterrupts(batch *Batch, abort context.CancelFunc) func() {
+	sigChan := make(chan os.Signal, 3)
+	signal.Notify(sigChan, os.Interrupt)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-sigChan:
+			logInfo("\nfinishing the active transfers, press Ctrl-C again to abort them")
+			batch.SoftStop()
+		case <-done:
+			return
+		}
+
+		select {
+		case <-sigChan:
+			logInfo("\naborting the active transfers, press Ctrl-C again to exit immediately")
+			abort()
+		case <-done:
+			return
+		}
+
+		select {
+		case <-sigChan:
+			os.Exit(exitCodeInterrupted)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigChan)
+		close(done)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ostafen/xdcc-cli/pkg/xdcc"
+)
+
+const knownBotsFileName = "known_bots.json"
+
+// Checks of the bots against the hosts they were first seen from.
+const (
+	FingerprintsWarn  = "warn"
+	FingerprintsBlock = "block"
+	FingerprintsOff   = "off"
+)
+
+// knownBot is the fingerprint of a bot, recorded on its first completed transfer and trusted since.
+type knownBot struct {
+	Network   string    `json:"network"`
+	Bot       string    `json:"bot"`
+	Host      string    `json:"host"` // ident@host
+	Version   string    `json:"version,omitempty"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// KnownBots records where the bots connect from, so that a bot appearing from another host, which is
+// how fakes impersonate the well known bots, is noticed (trust on first use).
+type KnownBots struct {
+	mu   sync.Mutex
+	path string
+	bots map[string]*knownBot
+}
+
+func NewKnownBots(path string) (*KnownBots, error) {
+	known := &KnownBots{path: path, bots: make(map[string]*knownBot)}
+	if err := readJSONFile(path, &known.bots); err != nil {
+		return nil, err
+	}
+	return known, nil
+}
+
+func knownBotKey(network string, bot string) string {
+	return strings.ToLower(network + "/" + bot)
+}
+
+// Verify checks that the bot connects from the host it was first seen from. A bot seen from
+// another host, or whose host is unknown, is refused if block is set, and reported otherwise.
+func (known *KnownBots) Verify(id xdcc.BotIdentity, block bool) error {
+	known.mu.Lock()
+	defer known.mu.Unlock()
+
+	bot, ok := known.bots[knownBotKey(id.Network, id.Nick)]
+	if !ok {
+		return nil
+	}
+
+	if !strings.EqualFold(bot.Host, id.Address()) {
+		msg := fmt.Sprintf("%s connects from %s, but was first seen from %s: it may be impersonated", id.Nick, id.Address(), bot.Host)
+		if id.Address() == "" {
+			msg = fmt.Sprintf("the host of %s is unknown, but it was first seen from %s: it may be impersonated", id.Nick, bot.Host)
+		}
+		if block {
+			return fmt.Errorf("%s (use \"xdcc bots forget %s/%s\" if it moved, or --force)", msg, bot.Network, bot.Bot)
+		}
+		logWarn("%s/%s: %s", id.Network, id.Nick, msg)
+		return nil
+	}
+
+	if id.Version != "" && bot.Version != "" && id.Version != bot.Version {
+		logAt(LogIRC, "%s/%s: version changed from %q to %q", id.Network, id.Nick, bot.Version, id.Version)
+	}
+	return nil
+}
+
+// Record trusts the host of the bot, unless another host is trusted already, after a completed transfer.
+func (known *KnownBots) Record(id xdcc.BotIdentity) {
+	if id.Address() == "" {
+		return
+	}
+
+	known.mu.Lock()
+	defer known.mu.Unlock()
+
+	now := time.Now()
+	key := knownBotKey(id.Network, id.Nick)
+	bot, ok := known.bots[key]
+	switch {
+	case !ok:
+		bot = &knownBot{Network: id.Network, Bot: id.Nick, Host: id.Address(), FirstSeen: now}
+		known.bots[key] = bot
+		logAt(LogIRC, "%s/%s: first seen from %s", id.Network, id.Nick, id.Address())
+	case !strings.EqualFold(bot.Host, id.Address()):
+		return
+	}
+
+	bot.LastSeen = now
+	if id.Version != "" {
+		bot.Version = id.Version
+	}
+
+	if err := writeJSONFile(known.path, known.bots); err != nil {
+		logWarn("unable to save known bots: %s", err)
+	}
+}
+
+// Forget removes the bots matching target, either network/bot or a bot of any network,
+// returning how many were removed.
+func (known *KnownBots) Forget(target string) (int, error) {
+	known.mu.Lock()
+	defer known.mu.Unlock()
+
+	target = strings.ToLower(target)
+	removed := 0
+	for key, bot := range known.bots {
+		if key == target || (!strings.Contains(target, "/") && strings.ToLower(bot.Bot) == target) {
+			delete(known.bots, key)
+			removed++
+		}
+	}
+
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, writeJSONFile(known.path, known.bots)
+}
+
+// List returns the known bots, sorted by network and name.
+func (known *KnownBots) List() []knownBot {
+	known.mu.Lock()
+	defer known.mu.Unlock()
+
+	keys := make([]string, 0, len(known.bots))
+	for key := range known.bots {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	bots := make([]knownBot, 0, len(keys))
+	for _, key := range keys {
+		bots = append(bots, *known.bots[key])
+	}
+	return bots
+}
+
+var knownBots *KnownBots
+
+func setupKnownBots() {
+	if config.Bots.Fingerprints == FingerprintsOff {
+		return
+	}
+
+	path, err := dataFilePath(knownBotsFileName)
+	if err != nil {
+		logWarn("unable to locate known bots file: %s", err)
+		return
+	}
+
+	known, err := NewKnownBots(path)
+	if err != nil {
+		logWarn("unable to load known bots file: %s", err)
+		return
+	}
+	knownBots = known
+}
+
+// verifyBot returns the check of the bots of the transfers against their fingerprints, if any.
+// Bots seen from another host are only reported when forced.
+func verifyBot(opts *transferOptions) func(xdcc.BotIdentity) error {
+	if knownBots == nil {
+		return nil
+	}
+
+	block := config.Bots.Fingerprints == FingerprintsBlock && !opts.force
+	return func(id xdcc.BotIdentity) error {
+		return knownBots.Verify(id, block)
+	}
+}
+
+// recordBotIdentity trusts the bot of the item on its first completed transfer.
+func (batch *Batch) recordBotIdentity(item *batchItem, transfer *xdcc.Transfer) {
+	if knownBots != nil && batch.itemState(item) == itemStateCompleted {
+		knownBots.Record(transfer.Bot())
+	}
+}
+
+func printKnownBots() {
+	printer := NewTablePrinter([]string{"Network", "Bot", "Host", "Version", "First Seen", "Last Seen"})
+	for _, bot := range knownBots.List() {
+		printer.AddRow(Row{bot.Network, bot.Bot, bot.Host, bot.Version,
+			bot.FirstSeen.Local().Format("2006-01-02 15:04"), bot.LastSeen.Local().Format("2006-01-02 15:04")})
+	}
+	printer.Print()
+}
+
+func printBotsUsageAndExit() {
+	fmt.Println("usage:")
+	fmt.Println("  xdcc bots list")
+	fmt.Println("  xdcc bots forget <bot>...")
+	fmt.Println("where bot is a bot of any network (SomeBot) or of a network (irc.rizon.net/SomeBot)")
+	os.Exit(1)
+}
+
+func botsCommand(args []string) {
+	if len(args) < 1 {
+		printBotsUsageAndExit()
+	}
+
+	if knownBots == nil {
+		logError("the fingerprints of the bots are not recorded")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "list":
+		printKnownBots()
+	case "forget":
+		if len(args) < 2 {
+			printBotsUsageAndExit()
+		}
+		for _, target := range args[1:] {
+			removed, err := knownBots.Forget(target)
+			if err != nil {
+				logError("unable to save known bots: %s", err)
+				os.Exit(1)
+			}
+			if removed == 0 {
+				logWarn("no such bot: %s", target)
+			}
+		}
+	default:
+		fmt.Println("no such command: ", args[0])
+		os.Exit(1)
+	}
+}
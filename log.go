@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ostafen/xdcc-cli/pkg/search"
+	"github.com/ostafen/xdcc-cli/pkg/xdcc"
+)
+
+type LogLevel int
+
+const (
+	LogQuiet     LogLevel = iota // errors and final summary only
+	LogNormal                    // default output
+	LogProviders                 // -v: search provider chatter
+	LogIRC                       // -vv: IRC events
+	LogTransfers                 // -vvv: transfer details
+)
+
+var logLevel = LogNormal
+
+func isQuiet() bool {
+	return logLevel == LogQuiet
+}
+
+// Severity is the importance of a message. The messages printed by logAt are debug
+// messages, which are shown according to the verbosity (see LogLevel).
+type Severity int
+
+const (
+	SeverityDebug Severity = iota
+	SeverityInfo
+	SeverityWarn
+	SeverityError
+)
+
+var severityNames = []string{"debug", "info", "warn", "error"}
+
+func (s Severity) String() string {
+	return severityNames[s]
+}
+
+func parseSeverity(name string) (Severity, error) {
+	for i, n := range severityNames {
+		if strings.EqualFold(n, name) {
+			return Severity(i), nil
+		}
+	}
+	return SeverityDebug, fmt.Errorf("invalid log level %q, expected one of: %s", name, strings.Join(severityNames, ", "))
+}
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+var (
+	minSeverity = SeverityDebug // messages less important are dropped, whatever the verbosity
+	logFormat   = logFormatText
+
+	logMu   sync.Mutex
+	logFile io.Writer // receives the log instead of stderr, if set
+)
+
+// logRecord is a message, as written with --log-format json and to the log file.
+type logRecord struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"msg"`
+}
+
+// emit writes the message to the log. Without log file, text messages are printed as they
+// are to out, which is stdout for the messages part of the normal output. With a log file,
+// errors and the normal output are printed as well, so that they are not missed.
+func emit(severity Severity, out io.Writer, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	if logFile != nil {
+		writeRecord(logFile, severity, msg, true)
+		if severity == SeverityError || out == os.Stdout {
+			fmt.Fprintln(out, msg)
+		}
+		return
+	}
+
+	if logFormat == logFormatJSON {
+		writeRecord(os.Stderr, severity, msg, false)
+		return
+	}
+	fmt.Fprintln(out, msg)
+}
+
+func writeRecord(w io.Writer, severity Severity, msg string, timestamped bool) {
+	now := time.Now()
+	if logFormat == logFormatJSON {
+		line, _ := json.Marshal(&logRecord{Time: now, Level: severity.String(), Message: msg})
+		w.Write(append(line, '\n'))
+		return
+	}
+
+	if timestamped {
+		fmt.Fprintf(w, "%s %-5s %s\n", now.Format(time.RFC3339), strings.ToUpper(severity.String()), msg)
+	} else {
+		fmt.Fprintln(w, msg)
+	}
+}
+
+// logAt prints the debug message to stderr if the current verbosity is at least level.
+func logAt(level LogLevel, format string, args ...interface{}) {
+	if logLevel >= level && minSeverity <= SeverityDebug {
+		emit(SeverityDebug, os.Stderr, format, args...)
+	}
+}
+
+// logInfo prints a message which is part of the normal output.
+func logInfo(format string, args ...interface{}) {
+	if logLevel >= LogNormal && minSeverity <= SeverityInfo {
+		emit(SeverityInfo, os.Stdout, format, args...)
+	}
+}
+
+// logWarn prints a problem which does not prevent the command from running, unless quiet.
+func logWarn(format string, args ...interface{}) {
+	if logLevel >= LogNormal && minSeverity <= SeverityWarn {
+		emit(SeverityWarn, os.Stderr, format, args...)
+	}
+}
+
+// logError prints an error message, regardless of the current verbosity.
+func logError(format string, args ...interface{}) {
+	emit(SeverityError, os.Stderr, format, args...)
+}
+
+// the messages of the library packages are printed according to the current verbosity
+func init() {
+	search.Logger = func(level search.LogLevel, format string, args ...interface{}) {
+		if level == search.LogIRC {
+			logAt(LogIRC, format, args...)
+		} else {
+			logAt(LogProviders, format, args...)
+		}
+	}
+
+	xdcc.Logger = func(level xdcc.LogLevel, format string, args ...interface{}) {
+		switch level {
+		case xdcc.LogError:
+			logError(format, args...)
+		case xdcc.LogIRC:
+			logAt(LogIRC, format, args...)
+		default:
+			logAt(LogTransfers, format, args...)
+		}
+	}
+}
+
+type logFlags struct {
+	v        bool
+	vv       bool
+	vvv      bool
+	quiet    bool
+	verbose  bool
+	level    string
+	format   string
+	filePath string
+	errors   string
+}
+
+func addLogFlags(flagSet *flag.FlagSet) *logFlags {
+	flags := &logFlags{}
+	flagSet.BoolVar(&flags.v, "v", false, "print search provider activity")
+	flagSet.BoolVar(&flags.vv, "vv", false, "print search provider activity and IRC events")
+	flagSet.BoolVar(&flags.vvv, "vvv", false, "print search provider activity, IRC events and transfer details")
+	flagSet.BoolVar(&flags.verbose, "verbose", false, "same as -vvv")
+	flagSet.BoolVar(&flags.quiet, "quiet", false, "print nothing but errors and a final summary")
+	flagSet.StringVar(&flags.level, "log-level", "", "only print the messages of at least the given level: debug (same as -vvv), info, warn or error")
+	flagSet.StringVar(&flags.format, "log-format", logFormatText, "format of the log: text or json (one object per line)")
+	flagSet.StringVar(&flags.filePath, "log-file", "", "append the log to the given file, with timestamps, instead of printing it (errors are printed as well)")
+	flagSet.StringVar(&flags.errors, "errors", errorsFormatText, "format of the failure reports: text, or json to print the reason of a failure as a JSON object on stderr before exiting")
+	return flags
+}
+
+func (flags *logFlags) apply() {
+	switch {
+	case flags.quiet:
+		logLevel = LogQuiet
+		setProgressOutput(ioutil.Discard)
+	case flags.vvv || flags.verbose:
+		logLevel = LogTransfers
+	case flags.vv:
+		logLevel = LogIRC
+	case flags.v:
+		logLevel = LogProviders
+	}
+
+	if flags.format != logFormatText && flags.format != logFormatJSON {
+		logError("invalid log format %q, expected text or json", flags.format)
+		os.Exit(1)
+	}
+	logFormat = flags.format
+
+	if flags.errors != errorsFormatText && flags.errors != errorsFormatJSON {
+		logError("invalid errors format %q, expected text or json", flags.errors)
+		os.Exit(1)
+	}
+	errorsFormat = flags.errors
+
+	if flags.level != "" {
+		severity, err := parseSeverity(flags.level)
+		if err != nil {
+			logError(err.Error())
+			os.Exit(1)
+		}
+
+		minSeverity = severity
+		if severity == SeverityDebug && !flags.quiet {
+			logLevel = LogTransfers
+		}
+	}
+
+	if flags.filePath != "" {
+		f, err := os.OpenFile(flags.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			logError("unable to open log file: %s", err)
+			os.Exit(1)
+		}
+		logFile = f // closed at exit
+	}
+}
@@ -2,7 +2,8 @@ package main
 
 import (
 	"bufio"
-	"crypto/x509"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -10,103 +11,624 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/ostafen/xdcc-cli/pkg/search"
+	"github.com/ostafen/xdcc-cli/pkg/xdcc"
 )
 
-var registry *XdccProviderRegistry = nil
+var registry *search.Registry = nil
 
 func init() {
-	registry = NewProviderRegistry()
-	registry.AddProvider(&XdccEuProvider{})
+	registry = search.NewRegistry()
+	registry.AddProvider(&search.XdccEuProvider{})
+	registry.AddProvider(&search.NiblProvider{})
 }
 
 var defaultColWidths []int = []int{50, 8, 26, -1}
 
 const (
-	KiloByte = 1024
-	MegaByte = KiloByte * 1024
-	GigaByte = MegaByte * 1024
+	sortByGets = "gets"
+	sortBySize = "size"
+	sortByName = "name"
+	sortByDate = "date" // time the pack was added or last announced
 )
 
-func FloatToString(value float64) string {
-	if value-float64(int64(value)) > 0 {
-		return strconv.FormatFloat(value, 'f', 2, 32)
+// printOptions controls how search results are displayed.
+type printOptions struct {
+	limit      int
+	page       int
+	sortBy     string
+	exactBytes bool
+	columns    columnList // defaultColumns if empty
+	noColor    bool
+	format     string // one of the outputFormat* constants
+	local      bool   // the results come from the pack index
+	group      bool   // group the episodes of shows by season, see groupResults
+}
+
+func defaultPrintOptions() *printOptions {
+	return &printOptions{page: 1, sortBy: sortByGets, format: outputFormatTable}
+}
+
+// addPrintFlags adds the switches controlling how the results are displayed.
+func addPrintFlags(flagSet *flag.FlagSet) *printOptions {
+	opts := defaultPrintOptions()
+	flagSet.BoolVar(&opts.exactBytes, "bytes", false, "print exact file sizes in bytes")
+	flagSet.Var(&opts.columns, "columns", "comma separated list of the columns to display (e.g. network,bot,slot,size,name).\nAvailable columns: "+strings.Join(resultColumnNames(), ", "))
+	flagSet.StringVar(&opts.format, "format", outputFormatTable, "output format of the results: table, json, csv (including the custom columns of the configuration), markdown or html")
+	flagSet.BoolVar(&opts.group, "group", false, "group the episodes of shows by title and season (parsing names such as S01E02), showing the best result of each episode")
+	flagSet.BoolVar(&opts.noColor, "no-color", false, "disable colors (also disabled by the NO_COLOR environment variable and when the output is not a terminal)")
+	return opts
+}
+
+func (opts *printOptions) apply() {
+	if !isValidOutputFormat(opts.format) {
+		logError("invalid output format: %s", opts.format)
+		os.Exit(1)
+	}
+
+	if opts.page < 1 {
+		logError("invalid page: %d", opts.page)
+		os.Exit(1)
+	}
+
+	if opts.noColor {
+		disableColors()
 	}
-	return strconv.FormatFloat(value, 'f', 0, 32)
 }
 
-func formatSize(size int64) string {
-	if size < 0 {
-		return "--"
+func sortResults(res []search.FileInfo, sortBy string) {
+	sort.SliceStable(res, func(i, j int) bool {
+		pi, pj := config.ResultPriority(&res[i]), config.ResultPriority(&res[j])
+		if pi != pj {
+			return pi < pj
+		}
+
+		switch sortBy {
+		case sortBySize:
+			return res[i].Size < res[j].Size
+		case sortByName:
+			return res[i].Name < res[j].Name
+		case sortByDate:
+			return res[i].Freshness().Before(res[j].Freshness())
+		}
+		return res[i].Gets < res[j].Gets
+	})
+}
+
+// pageBounds returns the range of the results to be displayed for the given page.
+// A limit <= 0 means that all the results fit in a single page, and pages before the first are the first one.
+func pageBounds(numResults int, limit int, page int) (int, int) {
+	if limit <= 0 {
+		return 0, numResults
 	}
 
-	if size >= GigaByte {
-		return FloatToString(float64(size)/float64(GigaByte)) + "GB"
-	} else if size >= MegaByte {
-		return FloatToString(float64(size)/float64(MegaByte)) + "MB"
-	} else if size >= KiloByte {
-		return FloatToString(float64(size)/float64(KiloByte)) + "KB"
+	start := (page - 1) * limit
+	if start < 0 {
+		start = 0
+	}
+	if start > numResults {
+		start = numResults
 	}
-	return FloatToString(float64(size)) + "B"
+
+	end := start + limit
+	if end > numResults {
+		end = numResults
+	}
+	return start, end
 }
 
-func searchCommand(args []string) {
-	searchCmd := flag.NewFlagSet("search", flag.ExitOnError)
-	// sortByFilename := searchCmd.Bool("s", false, "sort results by filename")
+func numPages(numResults int, limit int) int {
+	if limit <= 0 || numResults == 0 {
+		return 1
+	}
+	return (numResults + limit - 1) / limit
+}
 
-	args = parseFlags(searchCmd, args)
+func printResults(res []search.FileInfo, opts *printOptions) {
+	if opts.group {
+		printGroupedResults(groupResults(res, opts.sortBy), opts)
+		return
+	}
 
-	if len(args) < 1 {
-		fmt.Println("search: no keyword provided.")
-		os.Exit(1)
+	sortResults(res, opts.sortBy)
+
+	start, end := pageBounds(len(res), opts.limit, opts.page)
+	switch opts.format {
+	case outputFormatJSON:
+		printResultsJSON(res[start:end], opts)
+		return
+	case outputFormatCSV:
+		printResultsCSV(res[start:end], start+1, opts)
+		return
 	}
 
-	res, _ := registry.Search(args)
-	sort.Slice(res, func(i, j int) bool {
-		return res[i].Gets < res[j].Gets
-	})
-	for _, fileInfo := range res {
-		fmt.Printf("%s\n\tgets: %d\n\tsize: %s\n\tlink: %s\n\tcmd: %s\n", fileInfo.Name, fileInfo.Gets, formatSize(fileInfo.Size), fileInfo.Url, fileInfo.Command)
+	if r, ok := markupRenderers[opts.format]; ok {
+		writeResultsMarkup(os.Stdout, r, "Search results", res[start:end], start+1, opts)
+		return
+	}
+
+	if end > start {
+		printResultsTable(res[start:end], start+1, opts)
+	}
+
+	if opts.limit > 0 {
+		fmt.Printf("\npage %d/%d (%d results)\n", opts.page, numPages(len(res), opts.limit), len(res))
 	}
 }
 
-func transferLoop(transfer *XdccTransfer) {
-	pb := NewProgressBar()
+// collectResults gathers the results delivered on resultsChan until every provider
+// has answered or the budget expires. A budget <= 0 means no time limit.
+// It returns the collected results and the number of providers which are still running.
+func collectResults(resultsChan <-chan search.ProviderResult, numProviders int, budget time.Duration) ([]search.FileInfo, int, int) {
+	res := make([]search.FileInfo, 0, search.MaxResults)
+
+	var timeout <-chan time.Time
+	if budget > 0 {
+		timeout = time.After(budget)
+	}
 
-	evts := transfer.PollEvents()
-	quit := false
-	for !quit {
-		e := <-evts
-		switch evtType := e.(type) {
-		case *TransferStartedEvent:
-			pb.SetTotal(int(evtType.FileSize))
-			pb.SetFileName(evtType.FileName)
-			pb.SetState(ProgressStateDownloading)
-		case *TransferProgessEvent:
-			pb.Increment(int(evtType.transferBytes))
-		case *TransferCompletedEvent:
-			pb.SetState(ProgressStateCompleted)
-			quit = true
+	pending, failed := numProviders, 0
+	for pending > 0 {
+		select {
+		case r := <-resultsChan:
+			if r.Err == nil {
+				res = append(res, r.Results...)
+			} else {
+				if r.Err == search.ErrProviderTimeout {
+					logAt(LogNormal, "%s: timed out, results may be incomplete", r.Provider.Name())
+				}
+				failed++
+			}
+			pending--
+		case <-timeout:
+			return res, pending, failed
 		}
 	}
-	// TODO: do clean-up operations here
+	return res, pending, failed
 }
 
-func suggestUnknownAuthoritySwitch(err error) {
-	if err.Error() == (x509.UnknownAuthorityError{}.Error()) {
-		fmt.Println("use the --allow-unknown-authority flag to skip certificate verification")
+// noResultsFailure returns the failure of a search without results, where failed of the
+// numProviders queries of the search engines failed.
+func noResultsFailure(failed int, numProviders int, interrupted bool) *commandFailure {
+	switch {
+	case interrupted:
+		return newCommandFailure(failureCancelled, "search interrupted without results")
+	case numProviders > 0 && failed == numProviders:
+		return newCommandFailure(failureProviderFailure, "every search engine failed")
 	}
+	return newCommandFailure(failureNoResults, "no results")
 }
 
-func doTransfer(transfer *XdccTransfer) {
-	err := transfer.Start()
+const minSuggestedBudget = 10 * time.Second
 
-	if err != nil {
-		fmt.Println(err)
-		suggestUnknownAuthoritySwitch(err)
+func suggestBudget(budget time.Duration) time.Duration {
+	if 2*budget > minSuggestedBudget {
+		return 2 * budget
+	}
+	return minSuggestedBudget
+}
+
+// parsePickList parses a list of result numbers such as "3,7" or "3-5,9".
+func parsePickList(pickStr string, numResults int) ([]int, error) {
+	picks := make([]int, 0)
+	for _, field := range strings.Split(pickStr, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		bounds := strings.SplitN(field, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, errors.New("invalid result number: " + field)
+		}
+
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, errors.New("invalid result number: " + field)
+			}
+		}
+
+		for n := first; n <= last; n++ {
+			if n < 1 || n > numResults {
+				return nil, fmt.Errorf("no such result: %d", n)
+			}
+			picks = append(picks, n)
+		}
+	}
+	return picks, nil
+}
+
+func isPickList(input string) bool {
+	return strings.Trim(input, "0123456789,- ") == ""
+}
+
+// searchSession holds the state of a search whose results are displayed page by page,
+// while the slower providers may still be running in background.
+type searchSession struct {
+	mu          sync.Mutex
+	results     []search.FileInfo
+	lateResults []search.FileInfo
+	pending     int
+	budget      time.Duration
+	opts        *printOptions
+	filters     []*SearchQuery // typed in the prompt to narrow the results
+	filterTexts []string
+	shown       []search.FileInfo // results matching the filters, in display order
+}
+
+func (session *searchSession) collectLateResults(resultsChan <-chan search.ProviderResult) {
+	for r := range resultsChan {
+		session.mu.Lock()
+		if r.Err == nil {
+			session.lateResults = append(session.lateResults, r.Results...)
+		}
+		session.pending--
+		session.mu.Unlock()
+	}
+}
+
+// refresh merges the late results into the displayed ones
+// and returns their number along with the number of providers still running.
+func (session *searchSession) refresh() (int, int) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	numLate := len(session.lateResults)
+	session.results = append(session.results, session.lateResults...)
+	session.lateResults = session.lateResults[:0]
+	return numLate, session.pending
+}
+
+func (session *searchSession) allResults() []search.FileInfo {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	all := make([]search.FileInfo, 0, len(session.results)+len(session.lateResults))
+	all = append(all, session.results...)
+	return append(all, session.lateResults...)
+}
+
+func (session *searchSession) print() {
+	sortResults(session.results, session.opts.sortBy)
+
+	session.shown = make([]search.FileInfo, 0, len(session.results))
+	for i := range session.results {
+		if session.matchFilters(&session.results[i]) {
+			session.shown = append(session.shown, session.results[i])
+		}
+	}
+
+	if len(session.filters) > 0 {
+		fmt.Printf("%d/%d results matching %q\n", len(session.shown), len(session.results), strings.Join(session.filterTexts, " "))
+	}
+
+	if session.opts.group {
+		// the best results of the episodes are the ones numbered, and picked
+		rows := groupResults(session.shown, session.opts.sortBy)
+		session.shown = groupedCandidates(rows)
+		printGroupedResults(rows, session.opts)
+		return
+	}
+	printResults(session.shown, session.opts)
+}
+
+func (session *searchSession) matchFilters(info *search.FileInfo) bool {
+	for _, filter := range session.filters {
+		if !filter.Match(info) {
+			return false
+		}
+	}
+	return true
+}
+
+// narrow restricts the displayed results to the ones matching the query, or shows
+// all the results again if the query is empty.
+func (session *searchSession) narrow(query string) {
+	if strings.TrimSpace(query) == "" {
+		session.filters, session.filterTexts = nil, nil
+	} else {
+		// unlike search engine keywords, every typed term must be matched
+		filter := ParseSearchQuery(query)
+		for _, alt := range filter.Queries() {
+			alt.Required = append(alt.Required, alt.Terms...)
+			alt.Terms = nil
+		}
+
+		session.filters = append(session.filters, filter)
+		session.filterTexts = append(session.filterTexts, strings.TrimSpace(query))
+	}
+	session.opts.page = 1
+}
+
+func (session *searchSession) printPrompt(numLate int, pending int) {
+	if pending > 0 || numLate > 0 {
+		fmt.Printf("\n%d late results (%d providers still searching), press r to refresh / rerun with --budget %s\n",
+			numLate, pending, suggestBudget(session.budget))
+	}
+
+	if session.opts.limit > 0 {
+		fmt.Print("n/p: next/previous page, ")
+	}
+	fmt.Print("text: narrow, /: show all, numbers (e.g. 3,7): download, enter: quit > ")
+}
+
+const defaultPromptLimit = 10
+
+// prompt lets the user refresh the results, move between pages, narrow the
+// results by typing some text and pick the results to download.
+// It returns the picked results.
+func (session *searchSession) prompt() []search.FileInfo {
+	session.mu.Lock()
+	pending := session.pending
+	session.mu.Unlock()
+	session.printPrompt(0, pending)
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		numLate := 0
+		input := strings.TrimSpace(scanner.Text())
+
+		switch input {
+		case "":
+			return nil
+		case "r":
+			numLate, pending = session.refresh()
+			session.print()
+		case "n", "p":
+			if input == "n" && session.opts.page < numPages(len(session.shown), session.opts.limit) {
+				session.opts.page++
+			} else if input == "p" && session.opts.page > 1 {
+				session.opts.page--
+			}
+			session.print()
+		default:
+			if strings.HasPrefix(input, "/") || !isPickList(input) {
+				session.narrow(strings.TrimPrefix(input, "/"))
+				session.print()
+				break
+			}
+
+			picks, err := parsePickList(input, len(session.shown))
+			if err == nil {
+				return pickResults(session.shown, picks)
+			}
+			fmt.Println(err)
+		}
+		session.printPrompt(numLate, pending)
+	}
+	return nil
+}
+
+func pickResults(res []search.FileInfo, picks []int) []search.FileInfo {
+	picked := make([]search.FileInfo, 0, len(picks))
+	for _, n := range picks {
+		picked = append(picked, res[n-1])
+	}
+	return picked
+}
+
+// findAlternatives returns the urls of the other results offering the same file.
+func findAlternatives(fileInfo *search.FileInfo, allResults []search.FileInfo) []xdcc.IRCFileURL {
+	alternatives := make([]xdcc.IRCFileURL, 0)
+	for i := range allResults {
+		other := &allResults[i]
+		if other.Name != fileInfo.Name || other.Size != fileInfo.Size || packKey(other) == packKey(fileInfo) {
+			continue
+		}
+
+		if url, err := other.IRCFileURL(); err == nil {
+			alternatives = append(alternatives, *url)
+		}
+	}
+	return alternatives
+}
+
+// downloadResults downloads the picked results, using the other results as fallback sources.
+func downloadResults(ctx context.Context, picked []search.FileInfo, allResults []search.FileInfo, opts *transferOptions) error {
+	requests := make([]downloadRequest, 0, len(picked))
+	for i := range picked {
+		fileInfo := &picked[i]
+		url, err := fileInfo.IRCFileURL()
+		if err != nil {
+			logError("%s: %s", fileInfo.Name, err)
+			continue
+		}
+		requests = append(requests, downloadRequest{
+			url:          *url,
+			alternatives: findAlternatives(fileInfo, allResults),
+			fileName:     fileInfo.Name,
+			fileSize:     fileInfo.Size,
+			hash:         fileInfo.Hash,
+		})
+	}
+	return downloadFiles(ctx, requests, opts)
+}
+
+// searchFlags holds the flags of the search subcommand.
+type searchFlags struct {
+	budget          time.Duration
+	timeout         time.Duration
+	providerTimeout time.Duration
+	local           bool
+	pick            string
+	interactive     bool
+	batchPath       string
+	top             bool
+	stream          bool
+	filter          *ResultFilter
+	print           *printOptions
+	transfer        *transferOptions
+	log             *logFlags
+}
+
+// newSearchFlagSet returns the flags of the search subcommand, also used by the completion.
+func newSearchFlagSet() (*flag.FlagSet, *searchFlags) {
+	searchCmd := flag.NewFlagSet("search", flag.ExitOnError)
+	flags := &searchFlags{filter: &ResultFilter{}}
+	searchCmd.DurationVar(&flags.budget, "budget", 0, "print the results arrived within the given time (e.g. 3s), keeping slower providers running in background")
+	searchCmd.DurationVar(&flags.timeout, "timeout", 0, "stop the search after the given time (e.g. 20s), returning the results arrived by then")
+	searchCmd.DurationVar(&flags.providerTimeout, "provider-timeout", 0, "time given to each search engine to answer (10s by default)")
+	flags.print = addPrintFlags(searchCmd)
+	searchCmd.IntVar(&flags.print.limit, "limit", 0, "maximum number of results per page")
+	searchCmd.IntVar(&flags.print.page, "page", 1, "page of results to display")
+	searchCmd.StringVar(&flags.print.sortBy, "sort", sortByGets, "sort results by gets, size, name or date (added or announced)")
+	searchCmd.BoolVar(&flags.local, "local", false, "search the packs seen by previous searches, without querying the search engines")
+	searchCmd.StringVar(&flags.pick, "pick", "", "comma separated list of result numbers to download (e.g. 3,7)")
+	searchCmd.BoolVar(&flags.interactive, "prompt", false, "interactively choose the results to download")
+	searchCmd.StringVar(&flags.batchPath, "batch", "", "run the queries of the given file, one per line (- for stdin), printing the results of each")
+	searchCmd.BoolVar(&flags.top, "top", false, "with --batch, download the top result of each query (the most gets, or the highest value of --sort)")
+	searchCmd.BoolVar(&flags.stream, "stream", false, "print the results of each search engine as soon as it answers, as a table, or one JSON object per line with --format json")
+	filter := flags.filter
+	searchCmd.Var((*sizeValue)(&filter.Size), "size", "only show files of the given size (e.g. 734003200 or 700M)")
+	searchCmd.Var((*sizeValue)(&filter.SizeTolerance), "size-tolerance", "accept sizes differing from --size by up to the given amount (e.g. 1M)")
+	searchCmd.IntVar(&filter.Sample, "sample", 0, "show at most the given number of results per search engine, for quick exploratory searches")
+	searchCmd.StringVar(&filter.Hash, "hash", "", "only show files with the given hash (CRC32 tags in file names are used when providers do not report hashes)")
+	searchCmd.Var((*tagList)(&filter.Tags), "tag", "only show files from the bots, channels or networks with the given tag (-tag excludes them), can be repeated")
+	flags.transfer = addTransferFlags(searchCmd)
+	flags.log = addLogFlags(searchCmd)
+	return searchCmd, flags
+}
+
+func searchCommand(args []string) {
+	searchCmd, flags := newSearchFlagSet()
+	filter, printOpts, opts, logOpts := flags.filter, flags.print, flags.transfer, flags.log
+
+	queryText := parseQueryArgs(searchCmd, args)
+	filter.Query = ParseSearchQuery(queryText)
+	logOpts.apply()
+	printOpts.apply()
+
+	if flags.timeout > 0 {
+		registry.SetSearchTimeout(flags.timeout)
+	}
+	if flags.providerTimeout > 0 {
+		registry.SetTimeout(flags.providerTimeout)
+	}
+	if opts.metricsPath != "" {
+		registry.SetQueryObserver(metrics.providerQueried)
+	}
+
+	if flags.batchPath != "" && (queryText != "" || flags.pick != "" || flags.interactive) {
+		fmt.Println("search: --batch cannot be used with keywords, --pick or --prompt.")
+		os.Exit(1)
+	}
+
+	if flags.top && flags.batchPath == "" {
+		fmt.Println("search: --top requires --batch.")
+		os.Exit(1)
+	}
+
+	if flags.stream && (flags.batchPath != "" || flags.pick != "" || flags.interactive || printOpts.group || printOpts.limit > 0) {
+		fmt.Println("search: --stream cannot be used with --batch, --pick, --prompt, --group or --limit.")
+		os.Exit(1)
+	}
+
+	if flags.stream && !isValidStreamFormat(printOpts.format) {
+		fmt.Println("search: --stream only supports the table, json and csv formats.")
+		os.Exit(1)
+	}
+
+	if flags.batchPath == "" && len(filter.Query.KeywordSets()) < 1 {
+		fmt.Println("search: no keyword provided.")
+		os.Exit(1)
+	}
+
+	if flags.local {
+		if packIndex == nil {
+			logError("search: the pack index is not available")
+			os.Exit(1)
+		}
+		registry = localSearchRegistry()
+		printOpts.local = true
+	}
+
+	if flags.batchPath != "" {
+		batchSearchCommand(flags.batchPath, filter, printOpts, flags.top, opts)
+		return
+	}
+
+	// the slower providers keep running while the results are displayed, until the user is done
+	searchCtx, cancelSearch := context.WithCancel(context.Background())
+	defer cancelSearch()
+
+	stopInterrupts := cancelOnInterrupt(cancelSearch)
+	resultsChan, numResults := searchQueryAsync(searchCtx, filter.Query)
+
+	var res []search.FileInfo
+	var pending, failed int
+	if flags.stream {
+		res, pending, failed = streamResults(FilterResultsAsync(resultsChan, filter), numResults, flags.budget, printOpts)
+	} else {
+		res, pending, failed = collectResults(FilterResultsAsync(resultsChan, filter), numResults, flags.budget)
+	}
+	outcome := "completed"
+	if searchCtx.Err() != nil {
+		outcome = "interrupted"
+		logInfo("search interrupted, showing the results received so far")
+	} else if pending > 0 {
+		outcome = "partial"
+	}
+	stopInterrupts()
+	recordSearch(queryText, len(res), outcome)
+
+	if flags.stream {
+		if len(res) == 0 {
+			exitWithFailure(noResultsFailure(failed, numResults, outcome == "interrupted"))
+		}
+		return
+	}
+
+	if flags.interactive && printOpts.limit == 0 {
+		printOpts.limit = defaultPromptLimit
+	}
+
+	session := &searchSession{
+		results:     res,
+		lateResults: make([]search.FileInfo, 0),
+		pending:     pending,
+		budget:      flags.budget,
+		opts:        printOpts,
+	}
+	session.print()
+
+	if flags.pick != "" {
+		picks, err := parsePickList(flags.pick, len(session.shown))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		cancelSearch()
+		if err := downloadResults(context.Background(), pickResults(session.shown, picks), res, opts); err != nil {
+			exitWithFailure(err)
+		}
 		return
 	}
 
-	transferLoop(transfer)
+	if (pending > 0 && !isQuiet()) || flags.interactive {
+		collected := make(chan struct{})
+		go func() {
+			session.collectLateResults(resultsChan)
+			close(collected)
+		}()
+
+		picked := session.prompt()
+		cancelSearch()
+		<-collected
+
+		if len(picked) > 0 {
+			if err := downloadResults(context.Background(), picked, session.allResults(), opts); err != nil {
+				exitWithFailure(err)
+			}
+		}
+	}
+
+	if len(session.allResults()) == 0 {
+		exitWithFailure(noResultsFailure(failed, numResults, outcome == "interrupted"))
+	}
 }
 
 func parseFlags(flagSet *flag.FlagSet, args []string) []string {
@@ -150,65 +672,245 @@ func loadUrlListFile(filePath string) []string {
 }
 
 func printGetUsageAndExit(flagSet *flag.FlagSet) {
-	fmt.Printf("usage: get url1 url2 ... [-o path] [-i file] [--allow-unknown-authority]\n\nFlag set:\n")
+	fmt.Printf("usage: get url1 url2 ... [-o path] [-i file] [--allow-unknown-authority]\n\n")
+	fmt.Printf("urls can be irc:// or xdcc:// urls, or pack references such as rizon/#nibl/Bot/#123\n\nFlag set:\n")
 	flagSet.PrintDefaults()
 	os.Exit(0)
 }
 
-func getCommand(args []string) {
+type transferOptions struct {
+	path                 string
+	skipCertificateCheck bool
+	noSSL                bool
+	requireTLSDCC        bool
+	maxParallel          int
+	destTemplate         string
+	collisionPolicy      string
+	batchConflictPolicy  string
+	manifestPath         string
+	checksumFormat       string
+	checksumScope        string
+	minSpeed             int64
+	minSpeedWindow       time.Duration
+	retries              int // times a file is requested again after losing the connection
+	checkpointsPath      string
+	checkpointInterval   time.Duration
+	departureMode        string
+	departureDelay       time.Duration
+	dryRun               bool
+	metricsPath          string
+	stats                string // format of the report printed at the end of the batch
+	noAutoJoin           bool
+	trustFileNames       bool
+	trustPackNumbers     bool
+	notify               bool
+	verifyCRC            bool
+	directIO             bool
+	ignoreFailures       bool
+	failFast             bool
+	mirror               bool
+	mirrorRace           bool
+	force                bool
+	botWindows           bool
+	scheduleSpec         string
+	schedule             *schedule           // parsed by validateTransferOptions
+	onDone               func(source string) // called once the file of the source is completed or skipped
+	extract              bool
+	extractDir           string
+	passwordFile         string
+	deleteArchives       bool
+	extractor            *extractor // set by validateTransferOptions, if extracting
+	forwardTargets       []string
+	deleteForwarded      bool
+	forwarder            *forwarder // set by validateTransferOptions
+	stdinCommands        bool       // stdin is read by the fserve session, rather than for status requests
+	daemon               bool       // the transfers are run by the daemon, which never exits on its own
+}
+
+func addTransferFlags(flagSet *flag.FlagSet) *transferOptions {
+	opts := &transferOptions{}
+	flagSet.StringVar(&opts.path, "o", defaultDownloadDir(), "output folder of dowloaded file")
+	flagSet.StringVar(&opts.path, "download-dir", defaultDownloadDir(), "same as -o")
+	flagSet.BoolVar(&opts.skipCertificateCheck, "allow-unknown-authority", false, "skip x509 certificate check during tls connection")
+	flagSet.BoolVar(&opts.noSSL, "no-ssl", false, "disable SSL.")
+	flagSet.BoolVar(&opts.requireTLSDCC, "require-tls-dcc", false, "request encrypted transfers (SSL DCC) and refuse plaintext ones")
+	flagSet.IntVar(&opts.maxParallel, "n", 0, "maximum number of simultaneous transfers (0 means no limit)")
+	flagSet.StringVar(&opts.destTemplate, "dest-template", "{name}", "destination of downloaded files, relative to the output folder.\nAvailable tokens: {network}, {channel}, {bot}, {slot}, {date}, {name}")
+	flagSet.StringVar(&opts.collisionPolicy, "on-collision", xdcc.CollisionRename, "what to do when a file already exists: skip, overwrite, rename or resume")
+	flagSet.BoolVar(&opts.trustFileNames, "trust-filenames", false, "save the files under the names offered by the bots as they are, including their folders, rather than sanitizing them")
+	flagSet.BoolVar(&opts.notify, "notify", false, "show desktop notifications when the transfers complete or fail, when their position in the queue of the bots changes and when the batch is over")
+	flagSet.BoolVar(&opts.trustPackNumbers, "trust-pack-numbers", false, "download the requested packs even if they offer another file than the search result, rather than looking for it in the packlist of the bot")
+	flagSet.BoolVar(&opts.noAutoJoin, "no-auto-join", false, "do not join the channels required by the bots, failing the transfers instead")
+	flagSet.StringVar(&opts.manifestPath, "manifest", "", "write a manifest of the downloaded files to the given .json or .csv file")
+	flagSet.BoolVar(&opts.directIO, "direct-io", false, "write the files bypassing the page cache (O_DIRECT, linux only), for fast transfers to fast disks")
+	flagSet.BoolVar(&opts.verifyCRC, "verify-crc", false, "check the downloaded files against the CRC32 reported by the search engine or tagged in their names (e.g. \"[1A2B3C4D]\")")
+	flagSet.StringVar(&opts.checksumFormat, "checksum-file", "", "write a sfv or md5 checksum file for the completed downloads")
+	flagSet.StringVar(&opts.checksumScope, "checksum-scope", ChecksumScopeFile, "write a checksum file per file or per dir")
+	flagSet.StringVar(&opts.batchConflictPolicy, "batch-conflict", BatchConflictSuffix, "how to separate different packs with the same file name: suffix or bot-dir")
+	flagSet.Var((*sizeValue)(&opts.minSpeed), "min-speed", "abort transfers slower than the given speed per second (e.g. 50K) and try another bot")
+	flagSet.DurationVar(&opts.minSpeedWindow, "min-speed-window", 30*time.Second, "how long a transfer can stay below --min-speed")
+	flagSet.IntVar(&opts.retries, "retries", 3, "how many times a file is requested again, resuming it, when the connection to the server or to the bot is lost")
+	flagSet.StringVar(&opts.checkpointsPath, "checkpoints", "", "append the transfer checkpoints to the given file as NDJSON lines (- for stdout)")
+	flagSet.DurationVar(&opts.checkpointInterval, "checkpoint-interval", defaultCheckpointInterval, "time between two checkpoints of a downloading transfer (0 disables periodic checkpoints)")
+	flagSet.StringVar(&opts.departureMode, "part", "", "when to leave the channels after a download: immediately, delay or never (overrides the configuration)")
+	flagSet.DurationVar(&opts.departureDelay, "part-delay", 0, "time spent in the channels after a download with --part delay (overrides the configuration)")
+	flagSet.BoolVar(&opts.dryRun, "dry-run", false, "request the files and report the offers of the bots without downloading them")
+	flagSet.BoolVar(&opts.mirror, "mirror", false, "when several bots offer the same file, probe them and download from the one answering first")
+	flagSet.BoolVar(&opts.mirrorRace, "mirror-race", false, "with --mirror, download the first bytes from the two best bots and keep the faster one")
+	flagSet.BoolVar(&opts.force, "force", false, "download even if the disk does not seem to have enough space for the files, from bots blocked by the configuration, or from bots seen from another host")
+	flagSet.BoolVar(&opts.botWindows, "bot-windows", false, "delay the transfers of the bots which are usually much faster at other hours of the day, until those hours")
+	flagSet.StringVar(&opts.scheduleSpec, "schedule", "", "only run the transfers during the given windows separated by ';' (e.g. \"01:00-07:00\" or \"mon-fri 22:00-06:00\"), pausing them outside (overrides the configuration)")
+	flagSet.BoolVar(&opts.extract, "extract", false, "extract the downloaded rar, zip and 7z archives (including multi-part ones) with 7-Zip, once verified")
+	flagSet.StringVar(&opts.extractDir, "extract-dir", "", "folder where the archives are extracted, relative to their own folder unless absolute")
+	flagSet.StringVar(&opts.passwordFile, "passwords", "", "file with the passwords to try on encrypted archives, one per line")
+	flagSet.BoolVar(&opts.deleteArchives, "delete-archives", false, "delete the archives once extracted")
+	flagSet.Var((*tagList)(&opts.forwardTargets), "forward", "upload the downloaded files with rclone to the given targets, separated by commas or repeated: rclone remotes (nas:dir), sftp://, webdav://, webdavs:// or s3:// urls (overrides the configuration)")
+	flagSet.BoolVar(&opts.deleteForwarded, "delete-forwarded", false, "delete the downloaded files once uploaded to every target")
+	flagSet.BoolVar(&opts.ignoreFailures, "ignore-failures", false, "exit successfully even if some transfers failed, reporting them in the summary")
+	flagSet.BoolVar(&opts.failFast, "fail-fast", false, "stop the whole batch as soon as a transfer fails")
+	flagSet.StringVar(&opts.stats, "stats", statsText, "report printed at the end of the batch: text (on stderr), json (on stdout, including the summary printed with --quiet), markdown or html (on stdout) or none")
+	flagSet.StringVar(&opts.metricsPath, "metrics-file", "", "write the metrics of the batch in the Prometheus text format to the given file at exit, e.g. for the textfile collector of node_exporter")
+	return opts
+}
+
+// getFlags holds the flags of the get subcommand.
+type getFlags struct {
+	inputFile string
+	transfer  *transferOptions
+	log       *logFlags
+}
+
+// newGetFlagSet returns the flags of the get subcommand, also used by the completion.
+func newGetFlagSet() (*flag.FlagSet, *getFlags) {
 	getCmd := flag.NewFlagSet("get", flag.ExitOnError)
-	path := getCmd.String("o", ".", "output folder of dowloaded file")
-	inputFile := getCmd.String("i", "", "input file containing a list of urls")
-	skipCertificateCheck := getCmd.Bool("allow-unknown-authority", false, "skip x509 certificate check during tls connection")
-	noSSL := getCmd.Bool("no-ssl", false, "disable SSL.")
+	flags := &getFlags{}
+	getCmd.StringVar(&flags.inputFile, "i", "", "input file containing a list of urls")
+	flags.transfer = addTransferFlags(getCmd)
+	flags.log = addLogFlags(getCmd)
+	return getCmd, flags
+}
+
+func getCommand(args []string) {
+	getCmd, flags := newGetFlagSet()
+	opts := flags.transfer
 
-	urlList := parseFlags(getCmd, args)
+	urlStrList := parseFlags(getCmd, args)
+	flags.log.apply()
 
-	if *inputFile != "" {
-		urlList = append(urlList, loadUrlListFile(*inputFile)...)
+	if flags.inputFile != "" {
+		urlStrList = append(urlStrList, loadUrlListFile(flags.inputFile)...)
 	}
 
-	if len(urlList) == 0 {
+	if len(urlStrList) == 0 {
 		printGetUsageAndExit(getCmd)
 	}
 
-	wg := sync.WaitGroup{}
-	for _, urlStr := range urlList {
-		if strings.HasPrefix(urlStr, "irc://") {
-			url, err := parseIRCFileURl(urlStr)
-
-			if err != nil {
-				fmt.Println(err.Error())
-				os.Exit(1)
-			}
+	urlList := make([]xdcc.IRCFileURL, 0, len(urlStrList))
+	for _, urlStr := range urlStrList {
+		urlStr = strings.TrimSpace(urlStr)
+		if urlStr == "" {
+			continue
+		}
 
-			wg.Add(1)
-			transfer := NewXdccTransfer(*url, *path, !*noSSL, *skipCertificateCheck)
-			go func(transfer *XdccTransfer) {
-				doTransfer(transfer)
-				wg.Done()
-			}(transfer)
-		} else {
-			fmt.Printf("no valid irc url %s\n", urlStr)
+		url, err := xdcc.ParsePackRef(urlStr)
+		if err != nil {
+			logError("%s: %s", urlStr, err)
+			os.Exit(1)
 		}
+		urlList = append(urlList, *url)
+	}
+	if err := downloadFiles(context.Background(), newDownloadRequests(urlList), opts); err != nil {
+		exitWithFailure(err)
+	}
+}
+
+func mustLoadConfig() {
+	path, err := configPath()
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
-	wg.Wait()
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		fmt.Printf("unable to load config file %s: %s\n", path, err)
+		os.Exit(1)
+	}
+	config = cfg
 }
 
 func main() {
 
 	if len(os.Args) < 2 {
-		fmt.Println("one of the following subcommands is expected: [search, get]")
+		fmt.Println("one of the following subcommands is expected: [search, get, fserve, preview, watch, watches, queue, daemon, providers, notes, bots, history, bookmark, completion]")
 		os.Exit(1)
 	}
 
-	searchCommand(os.Args[1:])
-	// switch os.Args[1] {
-	// case "search":
-	// case "get":
-	// 	getCommand(os.Args[2:])
-	// default:
-	// 	fmt.Println("no such command: ", os.Args[1])
-	// 	os.Exit(1)
-	// }
+	enableVirtualTerminal()
+	mustLoadConfig()
+
+	// run by the completion scripts on every Tab press, which only need the flags and the data files
+	if os.Args[1] == "__complete" {
+		completeCommand(os.Args[2:])
+		return
+	}
+
+	setupCertificatePins()
+	setupClientProfile()
+	setupNetworkIdentities()
+	registry.SetNetworkFilter(config.Networks.Allows)
+	setupBotPolicy()
+	registerAnnounceProviders()
+	registerPluginProviders()
+	setupSearchTimeouts()
+	setupProviderLocales()
+	setupProviderRateLimits()
+	setupProviderRequests()
+	setupCircuitBreaker()
+	setupBotStats()
+	setupKnownBots()
+	setupNotifications()
+	setupResumeStore()
+	setupNotes()
+	setupPackIndex()
+	setupCustomColumns()
+
+	if addr, args, ok := parseRemoteFlag(os.Args[1:]); ok {
+		remoteCommand(addr, args)
+		return
+	}
+
+	switch os.Args[1] {
+	case "search":
+		searchCommand(os.Args[2:])
+	case "get":
+		getCommand(os.Args[2:])
+	case "fserve":
+		fserveCommand(os.Args[2:])
+	case "preview":
+		previewCommand(os.Args[2:])
+	case "watch":
+		watchCommand(os.Args[2:])
+	case "watches":
+		watchesCommand(os.Args[2:])
+	case "daemon":
+		daemonCommand(os.Args[2:])
+	case "providers":
+		providersCommand(os.Args[2:])
+	case "notes":
+		notesCommand(os.Args[2:])
+	case "queue":
+		queueCommand(os.Args[2:])
+	case "bots":
+		botsCommand(os.Args[2:])
+	case "history":
+		historyCommand(os.Args[2:])
+	case "bookmark":
+		bookmarkCommand(os.Args[2:])
+	case "completion":
+		completionCommand(os.Args[2:])
+	default:
+		fmt.Println("no such command: ", os.Args[1])
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// manifestEntry describes a file of the batch, for archival catalog tools.
+type manifestEntry struct {
+	Path    string `json:"path"`
+	Name    string `json:"name"`
+	Size    uint64 `json:"size"`
+	SHA256  string `json:"sha256,omitempty"`
+	Source  string `json:"source"`
+	Network string `json:"network"`
+	Bot     string `json:"bot"`
+	Status  string `json:"status"`
+}
+
+func (batch *Batch) manifestEntries() []manifestEntry {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	entries := make([]manifestEntry, 0, len(batch.items))
+	for _, item := range batch.items {
+		entry := manifestEntry{
+			Path:    item.filePath,
+			Name:    item.fileName,
+			Size:    item.bytes,
+			Source:  item.url.String(),
+			Network: item.url.Network,
+			Bot:     item.url.UserName,
+			Status:  string(item.state),
+		}
+
+		if item.filePath != "" {
+			if absPath, err := filepath.Abs(item.filePath); err == nil {
+				entry.Path = absPath
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+var manifestCSVHeader = []string{"path", "name", "size", "sha256", "source", "network", "bot", "status"}
+
+func writeManifestCSV(path string, entries []manifestEntry) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write(manifestCSVHeader)
+	for _, e := range entries {
+		w.Write([]string{e.Path, e.Name, strconv.FormatUint(e.Size, 10), e.SHA256, e.Source, e.Network, e.Bot, e.Status})
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func writeManifestJSON(path string, entries []manifestEntry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(data)
+	return err
+}
+
+// writeManifest writes the manifest of the batch to path, in CSV format
+// if the file has a .csv extension, in JSON format otherwise.
+func writeManifest(batch *Batch, path string) error {
+	entries := batch.manifestEntries()
+	for i := range entries {
+		if entries[i].Status != string(itemStateCompleted) {
+			continue
+		}
+
+		checksum, err := fileSHA256(entries[i].Path)
+		if err != nil {
+			logError("%s: unable to compute checksum: %s", entries[i].Path, err)
+			continue
+		}
+		entries[i].SHA256 = checksum
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return writeManifestCSV(path, entries)
+	}
+	return writeManifestJSON(path, entries)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type providerMetrics struct {
+	queries uint64
+	errors  uint64
+	latency float64 // total time spent querying the provider, in seconds
+}
+
+// Metrics collects the counters exposed in the Prometheus text format by the daemon.
+type Metrics struct {
+	mu        sync.Mutex
+	bytes     uint64
+	transfers map[itemState]uint64  // finished transfers, by final state
+	durations map[itemState]float64 // total duration of the finished transfers, in seconds
+	providers map[string]*providerMetrics
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		transfers: make(map[itemState]uint64),
+		durations: make(map[itemState]float64),
+		providers: make(map[string]*providerMetrics),
+	}
+}
+
+var metrics = NewMetrics()
+
+func (m *Metrics) addBytes(n uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.bytes += n
+}
+
+func (m *Metrics) transferFinished(state itemState, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.transfers[state]++
+	m.durations[state] += duration.Seconds()
+}
+
+func (m *Metrics) providerQueried(provider string, latency time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, ok := m.providers[provider]
+	if !ok {
+		p = &providerMetrics{}
+		m.providers[provider] = p
+	}
+
+	p.queries++
+	p.latency += latency.Seconds()
+	if err != nil {
+		p.errors++
+	}
+}
+
+// activeStats returns the number of active transfers of the batch and their total speed.
+func (batch *Batch) activeStats() (int, float64) {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	active, speed := 0, 0.0
+	for _, item := range batch.items {
+		switch item.state {
+		case itemStateConnecting:
+			active++
+		case itemStateDownloading:
+			active++
+			speed += item.speed
+		}
+	}
+	return active, speed
+}
+
+func writeMetric(w io.Writer, name string, metricType string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+// The gauges are computed from the given batches.
+func (m *Metrics) WriteTo(w io.Writer, batches []*Batch) {
+	active, speed := 0, 0.0
+	for _, batch := range batches {
+		a, s := batch.activeStats()
+		active += a
+		speed += s
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeMetric(w, "xdcc_active_transfers", "gauge", "Number of transfers connecting or downloading.")
+	fmt.Fprintf(w, "xdcc_active_transfers %d\n", active)
+
+	writeMetric(w, "xdcc_transfer_speed_bytes_per_second", "gauge", "Total speed of the active transfers.")
+	fmt.Fprintf(w, "xdcc_transfer_speed_bytes_per_second %g\n", speed)
+
+	writeMetric(w, "xdcc_downloaded_bytes_total", "counter", "Number of bytes downloaded.")
+	fmt.Fprintf(w, "xdcc_downloaded_bytes_total %d\n", m.bytes)
+
+	finalStates := []itemState{itemStateCompleted, itemStateFailed, itemStateCancelled, itemStateSkipped}
+
+	writeMetric(w, "xdcc_transfers_total", "counter", "Number of finished transfers, by final state.")
+	for _, state := range finalStates {
+		fmt.Fprintf(w, "xdcc_transfers_total{state=%q} %d\n", state, m.transfers[state])
+	}
+
+	writeMetric(w, "xdcc_transfer_duration_seconds", "summary", "Time spent by the finished transfers, by final state.")
+	for _, state := range finalStates {
+		fmt.Fprintf(w, "xdcc_transfer_duration_seconds_sum{state=%q} %g\n", state, m.durations[state])
+		fmt.Fprintf(w, "xdcc_transfer_duration_seconds_count{state=%q} %d\n", state, m.transfers[state])
+	}
+
+	names := make([]string, 0, len(m.providers))
+	for name := range m.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	writeMetric(w, "xdcc_provider_queries_total", "counter", "Number of search provider queries.")
+	for _, name := range names {
+		fmt.Fprintf(w, "xdcc_provider_queries_total{provider=%q} %d\n", name, m.providers[name].queries)
+	}
+
+	writeMetric(w, "xdcc_provider_errors_total", "counter", "Number of failed search provider queries.")
+	for _, name := range names {
+		fmt.Fprintf(w, "xdcc_provider_errors_total{provider=%q} %d\n", name, m.providers[name].errors)
+	}
+
+	writeMetric(w, "xdcc_provider_query_duration_seconds", "summary", "Time spent querying the search providers.")
+	for _, name := range names {
+		p := m.providers[name]
+		fmt.Fprintf(w, "xdcc_provider_query_duration_seconds_sum{provider=%q} %g\n", name, p.latency)
+		fmt.Fprintf(w, "xdcc_provider_query_duration_seconds_count{provider=%q} %d\n", name, p.queries)
+	}
+}
+
+// writeBatchMetrics writes the metrics of a finished batch, along with the gauges describing the batch itself.
+func writeBatchMetrics(w io.Writer, batch *Batch) {
+	metrics.WriteTo(w, []*Batch{batch})
+
+	writeMetric(w, "xdcc_batch_duration_seconds", "gauge", "Duration of the last batch.")
+	fmt.Fprintf(w, "xdcc_batch_duration_seconds %g\n", time.Since(batch.started).Seconds())
+
+	writeMetric(w, "xdcc_batch_last_run_timestamp_seconds", "gauge", "Unix time at which the last batch ended.")
+	fmt.Fprintf(w, "xdcc_batch_last_run_timestamp_seconds %d\n", time.Now().Unix())
+}
+
+// writeMetricsFile writes the metrics of a finished batch to path, for the textfile collector of node_exporter.
+// The file is replaced atomically, so that the collector never reads a partial file.
+func writeMetricsFile(path string, batch *Batch) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	writeBatchMetrics(tmp, batch)
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	// the collector runs as another user
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
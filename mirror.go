@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ostafen/xdcc-cli/internal/group"
+	"github.com/ostafen/xdcc-cli/pkg/search"
+	"github.com/ostafen/xdcc-cli/pkg/xdcc"
+)
+
+const (
+	maxMirrorCandidates = 4                     // number of sources probed
+	mirrorProbeTimeout  = 30 * time.Second      // time given to each source to answer
+	mirrorRaceSize      = 256 * search.KiloByte // bytes downloaded from each source when racing them
+	mirrorRaceTimeout   = time.Minute
+)
+
+// betterMirror tells whether the source of a should be preferred to the one of b: the sources
+// offering the file come first by response time, then the ones queueing the request by position.
+func betterMirror(a, b *dryRunResult) bool {
+	switch {
+	case a.offer != nil && b.offer != nil:
+		return a.offer.ResponseTime < b.offer.ResponseTime
+	case a.offer != nil || b.offer != nil:
+		return a.offer != nil
+	case a.queued != nil && b.queued != nil:
+		// an unknown position is assumed to be worse than any known one
+		return a.queued.Position > 0 && (b.queued.Position == 0 || a.queued.Position < b.queued.Position)
+	}
+	return a.queued != nil && b.queued == nil
+}
+
+// rankMirrors probes the sources concurrently and sorts the results from the best one.
+func rankMirrors(ctx context.Context, urls []xdcc.IRCFileURL, opts *transferOptions) []dryRunResult {
+	results := make([]dryRunResult, len(urls))
+	departures := &group.Group{}
+
+	g := &group.Group{}
+	for i, url := range urls {
+		i, url := i, url
+		g.Go(func() error {
+			res, transfer := probe(ctx, url, opts, mirrorProbeTimeout)
+			if transfer != nil {
+				// the file is requested again from the selected source, on a new connection
+				departures.Go(func() error {
+					transfer.Leave(ctx, xdcc.Departure{Mode: xdcc.DepartImmediately})
+					return nil
+				})
+			}
+			results[i] = res
+			return nil
+		})
+	}
+	g.Wait()
+	departures.Wait()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return betterMirror(&results[i], &results[j])
+	})
+	return results
+}
+
+// raceMirrors downloads the first bytes of the file from both sources at once, returning the index
+// of the source which sent them first, or -1 if neither did. Both transfers are then cancelled.
+func raceMirrors(ctx context.Context, urls [2]xdcc.IRCFileURL, opts *transferOptions) int {
+	dir, err := ioutil.TempDir("", "xdcc-mirror")
+	if err != nil {
+		logError("unable to race mirrors: %s", err)
+		return -1
+	}
+
+	raceCtx, cancel := context.WithTimeout(ctx, mirrorRaceTimeout)
+
+	transfers := make([]*xdcc.Transfer, len(urls))
+	evts := make([]chan xdcc.TransferEvent, len(urls)) // a nil channel never delivers
+	for i, url := range urls {
+		transfers[i] = xdcc.NewTransfer(url, xdcc.TransferConfig{
+			FilePath:             dir,
+			DestTemplate:         "{name}",
+			NameSuffix:           "." + strconv.Itoa(i),
+			CollisionPolicy:      xdcc.CollisionOverwrite,
+			EnableSSL:            !opts.noSSL,
+			SkipCertificateCheck: opts.skipCertificateCheck,
+			RequireTLSDCC:        opts.requireTLSDCC,
+			NoAutoJoin:           opts.noAutoJoin,
+			TrustFileNames:       opts.trustFileNames,
+		})
+
+		if err := transfers[i].Start(raceCtx); err == nil {
+			evts[i] = transfers[i].PollEvents()
+		}
+	}
+
+	defer func() {
+		cancel()
+		// the samples are removed once both transfers are over
+		for i, transfer := range transfers {
+			if evts[i] != nil {
+				waitTransferOver(evts[i])
+			}
+			transfer.Wait()
+			transfer.Leave(ctx, xdcc.Departure{Mode: xdcc.DepartImmediately})
+		}
+		os.RemoveAll(dir)
+	}()
+
+	received := make([]uint64, len(urls))
+	for evts[0] != nil || evts[1] != nil {
+		var e xdcc.TransferEvent
+		i := 0
+		select {
+		case e = <-evts[0]:
+		case e = <-evts[1]:
+			i = 1
+		case <-raceCtx.Done():
+			return -1
+		}
+
+		switch evt := e.(type) {
+		case *xdcc.TransferProgressEvent:
+			received[i] += evt.Bytes
+			if received[i] >= mirrorRaceSize {
+				return i
+			}
+		case *xdcc.TransferCompletedEvent:
+			evts[i] = nil
+			return i // smaller than the race size
+		case *xdcc.TransferAbortedEvent, *xdcc.TransferSkippedEvent:
+			evts[i] = nil
+		}
+	}
+	return -1
+}
+
+// waitTransferOver waits for the last event of a cancelled transfer.
+func waitTransferOver(evts chan xdcc.TransferEvent) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-evts:
+			switch e.(type) {
+			case *xdcc.TransferCompletedEvent, *xdcc.TransferAbortedEvent, *xdcc.TransferSkippedEvent:
+				return
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+// selectMirror makes the item download from the best of its sources, keeping the others as alternatives.
+func (batch *Batch) selectMirror(ctx context.Context, item *batchItem, opts *transferOptions) {
+	batch.mu.Lock()
+	candidates := append([]xdcc.IRCFileURL{item.url}, item.alternatives...)
+	batch.mu.Unlock()
+
+	if len(candidates) < 2 {
+		return
+	}
+
+	rest := []xdcc.IRCFileURL(nil)
+	if len(candidates) > maxMirrorCandidates {
+		candidates, rest = candidates[:maxMirrorCandidates], candidates[maxMirrorCandidates:]
+	}
+
+	logInfo("%s: probing %d mirrors", item.url.String(), len(candidates))
+	ranked := rankMirrors(ctx, candidates, opts)
+	for _, r := range ranked {
+		switch {
+		case r.offer != nil:
+			logAt(LogProviders, "  %s: offered in %s", r.url.String(), r.offer.ResponseTime.Round(time.Millisecond))
+		case r.queued != nil:
+			logAt(LogProviders, "  %s: %s", r.url.String(), queueStatus(r.queued))
+		default:
+			logAt(LogProviders, "  %s: %s", r.url.String(), r.err)
+		}
+	}
+
+	if opts.mirrorRace && ranked[0].offer != nil && ranked[1].offer != nil && ctx.Err() == nil {
+		logInfo("%s: racing %s and %s", item.url.String(), ranked[0].url.String(), ranked[1].url.String())
+		if winner := raceMirrors(ctx, [2]xdcc.IRCFileURL{ranked[0].url, ranked[1].url}, opts); winner == 1 {
+			ranked[0], ranked[1] = ranked[1], ranked[0]
+		}
+	}
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	urls := make([]xdcc.IRCFileURL, 0, len(ranked)+len(rest))
+	for _, r := range ranked {
+		urls = append(urls, r.url)
+	}
+	urls = append(urls, rest...)
+
+	batch.mu.Lock()
+	item.url, item.alternatives = urls[0], urls[1:]
+	batch.mu.Unlock()
+
+	logInfo("downloading from %s", urls[0].String())
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ostafen/xdcc-cli/pkg/search"
+	"github.com/ostafen/xdcc-cli/pkg/xdcc"
+)
+
+const notesFileName = "notes.json"
+
+// Annotation holds the tags and the note attached by the user to a network, a channel or a bot.
+type Annotation struct {
+	Tags []string `json:"tags,omitempty"`
+	Note string   `json:"note,omitempty"`
+}
+
+func (a *Annotation) isEmpty() bool {
+	return len(a.Tags) == 0 && a.Note == ""
+}
+
+// Notes maps the annotated targets to their annotations. Targets are networks ("irc.rizon.net"),
+// channels ("irc.rizon.net/#nibl") or bots ("irc.rizon.net/SomeBot").
+type Notes map[string]*Annotation
+
+var userNotes = make(Notes)
+
+// normalizeTarget checks the syntax of the target and returns its key.
+func normalizeTarget(target string) (string, error) {
+	fields := strings.Split(strings.ToLower(strings.TrimPrefix(target, "irc://")), "/")
+	if len(fields) > 2 || fields[0] == "" || (len(fields) == 2 && strings.TrimPrefix(fields[1], "#") == "") {
+		return "", fmt.Errorf("invalid target %s: expected network, network/#channel or network/bot", target)
+	}
+	return strings.Join(fields, "/"), nil
+}
+
+// targetKeys returns the keys of the network, the channel and the bot of a pack.
+func targetKeys(network, channel, bot string) []string {
+	network = strings.ToLower(network)
+	if !strings.HasPrefix(channel, "#") {
+		channel = "#" + channel
+	}
+	return []string{network, network + "/" + strings.ToLower(channel), network + "/" + strings.ToLower(bot)}
+}
+
+// lookup returns the tags and notes attached to the network, channel or bot of a pack.
+func (notes Notes) lookup(network, channel, bot string) ([]string, []string) {
+	tags := make([]string, 0)
+	texts := make([]string, 0)
+	for _, key := range targetKeys(network, channel, bot) {
+		if a, ok := notes[key]; ok {
+			tags = append(tags, a.Tags...)
+			if a.Note != "" {
+				texts = append(texts, a.Note)
+			}
+		}
+	}
+	return tags, texts
+}
+
+func (notes Notes) lookupResult(info *search.FileInfo) ([]string, []string) {
+	return notes.lookup(info.Network, info.Channel, info.BotName)
+}
+
+func (notes Notes) lookupURL(url *xdcc.IRCFileURL) ([]string, []string) {
+	return notes.lookup(url.Network, url.Channel, url.UserName)
+}
+
+// matchTags reports whether the pack has every given tag, and none of the ones prefixed with "-".
+func matchTags(tags []string, filterTags []string) bool {
+	for _, tag := range filterTags {
+		excluded := strings.HasPrefix(tag, "-")
+		if containsFold(tags, strings.TrimPrefix(tag, "-")) == excluded {
+			return false
+		}
+	}
+	return true
+}
+
+func notesPath() (string, error) {
+	return dataFilePath(notesFileName)
+}
+
+func setupNotes() {
+	path, err := notesPath()
+	if err != nil {
+		logError("unable to locate notes file: %s", err)
+		return
+	}
+
+	if err := readJSONFile(path, &userNotes); err != nil {
+		logError("unable to load notes file: %s", err)
+	}
+}
+
+func saveNotes() {
+	path, err := notesPath()
+	if err == nil {
+		err = writeJSONFile(path, userNotes)
+	}
+
+	if err != nil {
+		logError("unable to save notes: %s", err)
+		os.Exit(1)
+	}
+}
+
+// annotate applies f to the annotation of the target, removing it if it becomes empty.
+func annotate(target string, f func(a *Annotation)) {
+	key, err := normalizeTarget(target)
+	if err != nil {
+		logError(err.Error())
+		os.Exit(1)
+	}
+
+	a, ok := userNotes[key]
+	if !ok {
+		a = &Annotation{}
+	}
+
+	f(a)
+	if a.isEmpty() {
+		delete(userNotes, key)
+	} else {
+		userNotes[key] = a
+	}
+	saveNotes()
+}
+
+func printNotes() {
+	keys := make([]string, 0, len(userNotes))
+	for key := range userNotes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	printer := NewTablePrinter([]string{"Target", "Tags", "Note"})
+	for _, key := range keys {
+		a := userNotes[key]
+		printer.AddRow(Row{key, strings.Join(a.Tags, ", "), a.Note})
+	}
+	printer.Print()
+}
+
+func printNotesUsageAndExit() {
+	fmt.Println("usage:")
+	fmt.Println("  xdcc notes list")
+	fmt.Println("  xdcc notes tag <target> <tag>...")
+	fmt.Println("  xdcc notes untag <target> <tag>...")
+	fmt.Println("  xdcc notes note <target> [text]")
+	fmt.Println("where target is a network (irc.rizon.net), a channel (irc.rizon.net/#nibl) or a bot (irc.rizon.net/SomeBot)")
+	os.Exit(1)
+}
+
+func notesCommand(args []string) {
+	if len(args) < 1 {
+		printNotesUsageAndExit()
+	}
+
+	switch args[0] {
+	case "list":
+		printNotes()
+	case "tag":
+		if len(args) < 3 {
+			printNotesUsageAndExit()
+		}
+		annotate(args[1], func(a *Annotation) {
+			for _, tag := range args[2:] {
+				if !containsFold(a.Tags, tag) {
+					a.Tags = append(a.Tags, tag)
+				}
+			}
+		})
+	case "untag":
+		if len(args) < 3 {
+			printNotesUsageAndExit()
+		}
+		annotate(args[1], func(a *Annotation) {
+			tags := make([]string, 0, len(a.Tags))
+			for _, tag := range a.Tags {
+				if !containsFold(args[2:], tag) {
+					tags = append(tags, tag)
+				}
+			}
+			a.Tags = tags
+		})
+	case "note":
+		if len(args) < 2 {
+			printNotesUsageAndExit()
+		}
+		annotate(args[1], func(a *Annotation) {
+			a.Note = strings.Join(args[2:], " ") // no text removes the note
+		})
+	default:
+		fmt.Println("no such command: ", args[0])
+		os.Exit(1)
+	}
+}
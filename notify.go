@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// windowsToastScript shows a toast notification on behalf of PowerShell, whose application id is registered.
+const windowsToastScript = `
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] | Out-Null
+$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$texts = $xml.GetElementsByTagName('text')
+$texts.Item(0).AppendChild($xml.CreateTextNode($env:XDCC_TOAST_TITLE)) | Out-Null
+$texts.Item(1).AppendChild($xml.CreateTextNode($env:XDCC_TOAST_BODY)) | Out-Null
+$appId = '{1AC14E77-02E7-4E5D-B744-2EB1AE5198B7}\WindowsPowerShell\v1.0\powershell.exe'
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier($appId).Show([Windows.UI.Notifications.ToastNotification]::new($xml))
+`
+
+// sendDesktopNotification shows a notification using the tools available on the current platform.
+func sendDesktopNotification(title string, body string) error {
+	var cmd *exec.Cmd
+
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.Command("notify-send", title, body)
+	case "darwin":
+		cmd = exec.Command("osascript", "-e", fmt.Sprintf("display notification %q with title %q", body, title))
+	case "windows":
+		// the texts are passed through the environment, so that they are not interpreted by PowerShell
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", windowsToastScript)
+		cmd.Env = append(os.Environ(), "XDCC_TOAST_TITLE="+title, "XDCC_TOAST_BODY="+body)
+	default:
+		return errors.New("desktop notifications are not supported on " + runtime.GOOS)
+	}
+	return cmd.Run()
+}
+
+const webhookTimeout = 10 * time.Second
+
+// postWebhook sends the JSON encoding of payload to the given url.
+func postWebhook(url string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: webhookTimeout}
+	res, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %s", res.Status)
+	}
+	return nil
+}
+
+// The transfer events which can be notified on the desktop.
+const (
+	notifyCompleted = "completed"
+	notifyFailed    = "failed"
+	notifyQueued    = "queued" // the position of a request in the queue of the bot changed
+	notifyBatch     = "batch"  // a batch of several files is over
+)
+
+var notifyEvents = []string{notifyCompleted, notifyFailed, notifyQueued, notifyBatch}
+
+// desktopNotifier shows the desktop notifications of the transfers of a batch, in background.
+type desktopNotifier struct {
+	events  map[string]bool
+	pending sync.WaitGroup
+	failed  sync.Once // the notifications which cannot be shown are reported once
+
+	mu        sync.Mutex
+	positions map[*batchItem]int // last notified position in the queue of the bot
+}
+
+// newDesktopNotifier returns the notifier of the given events, or of all of them if none is given.
+func newDesktopNotifier(events []string) *desktopNotifier {
+	if len(events) == 0 {
+		events = notifyEvents
+	}
+
+	n := &desktopNotifier{events: make(map[string]bool), positions: make(map[*batchItem]int)}
+	for _, event := range events {
+		n.events[strings.ToLower(event)] = true
+	}
+	return n
+}
+
+func (n *desktopNotifier) notify(event string, title string, body string) {
+	if n == nil || !n.events[event] {
+		return
+	}
+
+	n.pending.Add(1)
+	go func() {
+		defer n.pending.Done()
+		if err := sendDesktopNotification(title, body); err != nil {
+			n.failed.Do(func() { logWarn("unable to show desktop notifications: %s", err) })
+		}
+	}()
+}
+
+// notifyQueued notifies the position of the item in the queue of the bot, if it changed.
+func (n *desktopNotifier) notifyQueued(item *batchItem, source string, position int) {
+	if n == nil {
+		return
+	}
+
+	n.mu.Lock()
+	last, ok := n.positions[item]
+	n.positions[item] = position
+	n.mu.Unlock()
+	if ok && last == position {
+		return
+	}
+
+	body := source + ": queued by the bot"
+	if position > 0 {
+		body = fmt.Sprintf("%s: position %d in the queue of the bot", source, position)
+	}
+	n.notify(notifyQueued, "xdcc-cli: queued", body)
+}
+
+// Wait waits for the notifications being shown.
+func (n *desktopNotifier) Wait() {
+	if n != nil {
+		n.pending.Wait()
+	}
+}
+
+// notifyDone notifies the final state of the item.
+func (batch *Batch) notifyDone(item *batchItem) {
+	if batch.notifier == nil {
+		return
+	}
+
+	payload := batch.hookPayload(item, "")
+	name := payload.File
+	if name == "" {
+		name = payload.Source
+	}
+
+	switch batch.itemState(item) {
+	case itemStateCompleted:
+		batch.notifier.notify(notifyCompleted, "xdcc-cli: download completed",
+			fmt.Sprintf("%s (%s) from %s", name, formatSize(int64(payload.Size)), payload.Bot))
+	case itemStateFailed:
+		batch.notifier.notify(notifyFailed, "xdcc-cli: download failed", name+": "+payload.Error)
+	}
+}
+
+// notifyBatchDone notifies the outcome of a batch of several files.
+func (batch *Batch) notifyBatchDone(summary *transferSummary) {
+	if len(batch.items) < 2 {
+		return
+	}
+
+	parts := []string{fmt.Sprintf("%d completed", summary.Completed)}
+	if summary.Failed > 0 {
+		parts = append(parts, fmt.Sprintf("%d failed", summary.Failed))
+	}
+	if summary.Cancelled > 0 {
+		parts = append(parts, fmt.Sprintf("%d cancelled", summary.Cancelled))
+	}
+	batch.notifier.notify(notifyBatch, "xdcc-cli: batch finished", strings.Join(parts, ", "))
+}
+
+// setupNotifications checks the desktop notifications of the configuration.
+func setupNotifications() {
+	for _, event := range config.Notifications.Events {
+		if !containsFold(notifyEvents, event) {
+			logError("invalid notifications event %q, expected one of: %s", event, strings.Join(notifyEvents, ", "))
+			os.Exit(1)
+		}
+	}
+}
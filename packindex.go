@@ -0,0 +1,558 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ostafen/xdcc-cli/pkg/search"
+)
+
+const (
+	packIndexFileName = "packs.ndjson"
+	packTermsFileName = "packs.terms"
+	maxIndexedPacks   = 100000
+	// the index is compacted when it holds this many lines more than packs
+	packIndexSlack = 5000
+	// size of the sightings appended since the last compaction past which the file is loaded by Add to be
+	// compacted, so that the searches read few of them, the packs taking a few hundred bytes each
+	packIndexMaxTail = 4 << 20
+)
+
+// localProviderName is the name of the provider searching the pack index, with search --local.
+const localProviderName = "local"
+
+// indexedPack is a pack returned by some search, with its availability over time.
+type indexedPack struct {
+	search.FileInfo
+	Provider  string    `json:"provider"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Seen      int       `json:"seen"` // number of searches which returned the pack
+}
+
+// merge adds the sightings of other to the pack, keeping the most recent details.
+func (pack *indexedPack) merge(other *indexedPack) {
+	if other.LastSeen.After(pack.LastSeen) {
+		pack.FileInfo, pack.Provider, pack.LastSeen = other.FileInfo, other.Provider, other.LastSeen
+	}
+	if other.FirstSeen.Before(pack.FirstSeen) {
+		pack.FirstSeen = other.FirstSeen
+	}
+	pack.Seen += other.Seen
+}
+
+// PackIndex records the packs returned by the searches, whether they come from the packlists
+// of the search engines or from announce channels, so that they can be searched offline.
+// The packs are appended to a file as they are seen, one JSON object per line, and the
+// sightings of the same pack are merged when the file is loaded. The file is compacted once it
+// holds too many sightings, or once too many were appended when it is not loaded.
+//
+// Compacting the file writes the term index next to it: the words of the names of the packs,
+// each with the lines of the packs having it. Searches read the lines of the packs having the
+// keywords from there, and only scan the sightings appended since.
+type PackIndex struct {
+	mu        sync.Mutex
+	path      string
+	termsPath string
+	packs     map[string]*indexedPack // loaded on demand
+	lines     int                     // in the file, once loaded
+	found     map[string]*indexedPack // returned by the last search
+}
+
+func NewPackIndex(path string) *PackIndex {
+	return &PackIndex{path: path, termsPath: filepath.Join(filepath.Dir(path), packTermsFileName)}
+}
+
+// packTermsHeader is the first line of the term index, telling the part of the file it indexes.
+type packTermsHeader struct {
+	Size  int64  `json:"size"`  // of the file once compacted, which then grows by appending
+	CRC32 uint32 `json:"crc32"` // of these bytes, which change once the file is compacted again
+}
+
+// packTerms is the term index, following its header.
+type packTerms struct {
+	Offsets []int64          `json:"offsets"` // of the line of each pack
+	Terms   map[string][]int `json:"terms"`   // words of the names, with the packs having them in ascending order
+}
+
+// nameTerms returns the words of a file name, as they are indexed.
+func nameTerms(name string) []string {
+	seen := make(map[string]bool)
+	terms := make([]string, 0)
+	for _, term := range strings.Fields(normalizeName(name)) {
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// candidates returns the packs having a term containing each of the words, in ascending order.
+func (terms *packTerms) candidates(words []string) []int {
+	var packs map[int]bool // nil until the first word
+	for _, word := range words {
+		withWord := make(map[int]bool)
+		for term, postings := range terms.Terms {
+			if !strings.Contains(term, word) {
+				continue
+			}
+			for _, i := range postings {
+				if packs == nil || packs[i] {
+					withWord[i] = true
+				}
+			}
+		}
+		packs = withWord
+	}
+
+	candidates := make([]int, 0, len(packs))
+	if packs == nil {
+		for i := range terms.Offsets {
+			candidates = append(candidates, i)
+		}
+		return candidates
+	}
+	for i := range packs {
+		if i >= 0 && i < len(terms.Offsets) {
+			candidates = append(candidates, i)
+		}
+	}
+	sort.Ints(candidates)
+	return candidates
+}
+
+var packIndex *PackIndex
+
+func setupPackIndex() {
+	path, err := dataFilePath(packIndexFileName)
+	if err != nil {
+		logWarn("unable to locate the pack index: %s", err)
+		return
+	}
+
+	packIndex = NewPackIndex(path)
+	if !config.DisablePackIndex {
+		registry.SetResultObserver(packIndex.Add)
+	}
+}
+
+// Add records the results of a search of the provider.
+func (index *PackIndex) Add(provider string, results []search.FileInfo) {
+	now := time.Now()
+
+	var buf strings.Builder
+	for i := range results {
+		line, err := json.Marshal(&indexedPack{FileInfo: results[i], Provider: provider, FirstSeen: now, LastSeen: now, Seen: 1})
+		if err != nil {
+			continue
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	size := int64(0)
+	f, err := os.OpenFile(index.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err == nil {
+		_, err = f.WriteString(buf.String())
+		if info, statErr := f.Stat(); statErr == nil {
+			size = info.Size()
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	if err != nil {
+		logWarn("unable to update the pack index: %s", err)
+		return
+	}
+
+	if index.packs != nil {
+		for i := range results {
+			index.addLocked(&indexedPack{FileInfo: results[i], Provider: provider, FirstSeen: now, LastSeen: now, Seen: 1})
+		}
+		index.lines += len(results)
+	}
+
+	// the file is reloaded before being compacted, with the sightings added by the other processes
+	if index.packs != nil && index.needsCompactionLocked() || index.packs == nil && size-index.indexedSize() > packIndexMaxTail {
+		index.packs = nil
+		if err := index.loadLocked(); err != nil {
+			logWarn("unable to compact the pack index: %s", err)
+		}
+	}
+}
+
+func (index *PackIndex) addLocked(pack *indexedPack) {
+	key := packKey(&pack.FileInfo)
+	if existing, ok := index.packs[key]; ok {
+		existing.merge(pack)
+	} else {
+		index.packs[key] = pack
+	}
+}
+
+// loadLocked reads the index, compacting the file if it holds too many sightings of the same packs.
+func (index *PackIndex) loadLocked() error {
+	if index.packs != nil {
+		return nil
+	}
+
+	f, err := os.Open(index.path)
+	if os.IsNotExist(err) {
+		index.packs = make(map[string]*indexedPack)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	packs := make(map[string]*indexedPack)
+	index.packs = packs
+
+	lines := 0
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var pack indexedPack
+		if err := json.Unmarshal(scanner.Bytes(), &pack); err != nil {
+			continue // e.g. a line cut by a crash
+		}
+		lines++
+		index.addLocked(&pack)
+	}
+
+	if err := scanner.Err(); err != nil {
+		index.packs = nil
+		return err
+	}
+
+	index.lines = lines
+	if index.needsCompactionLocked() {
+		if err := index.compactLocked(); err != nil {
+			logWarn("unable to compact the pack index: %s", err)
+		}
+	}
+	return nil
+}
+
+// needsCompactionLocked tells whether the loaded index holds too many sightings of the same packs, or too many packs.
+func (index *PackIndex) needsCompactionLocked() bool {
+	return index.lines > len(index.packs)+packIndexSlack || len(index.packs) > maxIndexedPacks
+}
+
+// compactLocked rewrites the index with a line per pack, dropping the packs not seen for the longest time.
+func (index *PackIndex) compactLocked() error {
+	packs := make([]*indexedPack, 0, len(index.packs))
+	for _, pack := range index.packs {
+		packs = append(packs, pack)
+	}
+
+	sort.Slice(packs, func(i, j int) bool {
+		return packs[i].LastSeen.After(packs[j].LastSeen)
+	})
+
+	if len(packs) > maxIndexedPacks {
+		for _, pack := range packs[maxIndexedPacks:] {
+			delete(index.packs, packKey(&pack.FileInfo))
+		}
+		packs = packs[:maxIndexedPacks]
+	}
+
+	tmpPath := index.path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	header := packTermsHeader{}
+	terms := packTerms{Offsets: make([]int64, 0, len(packs)), Terms: make(map[string][]int)}
+	crc := crc32.NewIEEE()
+	w := bufio.NewWriter(io.MultiWriter(f, crc))
+	for i, pack := range packs {
+		var line []byte
+		if line, err = json.Marshal(pack); err != nil {
+			break
+		}
+		w.Write(append(line, '\n'))
+
+		terms.Offsets = append(terms.Offsets, header.Size)
+		header.Size += int64(len(line)) + 1
+		for _, term := range nameTerms(pack.Name) {
+			terms.Terms[term] = append(terms.Terms[term], i)
+		}
+	}
+
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, index.path); err != nil {
+		return err
+	}
+	index.lines = len(packs)
+
+	header.CRC32 = crc.Sum32()
+	return index.writeTerms(&header, &terms)
+}
+
+// writeTerms replaces the term index. Until then, the searches find the file compacted
+// again by its checksum, and scan it whole.
+func (index *PackIndex) writeTerms(header *packTermsHeader, terms *packTerms) error {
+	tmpPath := index.termsPath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	if err = enc.Encode(header); err == nil {
+		err = enc.Encode(terms)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, index.termsPath)
+}
+
+// readTermsHeader returns the header of the term index, or nil if there is none.
+func (index *PackIndex) readTermsHeader(r *bufio.Reader) *packTermsHeader {
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return nil
+	}
+
+	var header packTermsHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return nil
+	}
+	return &header
+}
+
+// indexedSize returns the size of the part of the file covered by the term index, if any.
+func (index *PackIndex) indexedSize() int64 {
+	f, err := os.Open(index.termsPath)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	if header := index.readTermsHeader(bufio.NewReader(f)); header != nil {
+		return header.Size
+	}
+	return 0
+}
+
+// readTerms returns the term index of the file, or nil if there is none or it no longer matches the file.
+func (index *PackIndex) readTerms(f *os.File) (*packTermsHeader, *packTerms) {
+	termsFile, err := os.Open(index.termsPath)
+	if err != nil {
+		return nil, nil
+	}
+	defer termsFile.Close()
+
+	r := bufio.NewReader(termsFile)
+	header := index.readTermsHeader(r)
+	if header == nil {
+		return nil, nil
+	}
+
+	crc := crc32.NewIEEE()
+	if n, err := io.Copy(crc, io.NewSectionReader(f, 0, header.Size)); err != nil || n != header.Size || crc.Sum32() != header.CRC32 {
+		return nil, nil // compacted since
+	}
+
+	var terms packTerms
+	if err := json.NewDecoder(r).Decode(&terms); err != nil {
+		return nil, nil
+	}
+	return header, &terms
+}
+
+// readIndexedPack reads the i-th pack of the part of the file covered by the term index.
+func readIndexedPack(f *os.File, header *packTermsHeader, terms *packTerms, i int) (*indexedPack, error) {
+	end := header.Size
+	if i+1 < len(terms.Offsets) {
+		end = terms.Offsets[i+1]
+	}
+
+	start := terms.Offsets[i]
+	if start < 0 || end < start {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	line := make([]byte, end-start)
+	if _, err := f.ReadAt(line, start); err != nil {
+		return nil, err
+	}
+
+	var pack indexedPack
+	if err := json.Unmarshal(bytes.TrimSpace(line), &pack); err != nil {
+		return nil, err
+	}
+	return &pack, nil
+}
+
+// lookup returns what the index knows about the pack, if it has been loaded.
+func (index *PackIndex) lookup(info *search.FileInfo) *indexedPack {
+	if index == nil {
+		return nil
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	key := packKey(info)
+	if pack, ok := index.packs[key]; ok {
+		return pack
+	}
+	return index.found[key]
+}
+
+func (index *PackIndex) Name() string {
+	return localProviderName
+}
+
+// Search returns the indexed packs whose name contains every keyword. The packs of the part of the
+// file covered by the term index are looked up from their words, the sightings appended since are scanned.
+func (index *PackIndex) Search(ctx context.Context, keywords []string) ([]search.FileInfo, error) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+
+	phrases := make([]string, 0, len(keywords))
+	words := make([]string, 0, len(keywords))
+	for _, keyword := range keywords {
+		phrase := strings.TrimSpace(normalizeName(keyword))
+		phrases = append(phrases, phrase)
+		words = append(words, strings.Fields(phrase)...)
+	}
+
+	matches := func(pack *indexedPack) bool {
+		name := normalizeName(pack.Name)
+		for _, phrase := range phrases {
+			if !strings.Contains(name, phrase) {
+				return false
+			}
+		}
+		return true
+	}
+
+	f, err := os.Open(index.path)
+	if os.IsNotExist(err) {
+		return []search.FileInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	found := make(map[string]*indexedPack)
+	keys := make([]string, 0)
+	addFound := func(pack *indexedPack) {
+		key := packKey(&pack.FileInfo)
+		if existing, ok := found[key]; ok {
+			existing.merge(pack)
+			return
+		}
+		found[key] = pack
+		keys = append(keys, key)
+	}
+
+	tailStart := int64(0)
+	if header, terms := index.readTerms(f); header != nil {
+		tailStart = header.Size
+		for _, i := range terms.candidates(words) {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			pack, err := readIndexedPack(f, header, terms, i)
+			if err != nil {
+				return nil, err
+			}
+			if matches(pack) {
+				addFound(pack)
+			}
+		}
+	}
+
+	// the sightings appended since the compaction
+	if _, err := f.Seek(tailStart, io.SeekStart); err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var pack indexedPack
+		if err := json.Unmarshal(scanner.Bytes(), &pack); err != nil {
+			continue // e.g. a line cut by a crash
+		}
+		if _, ok := found[packKey(&pack.FileInfo)]; ok || matches(&pack) {
+			addFound(&pack)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	index.found = make(map[string]*indexedPack, len(found))
+	results := make([]search.FileInfo, 0, len(found))
+	for _, key := range keys {
+		if pack := found[key]; matches(pack) { // renamed by a later sighting
+			index.found[key] = pack
+			results = append(results, pack.FileInfo)
+		}
+	}
+	return results, ctx.Err()
+}
+
+// localSearchRegistry returns the registry searching the pack index instead of the search engines.
+func localSearchRegistry() *search.Registry {
+	local := search.NewRegistry()
+	local.AddProvider(packIndex)
+	local.SetNetworkFilter(config.Networks.Allows)
+	if !config.Bots.marksResults() {
+		local.SetResultFilter(config.Bots.AllowsResult)
+	}
+	return local
+}
+
+// formatSeen returns the time the pack was last seen, or an empty string if unknown.
+func formatSeen(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"io"
 	"time"
 
 	"github.com/vbauerster/mpb/v7"
@@ -81,6 +82,15 @@ func init() {
 	)
 }
 
+// setProgressOutput redirects the rendering of the progress bars created afterwards.
+func setProgressOutput(w io.Writer) {
+	progress = mpb.New(
+		mpb.WithWidth(barWidthDefault),
+		mpb.WithRefreshRate(barRefreshRateDefault),
+		mpb.WithOutput(w),
+	)
+}
+
 func newProgressBarImpl() *progressBarImpl {
 	bar := createMpbBar(progress, 0, "", ProgressStateConnecting, nil)
 	return &progressBarImpl{
@@ -0,0 +1,149 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	irc "github.com/fluffle/goirc/client"
+	"github.com/ostafen/xdcc-cli/pkg/xdcc"
+)
+
+// AnnounceChannel is an IRC channel where bots announce the packs they offer.
+type AnnounceChannel struct {
+	Network              string
+	Channel              string
+	Window               time.Duration // time spent collecting announcements
+	NoSSL                bool
+	SkipCertificateCheck bool
+}
+
+const defaultAnnounceWindow = 30 * time.Second
+
+// AnnounceProvider serves the pack announcements collected on an IRC channel as search results.
+type AnnounceProvider struct {
+	channel AnnounceChannel
+	locale  NumberLocale
+}
+
+func NewAnnounceProvider(channel AnnounceChannel) *AnnounceProvider {
+	if channel.Window <= 0 {
+		channel.Window = defaultAnnounceWindow
+	}
+
+	if !strings.HasPrefix(channel.Channel, "#") {
+		channel.Channel = "#" + channel.Channel
+	}
+	return &AnnounceProvider{channel: channel}
+}
+
+func (p *AnnounceProvider) Name() string {
+	return "announce:" + p.channel.Network + "/" + p.channel.Channel
+}
+
+func (p *AnnounceProvider) SetNumberLocale(locale NumberLocale) {
+	p.locale = locale
+}
+
+var ircFormattingRegexp = regexp.MustCompile("\x03[0-9]{0,2}(,[0-9]{1,2})?|[\x02\x0f\x16\x1d\x1f]")
+
+func stripIRCFormatting(text string) string {
+	return ircFormattingRegexp.ReplaceAllString(text, "")
+}
+
+var (
+	// e.g. "[ADDED] #123 [1.4G] Some.File.mkv" or "#123 1.4G Some.File.mkv"
+	announcePackRegexp = regexp.MustCompile(`#(\d+)\s*\[?\s*([\d.,]+\s*[KMGT]i?B?)\s*\]?\s+(\S+)`)
+	announceMsgRegexp  = regexp.MustCompile(`(?i)/msg\s+(\S+)\s+xdcc\s+send\s+#?(\d+)`)
+)
+
+// parseAnnouncement extracts the pack details from an announcement sent by bot.
+func (p *AnnounceProvider) parseAnnouncement(bot string, text string) (*FileInfo, error) {
+	text = stripIRCFormatting(text)
+
+	match := announcePackRegexp.FindStringSubmatch(text)
+	if match == nil {
+		return nil, errors.New("not a pack announcement")
+	}
+
+	fInfo := &FileInfo{
+		Network: p.channel.Network,
+		Channel: p.channel.Channel,
+		BotName: bot,
+		Slot:    "#" + match[1],
+		Name:    match[3],
+	}
+	fInfo.Size = parseResultSize(p.Name(), match[2], p.locale)
+	now := time.Now()
+	fInfo.LastAnnounced = &now
+
+	if msg := announceMsgRegexp.FindStringSubmatch(text); msg != nil {
+		fInfo.BotName = msg[1]
+		fInfo.Slot = "#" + msg[2]
+	}
+
+	fInfo.Url = "irc://" + fInfo.Network + "/" + strings.TrimPrefix(fInfo.Channel, "#") + "/" + fInfo.BotName + "/" + fInfo.Slot
+	fInfo.Command = "/msg " + fInfo.BotName + " xdcc send " + fInfo.Slot
+	return fInfo, nil
+}
+
+func matchKeywords(name string, keywords []string) bool {
+	name = strings.ToLower(name)
+	for _, keyword := range keywords {
+		if !strings.Contains(name, strings.ToLower(keyword)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Search joins the announce channel and returns the matching packs announced within the window.
+func (p *AnnounceProvider) Search(ctx context.Context, keywords []string) ([]FileInfo, error) {
+	conn := irc.Client(xdcc.NewIRCConfig(p.channel.Network, !p.channel.NoSSL, p.channel.SkipCertificateCheck))
+
+	mu := sync.Mutex{}
+	seen := make(map[string]bool)
+	fileInfos := make([]FileInfo, 0)
+
+	xdcc.HandleCTCPRequests(conn, p.channel.Network)
+	xdcc.HandleIdentity(conn, p.channel.Network, func(conn *irc.Conn) {
+		Logger(LogIRC, "%s: connected, joining %s", p.channel.Network, p.channel.Channel)
+		conn.Join(p.channel.Channel)
+	})
+
+	conn.HandleFunc(irc.PRIVMSG, func(conn *irc.Conn, line *irc.Line) {
+		if !strings.EqualFold(line.Args[0], p.channel.Channel) {
+			return
+		}
+
+		info, err := p.parseAnnouncement(line.Nick, line.Text())
+		if err != nil || !matchKeywords(info.Name, keywords) {
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if key := info.Url; !seen[key] {
+			seen[key] = true
+			fileInfos = append(fileInfos, *info)
+		}
+	})
+
+	if err := conn.Connect(); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-time.After(p.channel.Window):
+	case <-ctx.Done():
+	}
+	conn.Quit()
+
+	mu.Lock()
+	defer mu.Unlock()
+	return fileInfos, nil
+}
@@ -0,0 +1,12 @@
+package search
+
+// LogLevel classifies the messages logged by the package.
+type LogLevel int
+
+const (
+	LogProviders LogLevel = iota
+	LogIRC
+)
+
+// Logger receives the messages logged by the package. Messages are discarded by default.
+var Logger = func(level LogLevel, format string, args ...interface{}) {}
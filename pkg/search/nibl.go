@@ -0,0 +1,138 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	NiblSearchURL = "https://api.nibl.co.uk/nibl/search"
+	NiblBotsURL   = "https://api.nibl.co.uk/nibl/bots"
+
+	niblNetwork = "irc.rizon.net"
+	niblChannel = "#nibl"
+)
+
+// NiblProvider searches the packlists indexed by nibl.co.uk, focused on anime.
+type NiblProvider struct {
+	locale   NumberLocale
+	throttle throttle
+	requests requester
+}
+
+func (p *NiblProvider) Name() string {
+	return "nibl.co.uk"
+}
+
+func (p *NiblProvider) SetNumberLocale(locale NumberLocale) {
+	p.locale = locale
+}
+
+func (p *NiblProvider) SetMinRequestInterval(interval time.Duration) {
+	p.throttle.setInterval(interval)
+}
+
+func (p *NiblProvider) SetRequestOptions(opts RequestOptions) {
+	p.requests.setOptions(opts)
+}
+
+type niblPack struct {
+	BotID        int    `json:"botId"`
+	Number       int    `json:"number"`
+	Name         string `json:"name"`
+	Size         string `json:"size"`
+	LastModified string `json:"lastModified"` // e.g. "2021-03-05 12:34:56", in UTC
+}
+
+const niblTimeLayout = "2006-01-02 15:04:05"
+
+type niblBot struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type niblSearchResponse struct {
+	Status  string     `json:"status"`
+	Message string     `json:"message"`
+	Content []niblPack `json:"content"`
+}
+
+type niblBotsResponse struct {
+	Status  string    `json:"status"`
+	Message string    `json:"message"`
+	Content []niblBot `json:"content"`
+}
+
+func (p *NiblProvider) get(ctx context.Context, reqURL string, v interface{}) error {
+	res, err := p.throttle.get(ctx, &p.requests, p.Name(), reqURL)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// fetchBotNames maps the bot ids used by the search endpoint to the bot nicknames.
+func (p *NiblProvider) fetchBotNames(ctx context.Context) (map[int]string, error) {
+	var res niblBotsResponse
+	if err := p.get(ctx, NiblBotsURL, &res); err != nil {
+		return nil, err
+	}
+
+	names := make(map[int]string, len(res.Content))
+	for _, bot := range res.Content {
+		names[bot.ID] = bot.Name
+	}
+	return names, nil
+}
+
+func (p *NiblProvider) Search(ctx context.Context, keywords []string) ([]FileInfo, error) {
+	query := strings.Join(strings.Fields(strings.Join(keywords, " ")), " ")
+
+	var res niblSearchResponse
+	if err := p.get(ctx, NiblSearchURL+"?query="+url.QueryEscape(query), &res); err != nil {
+		return nil, err
+	}
+
+	if res.Status != "OK" {
+		return nil, fmt.Errorf("search failed: %s", res.Message)
+	}
+
+	botNames, err := p.fetchBotNames(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfos := make([]FileInfo, 0, len(res.Content))
+	for _, pack := range res.Content {
+		botName, ok := botNames[pack.BotID]
+		if !ok {
+			continue
+		}
+
+		fInfo := FileInfo{
+			Network: niblNetwork,
+			Channel: niblChannel,
+			BotName: botName,
+			Name:    pack.Name,
+			Slot:    "#" + strconv.Itoa(pack.Number),
+		}
+		fInfo.Size = parseResultSize(p.Name(), pack.Size, p.locale)
+		if added, err := time.Parse(niblTimeLayout, pack.LastModified); err == nil {
+			fInfo.Added = &added
+		}
+		fInfo.Url = "irc://" + niblNetwork + "/" + strings.TrimPrefix(niblChannel, "#") + "/" + botName + "/" + fInfo.Slot
+		fInfo.Command = "/msg " + botName + " xdcc send " + fInfo.Slot
+		fileInfos = append(fileInfos, fInfo)
+	}
+	return fileInfos, nil
+}
@@ -0,0 +1,167 @@
+package search
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// PluginProvider is a provider implemented by an external program. The program receives the keywords
+// on its standard input, on a single line separated by spaces, and writes the results to its standard
+// output as a JSON array of objects with the fields of FileInfo, or as one such object per line.
+// Sizes can be given either in bytes or as strings such as "700M", and slots as numbers. Its standard error is logged.
+type PluginProvider struct {
+	name    string
+	command []string
+	locale  NumberLocale
+}
+
+func NewPluginProvider(name string, command []string) (*PluginProvider, error) {
+	if name == "" {
+		return nil, errors.New("plugin without name")
+	}
+
+	if len(command) == 0 || command[0] == "" {
+		return nil, errors.New("plugin " + name + " without command")
+	}
+	return &PluginProvider{name: name, command: command}, nil
+}
+
+func (p *PluginProvider) Name() string {
+	return p.name
+}
+
+func (p *PluginProvider) SetNumberLocale(locale NumberLocale) {
+	p.locale = locale
+}
+
+// pluginResult is a result written by a plugin, whose size can also be a string and slot a number.
+type pluginResult struct {
+	FileInfo
+	Size json.RawMessage `json:"size"`
+	Slot json.RawMessage `json:"slot"`
+}
+
+func (p *PluginProvider) toFileInfo(res *pluginResult) FileInfo {
+	fInfo := res.FileInfo
+	fInfo.Size = -1
+
+	if size := string(res.Size); size != "" && size != "null" {
+		if n, err := strconv.ParseInt(size, 10, 64); err == nil {
+			fInfo.Size = n
+		} else if s, err := strconv.Unquote(size); err == nil {
+			fInfo.Size = parseResultSize(p.name, s, p.locale)
+		} else {
+			Logger(LogProviders, "%s: unable to parse size %s", p.name, size)
+		}
+	}
+
+	if slot := string(res.Slot); slot != "" && slot != "null" {
+		if s, err := strconv.Unquote(slot); err == nil {
+			fInfo.Slot = s
+		} else {
+			fInfo.Slot = slot
+		}
+	}
+
+	if fInfo.Slot != "" && !strings.HasPrefix(fInfo.Slot, "#") {
+		fInfo.Slot = "#" + fInfo.Slot
+	}
+
+	if fInfo.Url == "" {
+		fInfo.Url = "irc://" + fInfo.Network + "/" + strings.TrimPrefix(fInfo.Channel, "#") + "/" + fInfo.BotName + "/" + fInfo.Slot
+	}
+	if fInfo.Command == "" {
+		fInfo.Command = "/msg " + fInfo.BotName + " xdcc send " + fInfo.Slot
+	}
+	return fInfo
+}
+
+// decodeResults decodes a JSON array of results, or a sequence of results.
+func (p *PluginProvider) decodeResults(r io.Reader) ([]FileInfo, error) {
+	fileInfos := make([]FileInfo, 0)
+
+	dec := json.NewDecoder(r)
+	for {
+		var value json.RawMessage
+		if err := dec.Decode(&value); err == io.EOF {
+			return fileInfos, nil
+		} else if err != nil {
+			return fileInfos, errors.New("invalid output: " + err.Error())
+		}
+
+		results := make([]pluginResult, 0)
+		if bytes.HasPrefix(bytes.TrimSpace(value), []byte("[")) {
+			if err := json.Unmarshal(value, &results); err != nil {
+				return fileInfos, errors.New("invalid output: " + err.Error())
+			}
+		} else {
+			var res pluginResult
+			if err := json.Unmarshal(value, &res); err != nil {
+				return fileInfos, errors.New("invalid output: " + err.Error())
+			}
+			results = append(results, res)
+		}
+
+		for i := range results {
+			fInfo := p.toFileInfo(&results[i])
+			if fInfo.Network == "" || fInfo.Channel == "" || fInfo.BotName == "" || fInfo.Slot == "" || fInfo.Name == "" {
+				Logger(LogProviders, "%s: skipping incomplete result %q", p.name, fInfo.Name)
+				continue
+			}
+			fileInfos = append(fileInfos, fInfo)
+		}
+	}
+}
+
+func (p *PluginProvider) Search(ctx context.Context, keywords []string) ([]FileInfo, error) {
+	cmd := exec.CommandContext(ctx, p.command[0], p.command[1:]...)
+	cmd.Stdin = strings.NewReader(strings.Join(keywords, " ") + "\n")
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	lastError := make(chan string, 1)
+	go func() {
+		last := ""
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			last = scanner.Text()
+			Logger(LogProviders, "%s: %s", p.name, last)
+		}
+		lastError <- last
+	}()
+
+	fileInfos, decodeErr := p.decodeResults(stdout)
+	io.Copy(ioutil.Discard, stdout) // lets the program exit after invalid output
+
+	msg := <-lastError
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if msg != "" {
+			return nil, errors.New(err.Error() + ": " + msg)
+		}
+		return nil, err
+	}
+	return fileInfos, decodeErr
+}
@@ -0,0 +1,97 @@
+package search
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ostafen/xdcc-cli/internal/testbot"
+)
+
+// packSummary is the part of a result checked against the fixtures.
+type packSummary struct {
+	Network, Channel, BotName, Slot, Name string
+	Size                                  int64
+	Gets                                  int
+	Url                                   string
+}
+
+func summarize(results []FileInfo) []packSummary {
+	summaries := make([]packSummary, 0, len(results))
+	for _, r := range results {
+		summaries = append(summaries, packSummary{r.Network, r.Channel, r.BotName, r.Slot, r.Name, r.Size, r.Gets, r.Url})
+	}
+	return summaries
+}
+
+func checkResults(t *testing.T, results []FileInfo, expected []packSummary) {
+	t.Helper()
+
+	got := summarize(results)
+	if len(got) != len(expected) {
+		t.Fatalf("got %d results, expected %d: %+v", len(got), len(expected), got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("result %d:\n got %+v\nwant %+v", i, got[i], expected[i])
+		}
+	}
+}
+
+func searchFixtures(t *testing.T, p Provider) []FileInfo {
+	t.Helper()
+
+	if hp, ok := p.(HTTPProvider); ok {
+		hp.SetRequestOptions(RequestOptions{Transport: testbot.ProviderFixtures()})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	results, err := p.Search(ctx, []string{"some", "show"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return results
+}
+
+func TestNiblFixtures(t *testing.T) {
+	results := searchFixtures(t, &NiblProvider{})
+
+	checkResults(t, results, []packSummary{
+		{"irc.rizon.net", "#nibl", "SubBot", "#12", "[SubGroup] Some Show - 01 [1080p].mkv", 1503238553, 0, "irc://irc.rizon.net/nibl/SubBot/#12"},
+		{"irc.rizon.net", "#nibl", "SubBot", "#13", "[SubGroup] Some Show - 02 [1080p].mkv", 1395864371, 0, "irc://irc.rizon.net/nibl/SubBot/#13"},
+		{"irc.rizon.net", "#nibl", "Other|Bot", "#405", "[OtherGroup] Some Show - 01 (720p) [ABCD1234].mkv", 736100352, 0, "irc://irc.rizon.net/nibl/Other|Bot/#405"},
+		{"irc.rizon.net", "#nibl", "Mirror-Bot", "#7", "ubuntu-20.04-desktop-amd64.iso", 2684354560, 0, "irc://irc.rizon.net/nibl/Mirror-Bot/#7"},
+	})
+
+	added := time.Date(2021, 3, 5, 12, 34, 56, 0, time.UTC)
+	if results[0].Added == nil || !results[0].Added.Equal(added) {
+		t.Errorf("added %v, expected %v", results[0].Added, added)
+	}
+}
+
+func TestXdccEuFixtures(t *testing.T) {
+	results := searchFixtures(t, &XdccEuProvider{})
+
+	checkResults(t, results, []packSummary{
+		{"irc.rizon.net", "#ubuntu", "Mirror-Bot", "#7", "ubuntu-20.04-desktop-amd64.iso", 2684354560, 1234, "http://irc.rizon.net/#ubuntu"},
+		{"irc.abjects.net", "#moviegods", "[MG]-Bot|ISO", "#1042", "ubuntu-22.04-live-server-amd64.iso", 1181116006, 87, "http://irc.abjects.net/#moviegods"},
+		{"irc.scenep2p.net", "#THE.SOURCE", "Source|Bot", "#3", "xubuntu-20.04-desktop-amd64.iso", 996147200, 0, "http://irc.scenep2p.net/#THE.SOURCE"},
+	})
+}
+
+func TestPluginFixtures(t *testing.T) {
+	path := filepath.Join(testbot.ProviderFixtures().Dir, "plugin", "results.json")
+	p, err := NewPluginProvider("plugin", []string{"cat", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := searchFixtures(t, p)
+
+	checkResults(t, results, []packSummary{
+		{"irc.rizon.net", "#nibl", "SubBot", "#12", "[SubGroup] Some Show - 01 [1080p].mkv", 1503238553, 321, "irc://irc.rizon.net/nibl/SubBot/#12"},
+		{"irc.rizon.net", "#ubuntu", "Mirror-Bot", "#7", "ubuntu-20.04-desktop-amd64.iso", 2684354560, 0, "irc://irc.rizon.net/ubuntu/Mirror-Bot/#7"},
+	})
+}
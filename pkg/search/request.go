@@ -0,0 +1,117 @@
+package search
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// RequestOptions are the HTTP settings of the requests of a provider, for the indexes refusing the
+// default user agent of Go or requiring a session.
+type RequestOptions struct {
+	UserAgent      string
+	AcceptLanguage string
+	Headers        map[string]string
+	Jar            http.CookieJar    // keeps the cookies set by the index, if not nil
+	SessionURL     string            // page requested before the first search, to get the cookies of a session
+	Transport      http.RoundTripper // sends the requests, http.DefaultTransport if nil
+}
+
+// HTTPProvider is implemented by the providers whose HTTP requests can be configured.
+type HTTPProvider interface {
+	SetRequestOptions(opts RequestOptions)
+}
+
+// SetProviderRequestOptions sets the HTTP settings of the named provider, returning false if
+// there is no such provider or if its requests cannot be configured.
+func (registry *Registry) SetProviderRequestOptions(provider string, opts RequestOptions) bool {
+	for _, p := range registry.providerList {
+		if hp, ok := p.(HTTPProvider); ok && p.Name() == provider {
+			hp.SetRequestOptions(opts)
+			return true
+		}
+	}
+	return false
+}
+
+// requester sends the requests of a provider according to its RequestOptions.
+type requester struct {
+	mu      sync.Mutex
+	opts    RequestOptions
+	client  *http.Client
+	session sync.Once
+}
+
+func (r *requester) setOptions(opts RequestOptions) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.opts = opts
+	r.client = nil
+	if opts.Jar != nil || opts.Transport != nil {
+		r.client = &http.Client{Jar: opts.Jar, Transport: opts.Transport}
+	}
+}
+
+func (r *requester) options() (RequestOptions, *http.Client) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.client == nil {
+		return r.opts, http.DefaultClient
+	}
+	return r.opts, r.client
+}
+
+// newRequest returns a GET request of url with the headers of the provider.
+func (r *requester) newRequest(ctx context.Context, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	opts, _ := r.options()
+	if opts.UserAgent != "" {
+		req.Header.Set("User-Agent", opts.UserAgent)
+	}
+	if opts.AcceptLanguage != "" {
+		req.Header.Set("Accept-Language", opts.AcceptLanguage)
+	}
+	for name, value := range opts.Headers {
+		req.Header.Set(name, value)
+	}
+	return req, nil
+}
+
+// startSession requests the SessionURL of the provider once, unless the cookies of a session are kept already.
+func (r *requester) startSession(ctx context.Context, provider string) {
+	opts, client := r.options()
+	if opts.SessionURL == "" || opts.Jar == nil {
+		return
+	}
+
+	r.session.Do(func() {
+		req, err := r.newRequest(ctx, opts.SessionURL)
+		if err != nil {
+			Logger(LogProviders, "%s: invalid session URL: %s", provider, err)
+			return
+		}
+		if len(opts.Jar.Cookies(req.URL)) > 0 {
+			return
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			Logger(LogProviders, "%s: unable to start a session: %s", provider, err)
+			return
+		}
+		res.Body.Close()
+		Logger(LogProviders, "%s: session started (%s)", provider, res.Status)
+	})
+}
+
+// do sends the request with the client of the provider.
+func (r *requester) do(req *http.Request) (*http.Response, error) {
+	_, client := r.options()
+	return client.Do(req)
+}
@@ -0,0 +1,315 @@
+// Package search finds the packs offered by XDCC bots through search engines and IRC announce channels.
+package search
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/ostafen/xdcc-cli/internal/group"
+	"github.com/ostafen/xdcc-cli/pkg/xdcc"
+)
+
+// FileInfo describes a pack found by a provider.
+type FileInfo struct {
+	Network string `json:"network"`
+	Channel string `json:"channel"`
+	BotName string `json:"bot"`
+	Name    string `json:"name"`
+	Gets    int    `json:"gets"`
+	Url     string `json:"url"`
+	Command string `json:"command"`
+	Size    int64  `json:"size"`
+	Slot    string `json:"slot"`
+	Hash    string `json:"hash,omitempty"`
+
+	// optional metadata, set when the provider supplies it
+	Added         *time.Time `json:"added,omitempty"`         // when the pack was added to the packlist
+	LastAnnounced *time.Time `json:"lastAnnounced,omitempty"` // when the bot last announced the pack
+}
+
+// Freshness returns the last time the pack was added or announced, or the zero time if unknown.
+func (info *FileInfo) Freshness() time.Time {
+	var t time.Time
+	if info.Added != nil {
+		t = *info.Added
+	}
+	if info.LastAnnounced != nil && info.LastAnnounced.After(t) {
+		t = *info.LastAnnounced
+	}
+	return t
+}
+
+// IRCFileURL returns the url identifying the file on the IRC network.
+func (info *FileInfo) IRCFileURL() (*xdcc.IRCFileURL, error) {
+	slot, err := xdcc.ParseSlot(info.Slot)
+	if err != nil {
+		return nil, err
+	}
+
+	url := &xdcc.IRCFileURL{
+		Network:  info.Network,
+		Channel:  info.Channel,
+		UserName: info.BotName,
+		Slot:     slot,
+	}
+
+	if !strings.HasPrefix(url.Channel, "#") {
+		url.Channel = "#" + url.Channel
+	}
+	return url, nil
+}
+
+// Provider is a source of search results.
+type Provider interface {
+	Name() string
+	Search(ctx context.Context, keywords []string) ([]FileInfo, error)
+}
+
+// Breaker decides whether a provider should be queried, given its recent failures.
+type Breaker interface {
+	Allow(provider string) bool
+	Record(provider string, err error)
+}
+
+// Registry queries a set of providers at once.
+type Registry struct {
+	providerList []Provider
+	breaker      Breaker
+	allowNetwork func(network string) bool
+	allowResult  func(info *FileInfo) bool
+	observer     func(provider string, latency time.Duration, err error)
+	onResults    func(provider string, results []FileInfo)
+
+	timeout          time.Duration            // time given to each provider to answer
+	providerTimeouts map[string]time.Duration // overrides timeout for some providers
+	searchTimeout    time.Duration            // total time of a search
+}
+
+const MaxProviders = 100
+
+// DefaultProviderTimeout is the time given by default to each provider to answer.
+const DefaultProviderTimeout = 10 * time.Second
+
+func NewRegistry() *Registry {
+	return &Registry{
+		providerList:     make([]Provider, 0, MaxProviders),
+		timeout:          DefaultProviderTimeout,
+		providerTimeouts: make(map[string]time.Duration),
+	}
+}
+
+func (registry *Registry) AddProvider(provider Provider) {
+	registry.providerList = append(registry.providerList, provider)
+}
+
+func (registry *Registry) Providers() []Provider {
+	return registry.providerList
+}
+
+// SetCircuitBreaker makes searches skip the providers which failed recently.
+func (registry *Registry) SetCircuitBreaker(breaker Breaker) {
+	registry.breaker = breaker
+}
+
+// SetNetworkFilter makes searches drop the results from the networks which are not allowed.
+func (registry *Registry) SetNetworkFilter(allow func(network string) bool) {
+	registry.allowNetwork = allow
+}
+
+// SetResultFilter makes searches drop the results which are not allowed, e.g. those of blocked bots.
+func (registry *Registry) SetResultFilter(allow func(info *FileInfo) bool) {
+	registry.allowResult = allow
+}
+
+func (registry *Registry) filterResults(results []FileInfo) []FileInfo {
+	if registry.allowNetwork == nil && registry.allowResult == nil {
+		return results
+	}
+
+	filtered := make([]FileInfo, 0, len(results))
+	for i := range results {
+		info := &results[i]
+		if registry.allowNetwork != nil && !registry.allowNetwork(info.Network) {
+			continue
+		}
+		if registry.allowResult != nil && !registry.allowResult(info) {
+			continue
+		}
+		filtered = append(filtered, *info)
+	}
+	return filtered
+}
+
+// SetQueryObserver makes searches report the outcome of each provider query, e.g. to collect metrics.
+func (registry *Registry) SetQueryObserver(observer func(provider string, latency time.Duration, err error)) {
+	registry.observer = observer
+}
+
+// SetResultObserver makes searches report the results of each provider, e.g. to index them.
+func (registry *Registry) SetResultObserver(observer func(provider string, results []FileInfo)) {
+	registry.onResults = observer
+}
+
+// SetTimeout limits the time given to each provider to answer. Zero means no limit.
+func (registry *Registry) SetTimeout(timeout time.Duration) {
+	registry.timeout = timeout
+}
+
+// SetProviderLocale sets the number format of the named provider, returning false if
+// there is no such provider or if its number format cannot be set.
+func (registry *Registry) SetProviderLocale(provider string, locale NumberLocale) bool {
+	for _, p := range registry.providerList {
+		if lp, ok := p.(LocalizedProvider); ok && p.Name() == provider {
+			lp.SetNumberLocale(locale)
+			return true
+		}
+	}
+	return false
+}
+
+// SetProviderRateLimit sets the minimum time between two requests of the named provider, returning
+// false if there is no such provider or if its requests are not throttled.
+func (registry *Registry) SetProviderRateLimit(provider string, interval time.Duration) bool {
+	for _, p := range registry.providerList {
+		if rp, ok := p.(RateLimitedProvider); ok && p.Name() == provider {
+			rp.SetMinRequestInterval(interval)
+			return true
+		}
+	}
+	return false
+}
+
+// SetProviderTimeout limits the time given to the named provider to answer, overriding SetTimeout.
+func (registry *Registry) SetProviderTimeout(provider string, timeout time.Duration) {
+	registry.providerTimeouts[provider] = timeout
+}
+
+// ProviderTimeout returns the time given to the named provider to answer. Zero means no limit.
+func (registry *Registry) ProviderTimeout(provider string) time.Duration {
+	if timeout, ok := registry.providerTimeouts[provider]; ok {
+		return timeout
+	}
+	return registry.timeout
+}
+
+// SetSearchTimeout limits the total time of a search, the providers which did not answer
+// by then failing with ErrProviderTimeout. Zero means no limit.
+func (registry *Registry) SetSearchTimeout(timeout time.Duration) {
+	registry.searchTimeout = timeout
+}
+
+const MaxResults = 1024
+
+var ErrProviderSkipped = errors.New("provider skipped after recent failures")
+
+// ErrProviderTimeout is the error of the providers which did not answer in time.
+var ErrProviderTimeout = errors.New("provider timed out")
+
+// ProviderResult holds the outcome of a single provider query.
+type ProviderResult struct {
+	Provider Provider
+	Results  []FileInfo
+	Err      error
+}
+
+func (registry *Registry) NumProviders() int {
+	return len(registry.providerList)
+}
+
+// SearchAsync queries all the registered providers concurrently and delivers
+// the results of each provider as soon as they are available.
+// The returned channel is closed once every provider has answered.
+func (registry *Registry) SearchAsync(ctx context.Context, keywords []string) <-chan ProviderResult {
+	resultsChan := make(chan ProviderResult, len(registry.providerList))
+
+	searchCtx, cancel := ctx, context.CancelFunc(func() {})
+	if registry.searchTimeout > 0 {
+		searchCtx, cancel = context.WithTimeout(ctx, registry.searchTimeout)
+	}
+
+	g := &group.Group{}
+	for _, p := range registry.providerList {
+		p := p
+		g.Go(func() error {
+			resultsChan <- registry.query(ctx, searchCtx, p, keywords)
+			return nil // a failing provider does not stop the others
+		})
+	}
+
+	go func() {
+		g.Wait()
+		cancel()
+		close(resultsChan)
+	}()
+	return resultsChan
+}
+
+// query runs the search of a single provider within searchCtx, which is derived from the caller ctx.
+func (registry *Registry) query(ctx context.Context, searchCtx context.Context, p Provider, keywords []string) ProviderResult {
+	if registry.breaker != nil && !registry.breaker.Allow(p.Name()) {
+		Logger(LogProviders, "%s: skipped, failed recently", p.Name())
+		return ProviderResult{Provider: p, Err: ErrProviderSkipped}
+	}
+
+	Logger(LogProviders, "%s: searching %q", p.Name(), strings.Join(keywords, " "))
+
+	queryCtx := searchCtx
+	if timeout := registry.ProviderTimeout(p.Name()); timeout > 0 {
+		var cancel context.CancelFunc
+		queryCtx, cancel = context.WithTimeout(searchCtx, timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	res, err := p.Search(queryCtx, keywords)
+	if err != nil && ctx.Err() == nil && queryCtx.Err() == context.DeadlineExceeded {
+		err = ErrProviderTimeout
+	}
+
+	if err != nil {
+		Logger(LogProviders, "%s: search failed after %s: %s", p.Name(), time.Since(start), err)
+	} else {
+		Logger(LogProviders, "%s: %d results in %s", p.Name(), len(res), time.Since(start))
+	}
+
+	if registry.observer != nil {
+		registry.observer(p.Name(), time.Since(start), err)
+	}
+
+	// running out of the total time of the search is not a failure of the provider
+	if registry.breaker != nil && searchCtx.Err() == nil {
+		registry.breaker.Record(p.Name(), err)
+	}
+
+	res = registry.filterResults(res)
+	if registry.onResults != nil && len(res) > 0 {
+		registry.onResults(p.Name(), res)
+	}
+	return ProviderResult{Provider: p, Results: res, Err: err}
+}
+
+// Search queries all the registered providers and returns their results.
+// An error is returned only if the search is cancelled or every provider fails.
+func (registry *Registry) Search(ctx context.Context, keywords []string) ([]FileInfo, error) {
+	allResults := make([]FileInfo, 0, MaxResults)
+
+	errs := make([]string, 0)
+	for res := range registry.SearchAsync(ctx, keywords) {
+		if res.Err == nil {
+			allResults = append(allResults, res.Results...)
+		} else {
+			errs = append(errs, res.Provider.Name()+": "+res.Err.Error())
+		}
+	}
+
+	if ctx.Err() != nil {
+		return allResults, ctx.Err()
+	}
+
+	if len(errs) > 0 && len(errs) == len(registry.providerList) {
+		return nil, errors.New("every provider failed: " + strings.Join(errs, ", "))
+	}
+	return allResults, nil
+}
@@ -0,0 +1,197 @@
+package search
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	KiloByte = 1024
+	MegaByte = KiloByte * 1024
+	GigaByte = MegaByte * 1024
+	TeraByte = GigaByte * 1024
+)
+
+// NumberLocale tells how a provider formats the numbers it reports, i.e. which of "." and ","
+// is the decimal separator. The zero value guesses it from each number.
+type NumberLocale string
+
+const (
+	LocaleAuto         NumberLocale = ""
+	LocaleDecimalPoint NumberLocale = "point" // 1,234.5
+	LocaleDecimalComma NumberLocale = "comma" // 1.234,5
+)
+
+// localeNames maps some language codes to the decimal separator of their locale.
+var localeNames = map[string]NumberLocale{
+	"en": LocaleDecimalPoint, "ja": LocaleDecimalPoint, "zh": LocaleDecimalPoint, "ko": LocaleDecimalPoint,
+	"de": LocaleDecimalComma, "fr": LocaleDecimalComma, "it": LocaleDecimalComma, "es": LocaleDecimalComma,
+	"pt": LocaleDecimalComma, "nl": LocaleDecimalComma, "ru": LocaleDecimalComma, "pl": LocaleDecimalComma,
+	"tr": LocaleDecimalComma,
+}
+
+// ParseNumberLocale parses a locale hint, either "point", "comma", "auto" or a language code such as "en" or "de-DE".
+func ParseNumberLocale(name string) (NumberLocale, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch NumberLocale(name) {
+	case LocaleDecimalPoint, LocaleDecimalComma:
+		return NumberLocale(name), nil
+	case "auto", LocaleAuto:
+		return LocaleAuto, nil
+	}
+
+	if idx := strings.IndexAny(name, "-_"); idx >= 0 {
+		name = name[:idx]
+	}
+	if locale, ok := localeNames[name]; ok {
+		return locale, nil
+	}
+	return LocaleAuto, errors.New("unknown number locale: " + name)
+}
+
+// decimalSeparator returns the decimal separator of s, or 0 if it has none. With LocaleAuto, the
+// last of "." and "," is the decimal separator when both appear, and a separator appearing several times
+// is a grouping one. A single "," followed by exactly 3 digits, as in "1,234", is taken as a grouping one.
+func (locale NumberLocale) decimalSeparator(s string) byte {
+	switch locale {
+	case LocaleDecimalPoint:
+		return '.'
+	case LocaleDecimalComma:
+		return ','
+	}
+
+	dot, comma := strings.LastIndexByte(s, '.'), strings.LastIndexByte(s, ',')
+	switch {
+	case dot >= 0 && comma >= 0:
+		if dot > comma {
+			return '.'
+		}
+		return ','
+	case dot >= 0:
+		if strings.Count(s, ".") > 1 {
+			return 0
+		}
+		return '.'
+	case comma >= 0:
+		if strings.Count(s, ",") > 1 || len(s)-comma-1 == 3 {
+			return 0
+		}
+		return ','
+	}
+	return 0
+}
+
+// ParseNumber parses a number formatted according to the locale, such as "1,234.5" or "1.234,5".
+// Spaces and apostrophes, used as grouping separators by some locales, are ignored.
+func ParseNumber(s string, locale NumberLocale) (float64, error) {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || r == '\'' || r == '\u2019' {
+			return -1
+		}
+		return r
+	}, s)
+
+	decimal := locale.decimalSeparator(s)
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == decimal:
+			b.WriteByte('.')
+		case c == '.' || c == ',':
+			// grouping separator
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return strconv.ParseFloat(b.String(), 64)
+}
+
+// ParseCount parses a count, such as the gets of a pack, formatted according to the locale.
+// A trailing "x", as in "123x", is ignored. With LocaleAuto, both "." and "," are taken as grouping separators.
+func ParseCount(s string, locale NumberLocale) (int, error) {
+	s = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(s)), "x")
+	if locale == LocaleAuto {
+		s = strings.NewReplacer(".", "", ",", "").Replace(s)
+	}
+	n, err := ParseNumber(s, locale)
+	if err != nil {
+		return -1, err
+	}
+	return int(n), nil
+}
+
+// LocalizedProvider is implemented by the providers whose number format can be set.
+type LocalizedProvider interface {
+	SetNumberLocale(locale NumberLocale)
+}
+
+// parseResultSize parses the size of a result, logging it when it cannot be parsed.
+func parseResultSize(provider string, size string, locale NumberLocale) int64 {
+	n, err := ParseLocalizedFileSize(normalizeResultSize(size), locale)
+	if err != nil {
+		Logger(LogProviders, "%s: unable to parse size %q: %s", provider, size, err)
+	}
+	return n
+}
+
+// resultSizeDecorations are the characters around the sizes reported by the indexes, as in "[1.2G]",
+// "(700 MB)" or "~350M".
+const resultSizeDecorations = "[](){}<>~≈|"
+
+// normalizeResultSize cleans up a size reported by an index, before it is parsed.
+func normalizeResultSize(size string) string {
+	size = strings.TrimSpace(size)
+	if idx := strings.IndexByte(size, ':'); idx >= 0 {
+		size = size[idx+1:] // e.g. "Size: 1.2G"
+	}
+	return strings.TrimFunc(size, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(resultSizeDecorations, r)
+	})
+}
+
+// fileSizeUnits maps the units of the sizes, in lower case, to their multiple. Besides the symbols,
+// the full names and the French ones ("Go", "Mo", ...) are accepted.
+var fileSizeUnits = map[string]int64{
+	"": 1, "b": 1, "byte": 1, "bytes": 1, "o": 1, "octet": 1, "octets": 1,
+	"k": KiloByte, "kb": KiloByte, "kib": KiloByte, "ko": KiloByte, "kio": KiloByte,
+	"kilobyte": KiloByte, "kilobytes": KiloByte, "kibibyte": KiloByte, "kibibytes": KiloByte,
+	"m": MegaByte, "mb": MegaByte, "mib": MegaByte, "mo": MegaByte, "mio": MegaByte,
+	"megabyte": MegaByte, "megabytes": MegaByte, "mebibyte": MegaByte, "mebibytes": MegaByte,
+	"g": GigaByte, "gb": GigaByte, "gib": GigaByte, "go": GigaByte, "gio": GigaByte,
+	"gigabyte": GigaByte, "gigabytes": GigaByte, "gibibyte": GigaByte, "gibibytes": GigaByte,
+	"t": TeraByte, "tb": TeraByte, "tib": TeraByte, "to": TeraByte, "tio": TeraByte,
+	"terabyte": TeraByte, "terabytes": TeraByte, "tebibyte": TeraByte, "tebibytes": TeraByte,
+}
+
+// ParseFileSize parses sizes such as "700M", "1.4 GB", "350MiB" or "2 gigabytes", guessing the decimal separator.
+// Since indexes report sizes in multiples of 1024, K, KB and KiB are all treated as binary units.
+func ParseFileSize(sizeStr string) (int64, error) {
+	return ParseLocalizedFileSize(sizeStr, LocaleAuto)
+}
+
+// ParseLocalizedFileSize is like ParseFileSize, for sizes formatted according to the locale, such as "1.234,5M".
+func ParseLocalizedFileSize(sizeStr string, locale NumberLocale) (int64, error) {
+	sizeStr = strings.TrimSpace(sizeStr)
+	if len(sizeStr) == 0 {
+		return -1, errors.New("empty string")
+	}
+
+	unitIdx := strings.IndexFunc(sizeStr, unicode.IsLetter)
+	if unitIdx < 0 {
+		unitIdx = len(sizeStr)
+	}
+
+	size, err := ParseNumber(sizeStr[:unitIdx], locale)
+	if err != nil {
+		return -1, err
+	}
+
+	unit := strings.ToLower(strings.TrimSpace(sizeStr[unitIdx:]))
+	if multiple, ok := fileSizeUnits[unit]; ok {
+		return int64(size * float64(multiple)), nil
+	}
+	return -1, errors.New("unable to parse: " + sizeStr)
+}
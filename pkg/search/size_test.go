@@ -0,0 +1,69 @@
+package search
+
+import "testing"
+
+func TestParseResultSize(t *testing.T) {
+	type sizeCase struct {
+		size     string
+		expected int64
+	}
+
+	tests := []struct {
+		provider string
+		locale   NumberLocale
+		cases    []sizeCase
+	}{
+		{
+			provider: "nibl.co.uk",
+			locale:   LocaleAuto,
+			cases: []sizeCase{
+				{"1,2G", 1288490188},
+				{"1 234 MB", 1293942784}, // thin space
+				{"2 gigabytes", 2147483648},
+				{"1,234G", 1324997410816}, // grouping separator, with 3 digits after it
+				{"1.4G", 1503238553},
+			},
+		},
+		{
+			provider: "xdcc.eu",
+			locale:   LocaleDecimalComma,
+			cases: []sizeCase{
+				{"1,234G", 1324997410},
+				{"1.234,5 MiB", 1294467072},
+				{"1,4 Go", 1503238553},
+				{"[700M]", 734003200},
+			},
+		},
+		{
+			provider: "announce",
+			locale:   LocaleAuto,
+			cases: []sizeCase{
+				{"[700M]", 734003200},
+				{"(1.5 GB)", 1610612736},
+				{"Size: 1.2G", 1288490188},
+				{"~350 megabytes", 367001600},
+			},
+		},
+		{
+			provider: "plugin",
+			locale:   LocaleDecimalPoint,
+			cases: []sizeCase{
+				{"1,234G", 1324997410816},
+				{"1,234.5M", 1294467072},
+				{"Size: 1.2G", 1288490188},
+				{"2684354560", 2684354560},
+				{"12 parsecs", -1},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.provider, func(t *testing.T) {
+			for _, c := range test.cases {
+				if size := parseResultSize(test.provider, c.size, test.locale); size != c.expected {
+					t.Errorf("%q: got %d, expected %d", c.size, size, c.expected)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package search
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMinRequestInterval is the default time between two requests to the server of a provider.
+	DefaultMinRequestInterval = time.Second
+
+	maxRequestRetries  = 3
+	initialRetryDelay  = 2 * time.Second
+	maxRetryDelay      = time.Minute
+	retryJitterPercent = 50
+)
+
+// RateLimitedProvider is implemented by the providers whose requests are throttled.
+type RateLimitedProvider interface {
+	SetMinRequestInterval(interval time.Duration)
+}
+
+// throttle spaces out the requests of a provider, and retries the ones refused by an overloaded
+// server (429 or 503 status), waiting as asked by the server or backing off exponentially.
+type throttle struct {
+	mu          sync.Mutex
+	interval    time.Duration
+	intervalSet bool
+	next        time.Time // earliest time of the next request
+}
+
+func (t *throttle) setInterval(interval time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.interval, t.intervalSet = interval, true
+}
+
+// reserve returns when the next request can be sent, delaying the following one.
+func (t *throttle) reserve() time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	interval := t.interval
+	if !t.intervalSet {
+		interval = DefaultMinRequestInterval
+	}
+
+	at := time.Now()
+	if t.next.After(at) {
+		at = t.next
+	}
+	t.next = at.Add(interval)
+	return at
+}
+
+// backOff delays every request of the provider by the given time.
+func (t *throttle) backOff(delay time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if next := time.Now().Add(delay); next.After(t.next) {
+		t.next = next
+	}
+}
+
+var (
+	jitterMu   sync.Mutex
+	jitterRand = rand.New(rand.NewSource(time.Now().UnixNano())) // guarded by jitterMu
+)
+
+// retryDelay returns the time to wait before retrying a request refused by the server.
+func retryDelay(res *http.Response, attempt int) time.Duration {
+	if after := res.Header.Get("Retry-After"); after != "" {
+		if secs, err := strconv.Atoi(after); err == nil && secs >= 0 {
+			return minDuration(time.Duration(secs)*time.Second, maxRetryDelay)
+		}
+		if date, err := http.ParseTime(after); err == nil {
+			return minDuration(time.Until(date), maxRetryDelay)
+		}
+	}
+
+	delay := minDuration(initialRetryDelay<<uint(attempt), maxRetryDelay)
+	spread := delay * retryJitterPercent / 100
+
+	jitterMu.Lock()
+	jitter := time.Duration(jitterRand.Int63n(int64(2*spread)+1)) - spread
+	jitterMu.Unlock()
+	return delay + jitter
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// get sends a GET request to url with r once the provider is allowed to, retrying it while the server is overloaded.
+func (t *throttle) get(ctx context.Context, r *requester, provider string, url string) (*http.Response, error) {
+	r.startSession(ctx, provider)
+
+	for attempt := 0; ; attempt++ {
+		if err := sleepContext(ctx, time.Until(t.reserve())); err != nil {
+			return nil, err
+		}
+
+		req, err := r.newRequest(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := r.do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		overloaded := res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable
+		if !overloaded || attempt >= maxRequestRetries {
+			return res, nil
+		}
+		res.Body.Close()
+
+		delay := retryDelay(res, attempt)
+		Logger(LogProviders, "%s: %s, retrying in %s", provider, res.Status, delay.Round(time.Millisecond))
+		t.backOff(delay)
+	}
+}
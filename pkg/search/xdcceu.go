@@ -0,0 +1,290 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// XdccEuProvider searches the packlists indexed by xdcc.eu.
+type XdccEuProvider struct {
+	locale   NumberLocale
+	throttle throttle
+	requests requester
+}
+
+const XdccEuURL = "https://www.xdcc.eu/search.php"
+
+func (p *XdccEuProvider) Name() string {
+	return "xdcc.eu"
+}
+
+func (p *XdccEuProvider) SetNumberLocale(locale NumberLocale) {
+	p.locale = locale
+}
+
+func (p *XdccEuProvider) SetMinRequestInterval(interval time.Duration) {
+	p.throttle.setInterval(interval)
+}
+
+func (p *XdccEuProvider) SetRequestOptions(opts RequestOptions) {
+	p.requests.setOptions(opts)
+}
+
+// xdccEuField is a field of a pack, found in the column whose header matches one of its labels.
+type xdccEuField int
+
+const (
+	xdccEuNetwork xdccEuField = iota
+	xdccEuChannel
+	xdccEuBot
+	xdccEuSlot
+	xdccEuGets
+	xdccEuSize
+	xdccEuName
+	xdccEuNumberOfFields
+)
+
+var xdccEuHeaderLabels = [xdccEuNumberOfFields][]string{
+	xdccEuNetwork: {"network", "server", "net"},
+	xdccEuChannel: {"channel", "chan"},
+	xdccEuBot:     {"bot", "nick", "user"},
+	xdccEuSlot:    {"pack", "slot", "#", "number", "pack #"},
+	xdccEuGets:    {"gets", "downloads", "dls", "dl"},
+	xdccEuSize:    {"size"},
+	xdccEuName:    {"name", "file", "filename", "file name"},
+}
+
+// xdccEuColumns are the columns under the header of a field.
+type xdccEuColumns struct {
+	first, span int
+}
+
+// xdccEuLayout gives the columns of each field, with a zero span when the table does not have it.
+type xdccEuLayout [xdccEuNumberOfFields]xdccEuColumns
+
+// xdccEuLegacyLayout is the layout of the table before its headers were looked up,
+// used when the headers are not recognized.
+var xdccEuLegacyLayout = xdccEuLayout{{0, 1}, {1, 1}, {2, 1}, {3, 1}, {4, 1}, {5, 1}, {6, 1}}
+
+func (layout *xdccEuLayout) has(field xdccEuField) bool {
+	return layout[field].span > 0
+}
+
+// value returns the text of the field in a row. When the header of the field spans several
+// columns, such as an icon and a file name, the longest text is kept.
+func (layout *xdccEuLayout) value(cells []*goquery.Selection, field xdccEuField) string {
+	value := ""
+	cols := layout[field]
+	for i := cols.first; i < cols.first+cols.span && i < len(cells); i++ {
+		if i > cols.first && cells[i] == cells[i-1] {
+			continue
+		}
+		if text := cellText(cells[i]); len(text) > len(value) {
+			value = text
+		}
+	}
+	return value
+}
+
+// valid tells whether the layout has the fields a pack cannot do without.
+func (layout *xdccEuLayout) valid() bool {
+	return layout.has(xdccEuBot) && layout.has(xdccEuSlot) && layout.has(xdccEuName)
+}
+
+// cellText returns the text of a cell, ignoring its markup and collapsing whitespace.
+func cellText(cell *goquery.Selection) string {
+	return strings.Join(strings.Fields(cell.Text()), " ")
+}
+
+// rowCells returns the cells of a table row, repeating those spanning several columns
+// so that the columns keep their index.
+func rowCells(row *goquery.Selection) []*goquery.Selection {
+	cells := make([]*goquery.Selection, 0)
+	row.ChildrenFiltered("td, th").Each(func(_ int, cell *goquery.Selection) {
+		span, err := strconv.Atoi(cell.AttrOr("colspan", "1"))
+		if err != nil || span < 1 || span > 100 {
+			span = 1
+		}
+		for i := 0; i < span; i++ {
+			cells = append(cells, cell)
+		}
+	})
+	return cells
+}
+
+// parseHeaderLayout matches the labels of a header row to the fields of the packs.
+func parseHeaderLayout(cells []*goquery.Selection) xdccEuLayout {
+	var layout xdccEuLayout
+	for i, cell := range cells {
+		if i > 0 && cell == cells[i-1] {
+			continue // already matched with the first column it spans
+		}
+
+		span := 1
+		for i+span < len(cells) && cells[i+span] == cell {
+			span++
+		}
+
+		label := strings.ToLower(strings.Trim(cellText(cell), " :"))
+		for field, labels := range xdccEuHeaderLabels {
+			if layout[field].span > 0 {
+				continue
+			}
+			for _, l := range labels {
+				if label == l {
+					layout[field] = xdccEuColumns{i, span}
+				}
+			}
+		}
+	}
+	return layout
+}
+
+func (p *XdccEuProvider) parseRow(cells []*goquery.Selection, layout *xdccEuLayout) (*FileInfo, error) {
+	fInfo := &FileInfo{
+		Network: layout.value(cells, xdccEuNetwork),
+		Channel: layout.value(cells, xdccEuChannel),
+		BotName: layout.value(cells, xdccEuBot),
+		Slot:    layout.value(cells, xdccEuSlot),
+		Name:    layout.value(cells, xdccEuName),
+		Size:    -1,
+	}
+
+	if fInfo.BotName == "" || fInfo.Slot == "" || fInfo.Name == "" {
+		return nil, errors.New("missing bot, slot or file name")
+	}
+	if !strings.HasPrefix(fInfo.Slot, "#") {
+		fInfo.Slot = "#" + fInfo.Slot
+	}
+	if _, err := strconv.Atoi(fInfo.Slot[1:]); err != nil {
+		return nil, fmt.Errorf("invalid slot %q", fInfo.Slot)
+	}
+
+	// e.g. "1,234x"
+	if getsField := layout.value(cells, xdccEuGets); getsField != "" {
+		gets := strings.TrimRightFunc(getsField, func(r rune) bool { return !unicode.IsDigit(r) })
+		if n, err := ParseCount(gets, p.locale); err == nil {
+			fInfo.Gets = n
+		} else {
+			Logger(LogProviders, "%s: unable to parse gets %q: %s", p.Name(), getsField, err)
+		}
+	}
+
+	if sizeField := layout.value(cells, xdccEuSize); sizeField != "" {
+		fInfo.Size = parseResultSize(p.Name(), sizeField, p.locale)
+	}
+
+	// the link to the channel tells its network when the table does not
+	for _, cell := range cells {
+		href, exists := cell.Find(`a[href^="irc://"]`).First().Attr("href")
+		if !exists {
+			continue
+		}
+
+		fInfo.Url = strings.Replace(href, "irc://", "http://", 1)
+		if u, err := url.Parse(href); err == nil {
+			if fInfo.Network == "" {
+				fInfo.Network = u.Hostname()
+			}
+			if fInfo.Channel == "" && strings.Trim(u.Path, "/") != "" {
+				fInfo.Channel = "#" + strings.TrimPrefix(strings.Trim(u.Path, "/"), "#")
+			}
+		}
+		break
+	}
+
+	if fInfo.Url == "" && fInfo.Network != "" && fInfo.Channel != "" {
+		fInfo.Url = "irc://" + fInfo.Network + "/" + strings.TrimPrefix(fInfo.Channel, "#") + "/" + fInfo.BotName + "/" + fInfo.Slot
+	}
+	fInfo.Command = "/msg " + fInfo.BotName + " xdcc send " + fInfo.Slot
+	return fInfo, nil
+}
+
+// parseTable returns the packs of a table of results, whose columns are found from the labels of its
+// header, so that they can be reordered or added to. Tables without any recognized header are read
+// with the legacy layout, and the rows not describing a pack are skipped.
+func (p *XdccEuProvider) parseTable(table *goquery.Selection) []FileInfo {
+	// the rows of nested tables are left out
+	var rows []*goquery.Selection
+	table.Children().Each(func(_ int, child *goquery.Selection) {
+		if child.Is("tr") {
+			rows = append(rows, child)
+			return
+		}
+		child.ChildrenFiltered("tr").Each(func(_ int, row *goquery.Selection) {
+			rows = append(rows, row)
+		})
+	})
+
+	layout, headerFound := xdccEuLegacyLayout, false
+	fileInfos := make([]FileInfo, 0)
+	for i, row := range rows {
+		cells := rowCells(row)
+		if len(cells) == 0 {
+			continue
+		}
+
+		if row.ChildrenFiltered("th").Length() > 0 || i == 0 && !headerFound {
+			if l := parseHeaderLayout(cells); l.valid() {
+				layout, headerFound = l, true
+				continue
+			}
+			if i == 0 {
+				continue // unknown header
+			}
+		}
+
+		info, err := p.parseRow(cells, &layout)
+		if err != nil {
+			Logger(LogProviders, "%s: row %d skipped: %s", p.Name(), i, err)
+			continue
+		}
+		fileInfos = append(fileInfos, *info)
+	}
+
+	if !headerFound && len(fileInfos) > 0 {
+		Logger(LogProviders, "%s: table headers not recognized, using the default column order", p.Name())
+	}
+	return fileInfos
+}
+
+// parseXdccEuPage returns the packs listed in a page of results, from the table with the most of them.
+func (p *XdccEuProvider) parseXdccEuPage(r io.Reader) ([]FileInfo, error) {
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfos := make([]FileInfo, 0)
+	doc.Find("table").Each(func(_ int, table *goquery.Selection) {
+		if res := p.parseTable(table); len(res) > len(fileInfos) {
+			fileInfos = res
+		}
+	})
+	return fileInfos, nil
+}
+
+func (p *XdccEuProvider) Search(ctx context.Context, keywords []string) ([]FileInfo, error) {
+	keywordString := strings.Join(keywords, " ")
+	searchkey := strings.Join(strings.Fields(keywordString), "+")
+
+	res, err := p.throttle.get(ctx, &p.requests, p.Name(), XdccEuURL+"?searchkey="+searchkey)
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
+	}
+	return p.parseXdccEuPage(res.Body)
+}
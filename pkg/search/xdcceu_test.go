@@ -0,0 +1,37 @@
+package search
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseXdccEuPage(t *testing.T) {
+	f, err := os.Open("testdata/xdcceu_search.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	p := &XdccEuProvider{}
+	results, err := p.parseXdccEuPage(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkResults(t, results, []packSummary{
+		// with an irc:// link
+		{"irc.rizon.net", "#debian", "Mirror-Bot", "#21", "debian-11.0.0-amd64-DVD-1.iso", 3972844748, 2048, "http://irc.rizon.net/#debian"},
+		// without a link, the url is made from the columns
+		{"irc.abjects.net", "#moviegods", "[MG]-Bot|ISO", "#1043", "debian-11.0.0-amd64-netinst.iso", 395313152, 12, "irc://irc.abjects.net/moviegods/[MG]-Bot|ISO/#1043"},
+		// without a link nor a network
+		{"", "", "Source|Bot", "#4", "debian-live-11.0.0-amd64-xfce.iso", 681574400, 0, ""},
+		// the link tells the network
+		{"irc.scenep2p.net", "#THE.SOURCE", "Source|Bot", "#5", "debian-live-11.0.0-amd64-kde.iso", 2576980377, 1, "http://irc.scenep2p.net/THE.SOURCE"},
+	})
+
+	for _, r := range results {
+		if expected := "/msg " + r.BotName + " xdcc send " + r.Slot; r.Command != expected {
+			t.Errorf("command %q, expected %q", r.Command, expected)
+		}
+	}
+}
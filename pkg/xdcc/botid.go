@@ -0,0 +1,70 @@
+package xdcc
+
+import (
+	"strings"
+
+	irc "github.com/fluffle/goirc/client"
+)
+
+// BotIdentity is what the server tells about the bot offering a file: the user and host it connects
+// from, and its reply to CTCP VERSION if received by the time of the offer.
+type BotIdentity struct {
+	Network string
+	Nick    string
+	Ident   string
+	Host    string
+	Version string
+}
+
+// Address returns the ident@host of the bot, or "" if it is not known.
+func (id BotIdentity) Address() string {
+	if id.Host == "" {
+		return ""
+	}
+	return id.Ident + "@" + id.Host
+}
+
+// Bot returns the identity of the bot, as seen when it offered the file.
+func (transfer *Transfer) Bot() BotIdentity {
+	transfer.mu.Lock()
+	defer transfer.mu.Unlock()
+
+	id := transfer.bot
+	id.Network = transfer.url.Network
+	return id
+}
+
+// setBotAddress records where the bot connects from, as seen on a message it sent.
+func (transfer *Transfer) setBotAddress(line *irc.Line) {
+	transfer.mu.Lock()
+	defer transfer.mu.Unlock()
+
+	transfer.bot.Nick = line.Nick
+	transfer.bot.Ident = line.Ident
+	transfer.bot.Host = line.Host
+}
+
+// handleVersionReply records the reply of the bot to CTCP VERSION.
+func (transfer *Transfer) handleVersionReply(line *irc.Line) {
+	if !strings.EqualFold(line.Args[0], "VERSION") {
+		return
+	}
+
+	transfer.mu.Lock()
+	defer transfer.mu.Unlock()
+	transfer.bot.Version = strings.TrimSpace(line.Text())
+}
+
+// verifyBot checks the identity of the bot with VerifyBot, cancelling the transfer if it fails.
+func (transfer *Transfer) verifyBot() bool {
+	if transfer.config.VerifyBot == nil {
+		return true
+	}
+
+	if err := transfer.config.VerifyBot(transfer.Bot()); err != nil {
+		transfer.send(&XdccCancelReq{})
+		transfer.notifyEvent(&TransferAbortedEvent{Error: err.Error()})
+		return false
+	}
+	return true
+}
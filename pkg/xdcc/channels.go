@@ -0,0 +1,149 @@
+package xdcc
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	irc "github.com/fluffle/goirc/client"
+)
+
+// maxAutoJoins is the number of times the channels required by a bot are joined
+// before giving up, since some bots keep asking for more.
+const maxAutoJoins = 3
+
+var (
+	ircFormattingRegexp = regexp.MustCompile("\x03[0-9]{0,2}(,[0-9]{1,2})?|[\x02\x0f\x11\x16\x1d\x1e\x1f]")
+	channelNameRegexp   = regexp.MustCompile(`#[^\s,.:;!?'"()\[\]<>]+`)
+)
+
+// channelRequirementHints are the words used by bots when refusing a request
+// from a user who is not in some channel.
+var channelRequirementHints = []string{
+	"must be in", "must be on", "need to be in", "need to be on", "have to be in", "have to be on",
+	"must join", "need to join", "please join", "you are not in", "you're not in", "not on channel",
+	"only for users in", "only available to users in",
+}
+
+// RequiredChannels returns the channels which the notice of a bot asks to join
+// before requesting a pack, e.g. "You must be on #chan and #chan-chat to request packs".
+func RequiredChannels(notice string) []string {
+	text := strings.ToLower(ircFormattingRegexp.ReplaceAllString(notice, ""))
+
+	hinted := false
+	for _, hint := range channelRequirementHints {
+		if strings.Contains(text, hint) {
+			hinted = true
+			break
+		}
+	}
+	if !hinted {
+		return nil
+	}
+
+	channels := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, channel := range channelNameRegexp.FindAllString(text, -1) {
+		// pack numbers such as #42 are not channels
+		if _, err := strconv.Atoi(channel[1:]); err == nil || seen[channel] {
+			continue
+		}
+		seen[channel] = true
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// handleBotNotice joins the channels required by the bot, if its notice asks for some.
+// The pack is requested again once every channel has been joined.
+func (transfer *Transfer) handleBotNotice(conn *irc.Conn, notice string) {
+	channels := RequiredChannels(notice)
+	if len(channels) == 0 {
+		return
+	}
+
+	transfer.mu.Lock()
+	defer transfer.mu.Unlock()
+
+	if transfer.started {
+		return
+	}
+
+	missing := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		if !transfer.joined[channel] {
+			missing = append(missing, channel)
+		}
+	}
+
+	var abortErr string
+	switch {
+	case len(missing) == 0 || transfer.autoJoins >= maxAutoJoins:
+		abortErr = "refused by " + transfer.url.UserName + " after joining " + strings.Join(channels, ", ")
+	case transfer.config.NoAutoJoin:
+		abortErr = transfer.url.UserName + " requires joining " + strings.Join(missing, ", ")
+	}
+
+	if abortErr != "" {
+		transfer.notifyEvent(&TransferAbortedEvent{Error: abortErr, Refused: true})
+		transfer.leaving = true // the bot would refuse the request again after reconnecting
+		conn.Quit()
+		return
+	}
+
+	transfer.autoJoins++
+	for _, channel := range missing {
+		Logger(LogIRC, "%s: %s requires %s, joining it", transfer.url.Network, transfer.url.UserName, channel)
+		transfer.pendingJoins[channel] = true
+		conn.Join(channel)
+	}
+}
+
+// channelJoined records that the channel has been joined, returning true once the channel
+// of the pack and the channels required by the bot have all been joined by this join.
+func (transfer *Transfer) channelJoined(channel string) bool {
+	transfer.mu.Lock()
+	defer transfer.mu.Unlock()
+
+	channel = strings.ToLower(channel)
+	transfer.joined[channel] = true
+	required := transfer.pendingJoins[channel]
+	delete(transfer.pendingJoins, channel)
+
+	if !required && !strings.EqualFold(channel, transfer.url.Channel) {
+		return false
+	}
+	return len(transfer.pendingJoins) == 0 && transfer.joined[strings.ToLower(transfer.url.Channel)]
+}
+
+// channelsToJoin returns the channels to join once connected: the channel of the pack, along with
+// the channels required by the bot when reconnecting, which must be joined again before the request.
+func (transfer *Transfer) channelsToJoin() []string {
+	transfer.mu.Lock()
+	defer transfer.mu.Unlock()
+
+	for channel := range transfer.joined {
+		if !strings.EqualFold(channel, transfer.url.Channel) {
+			transfer.pendingJoins[channel] = true
+		}
+	}
+	transfer.joined = make(map[string]bool)
+
+	channels := []string{transfer.url.Channel}
+	for channel := range transfer.pendingJoins {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// joinedChannels returns the channels joined by the transfer.
+func (transfer *Transfer) joinedChannels() []string {
+	transfer.mu.Lock()
+	defer transfer.mu.Unlock()
+
+	channels := make([]string, 0, len(transfer.joined))
+	for channel := range transfer.joined {
+		channels = append(channels, channel)
+	}
+	return channels
+}
@@ -0,0 +1,72 @@
+package xdcc
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	irc "github.com/fluffle/goirc/client"
+)
+
+// ClientProfile is what the IRC servers and the bots can see of the client: its ident, real name,
+// the reply to CTCP VERSION requests and its quit message.
+type ClientProfile struct {
+	Version     string
+	Ident       string
+	RealName    string
+	QuitMessage string
+}
+
+// ClientProfiles are the profiles of common clients, which can be mimicked for the bots
+// dropping the users whose client is unknown to them.
+var ClientProfiles = map[string]ClientProfile{
+	"xdcc-cli": {Version: "xdcc-cli", Ident: "xdcc", RealName: "xdcc-cli", QuitMessage: "Bye"},
+	"mirc":     {Version: "mIRC v7.76 Khaled Mardam-Bey", Ident: "mirc", RealName: "mIRC user", QuitMessage: "Leaving"},
+	"hexchat":  {Version: "HexChat 2.16.1 [x64] / Windows 10 [3.60GHz]", Ident: "hexchat", RealName: "realname", QuitMessage: "Leaving"},
+	"irssi":    {Version: "irssi v1.4.5 - running on Linux x86_64", Ident: "irssi", RealName: "irssi user", QuitMessage: "leaving"},
+	"weechat":  {Version: "WeeChat 4.1.2", Ident: "weechat", RealName: "weechat user", QuitMessage: "WeeChat 4.1.2"},
+}
+
+// ClientProfileNames returns the names of the profiles in ClientProfiles, sorted.
+func ClientProfileNames() []string {
+	names := make([]string, 0, len(ClientProfiles))
+	for name := range ClientProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Client is the profile used by the connections to the IRC servers.
+var Client = ClientProfiles["xdcc-cli"]
+
+// ctcpCommands are the CTCP requests answered by the client, advertised by CLIENTINFO.
+var ctcpCommands = []string{"ACTION", "CLIENTINFO", "DCC", "PING", "TIME", "VERSION"}
+
+func applyClientProfile(config *irc.Config) {
+	config.Version = Client.Version
+	config.QuitMessage = Client.QuitMessage
+	if Client.Ident != "" {
+		config.Me.Ident = Client.Ident
+	}
+	if Client.RealName != "" {
+		config.Me.Name = Client.RealName
+	}
+}
+
+// HandleCTCPRequests makes the connection answer the standard CTCP requests, since some networks
+// and bots drop the users whose client doesn't. VERSION and PING are answered by goirc itself,
+// using the version of the configuration of the connection.
+func HandleCTCPRequests(conn *irc.Conn, network string) {
+	conn.HandleFunc(irc.CTCP, func(conn *irc.Conn, line *irc.Line) {
+		switch strings.ToUpper(line.Args[0]) {
+		case "TIME":
+			conn.CtcpReply(line.Nick, "TIME", time.Now().Format(time.RFC1123Z))
+		case "CLIENTINFO":
+			conn.CtcpReply(line.Nick, "CLIENTINFO", strings.Join(ctcpCommands, " "))
+		default:
+			return
+		}
+		Logger(LogIRC, "%s: answered ctcp %s from %s", network, line.Args[0], line.Nick)
+	})
+}
@@ -0,0 +1,65 @@
+package xdcc
+
+import (
+	"context"
+	"time"
+)
+
+// Modes of leaving the channel and the network once a transfer is over.
+// Channels often ban the users parting as soon as they got their file.
+const (
+	DepartImmediately = "immediately"
+	DepartAfterDelay  = "delay"
+	DepartNever       = "never"
+)
+
+// IsValidDepartureMode reports whether mode is one of the Depart* modes.
+func IsValidDepartureMode(mode string) bool {
+	switch mode {
+	case DepartImmediately, DepartAfterDelay, DepartNever:
+		return true
+	}
+	return false
+}
+
+// Departure tells when to leave the channel and the network once a transfer is over.
+type Departure struct {
+	Mode  string        // one of the Depart* modes
+	Delay time.Duration // time spent in the channel with DepartAfterDelay
+}
+
+// Leave parts the channels and quits the network as specified by departure.
+// It must be called once the transfer is over, and returns once the network has been left.
+// Cancelling ctx while waiting makes it leave immediately. With DepartNever, the connection
+// stays open until the program exits.
+func (transfer *Transfer) Leave(ctx context.Context, departure Departure) {
+	if departure.Mode == DepartNever {
+		return
+	}
+
+	transfer.mu.Lock()
+	transfer.leaving = true
+	transfer.mu.Unlock()
+
+	if departure.Mode == DepartAfterDelay && departure.Delay > 0 {
+		Logger(LogIRC, "%s: leaving %s in %s", transfer.url.Network, transfer.url.Channel, departure.Delay)
+
+		timer := time.NewTimer(departure.Delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+
+	if !transfer.conn.Connected() {
+		return
+	}
+
+	// the channels required by the bot were joined as well
+	for _, channel := range transfer.joinedChannels() {
+		Logger(LogIRC, "%s: leaving %s", transfer.url.Network, channel)
+		transfer.conn.Part(channel)
+	}
+	transfer.conn.Quit()
+}
@@ -0,0 +1,185 @@
+package xdcc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Policies applied when the destination file of a transfer already exists.
+const (
+	CollisionRename    = "rename"
+	CollisionSkip      = "skip"
+	CollisionOverwrite = "overwrite"
+	CollisionResume    = "resume"
+)
+
+// IsValidCollisionPolicy reports whether policy is one of the Collision* policies.
+func IsValidCollisionPolicy(policy string) bool {
+	switch policy {
+	case CollisionRename, CollisionSkip, CollisionOverwrite, CollisionResume:
+		return true
+	}
+	return false
+}
+
+// AddNameSuffix inserts the suffix between the name and the extension of the file.
+func AddNameSuffix(fileName string, suffix string) string {
+	ext := filepath.Ext(fileName)
+	return strings.TrimSuffix(fileName, ext) + suffix + ext
+}
+
+// maxFileNameLength is the length, in bytes, of the longest file name allowed by most file systems.
+const maxFileNameLength = 255
+
+// reservedNames are the device names of windows, which cannot be used as file names there
+// whatever their extension. They are renamed on every platform, the files being often shared.
+var reservedNames = []string{
+	"CON", "PRN", "AUX", "NUL",
+	"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+	"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9",
+}
+
+// isReservedName reports whether the name, without its extensions, is a windows device name (e.g. "con.txt").
+func isReservedName(name string) bool {
+	base := name
+	if idx := strings.Index(base, "."); idx >= 0 {
+		base = base[:idx]
+	}
+
+	for _, reserved := range reservedNames {
+		if strings.EqualFold(strings.TrimSpace(base), reserved) {
+			return true
+		}
+	}
+	return false
+}
+
+// truncateFileName shortens the name to maxFileNameLength bytes, keeping its extension
+// and without splitting its characters.
+func truncateFileName(name string) string {
+	if len(name) <= maxFileNameLength {
+		return name
+	}
+
+	ext := filepath.Ext(name)
+	if len(ext) > 16 || strings.ContainsRune(ext, ' ') {
+		ext = "" // not an actual extension
+	}
+
+	base := strings.TrimSuffix(name, ext)
+	for len(base)+len(ext) > maxFileNameLength {
+		_, size := utf8.DecodeLastRuneInString(base)
+		base = base[:len(base)-size]
+	}
+	return base + ext
+}
+
+// sanitizePathComponent makes s usable as a single path element,
+// replacing path separators and the characters not allowed by the platform,
+// and dropping control characters. Leading dots are dropped as well, so that
+// the files are not hidden, reserved names are renamed and long names truncated.
+func sanitizePathComponent(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '/' || r == '\\' || strings.ContainsRune(invalidPathChars, r):
+			return '_'
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, s)
+
+	s = strings.TrimLeft(strings.TrimSpace(s), ". ")
+	if s == "" {
+		return "_"
+	}
+
+	if s = sanitizePlatformName(s); s == "" {
+		return "_"
+	}
+	if isReservedName(s) {
+		s = "_" + s
+	}
+	return truncateFileName(s)
+}
+
+// SanitizeFileName returns the name under which a file offered by a bot is saved: the name chosen
+// by the bot, made a single valid path element which cannot be a device or hidden file.
+func SanitizeFileName(fileName string) string {
+	return sanitizePathComponent(fileName)
+}
+
+// ExpandDestTemplate replaces the tokens of the template ({network}, {channel}, {bot},
+// {slot}, {date} and {name}) with the sanitized values of the transfer.
+func ExpandDestTemplate(template string, url IRCFileURL, fileName string, now time.Time) string {
+	return expandDestTemplate(template, url, SanitizeFileName(fileName), now)
+}
+
+// expandDestTemplate is ExpandDestTemplate with a file name used as is.
+func expandDestTemplate(template string, url IRCFileURL, fileName string, now time.Time) string {
+	if template == "" {
+		template = "{name}"
+	}
+
+	replacer := strings.NewReplacer(
+		"{network}", sanitizePathComponent(url.Network),
+		"{channel}", sanitizePathComponent(strings.TrimPrefix(url.Channel, "#")),
+		"{bot}", sanitizePathComponent(url.UserName),
+		"{slot}", strconv.Itoa(url.Slot),
+		"{date}", now.Format("2006-01-02"),
+		"{name}", fileName,
+	)
+	return filepath.FromSlash(replacer.Replace(template))
+}
+
+// isWithinDir reports whether path is dir or one of its descendants.
+func isWithinDir(path string, dir string) bool {
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+const maxRenameAttempts = 1000
+
+// renameWithSuffix returns the first path of the form "name_N.ext" which does not exist.
+func renameWithSuffix(path string) (string, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+
+	for i := 1; i < maxRenameAttempts; i++ {
+		candidate := fmt.Sprintf("%s_%d%s", base, i, ext)
+		if !fileExists(candidate) {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("unable to find a free name for %s", path)
+}
+
+// resolveCollision applies the collision policy to the destination path.
+// It returns the path to write to, or an empty path if the file must be skipped.
+func resolveCollision(path string, policy string) (string, error) {
+	if !fileExists(path) {
+		return path, nil
+	}
+
+	switch policy {
+	case CollisionSkip:
+		return "", nil
+	case CollisionOverwrite, CollisionResume:
+		return path, nil
+	}
+	return renameWithSuffix(path)
+}
@@ -0,0 +1,28 @@
+//go:build linux
+// +build linux
+
+package xdcc
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize allocates the blocks without changing the size of the file
+const fallocKeepSize = 0x01
+
+// preallocate allocates the disk blocks of the rest of the file, so that it is not fragmented.
+// The size of the file is kept, since it is the position from which an interrupted download resumes.
+func preallocate(file *os.File, filePath string, offset uint64, fileSize uint64) {
+	if fileSize <= offset {
+		return
+	}
+
+	if err := syscall.Fallocate(int(file.Fd()), fallocKeepSize, int64(offset), int64(fileSize-offset)); err != nil {
+		Logger(LogTransfers, "%s: unable to preallocate %d bytes: %s", filePath, fileSize-offset, err)
+	}
+}
+
+func openDirectFile(filePath string, flags int) (*os.File, error) {
+	return os.OpenFile(filePath, flags|syscall.O_DIRECT, 0644)
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package xdcc
+
+import "os"
+
+// preallocate does nothing where the blocks cannot be allocated without extending the file: an
+// extended (truncated up) file would look complete to the resume of an interrupted download.
+func preallocate(file *os.File, filePath string, offset uint64, fileSize uint64) {}
+
+func openDirectFile(filePath string, flags int) (*os.File, error) {
+	return nil, errDirectIOUnsupported
+}
@@ -0,0 +1,221 @@
+package xdcc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	CHAT  = "CHAT"
+	SCHAT = "SCHAT"
+)
+
+// FServe describes a session with a file server (fserve): instead of requesting a pack, the transfer
+// triggers the fserve of the bot, which opens a DCC CHAT session where the file is looked for with
+// commands such as "cd movies" and requested with "get file.mkv". The file is then sent as usual.
+type FServe struct {
+	Trigger  string   // sent to the bot as CTCP to open the session, e.g. "!files"
+	Commands []string // sent in order once the session is open
+
+	// NextCommand, if set, is called for the commands to send after Commands, until it returns false.
+	NextCommand func() (string, bool)
+
+	// OnLine, if set, is called with each line written by the fserve.
+	OnLine func(line string)
+}
+
+const (
+	fserveDialTimeout = 30 * time.Second
+	// the commands are sent once the fserve has stopped writing for fserveIdle, or after fserveMaxWait
+	fserveIdle    = time.Second
+	fserveMaxWait = 15 * time.Second
+	// time given to the fserve to send the requested file, unless it reports that the request is queued
+	fserveSendTimeout = 2 * time.Minute
+)
+
+// XdccChatRes is the DCC CHAT offer of a bot, e.g. "CHAT chat 3232235777 1024".
+type XdccChatRes struct {
+	IP     net.IP
+	Port   int
+	Secure bool // true if the session runs over TLS (SCHAT)
+}
+
+const XdccChatResArgs = 3
+
+func (chat *XdccChatRes) Name() string {
+	if chat.Secure {
+		return SCHAT
+	}
+	return CHAT
+}
+
+func (chat *XdccChatRes) Parse(args []string) error {
+	if len(args) != XdccChatResArgs {
+		return errors.New("invalid number of arguments")
+	}
+
+	var err error
+	if chat.IP, err = parseDCCAddress(args[1]); err != nil {
+		return err
+	}
+	chat.Port, err = strconv.Atoi(args[2])
+	return err
+}
+
+// requestFServe triggers the fserve of the bot.
+func (transfer *Transfer) requestFServe() {
+	Logger(LogIRC, "%s: triggering the fserve of %s with %q", transfer.url.Network, transfer.url.UserName, transfer.config.FServe.Trigger)
+	transfer.conn.Ctcp(transfer.url.UserName, transfer.config.FServe.Trigger)
+}
+
+// handleXdccChatRes connects to the DCC CHAT session offered by the bot and runs the fserve commands.
+func (transfer *Transfer) handleXdccChatRes(chat *XdccChatRes) {
+	if transfer.config.FServe == nil || transfer.config.DryRun {
+		Logger(LogIRC, "%s: ignoring the DCC CHAT offer of %s", transfer.url.Network, transfer.url.UserName)
+		return
+	}
+
+	address := net.JoinHostPort(chat.IP.String(), strconv.Itoa(chat.Port))
+	conn, err := net.DialTimeout("tcp", address, fserveDialTimeout)
+	if err != nil {
+		transfer.notifyEvent(&TransferAbortedEvent{Error: "unable to reach the fserve at " + address})
+		return
+	}
+
+	if chat.Secure {
+		conn = tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	}
+	Logger(LogIRC, "%s: fserve session with %s opened", transfer.url.Network, transfer.url.UserName)
+
+	transfer.goroutines.Go(func() error {
+		select {
+		case <-transfer.done:
+		case <-transfer.ctx.Done():
+		}
+		conn.Close()
+		return nil
+	})
+	transfer.goroutines.Go(func() error {
+		transfer.runFServe(conn)
+		return nil
+	})
+}
+
+// runFServe sends the commands to the fserve, relaying what it writes, then waits for the file.
+func (transfer *Transfer) runFServe(conn net.Conn) {
+	fserve := transfer.config.FServe
+	activity := make(chan struct{}, 1)
+	closed := make(chan struct{})
+	queued := make(chan struct{}, 1)
+
+	go func() {
+		defer close(closed)
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			line := strings.TrimRight(scanner.Text(), "\r")
+			Logger(LogIRC, "%s: fserve: %s", transfer.url.Network, line)
+			if fserve.OnLine != nil {
+				fserve.OnLine(line)
+			}
+
+			if position, ok := parseQueueNotice(line); ok {
+				transfer.notifyEvent(&TransferQueuedEvent{Position: position})
+				select {
+				case queued <- struct{}{}:
+				default:
+				}
+			}
+
+			select {
+			case activity <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	// waitIdle waits for the fserve to stop writing, returning false if the session is over
+	waitIdle := func() bool {
+		deadline := time.After(fserveMaxWait)
+		for {
+			select {
+			case <-activity:
+			case <-time.After(fserveIdle):
+				return true
+			case <-deadline:
+				return true
+			case <-closed:
+				return false
+			}
+		}
+	}
+
+	sent := 0
+	next := func() (string, bool) {
+		for {
+			var command string
+			switch {
+			case sent < len(fserve.Commands):
+				command = fserve.Commands[sent]
+				sent++
+			case fserve.NextCommand != nil:
+				var ok bool
+				if command, ok = fserve.NextCommand(); !ok {
+					return "", false
+				}
+			default:
+				return "", false
+			}
+
+			if command = strings.TrimSpace(command); command != "" {
+				return command, true
+			}
+		}
+	}
+
+	requested := false
+	for waitIdle() {
+		command, ok := next()
+		if !ok {
+			break
+		}
+
+		Logger(LogIRC, "%s: fserve command: %s", transfer.url.Network, command)
+		if _, err := conn.Write([]byte(command + "\n")); err != nil {
+			break
+		}
+
+		if fields := strings.Fields(command); strings.EqualFold(fields[0], "get") {
+			requested = true
+			break // the session ends with the first file requested
+		}
+	}
+
+	if transfer.ctx.Err() != nil {
+		return // reported by watchCancellation
+	}
+
+	if !requested {
+		transfer.notifyEvent(&TransferAbortedEvent{Error: "the fserve session ended without requesting a file"})
+		return
+	}
+
+	// the chat can be closed by the fserve meanwhile, the file being sent through IRC
+	select {
+	case <-queued:
+	case <-transfer.done:
+	case <-transfer.ctx.Done():
+	case <-time.After(fserveSendTimeout):
+		transfer.mu.Lock()
+		receiving := transfer.dccConn != nil || transfer.pendingResume != nil
+		transfer.mu.Unlock()
+
+		if !receiving {
+			transfer.notifyEvent(&TransferAbortedEvent{Error: "the fserve did not send the requested file"})
+		}
+	}
+}
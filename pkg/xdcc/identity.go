@@ -0,0 +1,181 @@
+package xdcc
+
+import (
+	"encoding/base64"
+	"strings"
+	"sync"
+	"time"
+
+	irc "github.com/fluffle/goirc/client"
+)
+
+// Identity is the identity used on a network instead of a random nick.
+// The fields left empty keep their default values.
+type Identity struct {
+	Nick             string
+	Ident            string
+	RealName         string
+	ServerPassword   string // sent with PASS
+	NickServPassword string // sent to NickServ once connected
+	SASLUser         string // SASL PLAIN credentials, Nick if empty
+	SASLPassword     string
+	UserModes        string // set once connected, e.g. "+x" to hide the host
+	WaitForVHost     bool   // join the channels once the server reports the virtual host (396)
+}
+
+// NetworkIdentity returns the identity to use on the server, if any.
+var NetworkIdentity = func(server string) *Identity { return nil }
+
+// vhostTimeout is the time given to the server to set the virtual host before joining anyway.
+const vhostTimeout = 15 * time.Second
+
+// applyIdentity makes the configuration of a connection use the identity.
+func applyIdentity(config *irc.Config, identity *Identity) {
+	if identity.Nick != "" {
+		config.Me.Nick = identity.Nick
+	}
+	if identity.Ident != "" {
+		config.Me.Ident = identity.Ident
+	}
+	if identity.RealName != "" {
+		config.Me.Name = identity.RealName
+	}
+	config.Pass = identity.ServerPassword
+}
+
+// identitySession establishes the identity of a connection, running ready once done.
+type identitySession struct {
+	identity *Identity
+	network  string
+	ready    func(conn *irc.Conn)
+
+	mu       sync.Mutex
+	pending  bool // waiting for the virtual host, guarded by mu
+	deadline *time.Timer
+}
+
+// HandleIdentity makes the connection authenticate with the identity of the network, if any, calling
+// ready once connected and identified. Without identity, ready is called as soon as the connection is up.
+// With WaitForVHost, ready is called once the server reports the virtual host, or after a timeout.
+func HandleIdentity(conn *irc.Conn, network string, ready func(conn *irc.Conn)) {
+	identity := NetworkIdentity(network)
+	if identity == nil {
+		conn.HandleFunc(irc.CONNECTED, func(conn *irc.Conn, line *irc.Line) { ready(conn) })
+		return
+	}
+
+	session := &identitySession{identity: identity, network: network, ready: ready}
+	if identity.SASLPassword != "" {
+		session.handleSASL(conn)
+	}
+
+	conn.HandleFunc(irc.CONNECTED, session.connected)
+	conn.HandleFunc("396", func(conn *irc.Conn, line *irc.Line) {
+		Logger(LogIRC, "%s: %s", network, line.Text())
+		session.done(conn)
+	})
+}
+
+func (session *identitySession) connected(conn *irc.Conn, line *irc.Line) {
+	identity := session.identity
+	if identity.NickServPassword != "" {
+		Logger(LogIRC, "%s: identifying to NickServ", session.network)
+		account := identity.Nick
+		if account == "" {
+			account = conn.Me().Nick
+		}
+		conn.Privmsg("NickServ", "IDENTIFY "+account+" "+identity.NickServPassword)
+	}
+
+	if identity.UserModes != "" {
+		conn.Mode(conn.Me().Nick, identity.UserModes)
+	}
+
+	if !identity.WaitForVHost {
+		session.ready(conn)
+		return
+	}
+
+	Logger(LogIRC, "%s: waiting for the virtual host", session.network)
+	session.mu.Lock()
+	session.pending = true
+	session.deadline = time.AfterFunc(vhostTimeout, func() {
+		Logger(LogIRC, "%s: no virtual host after %s, going on", session.network, vhostTimeout)
+		session.done(conn)
+	})
+	session.mu.Unlock()
+}
+
+// done calls ready if the session was waiting for the virtual host.
+func (session *identitySession) done(conn *irc.Conn) {
+	session.mu.Lock()
+	pending := session.pending
+	session.pending = false
+	if session.deadline != nil {
+		session.deadline.Stop()
+	}
+	session.mu.Unlock()
+
+	if pending {
+		session.ready(conn)
+	}
+}
+
+// handleSASL authenticates with SASL PLAIN. Since goirc registers the connection on its own,
+// the capability is requested right after the registration, which servers hold while they
+// check the client (ident and DNS lookups).
+func (session *identitySession) handleSASL(conn *irc.Conn) {
+	network := session.network
+	conn.HandleFunc(irc.REGISTER, func(conn *irc.Conn, line *irc.Line) {
+		conn.Raw("CAP REQ :sasl")
+	})
+
+	conn.HandleFunc(irc.CAP, func(conn *irc.Conn, line *irc.Line) {
+		if len(line.Args) < 2 {
+			return
+		}
+
+		switch strings.ToUpper(line.Args[1]) {
+		case "ACK":
+			conn.Raw("AUTHENTICATE PLAIN")
+		case "NAK":
+			Logger(LogError, "%s: SASL is not supported by the server", network)
+			conn.Raw("CAP END")
+		}
+	})
+
+	conn.HandleFunc("AUTHENTICATE", func(conn *irc.Conn, line *irc.Line) {
+		if len(line.Args) == 0 || line.Args[0] != "+" {
+			return
+		}
+
+		user := session.identity.SASLUser
+		if user == "" {
+			user = session.identity.Nick
+		}
+		if user == "" {
+			user = conn.Me().Nick
+		}
+		creds := base64.StdEncoding.EncodeToString([]byte(user + "\x00" + user + "\x00" + session.identity.SASLPassword))
+		for len(creds) >= 400 {
+			conn.Raw("AUTHENTICATE " + creds[:400])
+			creds = creds[400:]
+		}
+		if creds == "" {
+			creds = "+"
+		}
+		conn.Raw("AUTHENTICATE " + creds)
+	})
+
+	conn.HandleFunc("903", func(conn *irc.Conn, line *irc.Line) {
+		Logger(LogIRC, "%s: authenticated with SASL", network)
+		conn.Raw("CAP END")
+	})
+
+	for _, numeric := range []string{"902", "904", "905", "906"} {
+		conn.HandleFunc(numeric, func(conn *irc.Conn, line *irc.Line) {
+			Logger(LogError, "%s: SASL authentication failed: %s", network, line.Text())
+			conn.Raw("CAP END")
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package xdcc
+
+import (
+	"sync"
+	"time"
+
+	irc "github.com/fluffle/goirc/client"
+)
+
+// KeepAliveInterval is the time between two pings to the server. A server which has not answered
+// for two intervals is considered gone, and the connection is closed to make it reconnect.
+var KeepAliveInterval = time.Minute
+
+// HandleKeepAlive watches the answers of the server to the pings of the connection, closing the
+// connection once they stop, since a dropped connection may otherwise go unnoticed for long.
+func HandleKeepAlive(conn *irc.Conn, network string) {
+	var mu sync.Mutex
+	lastSeen := time.Now()
+	watching := false
+
+	seen := func(conn *irc.Conn, line *irc.Line) {
+		mu.Lock()
+		lastSeen = time.Now()
+		mu.Unlock()
+	}
+	conn.HandleFunc(irc.PING, seen)
+	conn.HandleFunc(irc.PONG, seen)
+
+	watch := func() {
+		ticker := time.NewTicker(KeepAliveInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			mu.Lock()
+			if !conn.Connected() {
+				// a new watch starts with the next connection
+				watching = false
+				mu.Unlock()
+				return
+			}
+			silence := time.Since(lastSeen)
+			mu.Unlock()
+
+			if silence > 2*KeepAliveInterval {
+				Logger(LogError, "%s: no answer from the server for %s, reconnecting", network, silence.Round(time.Second))
+				conn.Close()
+			}
+		}
+	}
+
+	conn.HandleFunc(irc.CONNECTED, func(conn *irc.Conn, line *irc.Line) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		lastSeen = time.Now()
+		if !watching {
+			watching = true
+			go watch()
+		}
+	})
+}
@@ -0,0 +1,13 @@
+package xdcc
+
+// LogLevel classifies the messages logged by the package.
+type LogLevel int
+
+const (
+	LogError LogLevel = iota
+	LogIRC
+	LogTransfers
+)
+
+// Logger receives the messages logged by the package. Messages are discarded by default.
+var Logger = func(level LogLevel, format string, args ...interface{}) {}
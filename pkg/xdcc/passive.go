@@ -0,0 +1,82 @@
+package xdcc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// passiveDCCTimeout is the time given to the bot to connect, once told where.
+const passiveDCCTimeout = time.Minute
+
+// acceptPassiveDCC answers a passive offer, made by the bots which cannot be reached, with the address where
+// the client listens, and waits for the bot to connect. The client must be reachable by the bot.
+func (transfer *Transfer) acceptPassiveDCC(send *XdccSendRes) (net.Conn, error) {
+	ip, err := transfer.passiveDCCAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	Logger(LogTransfers, "%s: waiting for %s to connect to %s", transfer.URL().String(), transfer.url.UserName,
+		net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+
+	reply := fmt.Sprintf("%s %s %s %d %d %s", send.Name(), send.FileName, formatDCCAddress(ip), port, send.FileSize, send.Token)
+	transfer.conn.Ctcp(transfer.url.UserName, DCC, reply)
+
+	// cancelling the transfer stops waiting
+	accepted := make(chan struct{})
+	defer close(accepted)
+	transfer.goroutines.Go(func() error {
+		select {
+		case <-transfer.ctx.Done():
+			listener.Close()
+		case <-accepted:
+		}
+		return nil
+	})
+
+	listener.SetDeadline(time.Now().Add(passiveDCCTimeout))
+	return listener.Accept()
+}
+
+// passiveDCCAddress returns the address given to the bots making passive offers: PassiveDCCAddress if set,
+// or else the local address of the route to the server.
+func (transfer *Transfer) passiveDCCAddress() (net.IP, error) {
+	if address := transfer.config.PassiveDCCAddress; address != "" {
+		ip := net.ParseIP(address)
+		if ip == nil {
+			return nil, errors.New("invalid passive DCC address: " + address)
+		}
+		return ip, nil
+	}
+
+	host := transfer.url.Network
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	// no packet is sent, the route is only looked up
+	conn, err := net.Dial("udp", net.JoinHostPort(host, "6667"))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// formatDCCAddress formats an address for a DCC message: IPv4 addresses as 32-bit integers, as bots expect.
+func formatDCCAddress(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return strconv.FormatUint(uint64(v4[0])<<24|uint64(v4[1])<<16|uint64(v4[2])<<8|uint64(v4[3]), 10)
+	}
+	return ip.String()
+}
@@ -0,0 +1,59 @@
+package xdcc
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// PinnedCertificates returns the SHA-256 fingerprints expected for the certificate of the server, if any.
+// The connections to servers with pinned certificates always use TLS, and are refused unless the
+// certificate of the server matches one of the fingerprints, whether or not it is signed by a known authority.
+var PinnedCertificates = func(server string) []string { return nil }
+
+// NormalizeFingerprint returns the SHA-256 fingerprint in lowercase hex, without the colons
+// used by tools such as openssl (e.g. "AB:CD:..." becomes "abcd...").
+func NormalizeFingerprint(fingerprint string) (string, error) {
+	fp := strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(fingerprint))
+	if len(fp) != 2*sha256.Size {
+		return "", fmt.Errorf("invalid SHA-256 fingerprint %q", fingerprint)
+	}
+
+	if _, err := hex.DecodeString(fp); err != nil {
+		return "", fmt.Errorf("invalid SHA-256 fingerprint %q", fingerprint)
+	}
+	return fp, nil
+}
+
+// CertificateFingerprint returns the SHA-256 fingerprint of the DER encoded certificate.
+func CertificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// verifyPinnedCertificate returns a tls.Config.VerifyPeerCertificate function accepting
+// only the certificates of the server matching one of the fingerprints.
+func verifyPinnedCertificate(server string, fingerprints []string) func([][]byte, [][]*x509.Certificate) error {
+	pinned := make(map[string]bool)
+	for _, fingerprint := range fingerprints {
+		if fp, err := NormalizeFingerprint(fingerprint); err == nil {
+			pinned[fp] = true
+		}
+	}
+
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New(server + ": no certificate")
+		}
+
+		// the leaf certificate comes first
+		fp := CertificateFingerprint(rawCerts[0])
+		if !pinned[fp] {
+			return fmt.Errorf("%s: certificate fingerprint %s does not match the pinned ones", server, fp)
+		}
+		return nil
+	}
+}
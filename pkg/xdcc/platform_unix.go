@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package xdcc
+
+// characters which are not allowed in file names, besides the path separators
+const invalidPathChars = ""
+
+func sanitizePlatformName(name string) string {
+	return name
+}
@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package xdcc
+
+import "strings"
+
+// characters which are not allowed in windows file names
+const invalidPathChars = `<>:"|?*`
+
+// sanitizePlatformName drops the trailing dots and spaces ignored by windows.
+func sanitizePlatformName(name string) string {
+	return strings.TrimRight(name, ". ")
+}
@@ -0,0 +1,32 @@
+package xdcc
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// TransferQueuedEvent reports that the bot queued the request, since all its slots are busy.
+// The file is offered once the request reaches the head of the queue.
+type TransferQueuedEvent struct {
+	Position int // position in the queue, 0 if not reported by the bot
+}
+
+var (
+	queueNoticeRegexp   = regexp.MustCompile(`(?i)added you to the \w+ queue|you (are|have been) (already )?queued|queue position|position in (the )?queue`)
+	queuePositionRegexp = regexp.MustCompile(`(?i)position\D{0,8}(\d+)`)
+)
+
+// parseQueueNotice tells whether the notice of a bot reports that the request was queued,
+// e.g. "Added you to the main queue for pack 3 ("file.mkv") in position 2", and the position if any.
+func parseQueueNotice(notice string) (int, bool) {
+	text := ircFormattingRegexp.ReplaceAllString(notice, "")
+	if !queueNoticeRegexp.MatchString(text) {
+		return 0, false
+	}
+
+	position := 0
+	if m := queuePositionRegexp.FindStringSubmatch(text); m != nil {
+		position, _ = strconv.Atoi(m[1])
+	}
+	return position, true
+}
@@ -0,0 +1,248 @@
+package xdcc
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// The read buffers are shared by the transfers, fast transfers reading up to maxDownloadBufSize at once.
+var downloadBufPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, maxDownloadBufSize)
+		return &buf
+	},
+}
+
+// fileWriteBufSize is the size of the buffer gathering the small reads of slow transfers into
+// larger writes. Larger reads are written to the file as they are, without being copied.
+const fileWriteBufSize = 256 << 10
+
+// Acknowledgements are cumulative, so those of fast transfers are batched: the received bytes are
+// acknowledged once ackBatchSize of them are pending, or after ackBatchDelay, which also keeps going
+// the bots waiting for each acknowledgement (they never reach batchedAckSpeed for long).
+const (
+	batchedAckSpeed = 8 << 20 // bytes per second
+	ackBatchSize    = 1 << 20
+	ackBatchDelay   = 20 * time.Millisecond
+)
+
+// dccAcker acknowledges the bytes received from the bot.
+type dccAcker struct {
+	mu       sync.Mutex
+	conn     net.Conn
+	name     string // of the transfer, for logging
+	fileSize uint64
+	buf      [8]byte
+	received uint64
+	acked    uint64
+	batched  bool
+	timer    *time.Timer
+	armed    bool
+	stopped  bool
+}
+
+func newDCCAcker(conn net.Conn, name string, fileSize uint64, acked uint64) *dccAcker {
+	return &dccAcker{conn: conn, name: name, fileSize: fileSize, received: acked, acked: acked}
+}
+
+// setSpeed batches the acknowledgements while the transfer is fast.
+func (a *dccAcker) setSpeed(speed float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.batched = speed >= batchedAckSpeed
+}
+
+// ack acknowledges the received bytes, now or soon if batched.
+func (a *dccAcker) ack(received uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.received = received
+	if !a.batched || received-a.acked >= ackBatchSize || received >= a.fileSize {
+		a.sendLocked()
+		return
+	}
+
+	if a.armed {
+		return
+	}
+	a.armed = true
+	if a.timer == nil {
+		a.timer = time.AfterFunc(ackBatchDelay, a.flush)
+	} else {
+		a.timer.Reset(ackBatchDelay)
+	}
+}
+
+func (a *dccAcker) flush() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.armed = false
+	if !a.stopped && a.received > a.acked {
+		a.sendLocked()
+	}
+}
+
+func (a *dccAcker) sendLocked() {
+	a.acked = a.received
+
+	// bots ignoring acknowledgements (turbo DCC) may have closed the connection already
+	if _, err := a.conn.Write(putDCCAck(a.buf[:], a.received, a.fileSize)); err != nil {
+		Logger(LogTransfers, "%s: unable to acknowledge %d bytes: %s", a.name, a.received, err)
+	}
+}
+
+// stop cancels the pending acknowledgement, if any.
+func (a *dccAcker) stop() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.stopped = true
+	if a.timer != nil {
+		a.timer.Stop()
+	}
+}
+
+// receiveWriter writes the received data to the file.
+type receiveWriter interface {
+	Write(p []byte) (int, error)
+	Flush() error // writes the data to the file, except what is kept back for alignment
+	Close() error // writes all the remaining data to the file
+}
+
+type bufferedWriter struct {
+	*bufio.Writer
+}
+
+func (w bufferedWriter) Close() error {
+	return w.Flush()
+}
+
+var errDirectIOUnsupported = errors.New("direct I/O is not supported on this platform")
+
+// O_DIRECT writes must be made of whole blocks, from buffers aligned in memory, at aligned offsets.
+const (
+	directIOAlignment = 4096
+	directIOBufSize   = 4 << 20
+)
+
+// directWriter writes to a file opened for direct I/O, which bypasses the page cache, so that fast
+// transfers are not slowed down by flushing it and do not evict the cached data of other programs.
+// The data is gathered in an aligned buffer, and the last partial block is written by Close through
+// a regular descriptor.
+type directWriter struct {
+	file *os.File
+	path string
+	buf  []byte
+	n    int
+}
+
+func newDirectWriter(file *os.File, path string) *directWriter {
+	buf := make([]byte, directIOBufSize+directIOAlignment)
+	if misalignment := int(uintptr(unsafe.Pointer(&buf[0])) & (directIOAlignment - 1)); misalignment != 0 {
+		buf = buf[directIOAlignment-misalignment:]
+	}
+	return &directWriter{file: file, path: path, buf: buf[:directIOBufSize]}
+}
+
+func (w *directWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := copy(w.buf[w.n:], p)
+		w.n += n
+		written += n
+		p = p[n:]
+
+		if w.n == len(w.buf) {
+			if err := w.Flush(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Flush writes the complete blocks of the buffer, keeping the last partial one.
+func (w *directWriter) Flush() error {
+	aligned := w.n &^ (directIOAlignment - 1)
+	if aligned == 0 {
+		return nil
+	}
+
+	if _, err := w.file.Write(w.buf[:aligned]); err != nil {
+		return err
+	}
+	w.n = copy(w.buf, w.buf[aligned:w.n])
+	return nil
+}
+
+func (w *directWriter) Close() error {
+	if err := w.Flush(); err != nil || w.n == 0 {
+		return err
+	}
+
+	file, err := os.OpenFile(w.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(w.buf[:w.n]); err != nil {
+		return err
+	}
+	w.n = 0
+	return file.Close()
+}
+
+// openReceivedFile opens the file receiving the data from the given offset, which is preallocated up
+// to fileSize, and returns the writer of the data. Direct I/O is used if asked and possible, or else
+// the data is buffered. A resumed file is cut at the offset, which the bot may have moved back.
+func openReceivedFile(filePath string, offset uint64, fileSize uint64, directIO bool) (*os.File, receiveWriter, error) {
+	flags := os.O_TRUNC | os.O_CREATE | os.O_WRONLY
+	if offset > 0 {
+		flags = os.O_CREATE | os.O_WRONLY
+	}
+
+	if directIO && offset%directIOAlignment == 0 {
+		file, err := openDirectFile(filePath, flags)
+		if err == nil {
+			if err := seekOffset(file, offset); err != nil {
+				file.Close()
+				return nil, nil, err
+			}
+			preallocate(file, filePath, offset, fileSize)
+			return file, newDirectWriter(file, filePath), nil
+		}
+		Logger(LogTransfers, "%s: unable to use direct I/O, writing through the page cache: %s", filePath, err)
+	}
+
+	file, err := os.OpenFile(filePath, flags, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := seekOffset(file, offset); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	preallocate(file, filePath, offset, fileSize)
+	return file, bufferedWriter{bufio.NewWriterSize(file, fileWriteBufSize)}, nil
+}
+
+// seekOffset cuts the file at the offset and moves to its end.
+func seekOffset(file *os.File, offset uint64) error {
+	if offset == 0 {
+		return nil
+	}
+	if err := file.Truncate(int64(offset)); err != nil {
+		return err
+	}
+	_, err := file.Seek(int64(offset), io.SeekStart)
+	return err
+}
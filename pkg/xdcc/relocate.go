@@ -0,0 +1,144 @@
+package xdcc
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// packListTimeout is the time given to the bot to list the renumbered pack.
+const packListTimeout = 30 * time.Second
+
+// TransferRelocatedEvent reports that the bot renumbered the pack, which offered another file,
+// and that the expected file is requested again under its new number.
+type TransferRelocatedEvent struct {
+	FileName string // offered by the old number
+	OldSlot  int
+	NewSlot  int
+}
+
+// XdccListReq asks the bot for its packlist.
+type XdccListReq struct{}
+
+func (list *XdccListReq) String() string {
+	return "xdcc list"
+}
+
+var (
+	// e.g. "#12   34x [700M] File.mkv"
+	packListLineRegexp = regexp.MustCompile(`^\s*#(\d+)\s+\d+x\s+\[[^\]]*\]\s+(.+?)\s*$`)
+	// e.g. ` - Pack #12 matches, "File.mkv"`, in reply to xdcc search
+	packSearchLineRegexp = regexp.MustCompile(`(?i)pack\s+#(\d+)\s+matches,?\s+"([^"]+)"`)
+)
+
+// parsePackListLine parses a line of the packlist of a bot, returning the number and the file of the pack.
+func parsePackListLine(line string) (int, string, bool) {
+	text := ircFormattingRegexp.ReplaceAllString(line, "")
+
+	m := packListLineRegexp.FindStringSubmatch(text)
+	if m == nil {
+		m = packSearchLineRegexp.FindStringSubmatch(text)
+	}
+	if m == nil {
+		return 0, "", false
+	}
+
+	slot, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, "", false
+	}
+	return slot, m[2], true
+}
+
+// sameFileName tells whether two names designate the same file, the indexes and the bots
+// sometimes differing on the case and on underscores in place of spaces.
+func sameFileName(a string, b string) bool {
+	normalize := func(name string) string {
+		return strings.ReplaceAll(strings.TrimSpace(name), "_", " ")
+	}
+	return strings.EqualFold(normalize(a), normalize(b))
+}
+
+// relocation is the search of the expected file in the packlist of the bot.
+type relocation struct {
+	offered string // file offered by the old number
+	found   bool
+	timer   *time.Timer
+}
+
+// slot returns the number of the requested pack.
+func (transfer *Transfer) slot() int {
+	transfer.mu.Lock()
+	defer transfer.mu.Unlock()
+	return transfer.url.Slot
+}
+
+// relocate checks that the offered file is the expected one. Otherwise the offer is declined
+// and the expected file is looked for in the packlist of the bot, returning true.
+func (transfer *Transfer) relocate(send *XdccSendRes) bool {
+	expected := transfer.config.ExpectedName
+	if expected == "" || sameFileName(send.FileName, expected) {
+		return false
+	}
+
+	transfer.mu.Lock()
+	defer transfer.mu.Unlock()
+
+	switch r := transfer.relocation; {
+	case r != nil && !r.found:
+		// e.g. the packlist itself, sent by DCC
+		Logger(LogIRC, "%s: ignoring the offer of %s while looking for %s", transfer.url.Network, send.FileName, expected)
+		return true
+	case r != nil:
+		transfer.notifyEvent(&TransferAbortedEvent{Error: fmt.Sprintf("pack #%d offers %s instead of %s", transfer.url.Slot, send.FileName, expected)})
+		return true
+	}
+
+	Logger(LogIRC, "%s: pack #%d offers %s instead of %s, looking for it in the packlist of %s",
+		transfer.url.Network, transfer.url.Slot, send.FileName, expected, transfer.url.UserName)
+	transfer.relocation = &relocation{offered: send.FileName, timer: time.AfterFunc(packListTimeout, transfer.relocationTimedOut)}
+	transfer.send(&XdccCancelReq{})
+	transfer.send(&XdccListReq{})
+	return true
+}
+
+// handlePackListLine requests the expected file again if the line of the packlist of the bot lists it.
+func (transfer *Transfer) handlePackListLine(line string) {
+	slot, name, ok := parsePackListLine(line)
+	if !ok || !sameFileName(name, transfer.config.ExpectedName) {
+		return
+	}
+
+	transfer.mu.Lock()
+	r := transfer.relocation
+	if r == nil || r.found {
+		transfer.mu.Unlock()
+		return
+	}
+	r.found = true
+	r.timer.Stop()
+	oldSlot := transfer.url.Slot
+	transfer.url.Slot = slot
+	transfer.mu.Unlock()
+
+	Logger(LogIRC, "%s: %s is now pack #%d, requesting it to %s", transfer.url.Network, name, slot, transfer.url.UserName)
+	transfer.notifyEvent(&TransferRelocatedEvent{FileName: r.offered, OldSlot: oldSlot, NewSlot: slot})
+	transfer.send(&XdccSendReq{Slot: slot, Secure: transfer.config.RequireTLSDCC})
+}
+
+func (transfer *Transfer) relocationTimedOut() {
+	transfer.mu.Lock()
+	r := transfer.relocation
+	if r.found {
+		transfer.mu.Unlock()
+		return
+	}
+	r.found = true // a late listing of the pack is ignored
+	slot := transfer.url.Slot
+	transfer.mu.Unlock()
+
+	transfer.notifyEvent(&TransferAbortedEvent{Error: fmt.Sprintf("pack #%d offers %s instead of %s, which %s does not list",
+		slot, r.offered, transfer.config.ExpectedName, transfer.url.UserName)})
+}
@@ -0,0 +1,151 @@
+package xdcc
+
+import (
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// ResumeBlockSize is the size of the blocks whose checksums are recorded while downloading.
+const ResumeBlockSize = 1 << 20
+
+// resumeSaveInterval is the number of downloaded blocks after which the checksums are saved.
+const resumeSaveInterval = 16
+
+// ResumeState holds the checksums of the complete blocks of a partial file, in order.
+type ResumeState struct {
+	BlockSize int      `json:"blockSize"`
+	Blocks    []string `json:"blocks"` // CRC32 of each block
+}
+
+// ResumeStore persists the checksums of partial files, so that they can be verified
+// before being resumed, e.g. after a crash.
+type ResumeStore interface {
+	Load(filePath string) (*ResumeState, error) // nil if the file is unknown
+	Save(filePath string, state *ResumeState) error
+	Delete(filePath string) error
+}
+
+// blockHasher computes the checksums of the blocks of a file while it is written.
+type blockHasher struct {
+	store    ResumeStore
+	filePath string
+	state    *ResumeState
+	hash     hash.Hash32
+	n        int // bytes of the current block hashed so far
+	unsaved  int // blocks not saved yet
+}
+
+func newBlockHasher(store ResumeStore, filePath string) *blockHasher {
+	return &blockHasher{
+		store:    store,
+		filePath: filePath,
+		state:    &ResumeState{BlockSize: ResumeBlockSize, Blocks: make([]string, 0)},
+		hash:     crc32.NewIEEE(),
+	}
+}
+
+func (hasher *blockHasher) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		chunk := ResumeBlockSize - hasher.n
+		if chunk > len(p) {
+			chunk = len(p)
+		}
+
+		hasher.hash.Write(p[:chunk])
+		hasher.n += chunk
+		p = p[chunk:]
+
+		if hasher.n == ResumeBlockSize {
+			hasher.state.Blocks = append(hasher.state.Blocks, fmt.Sprintf("%08x", hasher.hash.Sum32()))
+			hasher.hash.Reset()
+			hasher.n = 0
+			hasher.unsaved++
+		}
+	}
+	return written, nil
+}
+
+// save stores the checksums of the blocks. The data they refer to must have been written to the file.
+func (hasher *blockHasher) save() {
+	if hasher.unsaved == 0 {
+		return
+	}
+
+	if err := hasher.store.Save(hasher.filePath, hasher.state); err != nil {
+		Logger(LogError, "unable to save the checksums of %s: %s", hasher.filePath, err)
+		return
+	}
+	hasher.unsaved = 0
+}
+
+// discard forgets the checksums, once the file is complete.
+func (hasher *blockHasher) discard() {
+	hasher.unsaved = 0
+	if err := hasher.store.Delete(hasher.filePath); err != nil {
+		Logger(LogError, "unable to delete the checksums of %s: %s", hasher.filePath, err)
+	}
+}
+
+// verifyPartialFile checks the blocks of the partial file against the checksums recorded while
+// downloading it, and truncates the file after the last valid block, so that only the invalid
+// or unverifiable tail is downloaded again. Files downloaded without recording checksums are
+// trusted. It returns the position to resume from, and the hasher to continue with.
+func verifyPartialFile(store ResumeStore, filePath string) (uint64, *blockHasher, error) {
+	recorded, err := store.Load(filePath)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if recorded != nil && recorded.BlockSize != ResumeBlockSize {
+		recorded = &ResumeState{} // recorded by an incompatible version: nothing can be verified
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer file.Close()
+
+	hasher := newBlockHasher(store, filePath)
+	buf := make([]byte, ResumeBlockSize)
+
+	var position uint64
+	for {
+		n, err := io.ReadFull(file, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return 0, nil, err
+		}
+
+		if n < ResumeBlockSize { // end of the file
+			if recorded == nil {
+				hasher.Write(buf[:n])
+				position += uint64(n)
+			}
+			break
+		}
+
+		i := len(hasher.state.Blocks)
+		hasher.Write(buf)
+		if recorded != nil && (i >= len(recorded.Blocks) || recorded.Blocks[i] != hasher.state.Blocks[i]) {
+			if i < len(recorded.Blocks) {
+				Logger(LogError, "%s: corrupted data found at byte %d, downloading it again", filePath, position)
+			}
+			hasher.state.Blocks = hasher.state.Blocks[:i]
+			break
+		}
+		position += ResumeBlockSize
+	}
+	hasher.unsaved = 0
+
+	if info, err := file.Stat(); err == nil && uint64(info.Size()) > position {
+		Logger(LogTransfers, "%s: discarding %d unverified bytes after byte %d", filePath, uint64(info.Size())-position, position)
+		if err := os.Truncate(filePath, int64(position)); err != nil {
+			return 0, nil, err
+		}
+	}
+	return position, hasher, nil
+}
@@ -0,0 +1,206 @@
+package xdcc_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ostafen/xdcc-cli/internal/testbot"
+	"github.com/ostafen/xdcc-cli/pkg/xdcc"
+)
+
+const transferTimeout = 30 * time.Second
+
+var testData = bytes.Repeat([]byte("0123456789abcdef"), 20000)
+
+// runTransfer downloads a pack of the bot, returning the event ending the transfer and the requests received by the bot.
+func runTransfer(t *testing.T, botConfig testbot.Config, config xdcc.TransferConfig, slot int) (xdcc.TransferEvent, []string) {
+	t.Helper()
+
+	bot, err := testbot.Start(botConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bot.Close()
+
+	config.SkipCertificateCheck = true
+	transfer := xdcc.NewTransfer(bot.PackURL(slot), config)
+
+	ctx, cancel := context.WithTimeout(context.Background(), transferTimeout)
+	defer cancel()
+	if err := transfer.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		select {
+		case evt := <-transfer.PollEvents():
+			switch evt.(type) {
+			case *xdcc.TransferCompletedEvent, *xdcc.TransferAbortedEvent, *xdcc.TransferSkippedEvent:
+				cancel()
+				transfer.Wait() // the file is closed
+				return evt, bot.Requests()
+			}
+		case <-ctx.Done():
+			t.Fatal("transfer timed out")
+		}
+	}
+}
+
+func testPacks() []testbot.Pack {
+	return []testbot.Pack{{Slot: 1, Name: "file.bin", Data: testData}}
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "xdcc-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func assertCompleted(t *testing.T, evt xdcc.TransferEvent, path string) {
+	t.Helper()
+
+	if _, ok := evt.(*xdcc.TransferCompletedEvent); !ok {
+		t.Fatalf("transfer not completed: %#v", evt)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, testData) {
+		t.Fatalf("received %d bytes differing from the %d bytes sent", len(data), len(testData))
+	}
+}
+
+func TestActiveTransfer(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	evt, _ := runTransfer(t, testbot.Config{Packs: testPacks()}, xdcc.TransferConfig{FilePath: dir}, 1)
+	assertCompleted(t, evt, filepath.Join(dir, "file.bin"))
+}
+
+func TestTLSTransfer(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	evt, requests := runTransfer(t, testbot.Config{TLS: true, Packs: testPacks()},
+		xdcc.TransferConfig{FilePath: dir, EnableSSL: true, RequireTLSDCC: true}, 1)
+	assertCompleted(t, evt, filepath.Join(dir, "file.bin"))
+
+	if len(requests) == 0 || requests[0] != "xdcc ssend #1" {
+		t.Fatalf("the file was not requested over TLS: %q", requests)
+	}
+}
+
+func TestPassiveTransfer(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	evt, _ := runTransfer(t, testbot.Config{Passive: true, Packs: testPacks()}, xdcc.TransferConfig{FilePath: dir}, 1)
+	assertCompleted(t, evt, filepath.Join(dir, "file.bin"))
+}
+
+func TestResumeTransfer(t *testing.T) {
+	tests := []struct {
+		name    string
+		passive bool
+		rewind  int // of the resume position by the bot
+	}{
+		{name: "active"},
+		{name: "passive", passive: true},
+		{name: "rewound", rewind: 500},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := tempDir(t)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "file.bin")
+
+			// the end of the partial file is garbage, to be overwritten if the bot moves the position back
+			partial := append(append([]byte(nil), testData[:1000-test.rewind]...), bytes.Repeat([]byte("x"), test.rewind)...)
+			if err := ioutil.WriteFile(path, partial, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			evt, requests := runTransfer(t, testbot.Config{Passive: test.passive, ResumeRewind: test.rewind, Packs: testPacks()},
+				xdcc.TransferConfig{FilePath: dir, CollisionPolicy: xdcc.CollisionResume}, 1)
+			assertCompleted(t, evt, path)
+
+			resumed := false
+			for _, request := range requests {
+				resumed = resumed || strings.HasPrefix(request, "DCC RESUME file.bin")
+			}
+			if !resumed {
+				t.Fatalf("the transfer was not resumed: %q", requests)
+			}
+		})
+	}
+}
+
+func TestResumeCompletedFile(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "file.bin"), testData, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	evt, _ := runTransfer(t, testbot.Config{Packs: testPacks()}, xdcc.TransferConfig{FilePath: dir, CollisionPolicy: xdcc.CollisionResume}, 1)
+	if _, ok := evt.(*xdcc.TransferSkippedEvent); !ok {
+		t.Fatalf("completed file not skipped: %#v", evt)
+	}
+}
+
+func TestRelocatedTransfer(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	bot, err := testbot.Start(testbot.Config{Packs: []testbot.Pack{
+		{Slot: 1, Name: "other.bin", Data: []byte("renumbered")},
+		{Slot: 2, Name: "file.bin", Data: testData},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bot.Close()
+
+	transfer := xdcc.NewTransfer(bot.PackURL(1), xdcc.TransferConfig{FilePath: dir, ExpectedName: "file.bin"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), transferTimeout)
+	defer cancel()
+	if err := transfer.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	relocated := false
+	for done := false; !done; {
+		select {
+		case evt := <-transfer.PollEvents():
+			switch e := evt.(type) {
+			case *xdcc.TransferRelocatedEvent:
+				relocated = e.OldSlot == 1 && e.NewSlot == 2
+			case *xdcc.TransferCompletedEvent, *xdcc.TransferAbortedEvent:
+				assertCompleted(t, evt, filepath.Join(dir, "file.bin"))
+				done = true
+			}
+		case <-ctx.Done():
+			t.Fatal("transfer timed out")
+		}
+		transfer.URL() // read while the slot may change
+	}
+
+	if !relocated {
+		t.Fatal("the pack was not looked for in the packlist")
+	}
+	if slot := transfer.URL().Slot; slot != 2 {
+		t.Fatalf("url of pack #%d, expected #2", slot)
+	}
+}
@@ -0,0 +1,188 @@
+package xdcc
+
+import (
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"strconv"
+	"strings"
+)
+
+// IRCFileURL identifies a pack offered by a bot on an IRC network.
+type IRCFileURL struct {
+	Network  string
+	Channel  string
+	UserName string
+	Slot     int
+}
+
+type IRCBot struct {
+	Network string
+	Channel string
+	Name    string
+}
+
+const ircFileURLFields = 4
+
+// ParseSlot parses a pack number such as "#42".
+func ParseSlot(slotStr string) (int, error) {
+	return strconv.Atoi(strings.TrimPrefix(slotStr, "#"))
+}
+
+// ParseURL parses an url of the following format: irc://network/channel/bot/#slot.
+// Deep links of the form irc://network/channel?bot=bot&pack=slot are accepted as well,
+// and the xdcc:// scheme can be used in place of irc://.
+func ParseURL(url string) (*IRCFileURL, error) {
+	var rest string
+	switch {
+	case strings.HasPrefix(url, "irc://"):
+		rest = strings.TrimPrefix(url, "irc://")
+	case strings.HasPrefix(url, "xdcc://"):
+		rest = strings.TrimPrefix(url, "xdcc://")
+	default:
+		return nil, errors.New("not an IRC url")
+	}
+
+	if strings.Contains(rest, "?") {
+		return parseDeepLink(rest)
+	}
+
+	fields := strings.Split(rest, "/")
+	if len(fields) != ircFileURLFields {
+		return nil, errors.New("invalid IRC url")
+	}
+
+	slot, err := ParseSlot(fields[3])
+	if err != nil {
+		return nil, err
+	}
+
+	fileUrl := &IRCFileURL{
+		Network:  fields[0],
+		Channel:  fields[1],
+		UserName: fields[2],
+		Slot:     slot,
+	}
+
+	if !strings.HasPrefix(fileUrl.Channel, "#") {
+		fileUrl.Channel = "#" + fileUrl.Channel
+	}
+	return fileUrl, nil
+}
+
+// parseDeepLink parses the part following the scheme of an url such as irc://network/channel?bot=bot&pack=slot.
+func parseDeepLink(link string) (*IRCFileURL, error) {
+	path, rawQuery := link, ""
+	if i := strings.Index(link, "?"); i >= 0 {
+		path, rawQuery = link[:i], link[i+1:]
+	}
+
+	// an unescaped "#" in the channel name would otherwise be taken as the fragment
+	path, err := neturl.PathUnescape(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := strings.Split(strings.TrimSuffix(path, "/"), "/")
+	if len(fields) != 2 || fields[0] == "" || strings.TrimPrefix(fields[1], "#") == "" {
+		return nil, errors.New("invalid IRC url: network and channel expected")
+	}
+
+	query, err := neturl.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	bot := query.Get("bot")
+	if bot == "" {
+		return nil, errors.New("invalid IRC url: missing bot")
+	}
+
+	slot, err := ParseSlot(query.Get("pack"))
+	if err != nil {
+		return nil, errors.New("invalid IRC url: invalid pack")
+	}
+
+	fileUrl := &IRCFileURL{
+		Network:  fields[0],
+		Channel:  fields[1],
+		UserName: bot,
+		Slot:     slot,
+	}
+
+	if !strings.HasPrefix(fileUrl.Channel, "#") {
+		fileUrl.Channel = "#" + fileUrl.Channel
+	}
+	return fileUrl, nil
+}
+
+// NetworkAliases maps the short names of some networks, usable in pack references, to their servers.
+var NetworkAliases = map[string]string{
+	"rizon":      "irc.rizon.net",
+	"abjects":    "irc.abjects.net",
+	"xertion":    "irc.xertion.org",
+	"criten":     "irc.criten.net",
+	"scenep2p":   "irc.scenep2p.net",
+	"irchighway": "irc.irchighway.net",
+}
+
+// resolveNetwork returns the server of the network, which can be given by its alias.
+func resolveNetwork(network string) string {
+	if server, ok := NetworkAliases[strings.ToLower(network)]; ok {
+		return server
+	}
+	return network
+}
+
+// networkAlias returns the alias of the server, if any.
+func networkAlias(server string) string {
+	for alias, s := range NetworkAliases {
+		if strings.EqualFold(s, server) {
+			return alias
+		}
+	}
+	return server
+}
+
+// ParsePackRef parses a pack reference of the form network/#channel/bot/#slot, such as rizon/#nibl/Bot/#123,
+// where the network can be given by its alias (see NetworkAliases). Urls accepted by ParseURL are accepted as well.
+func ParsePackRef(ref string) (*IRCFileURL, error) {
+	if strings.HasPrefix(ref, "irc://") || strings.HasPrefix(ref, "xdcc://") {
+		return ParseURL(ref)
+	}
+
+	fields := strings.Split(ref, "/")
+	if len(fields) != ircFileURLFields || fields[0] == "" || fields[2] == "" {
+		return nil, errors.New("invalid pack reference: network/#channel/bot/#slot expected")
+	}
+
+	// the "#" tells the channel apart from a path
+	if !strings.HasPrefix(fields[1], "#") || len(fields[1]) < 2 {
+		return nil, errors.New("invalid pack reference: the channel must be given as #channel")
+	}
+
+	slot, err := ParseSlot(fields[3])
+	if err != nil {
+		return nil, errors.New("invalid pack reference: invalid slot " + fields[3])
+	}
+
+	return &IRCFileURL{
+		Network:  resolveNetwork(fields[0]),
+		Channel:  fields[1],
+		UserName: fields[2],
+		Slot:     slot,
+	}, nil
+}
+
+// PackRef returns the reference of the pack in the format parsed by ParsePackRef, using the alias of the network if any.
+func (url *IRCFileURL) PackRef() string {
+	return fmt.Sprintf("%s/%s/%s/#%d", networkAlias(url.Network), url.Channel, url.UserName, url.Slot)
+}
+
+func (url *IRCFileURL) GetBot() IRCBot {
+	return IRCBot{Network: url.Network, Channel: url.Channel, Name: url.UserName}
+}
+
+func (url *IRCFileURL) String() string {
+	return fmt.Sprintf("irc://%s/%s/%s/#%d", url.Network, url.Channel, url.UserName, url.Slot)
+}
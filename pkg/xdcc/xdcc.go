@@ -0,0 +1,986 @@
+// Package xdcc downloads the files offered by XDCC bots on IRC networks.
+package xdcc
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	irc "github.com/fluffle/goirc/client"
+	"github.com/ostafen/xdcc-cli/internal/group"
+)
+
+const IRCClientUserName = "basedbogsnak"
+
+type CTCPRequest interface {
+	String() string
+}
+
+type CTCPResponse interface {
+	Parse(args []string) error
+	Name() string
+}
+
+type XdccSendReq struct {
+	Slot   int
+	Secure bool
+}
+
+// XdccCancelReq stops the file currently being sent by the bot.
+type XdccCancelReq struct{}
+
+func (cancel *XdccCancelReq) String() string {
+	return "xdcc cancel"
+}
+
+// XdccRemoveReq removes the client requests from the bot queue.
+type XdccRemoveReq struct{}
+
+func (remove *XdccRemoveReq) String() string {
+	return "xdcc remove"
+}
+
+func (send *XdccSendReq) String() string {
+	if send.Secure {
+		return fmt.Sprintf("xdcc ssend #%d", send.Slot)
+	}
+	return fmt.Sprintf("xdcc send #%d", send.Slot)
+}
+
+type XdccSendRes struct {
+	FileName string
+	IP       net.IP
+	Port     int
+	FileSize uint64
+	Secure   bool   // true if the file is sent over TLS (SSEND)
+	Token    string // set by passive offers, whose port is 0, see acceptPassiveDCC
+}
+
+func uint32ToIP(n uint64) net.IP {
+	a := byte((n >> 24) & 255)
+	b := byte((n >> 16) & 255)
+	c := byte((n >> 8) & 255)
+	d := byte(n & 255)
+	return net.IPv4(a, b, c, d)
+}
+
+const XdccSendResArgs = 4
+
+func (send *XdccSendRes) Name() string {
+	if send.Secure {
+		return SSEND
+	}
+	return SEND
+}
+
+func (send *XdccSendRes) Parse(args []string) error {
+	if len(args) != XdccSendResArgs && len(args) != XdccSendResArgs+1 {
+		return errors.New("invalid number of arguments")
+	}
+
+	send.FileName = args[0]
+
+	var err error
+	send.IP, err = parseDCCAddress(args[1])
+
+	if err != nil {
+		return err
+	}
+
+	send.Port, err = strconv.Atoi(args[2])
+
+	if err != nil {
+		return err
+	}
+
+	// sizes above 4 GiB are sent by the bots supporting 64-bit transfers
+	send.FileSize, err = strconv.ParseUint(args[3], 10, 64)
+
+	if err != nil {
+		return err
+	}
+
+	if len(args) > XdccSendResArgs {
+		send.Token = args[4]
+	}
+	return nil
+}
+
+// Passive tells whether the bot waits for the client to tell where to connect, rather than
+// waiting for the client to connect.
+func (send *XdccSendRes) Passive() bool {
+	return send.Port == 0 && send.Token != ""
+}
+
+// parseDCCAddress parses the address of a DCC offer: an IPv4 address encoded as
+// a 32-bit integer, or an IPv6 (or dotted IPv4) address.
+func parseDCCAddress(s string) (net.IP, error) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32ToIP(n), nil
+	}
+
+	if ip := net.ParseIP(s); ip != nil {
+		return ip, nil
+	}
+	return nil, errors.New("invalid address: " + s)
+}
+
+// putDCCAck encodes the acknowledgement of the received bytes into buf, which must hold 8 bytes.
+// Acknowledgements are 32-bit, unless the file is larger than 4 GiB.
+func putDCCAck(buf []byte, received uint64, fileSize uint64) []byte {
+	if fileSize > math.MaxUint32 {
+		binary.BigEndian.PutUint64(buf, received)
+		return buf[:8]
+	}
+
+	binary.BigEndian.PutUint32(buf, uint32(received))
+	return buf[:4]
+}
+
+// XdccResumeReq asks the bot to resume the transfer of a file from the given position.
+type XdccResumeReq struct {
+	FileName string
+	Port     int
+	Position uint64
+	Token    string // of a passive offer
+}
+
+func (resume *XdccResumeReq) String() string {
+	if resume.Token != "" {
+		return fmt.Sprintf("RESUME %s %d %d %s", resume.FileName, resume.Port, resume.Position, resume.Token)
+	}
+	return fmt.Sprintf("RESUME %s %d %d", resume.FileName, resume.Port, resume.Position)
+}
+
+// XdccAcceptRes is the bot answer to a resume request.
+type XdccAcceptRes struct {
+	FileName string
+	Port     int
+	Position uint64
+	Token    string // of a passive offer
+}
+
+const XdccAcceptResArgs = 3
+
+func (accept *XdccAcceptRes) Name() string {
+	return ACCEPT
+}
+
+func (accept *XdccAcceptRes) Parse(args []string) error {
+	if len(args) != XdccAcceptResArgs && len(args) != XdccAcceptResArgs+1 {
+		return errors.New("invalid number of arguments")
+	}
+	if len(args) > XdccAcceptResArgs {
+		accept.Token = args[3]
+	}
+
+	accept.FileName = args[0]
+
+	var err error
+	accept.Port, err = strconv.Atoi(args[1])
+	if err != nil {
+		return err
+	}
+
+	accept.Position, err = strconv.ParseUint(args[2], 10, 64)
+	return err
+}
+
+const (
+	SEND    = "SEND"
+	SSEND   = "SSEND"
+	ACCEPT  = "ACCEPT"
+	DCC     = "DCC"
+	VERSION = "\x01VERSION\x01"
+)
+
+func parseCTCPRes(text string) (CTCPResponse, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return nil, errors.New("empty CTCP message")
+	}
+
+	var resp CTCPResponse = nil
+
+	switch strings.TrimSpace(fields[0]) {
+	case SEND:
+		resp = &XdccSendRes{}
+	case SSEND:
+		resp = &XdccSendRes{Secure: true}
+	case ACCEPT:
+		resp = &XdccAcceptRes{}
+	case CHAT:
+		resp = &XdccChatRes{}
+	case SCHAT:
+		resp = &XdccChatRes{Secure: true}
+	case VERSION:
+		return nil, nil
+	}
+
+	if resp == nil {
+		return nil, errors.New("invalid command: " + fields[0])
+	}
+
+	err := resp.Parse(fields[1:])
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+const defaultEventChanSize = 1024
+
+// Start connects to the network and requests the file to the bot.
+// Cancelling ctx aborts the transfer, letting the bot know about it.
+func (transfer *Transfer) Start(ctx context.Context) error {
+	transfer.ctx = ctx
+	if err := transfer.conn.Connect(); err != nil {
+		return err
+	}
+
+	transfer.goroutines.Go(func() error {
+		transfer.watchCancellation()
+		return nil
+	})
+	return nil
+}
+
+// Wait waits for the goroutines of the transfer to return once it is over or cancelled,
+// so that the file is written and closed.
+func (transfer *Transfer) Wait() {
+	transfer.goroutines.Wait()
+}
+
+// watchCancellation cleanly stops the transfer when its context is cancelled.
+func (transfer *Transfer) watchCancellation() {
+	select {
+	case <-transfer.ctx.Done():
+	case <-transfer.done:
+		return
+	}
+
+	select {
+	case <-transfer.done: // the transfer was already over when cancelled
+		return
+	default:
+	}
+
+	transfer.mu.Lock()
+	dccConn := transfer.dccConn
+	transfer.mu.Unlock()
+
+	if dccConn != nil {
+		transfer.send(&XdccCancelReq{})
+		dccConn.Close() // makes the download loop abort
+	} else {
+		transfer.send(&XdccRemoveReq{})
+		transfer.notifyEvent(&TransferAbortedEvent{Error: "cancelled"})
+	}
+	transfer.conn.Quit()
+}
+
+// TransferEvent is one of the Transfer*Event types, delivered by PollEvents.
+type TransferEvent interface{}
+
+type TransferAbortedEvent struct {
+	Error       string
+	Interrupted bool // the connection to the server or to the bot was lost, the file can be requested again
+	Refused     bool // the bot refused the request
+}
+
+// Reconnections to the server, waiting reconnectDelay before the first attempt
+// and twice as long before each of the next ones, up to maxReconnectDelay.
+const (
+	maxConnAttempts   = 8
+	reconnectDelay    = time.Second
+	maxReconnectDelay = 2 * time.Minute
+)
+
+// TransferConfig holds the settings of a transfer.
+type TransferConfig struct {
+	FilePath             string
+	DestTemplate         string // destination of the file, relative to FilePath (e.g. "{network}/{bot}/{name}")
+	NameSuffix           string // appended to the file name to avoid conflicts within a batch
+	CollisionPolicy      string // what to do when the destination file already exists (including CollisionResume)
+	EnableSSL            bool
+	SkipCertificateCheck bool
+	RequireTLSDCC        bool        // refuse plaintext DCC transfers
+	DirectIO             bool        // write the files bypassing the page cache, where supported
+	ResumeStore          ResumeStore // records the checksums of partial files, to verify them when resuming
+	DryRun               bool        // stop at the offer of the bot, reporting it with a TransferOfferedEvent
+	NoAutoJoin           bool        // abort instead of joining the channels required by the bot
+	FServe               *FServe     // get the file from the fserve of the bot rather than requesting the pack
+	TrustFileNames       bool        // save the files under the names offered by the bots, including their folders
+	ExpectedName         string      // file expected from the pack, looked for in the packlist of the bot if another is offered
+	PassiveDCCAddress    string      // address given to the bots making passive offers, the local address of the connection by default
+
+	// VerifyBot, if set, is called with the identity of the bot when it offers the file, which is
+	// refused if it fails. The bot is then asked its CTCP VERSION along with the file.
+	VerifyBot func(bot BotIdentity) error
+
+	// ReserveSpace, if set, is called before receiving a file with the number of bytes left to download.
+	// The transfer is cancelled if it fails, otherwise release is called once the file is received.
+	ReserveSpace func(filePath string, size uint64) (release func(), err error)
+}
+
+// Transfer is the download of a single file from a bot.
+type Transfer struct {
+	config       TransferConfig
+	url          IRCFileURL
+	conn         *irc.Conn
+	connAttempts int
+	started      bool
+	requested    time.Time // when the file was requested to the bot
+	events       chan TransferEvent
+	ctx          context.Context
+	goroutines   group.Group // receiving the file, and stopping the transfer when cancelled
+
+	mu       sync.Mutex
+	dccConn  net.Conn      // set while the file is being received
+	done     chan struct{} // closed once the transfer is over
+	doneOnce sync.Once
+	leaving  bool // set by Leave, to avoid reconnecting
+
+	pendingResume *pendingResume // guarded by mu
+
+	joined       map[string]bool // channels joined, guarded by mu
+	pendingJoins map[string]bool // channels required by the bot and not joined yet, guarded by mu
+	autoJoins    int             // number of times the bot asked to join some channels, guarded by mu
+
+	bot        BotIdentity // guarded by mu
+	relocation *relocation // guarded by mu, set once another file than expected is offered
+}
+
+// pendingResume is a transfer waiting for the bot to accept a resume request.
+type pendingResume struct {
+	send     *XdccSendRes
+	filePath string
+	position uint64
+	hasher   *blockHasher
+}
+
+// NewIRCConfig returns the configuration of a connection to the server, using a random nick unless
+// NetworkIdentity has an identity for the server. The certificates of the servers listed by PinnedCertificates
+// are verified against their fingerprints, and the client presents itself as described by Client.
+func NewIRCConfig(server string, enableSSL bool, skipCertificateCheck bool) *irc.Config {
+	rand.Seed(time.Now().UTC().UnixNano())
+	nick := IRCClientUserName + strconv.Itoa(int(rand.Uint32()))
+
+	config := irc.NewConfig(nick)
+	applyClientProfile(config)
+	if identity := NetworkIdentity(server); identity != nil {
+		applyIdentity(config, identity)
+	}
+	config.SSL = enableSSL
+	config.SSLConfig = &tls.Config{ServerName: server, InsecureSkipVerify: skipCertificateCheck}
+
+	if pins := PinnedCertificates(server); len(pins) > 0 {
+		// the pinned fingerprints replace the verification by the certificate authorities
+		config.SSL = true
+		config.SSLConfig.InsecureSkipVerify = true
+		config.SSLConfig.VerifyPeerCertificate = verifyPinnedCertificate(server, pins)
+	}
+	config.Server = server
+	config.PingFreq = KeepAliveInterval
+	config.NewNick = func(nick string) string {
+		return nick + "" + strconv.Itoa(int(rand.Uint32()))
+	}
+	return config
+}
+
+// NewTransfer prepares the download of the file identified by url.
+func NewTransfer(url IRCFileURL, transferConfig TransferConfig) *Transfer {
+	config := NewIRCConfig(url.Network, transferConfig.EnableSSL, transferConfig.SkipCertificateCheck)
+	conn := irc.Client(config)
+
+	t := &Transfer{
+		conn:         conn,
+		url:          url,
+		config:       transferConfig,
+		started:      false,
+		connAttempts: 0,
+		events:       make(chan TransferEvent, defaultEventChanSize),
+		ctx:          context.Background(),
+		done:         make(chan struct{}),
+		joined:       make(map[string]bool),
+		pendingJoins: make(map[string]bool),
+	}
+	t.setupHandlers(url.Channel, url.UserName)
+	return t
+}
+
+func (transfer *Transfer) send(req CTCPRequest) {
+	transfer.conn.Privmsg(transfer.url.UserName, req.String())
+}
+
+func (transfer *Transfer) setupHandlers(channel string, userName string) {
+	conn := transfer.conn
+
+	// e.g. join channel on connect, once identified. After a reconnection,
+	// the channels required by the bot are joined again as well.
+	HandleIdentity(conn, transfer.url.Network, func(conn *irc.Conn) {
+		channels := transfer.channelsToJoin()
+		Logger(LogIRC, "%s: connected, joining %s", transfer.url.Network, strings.Join(channels, ", "))
+		transfer.connAttempts = 0
+		for _, channel := range channels {
+			conn.Join(channel)
+		}
+	})
+	HandleKeepAlive(conn, transfer.url.Network)
+
+	conn.HandleFunc(irc.ERROR, func(conn *irc.Conn, line *irc.Line) {
+		Logger(LogError, "%s: %s", transfer.url.Network, line.Text())
+	})
+
+	// send xdcc send on successfull join, and again once the channels required by the bot are joined
+	conn.HandleFunc(irc.JOIN,
+		func(conn *irc.Conn, line *irc.Line) {
+			if line.Nick != conn.Me().Nick || transfer.started {
+				return
+			}
+
+			if transfer.channelJoined(line.Args[0]) {
+				transfer.requested = time.Now()
+				if transfer.config.FServe != nil {
+					transfer.requestFServe()
+					return
+				}
+
+				slot := transfer.slot() // possibly renumbered before a reconnection
+				Logger(LogIRC, "%s: joined %s, requesting pack #%d to %s", transfer.url.Network, line.Args[0], slot, userName)
+				transfer.send(&XdccSendReq{Slot: slot, Secure: transfer.config.RequireTLSDCC})
+				if transfer.config.VerifyBot != nil {
+					conn.Ctcp(userName, "VERSION")
+				}
+			}
+		})
+
+	conn.HandleFunc(irc.PRIVMSG, func(conn *irc.Conn, line *irc.Line) {
+		if strings.EqualFold(line.Nick, userName) {
+			transfer.handlePackListLine(line.Text())
+		}
+	})
+
+	conn.HandleFunc(irc.NOTICE, func(conn *irc.Conn, line *irc.Line) {
+		Logger(LogIRC, "%s: notice from %s: %s", transfer.url.Network, line.Nick, line.Text())
+		if !strings.EqualFold(line.Nick, userName) {
+			return
+		}
+
+		if position, queued := parseQueueNotice(line.Text()); queued && !transfer.started {
+			transfer.notifyEvent(&TransferQueuedEvent{Position: position})
+		}
+		transfer.handleBotNotice(conn, line.Text())
+		transfer.handlePackListLine(line.Text())
+	})
+
+	HandleCTCPRequests(conn, transfer.url.Network)
+	conn.HandleFunc(irc.CTCPREPLY, func(conn *irc.Conn, line *irc.Line) {
+		if strings.EqualFold(line.Nick, userName) {
+			transfer.handleVersionReply(line)
+		}
+	})
+	conn.HandleFunc(irc.CTCP,
+		func(conn *irc.Conn, line *irc.Line) {
+			Logger(LogIRC, "%s: ctcp from %s: %s %s", transfer.url.Network, line.Nick, line.Args[0], line.Text())
+			// offers from other users than the bot could impersonate it, or abort the transfer
+			if line.Args[0] != DCC || !strings.EqualFold(line.Nick, userName) {
+				return
+			}
+			transfer.setBotAddress(line)
+
+			res, err := parseCTCPRes(line.Text())
+			if err != nil {
+				transfer.notifyEvent(&TransferAbortedEvent{Error: err.Error()})
+				conn.Quit()
+				return
+			}
+			transfer.handleCTCPRes(res)
+		})
+
+	conn.HandleFunc(irc.DISCONNECTED,
+		func(conn *irc.Conn, line *irc.Line) {
+			for transfer.connAttempts < maxConnAttempts {
+				transfer.mu.Lock()
+				leaving := transfer.leaving
+				transfer.mu.Unlock()
+
+				if transfer.ctx.Err() != nil || leaving {
+					return
+				}
+
+				delay := reconnectDelay << uint(transfer.connAttempts)
+				if delay > maxReconnectDelay {
+					delay = maxReconnectDelay
+				}
+				transfer.connAttempts++
+				Logger(LogIRC, "%s: disconnected, reconnecting in %s (attempt %d/%d)", transfer.url.Network, delay, transfer.connAttempts, maxConnAttempts)
+
+				select {
+				case <-time.After(delay):
+				case <-transfer.ctx.Done():
+					return
+				case <-transfer.done:
+					return
+				}
+
+				err := conn.Connect()
+				if err == nil {
+					return
+				}
+				Logger(LogError, "%s: unable to reconnect: %s", transfer.url.Network, err)
+			}
+
+			// a file being received does not need the connection to the server
+			if !transfer.started {
+				transfer.notifyEvent(&TransferAbortedEvent{Error: "disconnected from server", Interrupted: true})
+			}
+		})
+}
+
+// PollEvents returns the channel delivering the events of the transfer.
+func (transfer *Transfer) PollEvents() chan TransferEvent {
+	return transfer.events
+}
+
+// URL returns the url of the transferred file, whose slot changes if the bot renumbered the pack.
+func (transfer *Transfer) URL() *IRCFileURL {
+	transfer.mu.Lock()
+	defer transfer.mu.Unlock()
+
+	url := transfer.url
+	return &url
+}
+
+type TransferProgressEvent struct {
+	Bytes uint64
+	Rate  float32
+}
+
+// The size of the reads of a transfer adapts to its speed: fast transfers use large reads to
+// reduce the CPU overhead, and slow transfers report their progress more often.
+const (
+	minDownloadBufSize     = 4 << 10
+	initialDownloadBufSize = 16 << 10
+	maxDownloadBufSize     = 4 << 20
+	targetReadsPerSecond   = 50
+
+	slowTransferSpeed          = 256 << 10 // bytes per second
+	slowTransferUpdateInterval = 250 * time.Millisecond
+	progressUpdateInterval     = time.Second
+)
+
+// adaptDownloadBufSize returns the read size giving about targetReadsPerSecond reads at the given speed.
+func adaptDownloadBufSize(speed float64) int {
+	size := minDownloadBufSize
+	for size < maxDownloadBufSize && float64(size)*targetReadsPerSecond < speed {
+		size *= 2
+	}
+	return size
+}
+
+// adaptUpdateInterval returns how often the progress of a transfer at the given speed is reported.
+func adaptUpdateInterval(speed float64) time.Duration {
+	if speed < slowTransferSpeed {
+		return slowTransferUpdateInterval
+	}
+	return progressUpdateInterval
+}
+
+type TransferStartedEvent struct {
+	FileName string
+	FilePath string
+	FileSize uint64
+	Offset   uint64 // number of bytes already downloaded when resuming
+}
+
+type TransferSkippedEvent struct {
+	FileName string
+	Reason   string
+}
+
+type TransferCompletedEvent struct {
+	FileSize uint64
+}
+
+// TransferOfferedEvent reports the file offered by the bot in a dry run, which is not downloaded.
+type TransferOfferedEvent struct {
+	FileName     string
+	FileSize     uint64
+	Secure       bool          // offered over TLS (SSEND)
+	ResponseTime time.Duration // time taken by the bot to answer the request
+}
+
+func (transfer *Transfer) notifyEvent(e TransferEvent) {
+	switch e.(type) {
+	case *TransferCompletedEvent, *TransferAbortedEvent, *TransferSkippedEvent, *TransferOfferedEvent:
+		transfer.doneOnce.Do(func() { close(transfer.done) })
+	}
+
+	select {
+	case transfer.events <- e:
+	default:
+		break
+	}
+}
+
+type SpeedMonitorReader struct {
+	reader         io.Reader
+	elapsedTime    time.Duration
+	currValue      uint64
+	currentSpeed   float64
+	updateInterval time.Duration
+	onUpdate       func(amount int, speed float64)
+}
+
+func NewSpeedMonitorReader(reader io.Reader, onUpdate func(int, float64)) *SpeedMonitorReader {
+	return &SpeedMonitorReader{
+		reader:         reader,
+		elapsedTime:    time.Duration(0),
+		currValue:      0,
+		currentSpeed:   0,
+		updateInterval: progressUpdateInterval,
+		onUpdate:       onUpdate,
+	}
+}
+
+// SetUpdateInterval changes how often onUpdate is called.
+func (monitor *SpeedMonitorReader) SetUpdateInterval(interval time.Duration) {
+	monitor.updateInterval = interval
+}
+
+func (monitor *SpeedMonitorReader) Read(buf []byte) (int, error) {
+	now := time.Now()
+	n, err := monitor.reader.Read(buf)
+	elapsedTime := time.Since(now)
+	monitor.currValue += uint64(n)
+	monitor.elapsedTime += elapsedTime
+
+	if monitor.elapsedTime > monitor.updateInterval {
+		monitor.currentSpeed = float64(monitor.currValue) / monitor.elapsedTime.Seconds()
+		monitor.onUpdate(int(monitor.currValue), monitor.currentSpeed)
+		monitor.currValue = 0
+		monitor.elapsedTime = time.Duration(0)
+	}
+	return n, err
+}
+
+// destinationPath returns the path where the file will be written, creating
+// the missing directories. An empty path means that the file must be skipped.
+// The name offered by the bot is sanitized, unless trusted, and the file is
+// never written outside of the download folder.
+func (transfer *Transfer) destinationPath(fileName string) (string, error) {
+	name := AddNameSuffix(fileName, transfer.config.NameSuffix)
+	if !transfer.config.TrustFileNames {
+		name = SanitizeFileName(name)
+	}
+
+	filePath := filepath.Join(transfer.config.FilePath, expandDestTemplate(transfer.config.DestTemplate, *transfer.URL(), name, time.Now()))
+	if !isWithinDir(filePath, transfer.config.FilePath) {
+		return "", fmt.Errorf("refusing to write %q outside of the download folder", fileName)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return "", err
+	}
+	return resolveCollision(filePath, transfer.config.CollisionPolicy)
+}
+
+func (transfer *Transfer) handleXdccSendRes(send *XdccSendRes) {
+	if transfer.relocate(send) {
+		return
+	}
+
+	if transfer.config.DryRun {
+		transfer.send(&XdccCancelReq{})
+		transfer.notifyEvent(&TransferOfferedEvent{
+			FileName:     send.FileName,
+			FileSize:     send.FileSize,
+			Secure:       send.Secure,
+			ResponseTime: time.Since(transfer.requested),
+		})
+		return
+	}
+
+	if send.Passive() && send.Secure {
+		transfer.send(&XdccCancelReq{})
+		transfer.notifyEvent(&TransferAbortedEvent{Error: "passive TLS DCC offers are not supported: " + send.FileName})
+		return
+	}
+
+	if !send.Secure && transfer.config.RequireTLSDCC {
+		transfer.notifyEvent(&TransferAbortedEvent{Error: "refusing plaintext transfer of " + send.FileName + ": TLS DCC is required"})
+		return
+	}
+
+	if !transfer.verifyBot() {
+		return
+	}
+
+	filePath, err := transfer.destinationPath(send.FileName)
+	if err != nil {
+		transfer.notifyEvent(&TransferAbortedEvent{Error: err.Error()})
+		return
+	}
+
+	if filePath == "" {
+		transfer.notifyEvent(&TransferSkippedEvent{FileName: send.FileName, Reason: "file already exists"})
+		return
+	}
+
+	if transfer.config.CollisionPolicy == CollisionResume {
+		if info, err := os.Stat(filePath); err == nil && info.Size() > 0 {
+			transfer.goroutines.Go(func() error {
+				transfer.resume(send, filePath, uint64(info.Size()))
+				return nil
+			})
+			return
+		}
+	}
+
+	transfer.goroutines.Go(func() error {
+		transfer.download(send, filePath, 0, transfer.newBlockHasher(filePath))
+		return nil
+	})
+}
+
+// newBlockHasher returns the hasher recording the checksums of the file, or nil if they are not recorded.
+func (transfer *Transfer) newBlockHasher(filePath string) *blockHasher {
+	if transfer.config.ResumeStore == nil {
+		return nil
+	}
+	return newBlockHasher(transfer.config.ResumeStore, filePath)
+}
+
+// resume verifies the partial file, if its checksums are recorded, and resumes its download.
+func (transfer *Transfer) resume(send *XdccSendRes, filePath string, size uint64) {
+	position := size
+	var hasher *blockHasher
+
+	if store := transfer.config.ResumeStore; store != nil {
+		var err error
+		if position, hasher, err = verifyPartialFile(store, filePath); err != nil {
+			transfer.notifyEvent(&TransferAbortedEvent{Error: err.Error()})
+			return
+		}
+	}
+
+	if position == 0 {
+		transfer.download(send, filePath, 0, hasher)
+		return
+	}
+	transfer.requestResume(send, filePath, position, hasher)
+}
+
+func (transfer *Transfer) requestResume(send *XdccSendRes, filePath string, position uint64, hasher *blockHasher) {
+	if position >= send.FileSize {
+		if hasher != nil {
+			hasher.discard()
+		}
+		transfer.notifyEvent(&TransferSkippedEvent{FileName: send.FileName, Reason: "file already completed"})
+		return
+	}
+
+	Logger(LogIRC, "%s: resuming %s from byte %d", transfer.URL().String(), send.FileName, position)
+
+	transfer.mu.Lock()
+	transfer.pendingResume = &pendingResume{send: send, filePath: filePath, position: position, hasher: hasher}
+	transfer.mu.Unlock()
+
+	req := &XdccResumeReq{FileName: send.FileName, Port: send.Port, Position: position, Token: send.Token}
+	transfer.conn.Ctcp(transfer.url.UserName, DCC, req.String())
+}
+
+func (transfer *Transfer) handleXdccAcceptRes(accept *XdccAcceptRes) {
+	transfer.mu.Lock()
+	resume := transfer.pendingResume
+	if resume == nil || resume.send.Port != accept.Port || resume.send.Token != accept.Token {
+		transfer.mu.Unlock()
+		return
+	}
+	transfer.pendingResume = nil
+	transfer.mu.Unlock()
+
+	hasher := resume.hasher
+	if accept.Position != resume.position {
+		if accept.Position > resume.position {
+			// resuming past the end of the partial file would leave a hole in it
+			transfer.send(&XdccCancelReq{})
+			transfer.notifyEvent(&TransferAbortedEvent{Error: fmt.Sprintf("%s: the bot resumes from byte %d, past the %d bytes received",
+				resume.send.FileName, accept.Position, resume.position)})
+			return
+		}
+		hasher = nil // the recorded checksums no longer match the file
+	}
+	transfer.goroutines.Go(func() error {
+		transfer.download(resume.send, resume.filePath, accept.Position, hasher)
+		return nil
+	})
+}
+
+// download receives the file offered by the bot, starting at the given offset.
+// The checksums of the blocks are recorded through hasher, if not nil.
+func (transfer *Transfer) download(send *XdccSendRes, filePath string, offset uint64, hasher *blockHasher) {
+	if reserve := transfer.config.ReserveSpace; reserve != nil && send.FileSize > offset {
+		release, err := reserve(filePath, send.FileSize-offset)
+		if err != nil {
+			transfer.send(&XdccCancelReq{})
+			transfer.notifyEvent(&TransferAbortedEvent{Error: err.Error()})
+			return
+		}
+		defer release()
+	}
+
+	var conn net.Conn
+	if send.Passive() {
+		var err error
+		if conn, err = transfer.acceptPassiveDCC(send); err != nil {
+			transfer.notifyEvent(&TransferAbortedEvent{Error: "no connection from the bot: " + err.Error()})
+			return
+		}
+	} else {
+		tcpConn, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: send.IP, Port: send.Port})
+		if err != nil {
+			transfer.notifyEvent(&TransferAbortedEvent{Error: "unable to reach host " + net.JoinHostPort(send.IP.String(), strconv.Itoa(send.Port))})
+			return
+		}
+		conn = tcpConn
+	}
+
+	Logger(LogTransfers, "%s: connected to %s, receiving %s (%d bytes)", transfer.URL().String(),
+		conn.RemoteAddr(), send.FileName, send.FileSize)
+
+	if send.Secure {
+		// bots use self-signed certificates for DCC over TLS
+		conn = tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	}
+	defer conn.Close()
+
+	transfer.mu.Lock()
+	transfer.dccConn = conn
+	transfer.mu.Unlock()
+
+	if transfer.ctx.Err() != nil { // cancelled while connecting
+		transfer.notifyEvent(&TransferAbortedEvent{Error: "cancelled"})
+		return
+	}
+
+	file, fileWriter, err := openReceivedFile(filePath, offset, send.FileSize, transfer.config.DirectIO)
+	if err != nil {
+		transfer.notifyEvent(&TransferAbortedEvent{Error: err.Error()})
+		return
+	}
+	defer file.Close()
+
+	if hasher != nil {
+		defer hasher.save() // after flushing the file
+	}
+	defer fileWriter.Close()
+
+	var writer io.Writer = fileWriter
+	if hasher != nil {
+		writer = io.MultiWriter(fileWriter, hasher)
+	}
+
+	transfer.notifyEvent(&TransferStartedEvent{
+		FileName: send.FileName,
+		FilePath: filePath,
+		FileSize: send.FileSize,
+		Offset:   offset,
+	})
+	transfer.started = true
+
+	bufSize := initialDownloadBufSize
+	acker := newDCCAcker(conn, transfer.URL().String(), send.FileSize, offset)
+	defer acker.stop()
+
+	var reader *SpeedMonitorReader
+	reader = NewSpeedMonitorReader(conn, func(dowloadedAmount int, speed float64) {
+		bufSize = adaptDownloadBufSize(speed)
+		reader.SetUpdateInterval(adaptUpdateInterval(speed))
+		acker.setSpeed(speed)
+
+		Logger(LogTransfers, "%s: received %d bytes (%.2f KiB/s)", transfer.URL().String(), dowloadedAmount, speed/1024)
+		transfer.notifyEvent(&TransferProgressEvent{
+			Rate:  float32(speed),
+			Bytes: uint64(dowloadedAmount),
+		})
+	})
+
+	// download loop
+	downloadedBytesTotal := offset
+	pooledBuf := downloadBufPool.Get().(*[]byte)
+	defer downloadBufPool.Put(pooledBuf)
+	buf := *pooledBuf
+	for downloadedBytesTotal < send.FileSize {
+		size := bufSize
+		if remaining := send.FileSize - downloadedBytesTotal; remaining < uint64(size) {
+			size = int(remaining)
+		}
+
+		n, err := reader.Read(buf[:size])
+
+		if transfer.ctx.Err() != nil {
+			writer.Write(buf[:n])
+			transfer.notifyEvent(&TransferAbortedEvent{Error: "cancelled"})
+			return
+		}
+
+		if err != nil {
+			transfer.notifyEvent(&TransferAbortedEvent{Error: err.Error(), Interrupted: true})
+			return
+		}
+
+		if _, err := writer.Write(buf[:n]); err != nil {
+			transfer.notifyEvent(&TransferAbortedEvent{Error: err.Error()})
+			return
+		}
+
+		if hasher != nil && hasher.unsaved >= resumeSaveInterval {
+			if err := fileWriter.Flush(); err != nil {
+				transfer.notifyEvent(&TransferAbortedEvent{Error: err.Error()})
+				return
+			}
+			hasher.save()
+		}
+
+		downloadedBytesTotal += uint64(n)
+		acker.ack(downloadedBytesTotal)
+	}
+
+	// the file must be complete once the event is delivered
+	if err := fileWriter.Close(); err != nil {
+		transfer.notifyEvent(&TransferAbortedEvent{Error: err.Error()})
+		return
+	}
+
+	if hasher != nil {
+		hasher.discard()
+	}
+
+	Logger(LogTransfers, "%s: transfer of %s completed", transfer.URL().String(), send.FileName)
+	transfer.notifyEvent(&TransferCompletedEvent{FileSize: send.FileSize})
+}
+
+func (transfer *Transfer) handleCTCPRes(resp CTCPResponse) {
+	switch r := resp.(type) {
+	case *XdccSendRes:
+		transfer.handleXdccSendRes(r)
+	case *XdccAcceptRes:
+		transfer.handleXdccAcceptRes(r)
+	case *XdccChatRes:
+		transfer.handleXdccChatRes(r)
+	}
+}
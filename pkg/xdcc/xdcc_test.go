@@ -0,0 +1,62 @@
+package xdcc
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestParseDCCAddress(t *testing.T) {
+	tests := []struct {
+		address  string
+		expected net.IP // nil if invalid
+	}{
+		{"2130706433", net.IPv4(127, 0, 0, 1)}, // IPv4, as a 32-bit integer
+		{"3232235777", net.IPv4(192, 168, 1, 1)},
+		{"0", net.IPv4(0, 0, 0, 0)},
+		{"4294967295", net.IPv4(255, 255, 255, 255)},
+		{"4294967296", nil}, // above 32 bits
+		{"::1", net.IPv6loopback},
+		{"2001:db8::42", net.ParseIP("2001:db8::42")},
+		{"10.0.0.2", net.IPv4(10, 0, 0, 2)},
+		{"bot.example.net", nil}, // hostnames are not addresses
+		{"", nil},
+	}
+
+	for _, test := range tests {
+		ip, err := parseDCCAddress(test.address)
+		if test.expected == nil {
+			if err == nil {
+				t.Errorf("%q: parsed as %s, expected an error", test.address, ip)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: %s", test.address, err)
+		} else if !ip.Equal(test.expected) {
+			t.Errorf("%q: got %s, expected %s", test.address, ip, test.expected)
+		}
+	}
+}
+
+func TestPutDCCAck(t *testing.T) {
+	tests := []struct {
+		received, fileSize uint64
+		expected           []byte
+	}{
+		{0, 1000, []byte{0, 0, 0, 0}},
+		{1000, 1000, []byte{0, 0, 0x03, 0xe8}},
+		{0xffffffff, 0xffffffff, []byte{0xff, 0xff, 0xff, 0xff}},  // 4 GiB - 1, still 32-bit
+		{1000, 0x100000000, []byte{0, 0, 0, 0, 0, 0, 0x03, 0xe8}}, // 4 GiB, 64-bit from the start
+		{0x100000000, 0x100000000, []byte{0, 0, 0, 0x01, 0, 0, 0, 0}},
+		{0x123456789, 0x200000000, []byte{0, 0, 0, 0x01, 0x23, 0x45, 0x67, 0x89}},
+	}
+
+	for _, test := range tests {
+		buf := make([]byte, 8)
+		if ack := putDCCAck(buf, test.received, test.fileSize); !bytes.Equal(ack, test.expected) {
+			t.Errorf("%d of %d bytes: got %x, expected %x", test.received, test.fileSize, ack, test.expected)
+		}
+	}
+}
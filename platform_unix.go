@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+func defaultDownloadDir() string {
+	return "."
+}
+
+// enableVirtualTerminal does nothing, since unix terminals support the ANSI escape sequences.
+func enableVirtualTerminal() {}
@@ -0,0 +1,41 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// defaultDownloadDir returns the Downloads folder of the user, if any.
+func defaultDownloadDir() string {
+	profile := os.Getenv("USERPROFILE")
+	if profile == "" {
+		return "."
+	}
+
+	dir := filepath.Join(profile, "Downloads")
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return "."
+	}
+	return dir
+}
+
+var procSetConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+
+const enableVirtualTerminalProcessing = 0x0004
+
+// enableVirtualTerminal makes the console interpret the ANSI escape sequences used by the progress bars.
+func enableVirtualTerminal() {
+	for _, f := range []*os.File{os.Stdout, os.Stderr} {
+		handle := syscall.Handle(f.Fd())
+
+		var mode uint32
+		if err := syscall.GetConsoleMode(handle, &mode); err != nil {
+			continue // redirected to a file or a pipe
+		}
+		procSetConsoleMode.Call(uintptr(handle), uintptr(mode|enableVirtualTerminalProcessing))
+	}
+}
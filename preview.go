@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/ostafen/xdcc-cli/pkg/search"
+	"github.com/ostafen/xdcc-cli/pkg/xdcc"
+)
+
+const defaultPreviewSize = 10 * search.MegaByte
+
+type previewOptions struct {
+	size                 int64
+	player               string
+	skipCertificateCheck bool
+	noSSL                bool
+}
+
+// downloadSample downloads the first bytes of the file into dir and returns the path of the sample.
+func downloadSample(ctx context.Context, url *xdcc.IRCFileURL, dir string, opts *previewOptions) (string, error) {
+	transferCtx, abort := context.WithCancel(ctx)
+	defer abort()
+
+	transfer := xdcc.NewTransfer(*url, xdcc.TransferConfig{
+		FilePath:             dir,
+		DestTemplate:         "{name}",
+		CollisionPolicy:      xdcc.CollisionOverwrite,
+		EnableSSL:            !opts.noSSL,
+		SkipCertificateCheck: opts.skipCertificateCheck,
+	})
+
+	if err := config.Networks.Check(url.Network); err != nil {
+		return "", err
+	}
+	if err := config.Bots.Check(url.Network, url.UserName); err != nil {
+		return "", err
+	}
+
+	if err := transfer.Start(transferCtx); err != nil {
+		return "", err
+	}
+	// the sample is played once it is written and closed
+	defer func() {
+		abort()
+		transfer.Wait()
+	}()
+
+	pb := NewProgressBar()
+
+	filePath := ""
+	received := uint64(0)
+	evts := transfer.PollEvents()
+	for {
+		switch evt := (<-evts).(type) {
+		case *xdcc.TransferStartedEvent:
+			filePath = evt.FilePath
+			total := evt.FileSize
+			if uint64(opts.size) < total {
+				total = uint64(opts.size)
+			}
+			pb.SetTotal(int(total))
+			pb.SetFileName(evt.FileName)
+			pb.SetState(ProgressStateDownloading)
+		case *xdcc.TransferProgressEvent:
+			received += evt.Bytes
+			pb.Increment(int(evt.Bytes))
+			if received >= uint64(opts.size) {
+				abort() // makes the bot cancel the transfer
+			}
+		case *xdcc.TransferCompletedEvent:
+			pb.SetState(ProgressStateCompleted)
+			return filePath, nil
+		case *xdcc.TransferSkippedEvent:
+			pb.SetState(ProgressStateAborted)
+			return "", errors.New(evt.Reason)
+		case *xdcc.TransferAbortedEvent:
+			if ctx.Err() == nil && transferCtx.Err() != nil && filePath != "" {
+				pb.SetState(ProgressStateCompleted)
+				return filePath, nil
+			}
+			pb.SetState(ProgressStateAborted)
+			return "", errors.New(evt.Error)
+		}
+	}
+}
+
+func playSample(player string, filePath string) error {
+	fields := strings.Fields(player)
+	if len(fields) == 0 {
+		return errors.New("no player configured")
+	}
+
+	cmd := exec.Command(fields[0], append(fields[1:], filePath)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+func preview(url *xdcc.IRCFileURL, opts *previewOptions) error {
+	dir, err := ioutil.TempDir("", "xdcc-preview")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, stop := interruptContext(context.Background())
+	defer stop()
+
+	filePath, err := downloadSample(ctx, url, dir, opts)
+	if err != nil {
+		return err
+	}
+
+	logInfo("playing %s with %s", filePath, opts.player)
+	return playSample(opts.player, filePath)
+}
+
+// newPreviewFlagSet returns the flags of the preview subcommand, also used by the completion.
+func newPreviewFlagSet() (*flag.FlagSet, *previewOptions, *logFlags) {
+	previewCmd := flag.NewFlagSet("preview", flag.ExitOnError)
+	opts := &previewOptions{size: defaultPreviewSize}
+	previewCmd.Var((*sizeValue)(&opts.size), "size", "amount of data to download before playing (e.g. 20M)")
+	previewCmd.StringVar(&opts.player, "player", config.PreviewPlayer, "command used to play the sample")
+	previewCmd.BoolVar(&opts.skipCertificateCheck, "allow-unknown-authority", false, "skip x509 certificate check during tls connection")
+	previewCmd.BoolVar(&opts.noSSL, "no-ssl", false, "disable SSL.")
+	return previewCmd, opts, addLogFlags(previewCmd)
+}
+
+func previewCommand(args []string) {
+	previewCmd, opts, logOpts := newPreviewFlagSet()
+
+	args = parseFlags(previewCmd, args)
+	logOpts.apply()
+
+	if len(args) != 1 {
+		fmt.Println("usage: preview url [--size 10M] [--player mpv]")
+		previewCmd.PrintDefaults()
+		os.Exit(1)
+	}
+
+	url, err := xdcc.ParsePackRef(args[0])
+	if err != nil {
+		logError(err.Error())
+		os.Exit(1)
+	}
+
+	if err := preview(url, opts); err != nil {
+		logError("preview failed: %s", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http/cookiejar"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ostafen/xdcc-cli/pkg/search"
+)
+
+const providerHealthFileName = "providers.json"
+
+type providerHealth struct {
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"lastFailure"`
+}
+
+// CircuitBreaker keeps track of the failures of each provider across runs,
+// so that providers failing repeatedly are not queried until a cooldown expires.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	path      string
+	health    map[string]*providerHealth
+	threshold int
+	cooldown  time.Duration
+}
+
+func NewCircuitBreaker(path string, threshold int, cooldown time.Duration) (*CircuitBreaker, error) {
+	breaker := &CircuitBreaker{
+		path:      path,
+		health:    make(map[string]*providerHealth),
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+
+	if err := readJSONFile(path, &breaker.health); err != nil {
+		return nil, err
+	}
+	return breaker, nil
+}
+
+// Allow reports whether the provider can be queried.
+func (breaker *CircuitBreaker) Allow(name string) bool {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	h, ok := breaker.health[name]
+	if !ok || breaker.threshold <= 0 || h.Failures < breaker.threshold {
+		return true
+	}
+	return time.Since(h.LastFailure) > breaker.cooldown
+}
+
+// Record updates the health of the provider with the outcome of a query.
+func (breaker *CircuitBreaker) Record(name string, err error) {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	h, ok := breaker.health[name]
+	if !ok {
+		h = &providerHealth{}
+		breaker.health[name] = h
+	}
+
+	if err != nil {
+		h.Failures++
+		h.LastFailure = time.Now()
+	} else {
+		h.Failures = 0
+	}
+
+	if err := writeJSONFile(breaker.path, breaker.health); err != nil {
+		logWarn("unable to save provider health: %s", err)
+	}
+}
+
+func (breaker *CircuitBreaker) Failures(name string) int {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	if h, ok := breaker.health[name]; ok {
+		return h.Failures
+	}
+	return 0
+}
+
+var providerBreaker *CircuitBreaker
+
+func setupCircuitBreaker() {
+	path, err := dataFilePath(providerHealthFileName)
+	if err != nil {
+		logWarn("unable to locate provider health file: %s", err)
+		return
+	}
+
+	breaker, err := NewCircuitBreaker(path, config.ProviderFailureThreshold, time.Duration(config.ProviderCooldown))
+	if err != nil {
+		logWarn("unable to load provider health file: %s", err)
+		return
+	}
+	providerBreaker = breaker
+	registry.SetCircuitBreaker(breaker)
+}
+
+type providerStatus struct {
+	provider search.Provider
+	latency  time.Duration
+	results  int
+	err      error
+}
+
+func probeProviders(ctx context.Context, query []string) []providerStatus {
+	providers := registry.Providers()
+	statusList := make([]providerStatus, len(providers))
+
+	wg := sync.WaitGroup{}
+	wg.Add(len(providers))
+	for i, p := range providers {
+		go func(i int, p search.Provider) {
+			defer wg.Done()
+
+			start := time.Now()
+			res, err := p.Search(ctx, query)
+			statusList[i] = providerStatus{provider: p, latency: time.Since(start), results: len(res), err: err}
+
+			if providerBreaker != nil {
+				providerBreaker.Record(p.Name(), err)
+			}
+		}(i, p)
+	}
+	wg.Wait()
+	return statusList
+}
+
+func printProvidersStatus(statusList []providerStatus) {
+	printer := NewTablePrinter([]string{"Provider", "Status", "Latency", "Results"})
+	for _, status := range statusList {
+		state := "ok"
+		if status.err != nil {
+			state = "unreachable: " + status.err.Error()
+		}
+
+		printer.AddRow(Row{
+			status.provider.Name(),
+			state,
+			status.latency.Round(time.Millisecond).String(),
+			strconv.Itoa(status.results),
+		})
+	}
+	printer.Print()
+}
+
+const defaultProbeQuery = "linux"
+
+// newProvidersStatusFlagSet returns the flags of the providers status subcommand, also used by the completion.
+func newProvidersStatusFlagSet() (*flag.FlagSet, *string, *logFlags) {
+	statusCmd := flag.NewFlagSet("providers status", flag.ExitOnError)
+	query := statusCmd.String("q", defaultProbeQuery, "query used to probe the providers")
+	return statusCmd, query, addLogFlags(statusCmd)
+}
+
+func providersStatusCommand(args []string) {
+	statusCmd, query, logOpts := newProvidersStatusFlagSet()
+
+	parseFlags(statusCmd, args)
+	logOpts.apply()
+
+	ctx, stop := interruptContext(context.Background())
+	defer stop()
+
+	printProvidersStatus(probeProviders(ctx, []string{*query}))
+}
+
+func providersCommand(args []string) {
+	if len(args) < 1 {
+		fmt.Println("one of the following subcommands is expected: [status]")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "status":
+		providersStatusCommand(args[1:])
+	default:
+		fmt.Println("no such command: ", args[0])
+		os.Exit(1)
+	}
+}
+
+// registerAnnounceProviders adds a provider for each announce channel of the configuration.
+func registerAnnounceProviders() {
+	for _, channel := range config.AnnounceChannels {
+		if err := config.Networks.Check(channel.Network); err != nil {
+			logAt(LogProviders, "%s %s: skipped, %s", channel.Network, channel.Channel, err)
+			continue
+		}
+		provider := search.NewAnnounceProvider(search.AnnounceChannel{
+			Network:              channel.Network,
+			Channel:              channel.Channel,
+			Window:               time.Duration(channel.Window),
+			NoSSL:                channel.NoSSL,
+			SkipCertificateCheck: channel.SkipCertificateCheck,
+		})
+		registry.AddProvider(provider)
+
+		// the whole window is needed to collect the announcements
+		registry.SetProviderTimeout(provider.Name(), time.Duration(channel.Window)+time.Duration(config.ProviderTimeout))
+	}
+}
+
+// registerPluginProviders adds a provider for each plugin of the configuration.
+func registerPluginProviders() {
+	for _, plugin := range config.Plugins {
+		provider, err := search.NewPluginProvider(plugin.Name, plugin.Command)
+		if err != nil {
+			logError("invalid plugin: %s", err)
+			os.Exit(1)
+		}
+
+		for _, p := range registry.Providers() {
+			if p.Name() == provider.Name() {
+				logError("invalid plugin: provider %s already exists", plugin.Name)
+				os.Exit(1)
+			}
+		}
+		registry.AddProvider(provider)
+	}
+}
+
+// setupProviderRateLimits applies the request rate limits of the providers set in the configuration.
+func setupProviderRateLimits() {
+	for name, interval := range config.ProviderRateLimits {
+		if !registry.SetProviderRateLimit(name, time.Duration(interval)) {
+			logWarn("providerRateLimits: no such provider %s", name)
+		}
+	}
+}
+
+// setupProviderLocales applies the number formats of the providers set in the configuration.
+func setupProviderLocales() {
+	for name, value := range config.ProviderLocales {
+		locale, err := search.ParseNumberLocale(value)
+		if err != nil {
+			logError("invalid locale of provider %s: %s", name, err)
+			os.Exit(1)
+		}
+
+		if !registry.SetProviderLocale(name, locale) {
+			logWarn("providerLocales: no such provider %s", name)
+		}
+	}
+}
+
+// setupProviderRequests applies the HTTP settings of the providers set in the configuration.
+func setupProviderRequests() {
+	var cookies *CookieStore
+	for name, cfg := range config.ProviderRequests {
+		opts := search.RequestOptions{
+			UserAgent:      cfg.UserAgent,
+			AcceptLanguage: cfg.AcceptLanguage,
+			Headers:        cfg.Headers,
+			SessionURL:     cfg.SessionURL,
+		}
+
+		// the cookies of a session are kept during the run at least
+		if cfg.SessionURL != "" {
+			opts.Jar, _ = cookiejar.New(nil)
+		}
+		if cfg.Cookies {
+			if cookies == nil {
+				cookies = loadCookieStore()
+			}
+			if cookies != nil {
+				if jar, err := cookies.Jar(name); err == nil {
+					opts.Jar = jar
+				} else {
+					logWarn("unable to keep the cookies of %s: %s", name, err)
+				}
+			}
+		}
+
+		if !registry.SetProviderRequestOptions(name, opts) {
+			logWarn("providerRequests: no such provider %s", name)
+		}
+	}
+}
+
+func loadCookieStore() *CookieStore {
+	path, err := dataFilePath(cookiesFileName)
+	if err != nil {
+		logWarn("unable to locate cookies file: %s", err)
+		return nil
+	}
+
+	store, err := NewCookieStore(path)
+	if err != nil {
+		logWarn("unable to load cookies file: %s", err)
+		return nil
+	}
+	return store
+}
+
+// setupSearchTimeouts applies the search timeouts of the configuration.
+func setupSearchTimeouts() {
+	registry.SetTimeout(time.Duration(config.ProviderTimeout))
+	registry.SetSearchTimeout(time.Duration(config.SearchTimeout))
+	for name, timeout := range config.ProviderTimeouts {
+		registry.SetProviderTimeout(name, time.Duration(timeout))
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/ostafen/xdcc-cli/internal/group"
+	"github.com/ostafen/xdcc-cli/pkg/search"
+)
+
+// SearchQuery is a search query such as `foo +1080p -HEVC "exact phrase"`.
+// Search engines only receive the plain keywords, since none of them supports
+// the operators: required terms, exclusions and phrases are checked on the results.
+//
+// Queries with alternatives, such as `(foo|"foo bar") 1080p`, are expanded into
+// several plain queries, which are searched separately.
+type SearchQuery struct {
+	Terms    []string
+	Required []string // +term
+	Excluded []string // -term
+	Phrases  []string // "exact phrase"
+
+	Alternatives []*SearchQuery // plain queries the query expands to, if it has alternatives
+}
+
+// maxQueryAlternatives bounds the number of searches a single query can expand to.
+const maxQueryAlternatives = 16
+
+// ParseSearchQuery parses the query, expanding its alternatives.
+func ParseSearchQuery(query string) *SearchQuery {
+	expanded := expandAlternatives(tokenizeQuery(query))
+	if len(expanded) == 1 {
+		return parsePlainQuery(strings.Join(expanded[0], " "))
+	}
+
+	q := &SearchQuery{}
+	for _, tokens := range expanded {
+		q.Alternatives = append(q.Alternatives, parsePlainQuery(strings.Join(tokens, " ")))
+	}
+	return q
+}
+
+// tokenizeQuery splits the query into words, double quoted phrases and the "(", ")" and "|" operators.
+func tokenizeQuery(query string) []string {
+	tokens := make([]string, 0)
+	word := strings.Builder{}
+	inQuotes := false
+
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+
+	for _, r := range query {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			word.WriteRune(r)
+		case inQuotes:
+			word.WriteRune(r)
+		case r == '(' || r == ')' || r == '|':
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			word.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+// expandAlternatives turns a sequence of tokens with alternatives into the list of plain sequences it matches,
+// e.g. "a (b c|d)" into "a b c" and "a d". Words are separated by spaces, "|" binds tighter than spaces
+// (so that "a b|c" means "a (b|c)") and parentheses group words. Unbalanced parentheses and dangling operators are ignored.
+func expandAlternatives(tokens []string) [][]string {
+	pos := 0
+	depth := 0
+
+	var parseSequence func() [][]string
+	parseAtom := func() [][]string {
+		token := tokens[pos]
+		pos++
+
+		if token != "(" {
+			return [][]string{{token}}
+		}
+
+		depth++
+		expanded := parseSequence()
+		depth--
+
+		if pos < len(tokens) { // closing parenthesis
+			pos++
+		}
+		return expanded
+	}
+
+	parseAlternatives := func() [][]string {
+		expanded := parseAtom()
+		for pos < len(tokens) && tokens[pos] == "|" {
+			for pos < len(tokens) && tokens[pos] == "|" {
+				pos++
+			}
+
+			if pos < len(tokens) && tokens[pos] != ")" {
+				expanded = append(expanded, parseAtom()...)
+			}
+		}
+		return expanded
+	}
+
+	parseSequence = func() [][]string {
+		expanded := [][]string{{}}
+		for pos < len(tokens) {
+			switch tokens[pos] {
+			case ")":
+				if depth > 0 {
+					return expanded
+				}
+				pos++
+				continue
+			case "|":
+				pos++
+				continue
+			}
+
+			alternatives := parseAlternatives()
+			product := make([][]string, 0, len(expanded)*len(alternatives))
+			for _, prefix := range expanded {
+				for _, alt := range alternatives {
+					if len(product) < maxQueryAlternatives {
+						product = append(product, append(append([]string(nil), prefix...), alt...))
+					}
+				}
+			}
+			expanded = product
+		}
+		return expanded
+	}
+	return parseSequence()
+}
+
+// parsePlainQuery splits a query without alternatives into terms, honouring double quoted phrases.
+func parsePlainQuery(query string) *SearchQuery {
+	q := &SearchQuery{}
+
+	inQuotes := false
+	fields := strings.FieldsFunc(query, func(r rune) bool {
+		if r == '"' {
+			inQuotes = !inQuotes
+			return false
+		}
+		return !inQuotes && unicode.IsSpace(r)
+	})
+
+	for _, field := range fields {
+		switch {
+		case strings.HasPrefix(field, "+"):
+			q.addTerm(&q.Required, field[1:])
+		case strings.HasPrefix(field, "-"):
+			q.addTerm(&q.Excluded, field[1:])
+		default:
+			q.addTerm(&q.Terms, field)
+		}
+	}
+	return q
+}
+
+func (q *SearchQuery) addTerm(list *[]string, term string) {
+	if strings.Contains(term, `"`) {
+		phrase := strings.Join(strings.Fields(strings.Replace(term, `"`, " ", -1)), " ")
+		if phrase == "" {
+			return
+		}
+
+		if list == &q.Excluded {
+			*list = append(*list, phrase)
+		} else {
+			q.Phrases = append(q.Phrases, phrase)
+		}
+		return
+	}
+
+	if term != "" {
+		*list = append(*list, term)
+	}
+}
+
+// Queries returns the plain queries to search.
+func (q *SearchQuery) Queries() []*SearchQuery {
+	if len(q.Alternatives) > 0 {
+		return q.Alternatives
+	}
+	return []*SearchQuery{q}
+}
+
+// KeywordSets returns the keywords to send to the search engines for each plain query,
+// skipping the duplicates.
+func (q *SearchQuery) KeywordSets() [][]string {
+	sets := make([][]string, 0, len(q.Queries()))
+	seen := make(map[string]bool)
+	for _, alt := range q.Queries() {
+		keywords := alt.Keywords()
+		key := strings.ToLower(strings.Join(keywords, " "))
+		if len(keywords) > 0 && !seen[key] {
+			seen[key] = true
+			sets = append(sets, keywords)
+		}
+	}
+	return sets
+}
+
+// Keywords returns the keywords to send to the search engines for a plain query.
+func (q *SearchQuery) Keywords() []string {
+	keywords := make([]string, 0, len(q.Terms)+len(q.Required))
+	keywords = append(keywords, q.Terms...)
+	keywords = append(keywords, q.Required...)
+	for _, phrase := range q.Phrases {
+		keywords = append(keywords, strings.Fields(phrase)...)
+	}
+	return keywords
+}
+
+func (q *SearchQuery) hasOperators() bool {
+	for _, alt := range q.Alternatives {
+		if alt.hasOperators() {
+			return true
+		}
+	}
+	return len(q.Required) > 0 || len(q.Excluded) > 0 || len(q.Phrases) > 0
+}
+
+// normalizeName makes the words separated by dots or underscores in file names comparable with query terms.
+func normalizeName(name string) string {
+	name = strings.ToLower(name)
+	name = strings.Map(func(r rune) rune {
+		if r == '.' || r == '_' {
+			return ' '
+		}
+		return r
+	}, name)
+	return " " + strings.Join(strings.Fields(name), " ") + " "
+}
+
+// Match reports whether the file matches the operators of the query, or of one of its alternatives.
+func (q *SearchQuery) Match(info *search.FileInfo) bool {
+	if len(q.Alternatives) > 0 {
+		for _, alt := range q.Alternatives {
+			if alt.Match(info) {
+				return true
+			}
+		}
+		return false
+	}
+
+	name := normalizeName(info.Name)
+	contains := func(term string) bool {
+		return strings.Contains(name, strings.TrimSpace(normalizeName(term)))
+	}
+
+	for _, term := range q.Required {
+		if !contains(term) {
+			return false
+		}
+	}
+
+	for _, phrase := range q.Phrases {
+		if !contains(phrase) {
+			return false
+		}
+	}
+
+	for _, term := range q.Excluded {
+		if contains(term) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseQueryArgs separates the query terms from the flags, so that exclusions
+// such as -HEVC are not taken for flags, and parses the flags.
+// Arguments containing spaces (quoted in the shell) are turned into phrases.
+func parseQueryArgs(flagSet *flag.FlagSet, args []string) string {
+	terms := make([]string, 0, len(args))
+	flagArgs := make([]string, 0, len(args))
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+
+		if arg == "--" {
+			terms = append(terms, args[i+1:]...)
+			break
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		if idx := strings.Index(name, "="); idx >= 0 {
+			name = name[:idx]
+		}
+
+		f := flagSet.Lookup(name)
+		if !strings.HasPrefix(arg, "-") || f == nil {
+			if strings.IndexFunc(arg, unicode.IsSpace) >= 0 && !strings.ContainsAny(arg, `"|()`) {
+				arg = `"` + arg + `"`
+			}
+			terms = append(terms, arg)
+			continue
+		}
+
+		flagArgs = append(flagArgs, arg)
+		boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool })
+		if !strings.Contains(arg, "=") && !(ok && boolFlag.IsBoolFlag()) && i+1 < len(args) {
+			i++
+			flagArgs = append(flagArgs, args[i])
+		}
+	}
+
+	flagSet.Parse(flagArgs)
+	return strings.Join(terms, " ")
+}
+
+// searchQueryAsync runs a search for each plain query the query expands to, merging their results
+// and dropping the packs already delivered. It returns the channel of the results, which delivers
+// the given number of provider results before being closed.
+func searchQueryAsync(ctx context.Context, q *SearchQuery) (<-chan search.ProviderResult, int) {
+	sets := q.KeywordSets()
+	if len(sets) == 1 {
+		return registry.SearchAsync(ctx, sets[0]), registry.NumProviders()
+	}
+
+	numResults := len(sets) * registry.NumProviders()
+	mergedChan := make(chan search.ProviderResult, numResults)
+
+	mu := sync.Mutex{}
+	seen := make(map[string]bool)
+
+	g := &group.Group{}
+	for _, keywords := range sets {
+		resultsChan := registry.SearchAsync(ctx, keywords)
+		g.Go(func() error {
+			for r := range resultsChan {
+				mu.Lock()
+				unique := make([]search.FileInfo, 0, len(r.Results))
+				for _, info := range r.Results {
+					if key := packKey(&info); !seen[key] {
+						seen[key] = true
+						unique = append(unique, info)
+					}
+				}
+				mu.Unlock()
+
+				r.Results = unique
+				mergedChan <- r
+			}
+			return nil
+		})
+	}
+
+	go func() {
+		g.Wait()
+		close(mergedChan)
+	}()
+	return mergedChan, numResults
+}
+
+// searchQuery returns the merged results of the plain queries the query expands to.
+// An error is returned only if the search is cancelled or every provider query fails.
+func searchQuery(ctx context.Context, q *SearchQuery) ([]search.FileInfo, error) {
+	sets := q.KeywordSets()
+	if len(sets) == 1 {
+		return registry.Search(ctx, sets[0])
+	}
+
+	resultsChan, numResults := searchQueryAsync(ctx, q)
+
+	res := make([]search.FileInfo, 0, search.MaxResults)
+	errs := make([]string, 0)
+	for r := range resultsChan {
+		if r.Err == nil {
+			res = append(res, r.Results...)
+		} else {
+			errs = append(errs, r.Provider.Name()+": "+r.Err.Error())
+		}
+	}
+
+	if ctx.Err() != nil {
+		return res, ctx.Err()
+	}
+
+	if len(errs) > 0 && len(errs) == numResults {
+		return nil, errors.New("every provider failed: " + strings.Join(errs, ", "))
+	}
+	return res, nil
+}
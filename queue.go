@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ostafen/xdcc-cli/pkg/xdcc"
+)
+
+const queueFileName = "queue.json"
+
+// queueEntry is a pending download of the queue.
+type queueEntry struct {
+	URL   string    `json:"url"`
+	Name  string    `json:"name,omitempty"` // expected file name, if known
+	Size  int64     `json:"size,omitempty"` // advertised size of the file, if known
+	Added time.Time `json:"added"`
+}
+
+// UnmarshalJSON also accepts a bare url, so that queues are easily generated by other tools.
+func (entry *queueEntry) UnmarshalJSON(data []byte) error {
+	var url string
+	if err := json.Unmarshal(data, &url); err == nil {
+		*entry = queueEntry{URL: url}
+		return nil
+	}
+
+	type plainEntry queueEntry
+	return json.Unmarshal(data, (*plainEntry)(entry))
+}
+
+func loadQueue() ([]queueEntry, string, error) {
+	path, err := dataFilePath(queueFileName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	queue := make([]queueEntry, 0)
+	if err := readJSONFile(path, &queue); err != nil {
+		return nil, "", err
+	}
+	return queue, path, nil
+}
+
+func mustLoadQueue() ([]queueEntry, string) {
+	queue, path, err := loadQueue()
+	if err != nil {
+		logError("unable to load queue: %s", err)
+		os.Exit(1)
+	}
+	return queue, path
+}
+
+func mustSaveQueue(path string, queue []queueEntry) {
+	if err := writeJSONFile(path, queue); err != nil {
+		logError("unable to save queue: %s", err)
+		os.Exit(1)
+	}
+}
+
+// enqueue appends the entries which are not queued yet, normalizing their urls,
+// and returns the number of entries added.
+func enqueue(queue []queueEntry, entries []queueEntry) ([]queueEntry, int, error) {
+	queued := make(map[string]bool, len(queue))
+	for _, entry := range queue {
+		queued[entry.URL] = true
+	}
+
+	added := 0
+	for _, entry := range entries {
+		url, err := xdcc.ParsePackRef(entry.URL)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %s", entry.URL, err)
+		}
+
+		entry.URL = url.String()
+		if queued[entry.URL] {
+			continue
+		}
+		if entry.Added.IsZero() {
+			entry.Added = time.Now()
+		}
+		queued[entry.URL] = true
+		queue = append(queue, entry)
+		added++
+	}
+	return queue, added, nil
+}
+
+func printQueue(queue []queueEntry) {
+	printer := NewTablePrinter([]string{"#", "Url", "File Name", "Size", "Added"})
+	for i, entry := range queue {
+		size := ""
+		if entry.Size > 0 {
+			size = formatSize(entry.Size)
+		}
+		printer.AddRow(Row{strconv.Itoa(i + 1), entry.URL, entry.Name, size, entry.Added.Local().Format("2006-01-02 15:04")})
+	}
+	printer.Print()
+}
+
+// removeQueued removes the entry of the url from the queue file, reloaded so that the entries
+// added meanwhile are kept.
+func removeQueued(url string) {
+	queue, path, err := loadQueue()
+	if err != nil {
+		logWarn("unable to load queue: %s", err)
+		return
+	}
+
+	kept := queue[:0]
+	for _, entry := range queue {
+		if entry.URL != url {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) == len(queue) {
+		return
+	}
+
+	if err := writeJSONFile(path, kept); err != nil {
+		logWarn("unable to save queue: %s", err)
+	}
+}
+
+// runQueue downloads the queued files, removing each of them from the queue once completed or skipped.
+// The failed and cancelled ones stay queued.
+func runQueue(queue []queueEntry, args []string) {
+	runCmd := flag.NewFlagSet("queue run", flag.ExitOnError)
+	opts := addTransferFlags(runCmd)
+	logOpts := addLogFlags(runCmd)
+
+	if rest := parseFlags(runCmd, args); len(rest) > 0 {
+		printQueueUsageAndExit()
+	}
+	logOpts.apply()
+
+	if len(queue) == 0 {
+		logInfo("the queue is empty")
+		return
+	}
+
+	requests := make([]downloadRequest, 0, len(queue))
+	for _, entry := range queue {
+		url, err := xdcc.ParsePackRef(entry.URL)
+		if err != nil {
+			logError("%s: %s", entry.URL, err)
+			os.Exit(1)
+		}
+		requests = append(requests, downloadRequest{url: *url, fileName: entry.Name, fileSize: entry.Size})
+	}
+
+	var mu sync.Mutex
+	opts.onDone = func(source string) {
+		mu.Lock()
+		defer mu.Unlock()
+		removeQueued(source)
+	}
+
+	if err := downloadFiles(context.Background(), requests, opts); err != nil {
+		exitWithFailure(err)
+	}
+}
+
+// exportJSON writes v as JSON to the file, or to stdout if it is "-".
+func exportJSON(path string, v interface{}) error {
+	if path == "-" {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Println(string(data))
+		return err
+	}
+	return writeJSONFile(path, v)
+}
+
+// importJSON reads v from the JSON file, or from stdin if it is "-".
+func importJSON(path string, v interface{}) error {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func printQueueUsageAndExit() {
+	fmt.Println("usage:")
+	fmt.Println("  xdcc queue add <url>...")
+	fmt.Println("  xdcc queue list")
+	fmt.Println("  xdcc queue remove <number|url>...")
+	fmt.Println("  xdcc queue run [get flags]")
+	fmt.Println("  xdcc queue export <file>")
+	fmt.Println("  xdcc queue import <file>")
+	fmt.Println("where file is - for stdout or stdin")
+	os.Exit(1)
+}
+
+func queueCommand(args []string) {
+	if len(args) < 1 {
+		printQueueUsageAndExit()
+	}
+
+	queue, path := mustLoadQueue()
+
+	switch args[0] {
+	case "add":
+		if len(args) < 2 {
+			printQueueUsageAndExit()
+		}
+
+		entries := make([]queueEntry, 0, len(args)-1)
+		for _, url := range args[1:] {
+			entries = append(entries, queueEntry{URL: url})
+		}
+
+		queue, added, err := enqueue(queue, entries)
+		if err != nil {
+			logError("%s", err)
+			os.Exit(1)
+		}
+		mustSaveQueue(path, queue)
+		logInfo("%d files queued", added)
+	case "list":
+		printQueue(queue)
+	case "remove":
+		if len(args) < 2 {
+			printQueueUsageAndExit()
+		}
+
+		removed := make(map[int]bool)
+		for _, arg := range args[1:] {
+			found := false
+			for i, entry := range queue {
+				if strconv.Itoa(i+1) == arg || entry.URL == arg {
+					removed[i], found = true, true
+				}
+			}
+			if !found {
+				logError("no such queued file: %s", arg)
+				os.Exit(1)
+			}
+		}
+
+		kept := make([]queueEntry, 0, len(queue))
+		for i, entry := range queue {
+			if !removed[i] {
+				kept = append(kept, entry)
+			}
+		}
+		mustSaveQueue(path, kept)
+	case "run":
+		runQueue(queue, args[1:])
+	case "export":
+		if len(args) != 2 {
+			printQueueUsageAndExit()
+		}
+
+		if err := exportJSON(args[1], queue); err != nil {
+			logError("unable to export queue: %s", err)
+			os.Exit(1)
+		}
+	case "import":
+		if len(args) != 2 {
+			printQueueUsageAndExit()
+		}
+
+		var entries []queueEntry
+		if err := importJSON(args[1], &entries); err != nil {
+			logError("unable to import queue: %s", err)
+			os.Exit(1)
+		}
+
+		queue, added, err := enqueue(queue, entries)
+		if err != nil {
+			logError("%s", err)
+			os.Exit(1)
+		}
+		mustSaveQueue(path, queue)
+		logInfo("%d files imported, %d already queued", added, len(entries)-added)
+	default:
+		fmt.Println("no such command: ", args[0])
+		os.Exit(1)
+	}
+}
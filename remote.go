@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ostafen/xdcc-cli/pkg/search"
+	"github.com/ostafen/xdcc-cli/pkg/xdcc"
+)
+
+// remoteRequestTimeout limits the requests to the daemon, except the streams of events.
+const remoteRequestTimeout = 30 * time.Second
+
+// remoteClient controls a daemon running on another host through its HTTP API.
+type remoteClient struct {
+	baseURL string
+	token   string // sent as a bearer token, if not empty
+}
+
+func newRemoteClient(addr string, token string) *remoteClient {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &remoteClient{baseURL: strings.TrimSuffix(addr, "/"), token: token}
+}
+
+// newRequest returns a request to the daemon, bearing the token.
+func (c *remoteClient) newRequest(method string, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	return req, nil
+}
+
+// call sends a request to the daemon and decodes its JSON answer into out, if not nil.
+func (c *remoteClient) call(method string, path string, body interface{}, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := c.newRequest(method, path, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := &http.Client{Timeout: remoteRequestTimeout}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		var daemonErr daemonError
+		if json.NewDecoder(res.Body).Decode(&daemonErr) == nil && daemonErr.Error != "" {
+			return errors.New(daemonErr.Error)
+		}
+		return fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// events streams the checkpoints of the transfers of the daemon, until ctx is done or the daemon closes the stream.
+// The returned channel is open once the daemon has accepted the stream.
+func (c *remoteClient) events(ctx context.Context) (<-chan transferCheckpoint, error) {
+	req, err := c.newRequest(http.MethodGet, "/events", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("status code error: %d %s", res.StatusCode, res.Status)
+	}
+
+	ch := make(chan transferCheckpoint)
+	go func() {
+		defer close(ch)
+		defer res.Body.Close()
+
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			var cp transferCheckpoint
+			if err := json.Unmarshal(scanner.Bytes(), &cp); err != nil {
+				continue
+			}
+
+			select {
+			case ch <- cp:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// isFinalState reports whether a transfer in the state is over.
+func isFinalState(state itemState) bool {
+	switch state {
+	case itemStateCompleted, itemStateFailed, itemStateCancelled, itemStateSkipped:
+		return true
+	}
+	return false
+}
+
+func remoteSearch(c *remoteClient, args []string) {
+	searchCmd := flag.NewFlagSet("search", flag.ExitOnError)
+	printOpts := addPrintFlags(searchCmd)
+	searchCmd.IntVar(&printOpts.limit, "limit", 0, "maximum number of results per page")
+	searchCmd.IntVar(&printOpts.page, "page", 1, "page of results to display")
+	searchCmd.StringVar(&printOpts.sortBy, "sort", sortByGets, "sort results by gets, size, name or date (added or announced)")
+	logOpts := addLogFlags(searchCmd)
+
+	queryText := parseQueryArgs(searchCmd, args)
+	logOpts.apply()
+	printOpts.apply()
+
+	if strings.TrimSpace(queryText) == "" {
+		fmt.Println("search: no keyword provided.")
+		os.Exit(1)
+	}
+
+	var records []resultRecord
+	if err := c.call(http.MethodGet, "/search?q="+url.QueryEscape(queryText), nil, &records); err != nil {
+		logError("search: %s", err)
+		os.Exit(1)
+	}
+
+	res := make([]search.FileInfo, 0, len(records))
+	for _, record := range records {
+		res = append(res, record.FileInfo)
+	}
+	printResults(res, printOpts)
+}
+
+// remoteProgress displays the progress of the transfers of the daemon from their checkpoints.
+type remoteProgress struct {
+	bar    ProgressBar
+	offset uint64
+	sized  bool
+}
+
+func (p *remoteProgress) update(cp *transferCheckpoint) {
+	if !p.sized && cp.Size > 0 {
+		p.sized = true
+		p.bar.SetTotal(int(cp.Size))
+		p.bar.SetFileName(cp.File)
+	}
+
+	if cp.Offset > p.offset {
+		p.bar.Increment(int(cp.Offset - p.offset))
+		p.offset = cp.Offset
+	}
+
+	switch cp.State {
+	case itemStateDownloading:
+		p.bar.SetState(ProgressStateDownloading)
+	case itemStateCompleted:
+		p.bar.SetState(ProgressStateCompleted)
+	case itemStateFailed, itemStateCancelled, itemStateSkipped:
+		p.bar.SetState(ProgressStateAborted)
+	}
+}
+
+func remoteGet(c *remoteClient, args []string) {
+	getCmd := flag.NewFlagSet("get", flag.ExitOnError)
+	inputFile := getCmd.String("i", "", "input file containing a list of urls")
+	detach := getCmd.Bool("detach", false, "return once the files are queued, without following their transfers")
+	var forward []string
+	getCmd.Var((*tagList)(&forward), "forward", "upload the files to the given targets once downloaded, instead of the targets of the daemon")
+	logOpts := addLogFlags(getCmd)
+
+	urlStrList := parseFlags(getCmd, args)
+	logOpts.apply()
+
+	if *inputFile != "" {
+		urlStrList = append(urlStrList, loadUrlListFile(*inputFile)...)
+	}
+
+	// the transfers are identified by their sources in the checkpoints
+	urls := make([]string, 0, len(urlStrList))
+	pending := make(map[string]*remoteProgress)
+	for _, urlStr := range urlStrList {
+		if urlStr = strings.TrimSpace(urlStr); urlStr == "" {
+			continue
+		}
+
+		ref, err := xdcc.ParsePackRef(urlStr)
+		if err != nil {
+			logError("%s: %s", urlStr, err)
+			os.Exit(1)
+		}
+		urls = append(urls, urlStr)
+		pending[ref.String()] = nil
+	}
+
+	if len(urls) == 0 {
+		printGetUsageAndExit(getCmd)
+	}
+
+	ctx, stop := interruptContext(context.Background())
+	defer stop()
+
+	// following the transfers before queueing them, so that none of their checkpoints is missed
+	var events <-chan transferCheckpoint
+	if !*detach {
+		var err error
+		if events, err = c.events(ctx); err != nil {
+			logError("get: %s", err)
+			os.Exit(1)
+		}
+	}
+
+	var queued daemonDownloadResponse
+	if err := c.call(http.MethodPost, "/downloads", &daemonDownloadRequest{URLs: urls, Forward: forward}, &queued); err != nil {
+		logError("get: %s", err)
+		os.Exit(1)
+	}
+	logInfo("%d files queued by %s", queued.Queued, c.baseURL)
+
+	if *detach {
+		return
+	}
+
+	failures := make([]transferFailure, 0)
+	for len(pending) > 0 {
+		cp, ok := <-events
+		if !ok {
+			if ctx.Err() != nil {
+				logInfo("stopped following the transfers, which go on in the daemon")
+				exitWithFailure(newCommandFailure(failureCancelled, "stopped following the transfers"))
+			}
+			logError("get: the daemon closed the stream of events")
+			os.Exit(1)
+		}
+
+		progress, ok := pending[cp.Source]
+		if !ok {
+			continue // not a transfer of this command
+		}
+		if progress == nil {
+			progress = &remoteProgress{bar: NewProgressBar()}
+			pending[cp.Source] = progress
+		}
+		progress.update(&cp)
+
+		if !isFinalState(cp.State) {
+			continue
+		}
+		delete(pending, cp.Source)
+
+		if cp.State == itemStateFailed {
+			failures = append(failures, transferFailure{Source: cp.Source, Error: cp.Error, Reason: cp.Reason})
+			logError("%s: %s", cp.Source, cp.Error)
+		}
+	}
+
+	if len(failures) > 0 {
+		exitWithFailure(transfersFailure(len(failures), failures))
+	}
+}
+
+func printRemoteCheckpoint(cp *transferCheckpoint) {
+	progress := formatSize(int64(cp.Offset))
+	if cp.Size > 0 {
+		progress += "/" + formatSize(int64(cp.Size))
+	}
+
+	line := fmt.Sprintf("%s %s %s %s %s", cp.Time.Format("15:04:05"), cp.Source, cp.State, progress, formatSpeed(cp.Speed))
+	if cp.Error != "" {
+		line += " (" + cp.Error + ")"
+	}
+	fmt.Println(line)
+}
+
+func remoteStatus(c *remoteClient, args []string) {
+	statusCmd := flag.NewFlagSet("status", flag.ExitOnError)
+	follow := statusCmd.Bool("follow", false, "print the checkpoints of the transfers as they are recorded, until interrupted")
+	jsonOutput := statusCmd.Bool("json", false, "print the transfers (or the checkpoints, with --follow) as JSON")
+	logOpts := addLogFlags(statusCmd)
+
+	parseFlags(statusCmd, args)
+	logOpts.apply()
+
+	if *follow {
+		ctx, stop := interruptContext(context.Background())
+		defer stop()
+
+		events, err := c.events(ctx)
+		if err != nil {
+			logError("status: %s", err)
+			os.Exit(1)
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		for cp := range events {
+			if *jsonOutput {
+				enc.Encode(&cp)
+			} else {
+				printRemoteCheckpoint(&cp)
+			}
+		}
+		return
+	}
+
+	var statuses []transferStatus
+	if err := c.call(http.MethodGet, "/transfers", nil, &statuses); err != nil {
+		logError("status: %s", err)
+		os.Exit(1)
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(statuses)
+		return
+	}
+
+	if len(statuses) == 0 {
+		fmt.Println("no transfer")
+		return
+	}
+
+	printer := NewTablePrinter([]string{"Source", "File", "State", "Progress", "Speed"})
+	printer.SetAligns([]Alignment{AlignLeft, AlignLeft, AlignLeft, AlignRight, AlignRight})
+	for _, status := range statuses {
+		progress := formatSize(int64(status.Offset))
+		if status.Size > 0 {
+			progress = strconv.Itoa(int(status.Offset*100/status.Size)) + "% of " + formatSize(int64(status.Size))
+		}
+
+		speed := ""
+		if status.State == itemStateDownloading {
+			speed = formatSpeed(status.Speed)
+		}
+		printer.AddRow(Row{status.Source, status.File, string(status.State), progress, speed})
+	}
+	printer.Print()
+}
+
+// parseRemoteFlag extracts the --remote flag preceding the subcommand, e.g. "--remote host:9100 get url".
+func parseRemoteFlag(args []string) (string, []string, bool) {
+	if len(args) == 0 {
+		return "", args, false
+	}
+
+	switch {
+	case args[0] == "--remote" || args[0] == "-remote":
+		if len(args) < 2 {
+			return "", nil, true
+		}
+		return args[1], args[2:], true
+	case strings.HasPrefix(args[0], "--remote="):
+		return strings.TrimPrefix(args[0], "--remote="), args[1:], true
+	case strings.HasPrefix(args[0], "-remote="):
+		return strings.TrimPrefix(args[0], "-remote="), args[1:], true
+	}
+	return "", args, false
+}
+
+// remoteCommand runs the search, get and status subcommands against the daemon at addr.
+func remoteCommand(addr string, args []string) {
+	if addr == "" || len(args) == 0 {
+		fmt.Println("usage: --remote host:port search|get|status ...")
+		os.Exit(1)
+	}
+
+	c := newRemoteClient(addr, config.DaemonToken)
+	switch args[0] {
+	case "search":
+		remoteSearch(c, args[1:])
+	case "get":
+		remoteGet(c, args[1:])
+	case "status":
+		remoteStatus(c, args[1:])
+	default:
+		fmt.Println("no such remote command: ", args[0])
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ostafen/xdcc-cli/pkg/search"
+)
+
+const (
+	outputFormatMarkdown = "markdown"
+	outputFormatHTML     = "html"
+)
+
+// markupRenderer writes documents made of headings, paragraphs and tables, to be pasted into chats
+// and wikis (Markdown) or served as pages (HTML). The renderers are registered in markupRenderers,
+// under the name of their format.
+type markupRenderer interface {
+	Begin(w io.Writer, title string)
+	Heading(w io.Writer, text string)
+	Paragraph(w io.Writer, text string)
+	Table(w io.Writer, header []string, rows [][]string)
+	End(w io.Writer)
+}
+
+var markupRenderers = map[string]markupRenderer{
+	outputFormatMarkdown: markdownRenderer{},
+	outputFormatHTML:     htmlRenderer{},
+}
+
+type markdownRenderer struct{}
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "|", `\|`, "*", `\*`, "_", `\_`, "`", "\\`", "<", `\<`, "\n", " ")
+
+func (markdownRenderer) Begin(w io.Writer, title string) {
+	fmt.Fprintf(w, "# %s\n\n", markdownEscaper.Replace(title))
+}
+
+func (markdownRenderer) Heading(w io.Writer, text string) {
+	fmt.Fprintf(w, "## %s\n\n", markdownEscaper.Replace(text))
+}
+
+func (markdownRenderer) Paragraph(w io.Writer, text string) {
+	fmt.Fprintf(w, "%s\n\n", markdownEscaper.Replace(text))
+}
+
+func (markdownRenderer) Table(w io.Writer, header []string, rows [][]string) {
+	cells := func(row []string) string {
+		escaped := make([]string, len(row))
+		for i, cell := range row {
+			escaped[i] = markdownEscaper.Replace(cell)
+		}
+		return "| " + strings.Join(escaped, " | ") + " |\n"
+	}
+
+	fmt.Fprint(w, cells(header))
+	fmt.Fprint(w, strings.Repeat("| --- ", len(header))+"|\n")
+	for _, row := range rows {
+		fmt.Fprint(w, cells(row))
+	}
+	fmt.Fprintln(w)
+}
+
+func (markdownRenderer) End(w io.Writer) {}
+
+type htmlRenderer struct{}
+
+const htmlStyle = `body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; }
+th { background: #f0f0f0; }
+tr:nth-child(even) td { background: #fafafa; }`
+
+func (htmlRenderer) Begin(w io.Writer, title string) {
+	fmt.Fprintf(w, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n<style>\n%s\n</style>\n</head>\n<body>\n<h1>%s</h1>\n",
+		html.EscapeString(title), htmlStyle, html.EscapeString(title))
+}
+
+func (htmlRenderer) Heading(w io.Writer, text string) {
+	fmt.Fprintf(w, "<h2>%s</h2>\n", html.EscapeString(text))
+}
+
+func (htmlRenderer) Paragraph(w io.Writer, text string) {
+	fmt.Fprintf(w, "<p>%s</p>\n", html.EscapeString(text))
+}
+
+func (htmlRenderer) Table(w io.Writer, header []string, rows [][]string) {
+	fmt.Fprint(w, "<table>\n<tr>")
+	for _, cell := range header {
+		fmt.Fprintf(w, "<th>%s</th>", html.EscapeString(cell))
+	}
+	fmt.Fprint(w, "</tr>\n")
+
+	for _, row := range rows {
+		fmt.Fprint(w, "<tr>")
+		for _, cell := range row {
+			fmt.Fprintf(w, "<td>%s</td>", html.EscapeString(cell))
+		}
+		fmt.Fprint(w, "</tr>\n")
+	}
+	fmt.Fprint(w, "</table>\n")
+}
+
+func (htmlRenderer) End(w io.Writer) {
+	fmt.Fprint(w, "</body>\n</html>\n")
+}
+
+// resultCells returns the header and the rows of the displayed columns of the results, numbered from first.
+func resultCells(res []search.FileInfo, first int, opts *printOptions) ([]string, [][]string) {
+	columns := displayedColumns(res, opts)
+
+	header := []string{"#"}
+	for _, col := range columns {
+		header = append(header, col.header)
+	}
+
+	rows := make([][]string, 0, len(res))
+	for i := range res {
+		row := []string{strconv.Itoa(first + i)}
+		for _, col := range columns {
+			row = append(row, col.value(&res[i], opts))
+		}
+		rows = append(rows, row)
+	}
+	return header, rows
+}
+
+// writeResultsMarkup writes the results, numbered from first, as a document of the renderer.
+func writeResultsMarkup(w io.Writer, r markupRenderer, title string, res []search.FileInfo, first int, opts *printOptions) {
+	r.Begin(w, title)
+	header, rows := resultCells(res, first, opts)
+	r.Table(w, header, rows)
+	r.End(w)
+}
+
+// printGroupedResultsMarkup prints the grouped results, with a table by show season.
+func printGroupedResultsMarkup(r markupRenderer, rows []groupRow, first int, opts *printOptions) {
+	r.Begin(os.Stdout, "Search results")
+	for i := 0; i < len(rows); {
+		group := rows[i].group
+		j := i + 1
+		for j < len(rows) && rows[j].group == group {
+			j++
+		}
+
+		if group != nil {
+			r.Heading(os.Stdout, group.String())
+		} else {
+			r.Heading(os.Stdout, fmt.Sprintf("Other results (%d)", j-i))
+		}
+
+		res := groupedCandidates(rows[i:j])
+		header, cells := resultCells(res, first+i, opts)
+		header = append([]string{header[0], "Ep", "Res", "Alt"}, header[1:]...)
+		for k := range cells {
+			alternatives := ""
+			if n := rows[i+k].alternatives(); n > 0 {
+				alternatives = "+" + strconv.Itoa(n)
+			}
+			cells[k] = append([]string{cells[k][0], rows[i+k].episodeLabel(), parseResolution(res[k].Name), alternatives}, cells[k][1:]...)
+		}
+		r.Table(os.Stdout, header, cells)
+		i = j
+	}
+	r.End(os.Stdout)
+}
+
+// printBatchResultsMarkup prints the results of each query of a batch search, with a table by query.
+func printBatchResultsMarkup(r markupRenderer, batch []batchQuery, opts *printOptions) {
+	r.Begin(os.Stdout, "Search results")
+	for i := range batch {
+		res := batch[i].Results
+		sortResults(res, opts.sortBy)
+		start, end := pageBounds(len(res), opts.limit, opts.page)
+
+		r.Heading(os.Stdout, fmt.Sprintf("%s: %d results", batch[i].Query, len(res)))
+		header, rows := resultCells(res[start:end], start+1, opts)
+		r.Table(os.Stdout, header, rows)
+	}
+	r.End(os.Stdout)
+}
+
+// writeStatsSection writes the report of a batch, under the given heading if any.
+func writeStatsSection(w io.Writer, r markupRenderer, heading string, stats *batchStats) {
+	if heading != "" {
+		r.Heading(w, heading)
+	}
+
+	r.Paragraph(w, fmt.Sprintf("%d completed, %d failed, %d cancelled, %d skipped in %s. Received %s, average speed %s, peak speed %s.",
+		stats.Completed, stats.Failed, stats.Cancelled, stats.Skipped, formatSeconds(stats.Duration),
+		formatSize(int64(stats.Received)), formatSpeed(stats.AverageSpeed), formatSpeed(stats.PeakSpeed)))
+
+	transfers := make([][]string, 0, len(stats.Transfers))
+	for _, t := range stats.Transfers {
+		transfers = append(transfers, []string{t.Status, t.Name, t.Source, formatSize(int64(t.Bytes)),
+			formatSeconds(t.Duration), formatSpeed(t.AverageSpeed), formatSpeed(t.PeakSpeed)})
+	}
+	r.Table(w, []string{"Status", "File", "Source", "Received", "Duration", "Speed", "Peak"}, transfers)
+
+	if len(stats.Bots) > 1 {
+		bots := make([][]string, 0, len(stats.Bots))
+		for _, bot := range stats.Bots {
+			bots = append(bots, []string{bot.Network + "/" + bot.Bot, strconv.Itoa(bot.Completed), strconv.Itoa(bot.Failed),
+				formatSize(int64(bot.Bytes)), formatSpeed(bot.AverageSpeed), formatSpeed(bot.PeakSpeed)})
+		}
+		r.Table(w, []string{"Bot", "Completed", "Failed", "Received", "Speed", "Peak"}, bots)
+	}
+
+	if len(stats.Failures) > 0 {
+		failures := make([][]string, 0, len(stats.Failures))
+		for _, failure := range stats.Failures {
+			failures = append(failures, []string{failure.Source, failure.Reason, failure.Error})
+		}
+		r.Table(w, []string{"Source", "Reason", "Error"}, failures)
+	}
+}
+
+// writeStatsMarkup writes the report of the batch as a document of the renderer.
+func writeStatsMarkup(w io.Writer, r markupRenderer, stats *batchStats) {
+	r.Begin(w, "Transfers of "+stats.Started.Local().Format(time.RFC1123))
+	writeStatsSection(w, r, "", stats)
+	r.End(w)
+}
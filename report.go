@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	statsText = "text"
+	statsJSON = "json"
+	statsNone = "none"
+)
+
+func isValidStatsFormat(format string) bool {
+	_, markup := markupRenderers[format]
+	return format == statsText || format == statsJSON || format == statsNone || markup
+}
+
+// transferStats are the statistics of a transfer of the batch.
+type transferStats struct {
+	Source       string  `json:"source"`
+	Name         string  `json:"name,omitempty"`
+	Status       string  `json:"status"`
+	Bytes        uint64  `json:"bytes"`    // received by this run
+	Duration     float64 `json:"duration"` // time spent downloading, in seconds
+	AverageSpeed float64 `json:"averageSpeed"`
+	PeakSpeed    float64 `json:"peakSpeed"`
+}
+
+// botTransferStats are the statistics of the transfers of a bot.
+type botTransferStats struct {
+	Network      string  `json:"network"`
+	Bot          string  `json:"bot"`
+	Completed    int     `json:"completed"`
+	Failed       int     `json:"failed"`
+	Bytes        uint64  `json:"bytes"`
+	AverageSpeed float64 `json:"averageSpeed"`
+	PeakSpeed    float64 `json:"peakSpeed"`
+
+	duration float64
+}
+
+// batchStats is the report of a batch run, printed at its end according to --stats.
+// The speeds are in bytes per second.
+type batchStats struct {
+	transferSummary
+	Started      time.Time          `json:"started"`
+	Duration     float64            `json:"duration"` // wall time of the batch, in seconds
+	Received     uint64             `json:"received"` // bytes received by this run, without the resumed parts
+	AverageSpeed float64            `json:"averageSpeed"`
+	PeakSpeed    float64            `json:"peakSpeed"` // of the fastest transfer
+	Bots         []botTransferStats `json:"bots"`
+	Transfers    []transferStats    `json:"transfers"`
+}
+
+func averageSpeed(bytes uint64, seconds float64) float64 {
+	if seconds <= 0 {
+		return 0
+	}
+	return float64(bytes) / seconds
+}
+
+func (batch *Batch) Stats() batchStats {
+	stats := batchStats{transferSummary: batch.Summary()}
+
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	stats.Started = batch.started
+	stats.Duration = time.Since(batch.started).Seconds()
+
+	bots := make(map[string]*botTransferStats)
+	stats.Bots = make([]botTransferStats, 0)
+	stats.Transfers = make([]transferStats, 0, len(batch.items))
+	for _, item := range batch.items {
+		elapsed := item.elapsed
+		if item.state == itemStateDownloading {
+			elapsed += time.Since(item.started)
+		}
+
+		t := transferStats{
+			Source:       item.url.String(),
+			Name:         item.fileName,
+			Status:       string(item.state),
+			Bytes:        item.received,
+			Duration:     elapsed.Seconds(),
+			AverageSpeed: averageSpeed(item.received, elapsed.Seconds()),
+			PeakSpeed:    item.peakSpeed,
+		}
+		stats.Transfers = append(stats.Transfers, t)
+
+		stats.Received += item.received
+		if item.peakSpeed > stats.PeakSpeed {
+			stats.PeakSpeed = item.peakSpeed
+		}
+
+		key := item.url.Network + "/" + item.url.UserName
+		bot, ok := bots[key]
+		if !ok {
+			bot = &botTransferStats{Network: item.url.Network, Bot: item.url.UserName}
+			bots[key] = bot
+		}
+
+		switch item.state {
+		case itemStateCompleted:
+			bot.Completed++
+		case itemStateFailed:
+			bot.Failed++
+		}
+		bot.Bytes += item.received
+		bot.duration += t.Duration
+		if item.peakSpeed > bot.PeakSpeed {
+			bot.PeakSpeed = item.peakSpeed
+		}
+	}
+
+	for _, bot := range bots {
+		bot.AverageSpeed = averageSpeed(bot.Bytes, bot.duration)
+		stats.Bots = append(stats.Bots, *bot)
+	}
+	sort.Slice(stats.Bots, func(i, j int) bool {
+		return stats.Bots[i].Bytes > stats.Bots[j].Bytes
+	})
+
+	stats.AverageSpeed = averageSpeed(stats.Received, stats.Duration)
+	return stats
+}
+
+func formatSpeed(speed float64) string {
+	return formatSize(int64(speed)) + "/s"
+}
+
+func formatSeconds(seconds float64) string {
+	return (time.Duration(seconds) * time.Second).String()
+}
+
+// printStatsText writes a human readable report of the batch.
+func printStatsText(w io.Writer, stats *batchStats) {
+	fmt.Fprintf(w, "\n=== %d completed, %d failed, %d cancelled, %d skipped in %s\n",
+		stats.Completed, stats.Failed, stats.Cancelled, stats.Skipped, formatSeconds(stats.Duration))
+	fmt.Fprintf(w, "received %s, average speed %s, peak speed %s\n",
+		formatSize(int64(stats.Received)), formatSpeed(stats.AverageSpeed), formatSpeed(stats.PeakSpeed))
+
+	if len(stats.Bots) > 1 {
+		fmt.Fprintf(w, "bots:\n")
+		for _, bot := range stats.Bots {
+			fmt.Fprintf(w, "  %s/%s: %d completed, %d failed, %s at %s (peak %s)\n", bot.Network, bot.Bot,
+				bot.Completed, bot.Failed, formatSize(int64(bot.Bytes)), formatSpeed(bot.AverageSpeed), formatSpeed(bot.PeakSpeed))
+		}
+	}
+
+	fmt.Fprintf(w, "transfers:\n")
+	for _, t := range stats.Transfers {
+		name := t.Name
+		if name == "" {
+			name = t.Source
+		}
+		fmt.Fprintf(w, "  %-9s %s: %s in %s at %s (peak %s)\n", t.Status, name,
+			formatSize(int64(t.Bytes)), formatSeconds(t.Duration), formatSpeed(t.AverageSpeed), formatSpeed(t.PeakSpeed))
+	}
+
+	if len(stats.Failures) > 0 {
+		fmt.Fprintf(w, "errors:\n")
+		for _, failure := range stats.Failures {
+			fmt.Fprintf(w, "  %s: %s\n", failure.Source, failure.Error)
+		}
+	}
+}
+
+// printStats prints the report of the batch in the format of --stats. The JSON, Markdown and HTML
+// reports go to stdout, the JSON one replacing the summary of the quiet runs, which it includes.
+func printStats(batch *Batch, format string) {
+	if r, ok := markupRenderers[format]; ok {
+		stats := batch.Stats()
+		writeStatsMarkup(os.Stdout, r, &stats)
+		return
+	}
+
+	switch format {
+	case statsJSON:
+		stats := batch.Stats()
+		data, _ := json.Marshal(&stats)
+		fmt.Println(string(data))
+	case statsText:
+		if isQuiet() {
+			summary := batch.Summary()
+			printSummary(&summary)
+			return
+		}
+		stats := batch.Stats()
+		printStatsText(os.Stderr, &stats)
+	default:
+		summary := batch.Summary()
+		if isQuiet() {
+			printSummary(&summary)
+		} else if summary.Failed > 0 {
+			logInfo("%d of %d transfers failed", summary.Failed, len(batch.items))
+		}
+	}
+}
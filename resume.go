@@ -0,0 +1,75 @@
+package main
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/ostafen/xdcc-cli/pkg/xdcc"
+)
+
+const resumeStateFileName = "resume.json"
+
+// resumeDatabase stores the block checksums of the partial downloads, keyed by absolute path,
+// so that they can be verified before being resumed.
+type resumeDatabase struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (db *resumeDatabase) update(filePath string, f func(states map[string]*xdcc.ResumeState, key string)) error {
+	key, err := filepath.Abs(filePath)
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	states := make(map[string]*xdcc.ResumeState)
+	if err := readJSONFile(db.path, &states); err != nil {
+		return err
+	}
+
+	f(states, key)
+	return writeJSONFile(db.path, states)
+}
+
+func (db *resumeDatabase) Load(filePath string) (*xdcc.ResumeState, error) {
+	key, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	states := make(map[string]*xdcc.ResumeState)
+	if err := readJSONFile(db.path, &states); err != nil {
+		return nil, err
+	}
+	return states[key], nil
+}
+
+func (db *resumeDatabase) Save(filePath string, state *xdcc.ResumeState) error {
+	return db.update(filePath, func(states map[string]*xdcc.ResumeState, key string) {
+		states[key] = state
+	})
+}
+
+func (db *resumeDatabase) Delete(filePath string) error {
+	return db.update(filePath, func(states map[string]*xdcc.ResumeState, key string) {
+		delete(states, key)
+	})
+}
+
+// resumeStore is nil if the resume database cannot be located, in which case partial files are not verified.
+var resumeStore xdcc.ResumeStore
+
+func setupResumeStore() {
+	path, err := dataFilePath(resumeStateFileName)
+	if err != nil {
+		logWarn("unable to locate resume database: %s", err)
+		return
+	}
+	resumeStore = &resumeDatabase{path: path}
+}
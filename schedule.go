@@ -0,0 +1,367 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scheduleRule tells whether the transfers can run at a given minute.
+type scheduleRule interface {
+	matches(t time.Time) bool
+}
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func parseWeekday(name string) (int, error) {
+	for i, n := range weekdayNames {
+		if strings.EqualFold(name, n) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid day %q", name)
+}
+
+// timeWindow is a window such as "mon-fri 01:00-07:00", possibly ending after midnight.
+// Its days are the ones on which it starts.
+type timeWindow struct {
+	days       [7]bool
+	start, end int // minutes from midnight
+}
+
+func parseClock(s string) (int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+
+	hours, err1 := strconv.Atoi(parts[0])
+	minutes, err2 := strconv.Atoi(parts[1])
+	if err1 != nil || err2 != nil || hours < 0 || minutes < 0 || minutes > 59 || hours*60+minutes > 24*60 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return hours*60 + minutes, nil
+}
+
+// parseDays parses days such as "mon-fri" or "sat,sun".
+func parseDays(s string) ([7]bool, error) {
+	var days [7]bool
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := parseWeekday(bounds[0])
+		if err != nil {
+			return days, err
+		}
+
+		last := first
+		if len(bounds) == 2 {
+			if last, err = parseWeekday(bounds[1]); err != nil {
+				return days, err
+			}
+		}
+
+		for d := first; ; d = (d + 1) % 7 {
+			days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseTimeWindow(s string) (*timeWindow, error) {
+	w := &timeWindow{days: [7]bool{true, true, true, true, true, true, true}}
+
+	fields := strings.Fields(s)
+	if len(fields) == 2 {
+		days, err := parseDays(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		w.days = days
+		fields = fields[1:]
+	}
+
+	bounds := strings.Split(fields[0], "-")
+	if len(fields) != 1 || len(bounds) != 2 {
+		return nil, fmt.Errorf("invalid window %q, expected e.g. 01:00-07:00 or mon-fri 01:00-07:00", s)
+	}
+
+	var err error
+	if w.start, err = parseClock(bounds[0]); err != nil {
+		return nil, err
+	}
+	if w.end, err = parseClock(bounds[1]); err != nil {
+		return nil, err
+	}
+
+	if w.start == w.end {
+		return nil, fmt.Errorf("empty window %q", s)
+	}
+	return w, nil
+}
+
+func (w *timeWindow) matches(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := int(t.Weekday())
+	if w.start < w.end {
+		return w.days[day] && minute >= w.start && minute < w.end
+	}
+	// the window ends after midnight
+	return w.days[day] && minute >= w.start || w.days[(day+6)%7] && minute < w.end
+}
+
+// cronRule is a cron expression (minute, hour, day of month, month and day of week)
+// matching the minutes during which the transfers can run.
+type cronRule struct {
+	minutes, hours, monthDays, months, weekDays []bool
+	anyMonthDay, anyWeekDay                     bool
+}
+
+// weekdayNumbers replaces the names of the days of a cron expression by their numbers.
+var weekdayNumbers = strings.NewReplacer("sun", "0", "mon", "1", "tue", "2", "wed", "3", "thu", "4", "fri", "5", "sat", "6")
+
+// parseCronField parses a field such as "*", "1-5", "*/15" or "0,30" whose values are between min and max.
+func parseCronField(s string, min, max int) ([]bool, error) {
+	values := make([]bool, max+1)
+	for _, part := range strings.Split(s, ",") {
+		step := 1
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			n, err := strconv.Atoi(part[idx+1:])
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", s)
+			}
+			step, part = n, part[:idx]
+		}
+
+		first, last := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			n, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value in %q", s)
+			}
+			first, last = n, n
+			if len(bounds) == 2 {
+				if last, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid value in %q", s)
+				}
+			} else if step > 1 {
+				last = max
+			}
+		}
+
+		if first < min || last > max || first > last {
+			return nil, fmt.Errorf("%q is out of range %d-%d", s, min, max)
+		}
+		for v := first; v <= last; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+func parseCronRule(s string) (*cronRule, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q, expected 5 fields", s)
+	}
+
+	rule := &cronRule{anyMonthDay: fields[2] == "*", anyWeekDay: fields[4] == "*"}
+
+	var err error
+	if rule.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if rule.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if rule.monthDays, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if rule.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if rule.weekDays, err = parseCronField(weekdayNumbers.Replace(strings.ToLower(fields[4])), 0, 7); err != nil {
+		return nil, err
+	}
+	rule.weekDays[0] = rule.weekDays[0] || rule.weekDays[7] // 7 is sunday as well
+	return rule, nil
+}
+
+func (rule *cronRule) matches(t time.Time) bool {
+	if !rule.minutes[t.Minute()] || !rule.hours[t.Hour()] || !rule.months[t.Month()] {
+		return false
+	}
+
+	monthDay, weekDay := rule.monthDays[t.Day()], rule.weekDays[t.Weekday()]
+	switch {
+	case rule.anyMonthDay:
+		return weekDay
+	case rule.anyWeekDay:
+		return monthDay
+	}
+	// as in cron, either day matches when both are restricted
+	return monthDay || weekDay
+}
+
+// schedule is the set of windows during which the transfers can run, in local time.
+type schedule struct {
+	rules []scheduleRule
+}
+
+// parseSchedule parses the rules of a schedule, each of which is either a window
+// such as "01:00-07:00" or "sat,sun 00:00-24:00", or a cron expression.
+func parseSchedule(specs []string) (*schedule, error) {
+	s := &schedule{}
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		var rule scheduleRule
+		var err error
+		if len(strings.Fields(spec)) == 5 {
+			rule, err = parseCronRule(spec)
+		} else {
+			rule, err = parseTimeWindow(spec)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		s.rules = append(s.rules, rule)
+	}
+
+	if len(s.rules) == 0 {
+		return nil, nil
+	}
+	return s, nil
+}
+
+func (s *schedule) isOpen(t time.Time) bool {
+	for _, rule := range s.rules {
+		if rule.matches(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxScheduleLookahead bounds the search of the next opening or closing of a schedule.
+const maxScheduleLookahead = 366 * 24 * time.Hour
+
+// next returns the first minute after t at which the schedule is open, or closed if open is false.
+// It returns the zero time if the schedule never changes.
+func (s *schedule) next(t time.Time, open bool) time.Time {
+	end := t.Add(maxScheduleLookahead)
+	for m := t.Truncate(time.Minute).Add(time.Minute); m.Before(end); m = m.Add(time.Minute) {
+		if s.isOpen(m) == open {
+			return m
+		}
+	}
+	return time.Time{}
+}
+
+// waitSchedule waits for the schedule of the options to open, unless the batch is stopped or the
+// context cancelled before. It returns false in that case.
+func (batch *Batch) waitSchedule(ctx context.Context, opts *transferOptions) bool {
+	if opts.schedule == nil || opts.schedule.isOpen(time.Now()) {
+		return true
+	}
+
+	batch.waitUntil(ctx, opts.schedule.next(time.Now(), true))
+	return ctx.Err() == nil && !batch.isStopping()
+}
+
+// pauseAtScheduleEnd pauses the transfer of the item through abort once the schedule closes.
+// The returned function stops watching the schedule.
+func (batch *Batch) pauseAtScheduleEnd(item *batchItem, abort context.CancelFunc, opts *transferOptions) func() {
+	if opts.schedule == nil {
+		return func() {}
+	}
+
+	batch.mu.Lock()
+	item.pausing = false
+	batch.mu.Unlock()
+
+	end := opts.schedule.next(time.Now(), false)
+	if end.IsZero() {
+		return func() {}
+	}
+
+	timer := time.AfterFunc(time.Until(end), func() {
+		batch.mu.Lock()
+		item.pausing = true
+		batch.mu.Unlock()
+		abort()
+	})
+	return func() { timer.Stop() }
+}
+
+// checkSchedule parses the schedule of the options, or else the one of the configuration.
+func checkSchedule(opts *transferOptions) error {
+	specs := config.Schedule
+	if opts.scheduleSpec != "" {
+		specs = strings.Split(opts.scheduleSpec, ";")
+	}
+
+	s, err := parseSchedule(specs)
+	if err != nil {
+		return fmt.Errorf("invalid schedule: %s", err)
+	}
+
+	if s != nil && !s.isOpen(time.Now()) && s.next(time.Now(), true).IsZero() {
+		return errors.New("the schedule never opens")
+	}
+	opts.schedule = s
+	return nil
+}
+
+// setPaused marks the item as waiting for the schedule to reopen, unless the batch is stopping.
+func (batch *Batch) setPaused(item *batchItem) {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	item.speed = 0
+	if batch.stopping {
+		batch.transitionLocked(item, itemStateCancelled)
+		return
+	}
+	batch.transitionLocked(item, itemStatePaused)
+}
+
+func (batch *Batch) isPausing(item *batchItem) bool {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	return item.pausing
+}
+
+// resumePaused marks the paused item as connecting again, unless it has been cancelled meanwhile.
+func (batch *Batch) resumePaused(item *batchItem) bool {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	item.pausing = false
+	if batch.stopping || item.state != itemStatePaused {
+		if item.state == itemStatePaused {
+			batch.transitionLocked(item, itemStateCancelled)
+		}
+		return false
+	}
+	batch.transitionLocked(item, itemStateConnecting)
+	return true
+}
+
+func (batch *Batch) isStopping() bool {
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	return batch.stopping
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/ostafen/xdcc-cli/pkg/search"
+)
+
+const (
+	sizeUnitsBinary = "binary"
+	sizeUnitsSI     = "si"
+)
+
+type sizeUnit struct {
+	value  int64
+	suffix string
+}
+
+var binaryUnits = []sizeUnit{
+	{search.TeraByte, "TiB"},
+	{search.GigaByte, "GiB"},
+	{search.MegaByte, "MiB"},
+	{search.KiloByte, "KiB"},
+}
+
+var siUnits = []sizeUnit{
+	{1000 * 1000 * 1000 * 1000, "TB"},
+	{1000 * 1000 * 1000, "GB"},
+	{1000 * 1000, "MB"},
+	{1000, "kB"},
+}
+
+func FloatToString(value float64) string {
+	if value-float64(int64(value)) > 0 {
+		return strconv.FormatFloat(value, 'f', 2, 32)
+	}
+	return strconv.FormatFloat(value, 'f', 0, 32)
+}
+
+func formatSizeWithUnits(size int64, units []sizeUnit) string {
+	if size < 0 {
+		return "--"
+	}
+
+	for _, unit := range units {
+		if size >= unit.value {
+			return FloatToString(float64(size)/float64(unit.value)) + unit.suffix
+		}
+	}
+	return FloatToString(float64(size)) + "B"
+}
+
+// formatSize returns a human readable representation of the size,
+// using the units chosen in the configuration.
+func formatSize(size int64) string {
+	if config.SizeUnits == sizeUnitsSI {
+		return formatSizeWithUnits(size, siUnits)
+	}
+	return formatSizeWithUnits(size, binaryUnits)
+}
+
+// sizeValue is a flag.Value accepting sizes in the format of search.ParseFileSize.
+type sizeValue int64
+
+func (size *sizeValue) String() string {
+	if size == nil || *size == 0 {
+		return ""
+	}
+	return formatSize(int64(*size))
+}
+
+func (size *sizeValue) Set(s string) error {
+	value, err := search.ParseFileSize(s)
+	if err != nil {
+		return err
+	}
+	*size = sizeValue(value)
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+// handleStatusRequests prints the status of the batch each time a status signal is received
+// or, if readKeys is set, the "s" key is followed by enter. The returned function stops handling the requests.
+func handleStatusRequests(batch *Batch, readKeys bool) func() {
+	sigChan := make(chan os.Signal, 1)
+	if len(statusSignals) > 0 {
+		signal.Notify(sigChan, statusSignals...)
+	}
+
+	done := make(chan struct{})
+
+	keyChan := make(chan struct{})
+	go func() {
+		if !readKeys {
+			return
+		}
+
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if strings.TrimSpace(scanner.Text()) != "s" {
+				continue
+			}
+
+			select {
+			case keyChan <- struct{}{}:
+			case <-done:
+				return
+			}
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case <-sigChan:
+				batch.PrintStatus(os.Stderr)
+			case <-keyChan:
+				batch.PrintStatus(os.Stderr)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigChan)
+		close(done)
+	}
+}
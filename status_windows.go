@@ -0,0 +1,9 @@
+//go:build windows
+// +build windows
+
+package main
+
+import "os"
+
+// SIGUSR1 is not available on windows: the status can only be requested from the keyboard.
+var statusSignals = []os.Signal{}
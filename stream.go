@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ostafen/xdcc-cli/pkg/search"
+)
+
+const (
+	maxStreamedResults = 99999 // sizes the column of the numbers of the streamed results
+	minStreamTextWidth = 40    // minimum width of the text columns of the streamed table, such as the names
+)
+
+// resultStream prints the results of each search engine as soon as it answers, instead of waiting
+// for the slowest one. The results are numbered in the order they are printed: a table whose columns
+// are sized on the first results received, CSV rows, or one JSON object per line (NDJSON).
+type resultStream struct {
+	opts    *printOptions
+	columns []*resultColumn
+	printer *TablePrinter
+	csv     *csv.Writer
+	json    *json.Encoder
+	printed int
+}
+
+func isValidStreamFormat(format string) bool {
+	return format == outputFormatTable || format == outputFormatJSON || format == outputFormatCSV
+}
+
+func newResultStream(opts *printOptions) *resultStream {
+	return &resultStream{opts: opts}
+}
+
+// start prints the header of the table or of the CSV output, with the columns of the first results.
+func (stream *resultStream) start(res []search.FileInfo) {
+	stream.columns = displayedColumns(res, stream.opts)
+
+	switch stream.opts.format {
+	case outputFormatJSON:
+		stream.json = json.NewEncoder(os.Stdout)
+	case outputFormatCSV:
+		stream.csv = csv.NewWriter(os.Stdout)
+		header := []string{"#"}
+		for _, col := range stream.columns {
+			header = append(header, col.name)
+		}
+		stream.csv.Write(header)
+	default:
+		headers := []string{"#"}
+		aligns := []Alignment{AlignRight}
+		widths := []int{0}
+		for _, col := range stream.columns {
+			headers = append(headers, col.header)
+			aligns = append(aligns, col.align)
+			widths = append(widths, col.maxWidth)
+		}
+
+		stream.printer = NewTablePrinter(headers)
+		stream.printer.SetAligns(aligns)
+		stream.printer.SetMaxWidths(widths)
+		// the following results may have wider numbers and longer names than the first ones
+		sizing := Row{strconv.Itoa(maxStreamedResults)}
+		for _, col := range stream.columns {
+			width := 0
+			if col.align == AlignLeft {
+				width = minStreamTextWidth
+			}
+			sizing = append(sizing, strings.Repeat(" ", width))
+		}
+		stream.printer.AddRow(sizing)
+		for i := range res {
+			stream.printer.AddRow(stream.row(&res[i], i+1))
+		}
+		stream.printer.StartStream()
+	}
+}
+
+func (stream *resultStream) row(info *search.FileInfo, num int) Row {
+	row := Row{strconv.Itoa(num)}
+	for _, col := range stream.columns {
+		row = append(row, col.value(info, stream.opts))
+	}
+	return row
+}
+
+// print prints the results of a search engine, sorted as the other outputs.
+func (stream *resultStream) print(provider string, res []search.FileInfo) {
+	if len(res) == 0 {
+		return
+	}
+	if stream.printed == 0 {
+		stream.start(res)
+	}
+
+	sortResults(res, stream.opts.sortBy)
+	switch {
+	case stream.json != nil:
+		for _, record := range newResultRecords(res, stream.opts) {
+			record.Provider = provider
+			if err := stream.json.Encode(record); err != nil {
+				logError("unable to print results: %s", err)
+			}
+		}
+	case stream.csv != nil:
+		for i := range res {
+			stream.csv.Write(stream.row(&res[i], stream.printed+i+1))
+		}
+		stream.csv.Flush()
+		if err := stream.csv.Error(); err != nil {
+			logError("unable to print results: %s", err)
+		}
+	default:
+		for i := range res {
+			info := &res[i]
+			stream.printer.StreamRow(stream.row(info, stream.printed+i+1), func(col int) string {
+				if col == 0 || stream.columns[col-1].color == nil {
+					return ""
+				}
+				return stream.columns[col-1].color(info)
+			})
+		}
+	}
+	stream.printed += len(res)
+}
+
+// end closes the table, reporting the number of results and of the search engines which answered.
+func (stream *resultStream) end(numProviders int, pending int, failed int) {
+	if stream.printer == nil {
+		return
+	}
+
+	stream.printer.EndStream()
+	fmt.Printf("\n%d results from %d/%d search engines\n", stream.printed, numProviders-pending-failed, numProviders)
+}
+
+// streamResults prints the results delivered on resultsChan as each provider answers, until every provider
+// has answered or the budget expires. A budget <= 0 means no time limit. It returns the printed results,
+// the number of providers which are still running and the number of providers which failed.
+func streamResults(resultsChan <-chan search.ProviderResult, numProviders int, budget time.Duration, opts *printOptions) ([]search.FileInfo, int, int) {
+	res := make([]search.FileInfo, 0, search.MaxResults)
+	stream := newResultStream(opts)
+
+	var timeout <-chan time.Time
+	if budget > 0 {
+		timeout = time.After(budget)
+	}
+
+	pending, failed := numProviders, 0
+loop:
+	for pending > 0 {
+		select {
+		case r := <-resultsChan:
+			if r.Err == nil {
+				stream.print(r.Provider.Name(), r.Results)
+				res = append(res, r.Results...)
+			} else {
+				if r.Err == search.ErrProviderTimeout {
+					logAt(LogNormal, "%s: timed out, results may be incomplete", r.Provider.Name())
+				}
+				failed++
+			}
+			pending--
+		case <-timeout:
+			break loop
+		}
+	}
+
+	stream.end(numProviders, pending, failed)
+	return res, pending, failed
+}
@@ -4,15 +4,31 @@ import (
 	"fmt"
 	"sort"
 	"strings"
+	"unicode/utf8"
 )
 
 type Row []string
 
+// Alignment is the position of the values within their column.
+type Alignment int
+
+const (
+	AlignCenter Alignment = iota
+	AlignLeft
+	AlignRight
+)
+
 type TablePrinter struct {
 	Headers   []string
 	Rows      []Row
 	MaxRows   int
 	MaxWidths []int
+	Aligns    []Alignment // centered by default
+
+	// CellColor returns the color of a cell of the given row, if any.
+	CellColor func(row int, col int) string
+
+	streamWidths []int // widths of the columns of a table printed row by row, see StartStream
 }
 
 func NewTablePrinter(headers []string) *TablePrinter {
@@ -26,24 +42,54 @@ func (printer *TablePrinter) SetMaxWidths(widths []int) {
 	printer.MaxWidths = widths
 }
 
+func (printer *TablePrinter) SetAligns(aligns []Alignment) {
+	printer.Aligns = aligns
+}
+
+func strWidth(s string) int {
+	return utf8.RuneCountInString(s)
+}
+
 func centerString(s string, width int) string {
-	padSpace := width - len(s)
+	padSpace := width - strWidth(s)
 	leftPadding := padSpace / 2
 	rightPadding := padSpace - leftPadding
 	return strings.Repeat(" ", leftPadding) + s + strings.Repeat(" ", rightPadding)
 }
 
 func cutStr(s string, maxSize int) string {
-	if len(s) <= maxSize {
+	if strWidth(s) <= maxSize {
 		return s
 	}
-	return s[:maxSize-3] + "..."
+	return string([]rune(s)[:maxSize-3]) + "..."
 }
 
 func formatStr(s string, maxSize int) string {
 	return centerString(cutStr(s, maxSize), maxSize)
 }
 
+// alignStr cuts s to the width of the column and pads it according to align,
+// keeping a space between the values and the borders.
+func alignStr(s string, width int, align Alignment) string {
+	if align == AlignCenter || width <= paddingDefault {
+		return formatStr(s, width)
+	}
+
+	s = cutStr(s, width-paddingDefault)
+	padding := strings.Repeat(" ", width-paddingDefault-strWidth(s))
+	if align == AlignRight {
+		return " " + padding + s + " "
+	}
+	return " " + s + padding + " "
+}
+
+func (printer *TablePrinter) align(col int) Alignment {
+	if col < len(printer.Aligns) {
+		return printer.Aligns[col]
+	}
+	return AlignCenter
+}
+
 const paddingDefault = 2
 
 func (printer *TablePrinter) NumRows() int {
@@ -64,14 +110,14 @@ func (printer *TablePrinter) hasRows() bool {
 func (printer *TablePrinter) computeColumnWidthds() []int {
 	widths := make([]int, printer.NumCols())
 	for i := 0; i < printer.NumCols(); i++ {
-		widths[i] = len(printer.Headers[i])
+		widths[i] = strWidth(printer.Headers[i])
 	}
 
 	if printer.hasRows() {
 		for col := 0; col < printer.NumCols(); col++ {
 			for row := 0; row < len(printer.Rows); row++ {
-				if len(printer.Rows[row][col]) > widths[col] {
-					widths[col] = len(printer.Rows[row][col])
+				if w := strWidth(printer.Rows[row][col]); w > widths[col] {
+					widths[col] = w
 				}
 			}
 		}
@@ -90,12 +136,16 @@ func (printer *TablePrinter) computeColumnWidthds() []int {
 	return widths
 }
 
-func (printer *TablePrinter) renderRow(s []string, colWidths []int) string {
+// renderRow renders the cells of a row, coloring each of them with the color returned by color, if any.
+func (printer *TablePrinter) renderRow(s []string, colWidths []int, color func(col int) string) string {
 	content := "|"
-	for i := 0; i < len(printer.Headers)-1; i++ {
-		content += formatStr(s[i], colWidths[i]) + "|"
+	for i := 0; i < printer.NumCols(); i++ {
+		cell := alignStr(s[i], colWidths[i], printer.align(i))
+		if color != nil {
+			cell = colorize(cell, color(i))
+		}
+		content += cell + "|"
 	}
-	content += formatStr(s[printer.NumCols()-1], colWidths[printer.NumCols()-1]) + "|"
 	return content
 }
 
@@ -110,7 +160,7 @@ func (printer *TablePrinter) renderLine(colWidths []int) string {
 
 func (printer *TablePrinter) renderHeader(colWidths []int) {
 	fmt.Println(printer.renderLine(colWidths))
-	fmt.Println(printer.renderRow(printer.Headers, colWidths))
+	fmt.Println(printer.renderRow(printer.Headers, colWidths, func(int) string { return colorBold }))
 	fmt.Println(printer.renderLine(colWidths))
 }
 
@@ -141,9 +191,33 @@ func (printer *TablePrinter) Print() {
 
 	printer.renderHeader(colWidths)
 	if printer.hasRows() {
-		for _, row := range printer.Rows {
-			fmt.Println(printer.renderRow(row, colWidths))
+		for i, row := range printer.Rows {
+			var color func(col int) string
+			if printer.CellColor != nil {
+				i := i
+				color = func(col int) string { return printer.CellColor(i, col) }
+			}
+			fmt.Println(printer.renderRow(row, colWidths, color))
 		}
 		fmt.Println(printer.renderLine(colWidths))
 	}
 }
+
+// StartStream prints the header of a table whose rows are printed as they come with StreamRow.
+// The widths of the columns are computed from the rows added so far, and the longer values
+// of the following rows are cut, so that the table stays aligned.
+func (printer *TablePrinter) StartStream() {
+	printer.streamWidths = printer.computeColumnWidthds()
+	printer.renderHeader(printer.streamWidths)
+}
+
+// StreamRow prints a row of a table started with StartStream, coloring its cells with the color
+// returned by color, if any.
+func (printer *TablePrinter) StreamRow(r Row, color func(col int) string) {
+	fmt.Println(printer.renderRow(r, printer.streamWidths, color))
+}
+
+// EndStream closes a table started with StartStream.
+func (printer *TablePrinter) EndStream() {
+	fmt.Println(printer.renderLine(printer.streamWidths))
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ostafen/xdcc-cli/pkg/search"
+)
+
+const watchStateFileName = "watch.json"
+
+// watchState maps each watched query to the packs already announced for it.
+type watchState map[string][]string
+
+func packKey(info *search.FileInfo) string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s", info.Network, info.Channel, info.BotName, info.Slot, info.Name)
+}
+
+func loadWatchState() (watchState, string, error) {
+	path, err := dataFilePath(watchStateFileName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	state := make(watchState)
+	if err := readJSONFile(path, &state); err != nil {
+		return nil, "", err
+	}
+	return state, path, nil
+}
+
+// updateSeen records the given results as seen for the query
+// and returns the ones which were never seen before.
+func (state watchState) updateSeen(query string, res []search.FileInfo) []search.FileInfo {
+	seen := make(map[string]bool)
+	for _, key := range state[query] {
+		seen[key] = true
+	}
+
+	newResults := make([]search.FileInfo, 0)
+	for _, fileInfo := range res {
+		key := packKey(&fileInfo)
+		if !seen[key] {
+			seen[key] = true
+			state[query] = append(state[query], key)
+			newResults = append(newResults, fileInfo)
+		}
+	}
+	return newResults
+}
+
+type watchNotification struct {
+	Query   string            `json:"query"`
+	Results []search.FileInfo `json:"results"`
+}
+
+type watchOptions struct {
+	interval time.Duration
+	desktop  bool
+	webhook  string
+	enqueue  bool
+	transfer *transferOptions
+	print    *printOptions
+}
+
+func notifyNewPacks(ctx context.Context, query string, res []search.FileInfo, opts *watchOptions) {
+	logInfo("%s: %d new packs", time.Now().Format(time.RFC3339), len(res))
+	printResults(res, opts.print)
+
+	if opts.desktop {
+		body := res[0].Name
+		if len(res) > 1 {
+			body = fmt.Sprintf("%s and %d more", body, len(res)-1)
+		}
+
+		if err := sendDesktopNotification("xdcc-cli: new packs for "+query, body); err != nil {
+			logError("desktop notification: %s", err)
+		}
+	}
+
+	if opts.webhook != "" {
+		if err := postWebhook(opts.webhook, &watchNotification{Query: query, Results: res}); err != nil {
+			logError("webhook: %s", err)
+		}
+	}
+
+	// the failures are reported by the batch, and do not stop watching
+	if opts.enqueue {
+		downloadResults(ctx, res, res, opts.transfer)
+	}
+}
+
+// watchLoop searches the query periodically, until ctx is cancelled.
+func watchLoop(ctx context.Context, query string, opts *watchOptions) {
+	parsedQuery := ParseSearchQuery(query)
+
+	for ctx.Err() == nil {
+		state, path, err := loadWatchState()
+		if err != nil {
+			logError("unable to load watch state: %s", err)
+			os.Exit(1)
+		}
+		_, watched := state[query]
+
+		res, err := searchQuery(ctx, parsedQuery)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			logError("%s: search failed: %s", time.Now().Format(time.RFC3339), err)
+		}
+		res = (&ResultFilter{Query: parsedQuery}).Apply(res)
+		newResults := state.updateSeen(query, res)
+
+		if err := writeJSONFile(path, state); err != nil {
+			logError("unable to save watch state: %s", err)
+		}
+
+		if !watched {
+			logInfo("watching %q: %d packs already available, checking every %s", query, len(res), opts.interval)
+		} else if len(newResults) > 0 {
+			notifyNewPacks(ctx, query, newResults, opts)
+		} else {
+			logAt(LogProviders, "%s: no new packs", time.Now().Format(time.RFC3339))
+		}
+
+		select {
+		case <-time.After(opts.interval):
+		case <-ctx.Done():
+		}
+	}
+}
+
+const defaultWatchInterval = 15 * time.Minute
+
+const watchSettingsFileName = "watch_settings.json"
+
+// watchSettings are the switches a query was last watched with, exported along with it and
+// used when it is watched again without them.
+type watchSettings struct {
+	Interval string `json:"interval,omitempty"`
+	Desktop  bool   `json:"desktop,omitempty"`
+	Webhook  string `json:"webhook,omitempty"`
+	Enqueue  bool   `json:"enqueue,omitempty"`
+}
+
+func loadWatchSettings() (map[string]watchSettings, string, error) {
+	path, err := dataFilePath(watchSettingsFileName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	settings := make(map[string]watchSettings)
+	if err := readJSONFile(path, &settings); err != nil {
+		return nil, "", err
+	}
+	return settings, path, nil
+}
+
+// applyWatchSettings completes the switches not given with the settings of the query, then records
+// the settings in use.
+func applyWatchSettings(query string, opts *watchOptions, given map[string]bool) {
+	settings, path, err := loadWatchSettings()
+	if err != nil {
+		logWarn("unable to load watch settings: %s", err)
+		return
+	}
+
+	if s, ok := settings[query]; ok {
+		if interval, err := time.ParseDuration(s.Interval); err == nil && interval > 0 && !given["interval"] {
+			opts.interval = interval
+		}
+		if !given["desktop"] {
+			opts.desktop = s.Desktop
+		}
+		if !given["webhook"] {
+			opts.webhook = s.Webhook
+		}
+		if !given["enqueue"] {
+			opts.enqueue = s.Enqueue
+		}
+	}
+
+	settings[query] = watchSettings{Interval: opts.interval.String(), Desktop: opts.desktop, Webhook: opts.webhook, Enqueue: opts.enqueue}
+	if err := writeJSONFile(path, settings); err != nil {
+		logWarn("unable to save watch settings: %s", err)
+	}
+}
+
+// exportedWatch is a watched query along with its settings and the packs already announced for it, as exported.
+type exportedWatch struct {
+	Query string `json:"query"`
+	watchSettings
+	Seen []string `json:"seen,omitempty"`
+}
+
+func printWatchesUsageAndExit() {
+	fmt.Println("usage:")
+	fmt.Println("  xdcc watches export <file>")
+	fmt.Println("  xdcc watches import <file>")
+	fmt.Println("where file is - for stdout or stdin")
+	os.Exit(1)
+}
+
+// watchesCommand exports the watched queries and their settings to a file, or imports them, merging
+// the packs already announced so that they are not announced again.
+func watchesCommand(args []string) {
+	if len(args) != 2 {
+		printWatchesUsageAndExit()
+	}
+
+	state, path, err := loadWatchState()
+	if err != nil {
+		logError("unable to load watch state: %s", err)
+		os.Exit(1)
+	}
+
+	settings, settingsPath, err := loadWatchSettings()
+	if err != nil {
+		logError("unable to load watch settings: %s", err)
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "export":
+		watches := make([]exportedWatch, 0, len(state))
+		for query, seen := range state {
+			watches = append(watches, exportedWatch{Query: query, watchSettings: settings[query], Seen: seen})
+		}
+		for query, s := range settings {
+			if _, ok := state[query]; !ok { // watched, but never searched
+				watches = append(watches, exportedWatch{Query: query, watchSettings: s})
+			}
+		}
+		sort.Slice(watches, func(i, j int) bool { return watches[i].Query < watches[j].Query })
+
+		if err := exportJSON(args[1], watches); err != nil {
+			logError("unable to export watches: %s", err)
+			os.Exit(1)
+		}
+	case "import":
+		var watches []exportedWatch
+		if err := importJSON(args[1], &watches); err != nil {
+			logError("unable to import watches: %s", err)
+			os.Exit(1)
+		}
+
+		for _, w := range watches {
+			query := strings.Join(strings.Fields(w.Query), " ")
+			if query == "" {
+				continue
+			}
+
+			seen := make(map[string]bool)
+			for _, key := range state[query] {
+				seen[key] = true
+			}
+			merged := append([]string{}, state[query]...)
+			for _, key := range w.Seen {
+				if !seen[key] {
+					seen[key] = true
+					merged = append(merged, key)
+				}
+			}
+			if len(merged) > 0 {
+				state[query] = merged
+			}
+			if w.watchSettings != (watchSettings{}) {
+				settings[query] = w.watchSettings
+			}
+		}
+
+		if err := writeJSONFile(path, state); err != nil {
+			logError("unable to save watch state: %s", err)
+			os.Exit(1)
+		}
+		if err := writeJSONFile(settingsPath, settings); err != nil {
+			logError("unable to save watch settings: %s", err)
+			os.Exit(1)
+		}
+		logInfo("%d watches imported", len(watches))
+	default:
+		fmt.Println("no such command: ", args[0])
+		os.Exit(1)
+	}
+}
+
+// newWatchFlagSet returns the flags of the watch subcommand, also used by the completion.
+func newWatchFlagSet() (*flag.FlagSet, *watchOptions, *logFlags) {
+	watchCmd := flag.NewFlagSet("watch", flag.ExitOnError)
+	opts := &watchOptions{}
+	watchCmd.DurationVar(&opts.interval, "interval", defaultWatchInterval, "time between two searches")
+	watchCmd.BoolVar(&opts.desktop, "desktop", false, "show a desktop notification when new packs appear")
+	watchCmd.StringVar(&opts.webhook, "webhook", "", "url receiving a JSON POST request when new packs appear")
+	watchCmd.BoolVar(&opts.enqueue, "enqueue", false, "automatically download new packs")
+	opts.transfer = addTransferFlags(watchCmd)
+	opts.print = addPrintFlags(watchCmd)
+	return watchCmd, opts, addLogFlags(watchCmd)
+}
+
+func watchCommand(args []string) {
+	watchCmd, opts, logOpts := newWatchFlagSet()
+
+	query := parseQueryArgs(watchCmd, args)
+	logOpts.apply()
+	opts.print.apply()
+
+	query = strings.Join(strings.Fields(query), " ")
+	if len(ParseSearchQuery(query).KeywordSets()) == 0 {
+		fmt.Println("watch: no query provided.")
+		os.Exit(1)
+	}
+
+	given := make(map[string]bool)
+	watchCmd.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	applyWatchSettings(query, opts, given)
+
+	ctx, stop := interruptContext(context.Background())
+	defer stop()
+
+	watchLoop(ctx, query, opts)
+}